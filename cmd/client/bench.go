@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// benchResult is the outcome of a single load test request.
+type benchResult struct {
+	latency time.Duration
+	// failure is empty for a 2xx/304 response, otherwise the error class.
+	failure string
+}
+
+// runBench fires benchRequests requests against serverURL from benchWorkers
+// concurrent workers and prints a latency and error summary. Nothing is
+// written to the quotation file. Ctrl-C stops the workers and still prints
+// the summary of what was done.
+func runBench() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client := benchClient()
+	jobs := make(chan struct{})
+	results := make(chan benchResult, benchWorkers)
+	var wg sync.WaitGroup
+	for i := uint64(0); i < benchWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				results <- benchRequest(ctx, client)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		for i := uint64(0); i < benchRequests; i++ {
+			select {
+			case jobs <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	start := time.Now()
+	var collected []benchResult
+	for r := range results {
+		collected = append(collected, r)
+	}
+	elapsed := time.Since(start)
+
+	if ctx.Err() != nil {
+		fmt.Println("Teste de carga interrompido.")
+	}
+	printBenchSummary(collected, elapsed)
+	return nil
+}
+
+// benchClient returns the client shared by every worker. The idle pool is
+// sized to the number of workers, otherwise most connections would be closed
+// and reopened between requests, which isn't what real clients do.
+func benchClient() *http.Client {
+	transport, ok := httpClient.Transport.(*http.Transport)
+	if httpClient.Transport == nil {
+		transport, ok = http.DefaultTransport.(*http.Transport)
+	}
+	if !ok {
+		return httpClient
+	}
+	transport = transport.Clone()
+	transport.MaxIdleConnsPerHost = int(benchWorkers)
+	return &http.Client{Transport: transport}
+}
+
+func benchRequest(ctx context.Context, client *http.Client) benchResult {
+	reqCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	start := time.Now()
+	req, err := http.NewRequestWithContext(reqCtx, "GET", serverURL, nil)
+	if err != nil {
+		return benchResult{failure: "requisição inválida"}
+	}
+	req.Header.Set("X-Request-Timeout", requestTimeout.String())
+	setAPIKey(req.Header)
+	resp, err := client.Do(req)
+	if err != nil {
+		result := benchResult{latency: time.Since(start), failure: "conexão"}
+		switch {
+		case ctx.Err() != nil:
+			result.failure = "cancelada"
+		case errors.Is(err, context.DeadlineExceeded):
+			result.failure = "timeout"
+		}
+		return result
+	}
+	// Reading the whole body lets the connection go back to the pool.
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	result := benchResult{latency: time.Since(start)}
+	if resp.StatusCode >= http.StatusBadRequest {
+		result.failure = fmt.Sprint("HTTP ", resp.StatusCode)
+	}
+	return result
+}
+
+func printBenchSummary(results []benchResult, elapsed time.Duration) {
+	failures := make(map[string]int)
+	latencies := make([]time.Duration, 0, len(results))
+	for _, r := range results {
+		if r.failure != "" {
+			failures[r.failure]++
+		}
+		if r.failure != "cancelada" {
+			latencies = append(latencies, r.latency)
+		}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	fmt.Printf("Requisições: %d em %s (%.1f req/s)\n",
+		len(results), elapsed.Round(time.Millisecond), float64(len(results))/elapsed.Seconds())
+	fmt.Printf("Latência: p50 %s | p95 %s | p99 %s\n",
+		percentile(latencies, 0.50), percentile(latencies, 0.95), percentile(latencies, 0.99))
+
+	total := 0
+	classes := make([]string, 0, len(failures))
+	for class, n := range failures {
+		classes = append(classes, class)
+		total += n
+	}
+	sort.Strings(classes)
+	fmt.Printf("Erros: %d\n", total)
+	for _, class := range classes {
+		fmt.Printf("  %s: %d\n", class, failures[class])
+	}
+}
+
+// percentile returns the nearest-rank percentile p of the sorted latencies.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank].Round(time.Microsecond)
+}
@@ -0,0 +1,687 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/twsm000/goxp-client-server-api/internal/buildinfo"
+	"github.com/twsm000/goxp-client-server-api/internal/tracing"
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+var (
+	requestTimeout time.Duration
+	maxRetries     uint64
+	streamMode     bool
+	fullMode       bool
+	serverURL      string
+	httpClient     = http.DefaultClient
+	databasePath   string
+	alsoFile       bool
+	jsonlPath      string
+	benchMode      bool
+	benchRequests  uint64
+	benchWorkers   uint64
+	output         outputFile = fixedOutput{file: fileName}
+	lineLayout     string
+	lockTimeout    time.Duration
+	maxAge         time.Duration
+	alertBP        int64
+	otlpEndpoint   string
+	tracer         *tracing.Tracer
+	pairs          []string
+	parallel       int
+	apiKey         string
+)
+
+const (
+	fileName            string        = "cotacao.txt"
+	etagFileName        string        = "cotacao.etag"
+	initialBackoff      time.Duration = 100 * time.Millisecond
+	maxErrorBodySize    int64         = 64 << 10
+	requestTimeoutUsage string        = "request timout usage: -rt 300ms or -rt 1s or -rt 1m"
+	retriesUsage        string        = "retries usage: -retries 3 (extra attempts on network errors and 5xx responses)"
+	streamUsage         string        = "stay connected to the server stream, appending every new quotation to the file"
+	fullUsage           string        = "request the full quotation and also save ask, high, low and date to the file"
+	serverURLUsage      string        = "server url usage: -url http://localhost:8080/v1/cotacao or -url https://example.com/v1/cotacao"
+	unixSocketUsage     string        = "unix socket usage: -unix /var/run/cotacao.sock (connect through the socket, the -url host is ignored)"
+	insecureUsage       string        = "skip TLS certificate verification (self-signed certificates in dev only)"
+	databaseUsage       string        = "database usage: -db cotacoes-cliente.db (store quotations in sqlite instead of the text file)"
+	alsoFileUsage       string        = "with -db or -jsonl, also append the quotation to the text file"
+	jsonlUsage          string        = "json lines usage: -jsonl cotacoes.jsonl (append the full quotation, one JSON object per line)"
+	benchUsage          string        = "load test the server instead of saving the quotation (see -n and -c)"
+	benchRequestsUsage  string        = "load test requests usage: -bench -n 500"
+	benchWorkersUsage   string        = "load test concurrency usage: -bench -c 20"
+	rotateUsage         string        = "rotation usage: -rotate daily (one output file per local day, empty disables)"
+	rotatePatternUsage  string        = "rotated file name usage: -rotate-pattern cotacao-{date}.txt ({date} becomes 2006-01-02)"
+	compressAfterUsage  string        = "compress usage: -rotate daily -compress-after 7 (gzip rotated files older than 7 days, 0 disables)"
+	timeLayoutUsage     string        = "line timestamp usage: -time-layout 2006-01-02T15:04:05Z07:00 (Go time layout, empty disables)"
+	lockTimeoutUsage    string        = "lock timeout usage: -lock-timeout 2s (wait for other clients writing the file)"
+	maxAgeUsage         string        = "max age usage: -max-age 5m (warn when the quotation is older, 0 disables)"
+	alertUsage          string        = "alert usage: -alert-threshold 0.5% (warn when the bid moves more than this since the last saved one, empty disables)"
+	otlpEndpointUsage   string        = "tracing usage: -otlp-endpoint http://localhost:4318 (OTLP/HTTP collector receiving the spans, empty disables)"
+	pairsUsage          string        = "pairs usage: -pairs USD-BRL,EUR-BRL,BTC-BRL (fetch the pairs concurrently and write a combined record)"
+	parallelUsage       string        = "parallel usage: -pairs USD-BRL,EUR-BRL -parallel 4 (pairs requested at the same time)"
+	versionUsage        string        = "print the version and build info and exit"
+	apiKeyUsage         string        = "api key usage: -api-key cot_... (sent in X-API-Key, for servers running with -require-api-key)"
+	exportTimeout       time.Duration = 5 * time.Second
+)
+
+func main() {
+	parseFlagValues()
+	os.Exit(run())
+}
+
+// run executes the selected mode and returns the process exit code. Errors
+// travel up to here instead of exiting deep in the call stack, so deferred
+// cleanups always run.
+func run() int {
+	tracer = tracing.New(otlpEndpoint, "cotacao-client")
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+		defer cancel()
+		if err := tracer.Shutdown(ctx); err != nil {
+			log.Println("Falha ao exportar spans pendentes:", err)
+		}
+	}()
+	if databasePath != "" {
+		if err := startDatabase(); err != nil {
+			log.Println(err)
+			return exitCode(err)
+		}
+		defer db.Close()
+	}
+	if len(pairs) == 0 {
+		loadPreviousBid()
+	}
+
+	var err error
+	switch {
+	case convertQuery != nil:
+		err = convertAmount()
+	case benchMode:
+		err = runBench()
+	case streamMode:
+		err = streamQuotations()
+	case len(pairs) > 0:
+		err = fetchPairs()
+	default:
+		err = makeRequest()
+	}
+	if err != nil {
+		log.Println(err)
+		return exitCode(err)
+	}
+	return exitSuccess
+}
+
+func parseFlagValues() {
+	var (
+		reqTimeout string
+		retries    string
+		insecure   bool
+		unixSocket string
+		requests   string
+		workers    string
+		rotate     string
+		pattern    string
+		compress   string
+		lockWait   string
+		age        string
+		alert      string
+		pairList   string
+		workLimit  string
+		version    bool
+	)
+
+	flag.StringVar(&reqTimeout, "rt", "200ms", requestTimeoutUsage)
+	flag.StringVar(&retries, "retries", "0", retriesUsage)
+	flag.BoolVar(&streamMode, "stream", false, streamUsage)
+	flag.BoolVar(&fullMode, "full", false, fullUsage)
+	flag.StringVar(&serverURL, "url", "http://localhost:8080/v1/cotacao", serverURLUsage)
+	flag.BoolVar(&insecure, "insecure", false, insecureUsage)
+	flag.StringVar(&unixSocket, "unix", "", unixSocketUsage)
+	flag.StringVar(&databasePath, "db", "", databaseUsage)
+	flag.BoolVar(&alsoFile, "also-file", false, alsoFileUsage)
+	flag.StringVar(&jsonlPath, "jsonl", "", jsonlUsage)
+	flag.BoolVar(&benchMode, "bench", false, benchUsage)
+	flag.StringVar(&requests, "n", "100", benchRequestsUsage)
+	flag.StringVar(&workers, "c", "10", benchWorkersUsage)
+	flag.StringVar(&rotate, "rotate", "", rotateUsage)
+	flag.StringVar(&pattern, "rotate-pattern", "cotacao-{date}.txt", rotatePatternUsage)
+	flag.StringVar(&compress, "compress-after", "0", compressAfterUsage)
+	flag.StringVar(&lineLayout, "time-layout", time.RFC3339, timeLayoutUsage)
+	flag.StringVar(&lockWait, "lock-timeout", "2s", lockTimeoutUsage)
+	flag.StringVar(&age, "max-age", "0", maxAgeUsage)
+	flag.StringVar(&alert, "alert-threshold", "", alertUsage)
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "", otlpEndpointUsage)
+	flag.StringVar(&pairList, "pairs", "", pairsUsage)
+	flag.StringVar(&workLimit, "parallel", "4", parallelUsage)
+	flag.StringVar(&apiKey, "api-key", "", apiKeyUsage)
+	flag.BoolVar(&version, "version", false, versionUsage)
+	annotateEnvUsage(flag.CommandLine)
+	flag.Parse()
+	if version {
+		fmt.Println("cotacao-client", buildinfo.Get())
+		os.Exit(exitSuccess)
+	}
+	applyEnv(flag.CommandLine)
+	d, err := time.ParseDuration(reqTimeout)
+	if err != nil {
+		log.Fatalln("Invalid argument,", requestTimeoutUsage)
+	}
+	requestTimeout = d
+
+	r, err := strconv.ParseUint(retries, 10, 8)
+	if err != nil {
+		log.Fatalln("Invalid argument,", retriesUsage)
+	}
+	maxRetries = r
+
+	n, err := strconv.ParseUint(requests, 10, 32)
+	if err != nil || n == 0 {
+		log.Fatalln("Invalid argument,", benchRequestsUsage)
+	}
+	benchRequests = n
+
+	c, err := strconv.ParseUint(workers, 10, 16)
+	if err != nil || c == 0 {
+		log.Fatalln("Invalid argument,", benchWorkersUsage)
+	}
+	benchWorkers = c
+
+	days, err := strconv.ParseUint(compress, 10, 16)
+	if err != nil {
+		log.Fatalln("Invalid argument,", compressAfterUsage)
+	}
+	lockTimeout, err = time.ParseDuration(lockWait)
+	if err != nil || lockTimeout < 0 {
+		log.Fatalln("Invalid argument,", lockTimeoutUsage)
+	}
+	maxAge, err = time.ParseDuration(age)
+	if err != nil || maxAge < 0 {
+		log.Fatalln("Invalid argument,", maxAgeUsage)
+	}
+	alertBP, err = parseThreshold(alert)
+	if err != nil {
+		log.Fatalln("Invalid argument,", alertUsage)
+	}
+
+	if pairList != "" {
+		pairs, err = upstream.ParsePairs(pairList)
+		if err != nil {
+			log.Fatalln("Invalid argument,", pairsUsage)
+		}
+	}
+	p, err := strconv.ParseUint(workLimit, 10, 16)
+	if err != nil || p == 0 {
+		log.Fatalln("Invalid argument,", parallelUsage)
+	}
+	parallel = int(p)
+
+	switch rotate {
+	case "":
+	case "daily":
+		if !strings.Contains(pattern, datePlaceholder) {
+			log.Fatalln("Invalid argument,", rotatePatternUsage)
+		}
+		output = newDailyOutput(pattern, int(days))
+	default:
+		log.Fatalln("Invalid argument,", rotateUsage)
+	}
+
+	// The JSON Lines file keeps every field, so it needs the full payload.
+	if jsonlPath != "" {
+		fullMode = true
+	}
+
+	u, err := url.Parse(serverURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		log.Fatalln("Invalid argument,", serverURLUsage)
+	}
+
+	switch command := flag.Arg(0); command {
+	case "":
+	case "convert":
+		convertQuery, err = parseConvertArgs(flag.Args()[1:])
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(exitSuccess)
+		}
+		if err != nil {
+			log.Fatalln("Invalid argument,", err)
+		}
+	default:
+		log.Fatalln("Invalid argument, unknown command:", command)
+	}
+
+	if insecure || unixSocket != "" {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if insecure {
+			transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		}
+		if unixSocket != "" {
+			transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", unixSocket)
+			}
+		}
+		httpClient = &http.Client{Transport: transport}
+	}
+}
+
+// makeRequest calls the server until it succeeds, a non retryable error
+// happens or the retries are exhausted.
+func makeRequest() error {
+	return withRetries(doRequest)
+}
+
+// withRetries runs attempt until it succeeds, fails with a non retryable
+// error or the retries are exhausted, doubling the wait between attempts.
+func withRetries(attempt func() error) error {
+	var (
+		attempts uint64
+		err      error
+	)
+	backoff := initialBackoff
+	for {
+		attempts++
+		err = attempt()
+		if err == nil {
+			return nil
+		}
+
+		var retryErr retryableError
+		if !errors.As(err, &retryErr) || attempts > maxRetries {
+			break
+		}
+		log.Printf("Tentativa %d falhou: %v. Nova tentativa em %s\n", attempts, err, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	return fmt.Errorf("falha após %d tentativa(s): %w", attempts, err)
+}
+
+// doRequest performs a single attempt, with its own timeout context, so a
+// retry never starts with an already expired deadline. Each attempt is a
+// span, propagated to the server, when tracing is enabled.
+func doRequest() (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	query := url.Values{}
+	if fullMode {
+		query.Set("full", "true")
+	}
+	endpoint, err := quotationURL(query)
+	if err != nil {
+		return err
+	}
+	ctx, span := tracer.Start(ctx, "GET /cotacao", tracing.KindClient)
+	span.SetAttribute("http.url", endpoint)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	req, err := newQuotationRequest(ctx, endpoint)
+	if err != nil {
+		return err
+	}
+	if etag, err := os.ReadFile(etagFileName); err == nil && len(etag) > 0 {
+		req.Header.Set("If-None-Match", string(etag))
+	}
+	resp, err := sendRequest(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	span.SetAttribute("http.status_code", resp.StatusCode)
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNotModified {
+		warnIfStale(resp.Header)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		err = saveQuotation(resp.Body, requestedPair())
+		if err != nil {
+			return err
+		}
+		saveETag(resp.Header.Get("ETag"))
+		return nil
+	case resp.StatusCode == http.StatusNotModified:
+		log.Println("Cotação sem alteração.")
+		return nil
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return retryableError{handleError(resp)}
+	default:
+		return handleError(resp)
+	}
+}
+
+// quotationURL is -url with query set on top of the query it already has.
+func quotationURL(query url.Values) (string, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return "", fmt.Errorf("falha ao criar requisição: %w", err)
+	}
+	q := u.Query()
+	for key, values := range query {
+		q[key] = values
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// streamURL is -url with /stream appended to its path, keeping its query.
+func streamURL() (string, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return "", fmt.Errorf("falha ao criar requisição: %w", err)
+	}
+	return u.JoinPath("stream").String(), nil
+}
+
+// requestedPair is the pair -url asks for, through ?pair= or a
+// /cotacao/{pair} path, or the default pair of the server.
+func requestedPair() string {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return upstream.DefaultPair
+	}
+	raw := u.Query().Get("pair")
+	if _, segment, ok := strings.Cut(u.Path, "/cotacao/"); ok && raw == "" {
+		raw = strings.Trim(segment, "/")
+	}
+	if pairs, err := upstream.ParsePairs(raw); err == nil && len(pairs) == 1 {
+		return pairs[0]
+	}
+	return upstream.DefaultPair
+}
+
+// newQuotationRequest creates the GET of endpoint under ctx, propagating the
+// trace and the timeout to the server.
+func newQuotationRequest(ctx context.Context, endpoint string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao criar requisição: %w", err)
+	}
+	tracing.Inject(ctx, req.Header)
+	// Lets the server give up on its side at the same time we do.
+	req.Header.Set("X-Request-Timeout", requestTimeout.String())
+	setAPIKey(req.Header)
+	return req, nil
+}
+
+// setAPIKey sends -api-key, when given.
+func setAPIKey(h http.Header) {
+	if apiKey != "" {
+		h.Set("X-API-Key", apiKey)
+	}
+}
+
+// sendRequest sends req, tagging network failures as retryable.
+func sendRequest(req *http.Request) (*http.Response, error) {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("requisição ultrapassou o tempo máximo de %s", requestTimeout)
+			return nil, retryableError{exitError{exitTimeout, err}}
+		}
+		return nil, retryableError{fmt.Errorf("requisição falhou: %w", err)}
+	}
+	return resp, nil
+}
+
+// warnIfStale logs a warning when the X-Quotation-Age reported by the server
+// is above -max-age.
+func warnIfStale(h http.Header) {
+	if maxAge == 0 {
+		return
+	}
+	sec, err := strconv.ParseInt(h.Get("X-Quotation-Age"), 10, 64)
+	if err != nil {
+		return
+	}
+	if age := time.Duration(sec) * time.Second; age > maxAge {
+		log.Printf("Atenção: cotação com %s de idade, acima de -max-age %s (fonte: %s)\n", age, maxAge, h.Get("X-Quotation-Source"))
+	}
+}
+
+// streamQuotations consumes the server-sent events of /cotacao/stream until
+// the server closes the connection.
+func streamQuotations() error {
+	endpoint, err := streamURL()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("falha ao criar requisição: %w", err)
+	}
+	setAPIKey(req.Header)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("falha ao conectar ao stream: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return handleError(resp)
+	}
+	log.Println("Conectado ao stream de cotações.")
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		err = saveQuotation(strings.NewReader(data), requestedPair())
+		if err != nil {
+			log.Println("Falha ao salvar cotação do stream:", err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("stream interrompido: %w", err)
+	}
+	log.Println("Stream encerrado pelo servidor.")
+	return nil
+}
+
+// saveQuotation decodes a quotation and stores it in the local database, the
+// JSON Lines file and the text file, depending on the flags. The text file is
+// written when no other destination is selected, or with -also-file. pair is
+// the one requested, which the database row is stored under.
+func saveQuotation(r io.Reader, pair string) error {
+	fetchedAt := time.Now()
+	var cotacao upstream.Quotation
+	err := json.NewDecoder(r).Decode(&cotacao)
+	if err == nil && cotacao.Bid == "" {
+		err = upstream.ErrMissingBid
+	}
+	if err != nil {
+		return exitError{exitDecodeFailure, fmt.Errorf("falha ao decodificar corpo da requisição: %w", err)}
+	}
+	bid, err := upstream.ParseDecimal(cotacao.Bid)
+	if err != nil {
+		return exitError{exitDecodeFailure, fmt.Errorf("bid inválido: %w", err)}
+	}
+	change := newVariation(previousBid, bid)
+	if change.exceeds(alertBP) {
+		log.Printf("*** ALERTA: dólar variou %s desde a última cotação salva, acima de -alert-threshold ***\n", change)
+	}
+
+	if databasePath != "" {
+		err = saveQuotationToDB(pair, &cotacao, change)
+		if err != nil {
+			return err
+		}
+	}
+	if jsonlPath != "" {
+		err = saveQuotationToJSONL(&cotacao, fetchedAt, change)
+		if err != nil {
+			return err
+		}
+	}
+	if (databasePath == "" && jsonlPath == "") || alsoFile {
+		err = saveQuotationToFile(&cotacao, change)
+		if err != nil {
+			return err
+		}
+	}
+	previousBid = bid
+	return nil
+}
+
+// saveQuotationToJSONL appends cotacao to the JSON Lines file.
+func saveQuotationToJSONL(cotacao *upstream.Quotation, fetchedAt time.Time, change variation) error {
+	line, err := upstream.MarshalRecord(upstream.Record{Quotation: *cotacao, FetchedAt: fetchedAt.UTC()})
+	if err != nil {
+		return exitError{exitWriteFailure, fmt.Errorf("falha ao codificar cotação: %w", err)}
+	}
+	err = appendJSONL(line)
+	if err != nil {
+		return err
+	}
+	log.Println("Registro salvo em", jsonlPath, cotacao.Bid, change)
+	return nil
+}
+
+// appendJSONL appends line to the JSON Lines file with a single write, under
+// the same lock as the text file, so concurrent clients never interleave
+// partial lines.
+func appendJSONL(line []byte) error {
+	f, err := os.OpenFile(jsonlPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0660)
+	if err != nil {
+		return exitError{exitWriteFailure, err}
+	}
+	defer f.Close()
+	unlock, err := lockFile(f, lockTimeout)
+	if err != nil {
+		return exitError{exitWriteFailure, fmt.Errorf("falha ao obter lock de %s: %w", f.Name(), err)}
+	}
+	defer unlock()
+
+	_, err = f.Write(line)
+	if err != nil {
+		return exitError{exitWriteFailure, fmt.Errorf("falha ao salvar dados em %s: %w", jsonlPath, err)}
+	}
+	return nil
+}
+
+func saveQuotationToFile(cotacao *upstream.Quotation, change variation) error {
+	msg := fmt.Sprint("Dólar: ", cotacao.Bid)
+	if change.known {
+		msg += " " + change.String()
+	}
+	if fullMode {
+		msg += fullDetails(cotacao)
+	}
+	if lineLayout != "" {
+		msg = time.Now().Format(lineLayout) + " | " + msg
+	}
+	err := appendOutput(msg + "\n")
+	if err != nil {
+		return err
+	}
+	log.Println("Registro salvo em disco.", msg)
+	return nil
+}
+
+// fullDetails is the -full part of a text file line.
+func fullDetails(cotacao *upstream.Quotation) string {
+	return fmt.Sprintf(" | Venda: %s | Máxima: %s | Mínima: %s | Data: %s",
+		cotacao.Ask, cotacao.High, cotacao.Low, cotacao.CreateDate)
+}
+
+// appendOutput appends text, one or more whole lines, to the text file.
+func appendOutput(text string) error {
+	file, err := output.open()
+	if err != nil {
+		return exitError{exitWriteFailure, err}
+	}
+	defer file.Close()
+
+	// The lock keeps lines of concurrent clients from interleaving.
+	if f, ok := file.(*os.File); ok {
+		unlock, err := lockFile(f, lockTimeout)
+		if err != nil {
+			return exitError{exitWriteFailure, fmt.Errorf("falha ao obter lock de %s: %w", f.Name(), err)}
+		}
+		defer unlock()
+	}
+
+	// The lines are written with a single call while the lock is held.
+	_, err = io.WriteString(file, text)
+	if err != nil {
+		return exitError{exitWriteFailure, fmt.Errorf("falha ao salvar dados em disco: %w", err)}
+	}
+	return nil
+}
+
+// saveETag remembers the validator of the last saved quotation so the next
+// run can ask the server whether anything changed.
+func saveETag(etag string) {
+	if etag == "" {
+		return
+	}
+	err := os.WriteFile(etagFileName, []byte(etag), 0660)
+	if err != nil {
+		log.Println("Falha ao salvar ETag:", err)
+	}
+}
+
+// handleError turns an error response into a serverError. Bodies that aren't
+// a problem document, like the pages of a proxy in front of the server, are
+// kept as the title along with the status code. The {"error", "status_code"}
+// documents of older servers are still understood.
+func handleError(resp *http.Response) error {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	if err != nil {
+		return exitError{exitDecodeFailure, fmt.Errorf("falha ao ler corpo da resposta: %w", err)}
+	}
+	var problem Problem
+	if err := json.Unmarshal(body, &problem); err != nil {
+		problem = Problem{}
+	}
+	if problem.Title == "" {
+		problem.Title = problem.LegacyError
+	}
+	if problem.Status == 0 {
+		problem.Status = problem.LegacyStatus
+	}
+	if problem.Title == "" {
+		problem = Problem{Title: strings.TrimSpace(string(body))}
+	}
+	if problem.Status == 0 {
+		problem.Status = resp.StatusCode
+	}
+	return serverError{problem}
+}
+
+// Problem is an RFC 7807 error response of the server.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail"`
+	Code      string `json:"code"`
+	RequestID string `json:"request_id"`
+
+	LegacyError  string `json:"error"`
+	LegacyStatus int    `json:"status_code"`
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+// useTestClient points the client at server, appending to a text file in a
+// temporary directory that is also the working directory, where the ETag is
+// kept. The globals it changes are restored afterwards. It returns the path
+// of the text file.
+func useTestClient(t *testing.T, server string) string {
+	t.Helper()
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	prevURL, prevOutput, prevFull, prevTimeout, prevRetries := serverURL, output, fullMode, requestTimeout, maxRetries
+	prevBid, prevLayout, prevLock := previousBid, lineLayout, lockTimeout
+	t.Cleanup(func() {
+		os.Chdir(wd)
+		serverURL, output, fullMode, requestTimeout, maxRetries = prevURL, prevOutput, prevFull, prevTimeout, prevRetries
+		previousBid, lineLayout, lockTimeout = prevBid, prevLayout, prevLock
+	})
+	path := filepath.Join(dir, fileName)
+	serverURL = server + "/cotacao"
+	output = fixedOutput{file: path}
+	fullMode = false
+	requestTimeout = time.Second
+	maxRetries = 0
+	previousBid = 0
+	lineLayout = ""
+	lockTimeout = time.Second
+	return path
+}
+
+// readOutput returns the lines of the text file at path.
+func readOutput(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func TestQuotationURL(t *testing.T) {
+	tests := []struct {
+		name   string
+		server string
+		query  url.Values
+		want   string
+	}{
+		{"no query", "http://localhost:8080/cotacao", url.Values{}, "http://localhost:8080/cotacao"},
+		{"full", "http://localhost:8080/cotacao", url.Values{"full": {"true"}}, "http://localhost:8080/cotacao?full=true"},
+		{"keeps existing query", "http://localhost:8080/cotacao?lang=en", url.Values{"full": {"true"}}, "http://localhost:8080/cotacao?full=true&lang=en"},
+		{"overrides existing key", "http://localhost:8080/cotacao?pairs=EUR-BRL", url.Values{"pairs": {"USD-BRL"}}, "http://localhost:8080/cotacao?pairs=USD-BRL"},
+	}
+	defer func(orig string) { serverURL = orig }(serverURL)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverURL = tt.server
+			got, err := quotationURL(tt.query)
+			if err != nil {
+				t.Fatalf("quotationURL() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("quotationURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuotationURLInvalid(t *testing.T) {
+	defer func(orig string) { serverURL = orig }(serverURL)
+	serverURL = "http://[::1"
+	if _, err := quotationURL(url.Values{}); err == nil {
+		t.Error("quotationURL() with an invalid -url: want error")
+	}
+}
+
+func TestStreamURL(t *testing.T) {
+	tests := []struct {
+		name   string
+		server string
+		want   string
+	}{
+		{"plain", "http://localhost:8080/v1/cotacao", "http://localhost:8080/v1/cotacao/stream"},
+		{"trailing slash", "http://localhost:8080/v1/cotacao/", "http://localhost:8080/v1/cotacao/stream"},
+		{"keeps query", "http://localhost:8080/v1/cotacao?pair=EUR-BRL", "http://localhost:8080/v1/cotacao/stream?pair=EUR-BRL"},
+	}
+	defer func(orig string) { serverURL = orig }(serverURL)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverURL = tt.server
+			got, err := streamURL()
+			if err != nil {
+				t.Fatalf("streamURL() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("streamURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDoRequestFull(t *testing.T) {
+	tests := []struct {
+		name     string
+		full     bool
+		body     string
+		wantLine string
+	}{
+		{"minimal", false, `{"bid":"5.1234","bid_value":5.1234,"source":"upstream"}`, "Dólar: 5.1234"},
+		{
+			"full", true,
+			`{"code":"USD","codein":"BRL","bid":"5.1234","ask":"5.1240","high":"5.2301","low":"5.1012","create_date":"2026-10-14 10:00:00","source":"upstream"}`,
+			"Dólar: 5.1234 | Venda: 5.1240 | Máxima: 5.2301 | Mínima: 5.1012 | Data: 2026-10-14 10:00:00",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotFull string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotFull = r.URL.Query().Get("full")
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+			path := useTestClient(t, srv.URL)
+			fullMode = tt.full
+
+			if err := doRequest(); err != nil {
+				t.Fatalf("doRequest() error = %v", err)
+			}
+			if want := map[bool]string{true: "true"}[tt.full]; gotFull != want {
+				t.Errorf("?full = %q, want %q", gotFull, want)
+			}
+			if lines := readOutput(t, path); len(lines) != 1 || lines[0] != tt.wantLine {
+				t.Errorf("file = %q, want %q", lines, tt.wantLine)
+			}
+		})
+	}
+}
+
+func TestFullDetails(t *testing.T) {
+	q := upstream.Quotation{Ask: "5.1240", High: "5.2301", Low: "5.1012", CreateDate: "2026-10-14 10:00:00"}
+	if got, want := fullDetails(&q), " | Venda: 5.1240 | Máxima: 5.2301 | Mínima: 5.1012 | Data: 2026-10-14 10:00:00"; got != want {
+		t.Errorf("fullDetails() = %q, want %q", got, want)
+	}
+}
+
+func TestMakeRequestExitCodes(t *testing.T) {
+	problem := func(status int, code string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(status)
+			fmt.Fprintf(w, `{"type":"urn:cotacao:error:%s","title":"erro","status":%d,"detail":"falhou","code":%q}`, code, status, code)
+		}
+	}
+	body := func(s string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(s)) }
+	}
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		noOutput bool
+		want     int
+	}{
+		{"success", body(`{"bid":"5.1234"}`), false, exitSuccess},
+		{"timeout", func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+		}, false, exitTimeout},
+		{"bad request", problem(http.StatusBadRequest, "bad_request"), false, exitServerError},
+		{"rate limited", problem(http.StatusTooManyRequests, "rate_limited"), false, exitServerError},
+		{"upstream error", problem(http.StatusBadGateway, "upstream_error"), false, exitUpstreamFailure},
+		{"proxy gateway timeout", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "<html>504 Gateway Time-out</html>", http.StatusGatewayTimeout)
+		}, false, exitUpstreamFailure},
+		{"storage failure", problem(http.StatusServiceUnavailable, "db_write_failed"), false, exitStorageFailure},
+		{"malformed body", body(`{"bid":`), false, exitDecodeFailure},
+		{"missing bid", body(`{"source":"upstream"}`), false, exitDecodeFailure},
+		{"invalid bid", body(`{"bid":"5,12"}`), false, exitDecodeFailure},
+		{"write failure", body(`{"bid":"5.1234"}`), true, exitWriteFailure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+			useTestClient(t, srv.URL)
+			requestTimeout = 50 * time.Millisecond
+			if tt.noOutput {
+				output = fixedOutput{file: filepath.Join(t.TempDir(), "missing", fileName)}
+			}
+
+			got := exitSuccess
+			if err := makeRequest(); err != nil {
+				got = exitCode(err)
+			}
+			if got != tt.want {
+				t.Errorf("exit code = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMakeRequestUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	useTestClient(t, srv.URL)
+	srv.Close()
+	if got := exitCode(makeRequest()); got != exitFailure {
+		t.Errorf("exit code = %d, want %d", got, exitFailure)
+	}
+}
+
+func TestMakeRequestRetries(t *testing.T) {
+	var hits int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if hits < 3 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"bid":"5.1234"}`))
+	}))
+	defer srv.Close()
+	useTestClient(t, srv.URL)
+
+	// One retry isn't enough: the 503 served twice is reported.
+	maxRetries = 1
+	if got := exitCode(makeRequest()); got != exitServerError || hits != 2 {
+		t.Fatalf("exit code = %d after %d requests, want %d after 2", got, hits, exitServerError)
+	}
+	if err := makeRequest(); err != nil || hits != 3 {
+		t.Errorf("makeRequest() = %v after %d requests, want success on the third", err, hits)
+	}
+}
+
+// captureLog sends the standard logger to the returned buffer for the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prev := log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(prev) })
+	return &buf
+}
+
+func TestWarnIfStale(t *testing.T) {
+	tests := []struct {
+		name   string
+		maxAge time.Duration
+		age    string
+		warn   bool
+	}{
+		{"older", 5 * time.Minute, "301", true},
+		{"at the limit", 5 * time.Minute, "300", false},
+		{"fresh", 5 * time.Minute, "0", false},
+		{"disabled", 0, "86400", false},
+		{"no header", 5 * time.Minute, "", false},
+		{"malformed header", 5 * time.Minute, "5m", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prev := maxAge
+			t.Cleanup(func() { maxAge = prev })
+			maxAge = tt.maxAge
+			logs := captureLog(t)
+			h := http.Header{"X-Quotation-Source": {"db"}}
+			if tt.age != "" {
+				h.Set("X-Quotation-Age", tt.age)
+			}
+			warnIfStale(h)
+			if got := strings.Contains(logs.String(), "acima de -max-age"); got != tt.warn {
+				t.Errorf("warned = %v, want %v; logs %q", got, tt.warn, logs)
+			}
+			if tt.warn && (!strings.Contains(logs.String(), "5m1s de idade") || !strings.Contains(logs.String(), "fonte: db")) {
+				t.Errorf("warning %q doesn't give the age and the source", logs)
+			}
+		})
+	}
+}
+
+func TestMakeRequestWarnsIfStale(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Quotation-Age", "600")
+		w.Header().Set("X-Quotation-Source", "cache")
+		w.Write([]byte(`{"bid":"5.1234"}`))
+	}))
+	defer srv.Close()
+	path := useTestClient(t, srv.URL)
+	prev := maxAge
+	t.Cleanup(func() { maxAge = prev })
+	maxAge = 5 * time.Minute
+	logs := captureLog(t)
+
+	if err := makeRequest(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logs.String(), "10m0s de idade") {
+		t.Errorf("logs = %q, want the stale warning", logs)
+	}
+	if lines := readOutput(t, path); len(lines) != 1 {
+		t.Errorf("output = %q, want the quotation saved anyway", lines)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/twsm000/goxp-client-server-api/internal/tracing"
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+const (
+	convertUsage       string = "convert usage: cotacao-client [flags] convert -from USD -to BRL -amount 123.45 (print the amount converted by the server, nothing is saved)"
+	convertPairUsage   string = "currencies usage: convert -from USD -to BRL"
+	convertAmountUsage string = "amount usage: convert -amount 123.45"
+	convertSideUsage   string = "rate usage: -side bid or -side ask"
+	convertDateUsage   string = "date usage: -date 2024-01-31 (use the last quotation stored that day, UTC, instead of the current one)"
+	convertRoundUsage  string = "rounding usage: -rounding half-up, half-even, down or up"
+	convertPlacesUsage string = "decimal places usage: -decimals 2 (0 to 8)"
+)
+
+// convertQuery is the query of the convert command, nil when it wasn't
+// given.
+var convertQuery url.Values
+
+// conversion is the answer of the server to GET /convert.
+type conversion struct {
+	From   string           `json:"from"`
+	To     string           `json:"to"`
+	Amount upstream.Decimal `json:"amount"`
+	Side   string           `json:"side"`
+	Rate   upstream.Decimal `json:"rate"`
+	Value  upstream.Decimal `json:"value"`
+	Date   string           `json:"date"`
+	Source string           `json:"source"`
+	// Components are the legs of a cross rate.
+	Components []struct {
+		Pair     string           `json:"pair"`
+		BidValue upstream.Decimal `json:"bid_value"`
+		AskValue upstream.Decimal `json:"ask_value"`
+	} `json:"components"`
+}
+
+// parseConvertArgs parses the flags after the convert command into the
+// query of /convert. The server validates the values themselves.
+func parseConvertArgs(args []string) (url.Values, error) {
+	fs := flag.NewFlagSet("convert", flag.ContinueOnError)
+	from := fs.String("from", "", convertPairUsage)
+	to := fs.String("to", "", convertPairUsage)
+	amount := fs.String("amount", "", convertAmountUsage)
+	side := fs.String("side", "bid", convertSideUsage)
+	date := fs.String("date", "", convertDateUsage)
+	decimals := fs.String("decimals", "2", convertPlacesUsage)
+	rounding := fs.String("rounding", "half-up", convertRoundUsage)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if *from == "" || *to == "" || *amount == "" || fs.NArg() > 0 {
+		return nil, errors.New(convertUsage)
+	}
+	query := url.Values{
+		"from":     {*from},
+		"to":       {*to},
+		"amount":   {*amount},
+		"side":     {*side},
+		"decimals": {*decimals},
+		"rounding": {*rounding},
+	}
+	if *date != "" {
+		query.Set("date", *date)
+	}
+	return query, nil
+}
+
+// convertAmount runs the convert command.
+func convertAmount() error {
+	return withRetries(doConvert)
+}
+
+// doConvert asks the server for the conversion of convertQuery and prints
+// it.
+func doConvert() (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	endpoint, err := convertURL()
+	if err != nil {
+		return err
+	}
+	ctx, span := tracer.Start(ctx, "GET /convert", tracing.KindClient)
+	span.SetAttribute("http.url", endpoint)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	req, err := newQuotationRequest(ctx, endpoint)
+	if err != nil {
+		return err
+	}
+	resp, err := sendRequest(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	span.SetAttribute("http.status_code", resp.StatusCode)
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return retryableError{handleError(resp)}
+	default:
+		return handleError(resp)
+	}
+	var c conversion
+	if err := json.NewDecoder(resp.Body).Decode(&c); err != nil {
+		return exitError{exitDecodeFailure, fmt.Errorf("falha ao decodificar corpo da requisição: %w", err)}
+	}
+	when := "cotação atual"
+	if c.Date != "" {
+		when = "cotação de " + c.Date
+	}
+	rate := c.Rate.String()
+	for i, leg := range c.Components {
+		legRate := leg.BidValue
+		if c.Side == "ask" {
+			legRate = leg.AskValue
+		}
+		sep := " = "
+		if i > 0 {
+			sep = " × "
+		}
+		rate += fmt.Sprint(sep, leg.Pair, " ", legRate)
+	}
+	fmt.Printf("%s %s = %s %s (%s %s, %s, fonte: %s)\n", c.Amount, c.From, c.Value, c.To, c.Side, rate, when, c.Source)
+	return nil
+}
+
+// convertURL is /convert next to the /cotacao of -url, with convertQuery.
+func convertURL() (string, error) {
+	base, err := url.Parse(serverURL)
+	if err != nil {
+		return "", fmt.Errorf("falha ao criar requisição: %w", err)
+	}
+	u := base.ResolveReference(&url.URL{Path: "convert"})
+	u.RawQuery = convertQuery.Encode()
+	return u.String(), nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+const (
+	databaseTimeout time.Duration = 10 * time.Second
+	busyTimeout     time.Duration = 5 * time.Second
+)
+
+var db *sql.DB
+
+// startDatabase opens the local history database, creating the schema on the
+// first run. The busy timeout lets two client instances share the file
+// instead of failing with "database is locked".
+func startDatabase() error {
+	var err error
+	dsn := fmt.Sprintf("file:%s?_journal_mode=WAL&_busy_timeout=%d", databasePath, busyTimeout.Milliseconds())
+	db, err = sql.Open("sqlite3", dsn)
+	if err != nil {
+		return exitError{exitWriteFailure, fmt.Errorf("falhou abrir o banco de dados: %w", err)}
+	}
+	db.SetMaxOpenConns(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), databaseTimeout)
+	defer cancel()
+	if err := createQuotationTable(ctx); err != nil {
+		db.Close()
+		return exitError{exitWriteFailure, fmt.Errorf("falha ao criar tabela de cotacao: %w", err)}
+	}
+	return nil
+}
+
+// createQuotationTable creates the client_quotation table. Older clients
+// named it cotacao, which is also the table of the server; that one is
+// renamed when it has the fetched_at column only the client's has, so a
+// database shared with the server is left alone.
+func createQuotationTable(ctx context.Context) error {
+	var legacy int
+	err := db.QueryRowContext(ctx, `
+	SELECT COUNT(*) FROM pragma_table_info('cotacao') WHERE name = 'fetched_at'
+	AND NOT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'client_quotation')`).Scan(&legacy)
+	if err != nil {
+		return err
+	}
+	if legacy > 0 {
+		if _, err := db.ExecContext(ctx, "ALTER TABLE cotacao RENAME TO client_quotation"); err != nil {
+			return err
+		}
+		log.Println("Tabela cotacao renomeada para client_quotation.")
+	}
+	_, err = db.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS client_quotation(
+		pair TEXT NOT NULL,
+		bid TEXT NOT NULL,
+		fetched_at TEXT NOT NULL
+	)`)
+	return err
+}
+
+// saveQuotationToDB stores the bid of cotacao under pair, the pair that was
+// requested: without -full the server sends only the bid.
+func saveQuotationToDB(pair string, cotacao *upstream.Quotation, change variation) error {
+	ctx, cancel := context.WithTimeout(context.Background(), databaseTimeout)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx,
+		"INSERT INTO client_quotation(pair, bid, fetched_at) VALUES (?, ?, ?)",
+		pair,
+		cotacao.Bid,
+		time.Now().UTC().Format(time.RFC3339),
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "database is locked") {
+			err = fmt.Errorf("banco de dados %s em uso por outro processo: %w", databasePath, err)
+		} else {
+			err = fmt.Errorf("falha ao salvar dados no banco: %w", err)
+		}
+		return exitError{exitWriteFailure, err}
+	}
+	log.Println("Registro salvo no banco.", pair, cotacao.Bid, change)
+	return nil
+}
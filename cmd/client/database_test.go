@@ -0,0 +1,102 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+// openTestDatabase points -db at a new file, running setup on it before
+// startDatabase.
+func openTestDatabase(t *testing.T, setup string) {
+	t.Helper()
+	defer func(orig string) { databasePath = orig }(databasePath)
+	databasePath = filepath.Join(t.TempDir(), "client.db")
+	if setup != "" {
+		raw, err := sql.Open("sqlite3", databasePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := raw.Exec(setup); err != nil {
+			t.Fatal(err)
+		}
+		raw.Close()
+	}
+	if err := startDatabase(); err != nil {
+		t.Fatalf("startDatabase() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+}
+
+func countRows(t *testing.T, query string) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow(query).Scan(&n); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return n
+}
+
+func TestSaveQuotationToDBStoresRequestedPair(t *testing.T) {
+	openTestDatabase(t, "")
+	// Without -full the server sends only the bid.
+	if err := saveQuotationToDB("EUR-BRL", &upstream.Quotation{Bid: "5.4321"}, variation{}); err != nil {
+		t.Fatalf("saveQuotationToDB() error = %v", err)
+	}
+	var pair, bid string
+	if err := db.QueryRow("SELECT pair, bid FROM client_quotation").Scan(&pair, &bid); err != nil {
+		t.Fatal(err)
+	}
+	if pair != "EUR-BRL" || bid != "5.4321" {
+		t.Errorf("row = %s %s, want EUR-BRL 5.4321", pair, bid)
+	}
+}
+
+func TestStartDatabaseRenamesLegacyTable(t *testing.T) {
+	openTestDatabase(t, `
+	CREATE TABLE cotacao(pair TEXT NOT NULL, bid TEXT NOT NULL, fetched_at TEXT NOT NULL);
+	INSERT INTO cotacao VALUES ('USD-BRL', '5.1', '2026-01-01T00:00:00Z');`)
+	if n := countRows(t, "SELECT COUNT(*) FROM client_quotation"); n != 1 {
+		t.Errorf("client_quotation has %d rows, want the 1 legacy row", n)
+	}
+	if n := countRows(t, "SELECT COUNT(*) FROM sqlite_master WHERE name = 'cotacao'"); n != 0 {
+		t.Error("legacy cotacao table was kept")
+	}
+}
+
+func TestStartDatabaseKeepsServerTable(t *testing.T) {
+	openTestDatabase(t, `
+	CREATE TABLE cotacao(code TEXT, code_in TEXT, bid TEXT);
+	INSERT INTO cotacao VALUES ('USD', 'BRL', '5.1');`)
+	if n := countRows(t, "SELECT COUNT(*) FROM cotacao"); n != 1 {
+		t.Errorf("server cotacao table has %d rows, want 1", n)
+	}
+	if n := countRows(t, "SELECT COUNT(*) FROM client_quotation"); n != 0 {
+		t.Errorf("client_quotation has %d rows, want 0", n)
+	}
+}
+
+func TestRequestedPair(t *testing.T) {
+	tests := []struct {
+		server string
+		want   string
+	}{
+		{"http://localhost:8080/v1/cotacao", "USD-BRL"},
+		{"http://localhost:8080/v1/cotacao?pair=eur-brl", "EUR-BRL"},
+		{"http://localhost:8080/v1/cotacao/GBP-BRL", "GBP-BRL"},
+		{"http://localhost:8080/v1/cotacao/GBP-BRL/", "GBP-BRL"},
+		{"http://localhost:8080/v1/cotacao?pair=invalid", "USD-BRL"},
+		{"http://localhost:8080/v1/cotacao?pairs=EUR-BRL,GBP-BRL", "USD-BRL"},
+	}
+	defer func(orig string) { serverURL = orig }(serverURL)
+	for _, tt := range tests {
+		t.Run(tt.server, func(t *testing.T) {
+			serverURL = tt.server
+			if got := requestedPair(); got != tt.want {
+				t.Errorf("requestedPair() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+)
+
+// flagEnv maps each flag to the environment variable used when the flag isn't
+// given on the command line. The COTACAO_CLIENT_ prefix keeps them apart from
+// the server's COTACAO_ ones, so both binaries can share a shell.
+var flagEnv = map[string]string{
+	"rt":              "COTACAO_CLIENT_REQUEST_TIMEOUT",
+	"retries":         "COTACAO_CLIENT_RETRIES",
+	"stream":          "COTACAO_CLIENT_STREAM",
+	"full":            "COTACAO_CLIENT_FULL",
+	"url":             "COTACAO_CLIENT_URL",
+	"insecure":        "COTACAO_CLIENT_INSECURE",
+	"unix":            "COTACAO_CLIENT_UNIX",
+	"db":              "COTACAO_CLIENT_DB",
+	"also-file":       "COTACAO_CLIENT_ALSO_FILE",
+	"jsonl":           "COTACAO_CLIENT_JSONL",
+	"bench":           "COTACAO_CLIENT_BENCH",
+	"n":               "COTACAO_CLIENT_BENCH_REQUESTS",
+	"c":               "COTACAO_CLIENT_BENCH_CONCURRENCY",
+	"rotate":          "COTACAO_CLIENT_ROTATE",
+	"rotate-pattern":  "COTACAO_CLIENT_ROTATE_PATTERN",
+	"compress-after":  "COTACAO_CLIENT_COMPRESS_AFTER",
+	"time-layout":     "COTACAO_CLIENT_TIME_LAYOUT",
+	"lock-timeout":    "COTACAO_CLIENT_LOCK_TIMEOUT",
+	"max-age":         "COTACAO_CLIENT_MAX_AGE",
+	"alert-threshold": "COTACAO_CLIENT_ALERT_THRESHOLD",
+	"otlp-endpoint":   "COTACAO_CLIENT_OTLP_ENDPOINT",
+	"pairs":           "COTACAO_CLIENT_PAIRS",
+	"parallel":        "COTACAO_CLIENT_PARALLEL",
+	"api-key":         "COTACAO_CLIENT_API_KEY",
+}
+
+// annotateEnvUsage adds the environment variable of each flag to its usage.
+func annotateEnvUsage(fs *flag.FlagSet) {
+	fs.VisitAll(func(f *flag.Flag) {
+		if env, ok := flagEnv[f.Name]; ok {
+			f.Usage += " (env " + env + ")"
+		}
+	})
+}
+
+// applyEnv sets every flag that wasn't given on the command line from its
+// environment variable. Values go through the flag parsing, so they are
+// validated exactly like the command line ones.
+func applyEnv(fs *flag.FlagSet) {
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	fs.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(flagEnv[f.Name])
+		if given[f.Name] || !ok {
+			return
+		}
+		if err := fs.Set(f.Name, value); err != nil {
+			log.Fatalf("Invalid argument, %s=%q - %s\n", flagEnv[f.Name], value, f.Usage)
+		}
+	})
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// serverError is an error response sent by the server.
+type serverError struct {
+	resp Problem
+}
+
+func (e serverError) Error() string {
+	var msg string
+	switch e.code() {
+	case "upstream_timeout":
+		msg = "o serviço de cotações demorou demais para responder"
+	case "upstream_unavailable", "upstream_busy", "upstream_budget_exhausted", "upstream_error", "upstream_invalid_response":
+		msg = "o serviço de cotações está indisponível ou respondeu dados inválidos"
+	case "db_timeout", "db_unavailable", "db_write_failed":
+		msg = "o servidor não conseguiu salvar a cotação"
+	case "db_read_failed":
+		msg = "o servidor não conseguiu consultar as cotações armazenadas"
+	case "db_disabled":
+		msg = "a persistência está desativada no servidor"
+	case "server_unavailable":
+		msg = "o servidor está indisponível"
+	case "unauthorized":
+		msg = "chave de acesso ausente ou inválida, informe -api-key"
+	case "forbidden":
+		msg = "chave de acesso sem permissão ou revogada"
+	case "rate_limited":
+		msg = "limite de requisições excedido"
+	case "not_found":
+		msg = "recurso não encontrado no servidor"
+	case "method_not_allowed":
+		msg = "método não permitido pelo servidor"
+	default:
+		msg = "ocorreu um erro"
+	}
+	detail := e.resp.Detail
+	if detail == "" {
+		detail = e.resp.Title
+	}
+	info := fmt.Sprint("código: ", e.resp.Status)
+	if e.resp.Code != "" {
+		info += ", " + e.resp.Code
+	}
+	if e.resp.RequestID != "" {
+		// The id finds the request in the server logs.
+		info += ", requisição " + e.resp.RequestID
+	}
+	return fmt.Sprintf("%s: %s (%s)", msg, detail, info)
+}
+
+// code is the error code of the response. Bodies without one, like the
+// pages of a proxy, get it from the status: 504 is an upstream timeout, 502
+// an upstream failure and 503 an unavailable server.
+func (e serverError) code() string {
+	if e.resp.Code != "" {
+		return e.resp.Code
+	}
+	switch e.resp.Status {
+	case http.StatusGatewayTimeout:
+		return "upstream_timeout"
+	case http.StatusBadGateway:
+		return "upstream_error"
+	case http.StatusServiceUnavailable:
+		return "server_unavailable"
+	default:
+		return ""
+	}
+}
+
+// Exit codes reported to the shell:
+//
+//	0 success
+//	1 unexpected failure
+//	2 request timeout
+//	3 server returned an error response
+//	4 response could not be decoded
+//	5 quotation could not be written to the file or local database
+//	6 server could not reach the quotation service (upstream)
+//	7 server could not store the quotation
+//	8 some of the -pairs failed, the others were saved
+const (
+	exitSuccess         int = 0
+	exitFailure         int = 1
+	exitTimeout         int = 2
+	exitServerError     int = 3
+	exitDecodeFailure   int = 4
+	exitWriteFailure    int = 5
+	exitUpstreamFailure int = 6
+	exitStorageFailure  int = 7
+	exitPartialFailure  int = 8
+)
+
+// exitError tags err with the exit code main should report.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e exitError) Unwrap() error {
+	return e.err
+}
+
+// exitCode translates err into the process exit code.
+func exitCode(err error) int {
+	var exitErr exitError
+	if errors.As(err, &exitErr) {
+		return exitErr.code
+	}
+	var srvErr serverError
+	if !errors.As(err, &srvErr) {
+		return exitFailure
+	}
+	switch srvErr.code() {
+	case "upstream_timeout", "upstream_unavailable", "upstream_busy", "upstream_budget_exhausted", "upstream_error", "upstream_invalid_response":
+		return exitUpstreamFailure
+	case "db_timeout", "db_unavailable", "db_write_failed":
+		return exitStorageFailure
+	default:
+		return exitServerError
+	}
+}
+
+// retryableError marks failures worth another attempt: network errors,
+// timeouts and 5xx responses.
+type retryableError struct {
+	err error
+}
+
+func (e retryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e retryableError) Unwrap() error {
+	return e.err
+}
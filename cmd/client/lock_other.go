@@ -0,0 +1,32 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// lockFile creates a sidecar f.lock file, retrying until timeout, as there is
+// no flock here. A client killed while holding it leaves the file behind;
+// the timeout error names it so it can be removed.
+func lockFile(f *os.File, timeout time.Duration) (func(), error) {
+	name := f.Name() + ".lock"
+	deadline := time.Now().Add(timeout)
+	for {
+		lock, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0660)
+		if err == nil {
+			lock.Close()
+			return func() { os.Remove(name) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%w: %s", errLockTimeout, name)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
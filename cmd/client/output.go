@@ -0,0 +1,146 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	datePlaceholder   string        = "{date}"
+	dateLayout        string        = "2006-01-02"
+	lockRetryInterval time.Duration = 20 * time.Millisecond
+)
+
+// errLockTimeout reports that another process held the output file lock for
+// longer than -lock-timeout. lockFile wraps it with the path of the lock.
+var errLockTimeout = errors.New("tempo de espera pelo lock esgotado")
+
+// outputFile produces the writer each quotation line is appended to. The
+// writer is opened per line, so a rotating implementation switches files
+// between two lines without any coordination.
+type outputFile interface {
+	open() (io.WriteCloser, error)
+	// name is the file the next line goes to.
+	name() string
+}
+
+func appendFile(name string) (io.WriteCloser, error) {
+	return os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0660)
+}
+
+// fixedOutput always appends to the same file.
+type fixedOutput struct {
+	file string
+}
+
+func (o fixedOutput) open() (io.WriteCloser, error) {
+	return appendFile(o.file)
+}
+
+func (o fixedOutput) name() string {
+	return o.file
+}
+
+// dailyOutput appends to one file per local day, named by replacing {date} in
+// pattern. Files older than compressAfter days are gzipped when the day
+// changes; zero keeps them as they are.
+type dailyOutput struct {
+	pattern       string
+	compressAfter int
+	now           func() time.Time
+
+	mu      sync.Mutex
+	current string
+}
+
+func newDailyOutput(pattern string, compressAfter int) *dailyOutput {
+	return &dailyOutput{
+		pattern:       pattern,
+		compressAfter: compressAfter,
+		now:           time.Now,
+	}
+}
+
+func (o *dailyOutput) open() (io.WriteCloser, error) {
+	today := o.now()
+	name := o.fileName(today)
+
+	o.mu.Lock()
+	changed := name != o.current
+	o.current = name
+	o.mu.Unlock()
+	if changed && o.compressAfter > 0 {
+		o.compressOld(today)
+	}
+	return appendFile(name)
+}
+
+func (o *dailyOutput) name() string {
+	return o.fileName(o.now())
+}
+
+func (o *dailyOutput) fileName(day time.Time) string {
+	return strings.Replace(o.pattern, datePlaceholder, day.Format(dateLayout), 1)
+}
+
+// compressOld gzips the rotated files older than compressAfter days. Failures
+// are only logged: they must not stop the quotation from being saved.
+func (o *dailyOutput) compressOld(today time.Time) {
+	prefix, suffix, _ := strings.Cut(o.pattern, datePlaceholder)
+	matches, err := filepath.Glob(prefix + "*" + suffix)
+	if err != nil {
+		log.Println("Rotação - padrão de arquivo inválido:", err)
+		return
+	}
+	midnight := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, today.Location())
+	limit := midnight.AddDate(0, 0, -o.compressAfter)
+	for _, name := range matches {
+		raw := strings.TrimSuffix(strings.TrimPrefix(name, prefix), suffix)
+		day, err := time.ParseInLocation(dateLayout, raw, today.Location())
+		if err != nil || !day.Before(limit) {
+			continue
+		}
+		if err := gzipFile(name); err != nil {
+			log.Printf("Rotação - falha ao compactar %s: %v\n", name, err)
+			continue
+		}
+		log.Println("Rotação - arquivo compactado:", name+".gz")
+	}
+}
+
+// gzipFile replaces name with name.gz.
+func gzipFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0660)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	zw.Name = filepath.Base(name)
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(name + ".gz")
+		return fmt.Errorf("falha ao compactar: %w", err)
+	}
+	src.Close()
+	return os.Remove(name)
+}
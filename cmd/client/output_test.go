@@ -0,0 +1,179 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClock is a settable now for dailyOutput.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+// brt is UTC-3, so a late evening is already the next day in UTC.
+var brt = time.FixedZone("BRT", -3*60*60)
+
+func newTestDailyOutput(t *testing.T, compressAfter int, at time.Time) (*dailyOutput, *fakeClock, string) {
+	t.Helper()
+	dir := t.TempDir()
+	o := newDailyOutput(filepath.Join(dir, "cotacao-{date}.txt"), compressAfter)
+	clock := &fakeClock{t: at}
+	o.now = clock.now
+	return o, clock, dir
+}
+
+func writeLine(t *testing.T, o outputFile, line string) {
+	t.Helper()
+	w, err := o.open()
+	if err != nil {
+		t.Fatalf("open() error = %v", err)
+	}
+	if _, err := io.WriteString(w, line+"\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDailyOutputRotatesAtMidnight(t *testing.T) {
+	o, clock, dir := newTestDailyOutput(t, 0, time.Date(2024, 6, 1, 23, 59, 58, 0, brt))
+
+	if got, want := o.name(), filepath.Join(dir, "cotacao-2024-06-01.txt"); got != want {
+		t.Errorf("name() = %q, want %q, the local date", got, want)
+	}
+	writeLine(t, o, "Dólar: 5.1")
+	clock.t = clock.t.Add(time.Second)
+	writeLine(t, o, "Dólar: 5.2")
+	clock.t = clock.t.Add(2 * time.Second)
+	writeLine(t, o, "Dólar: 5.3")
+
+	tests := []struct{ file, want string }{
+		{"cotacao-2024-06-01.txt", "Dólar: 5.1\nDólar: 5.2"},
+		{"cotacao-2024-06-02.txt", "Dólar: 5.3"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(readOutput(t, filepath.Join(dir, tt.file)), "\n"); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.file, got, tt.want)
+		}
+	}
+	if got := o.name(); got != filepath.Join(dir, "cotacao-2024-06-02.txt") {
+		t.Errorf("name() after midnight = %q", got)
+	}
+}
+
+// TestDailyOutputReusesTodaysFile runs two invocations on the same day, each
+// with its own dailyOutput.
+func TestDailyOutputReusesTodaysFile(t *testing.T) {
+	first, _, dir := newTestDailyOutput(t, 0, time.Date(2024, 6, 1, 9, 0, 0, 0, brt))
+	writeLine(t, first, "Dólar: 5.1")
+
+	second := newDailyOutput(first.pattern, 0)
+	second.now = (&fakeClock{t: time.Date(2024, 6, 1, 18, 0, 0, 0, brt)}).now
+	writeLine(t, second, "Dólar: 5.2")
+
+	if got, want := strings.Join(readOutput(t, filepath.Join(dir, "cotacao-2024-06-01.txt")), "\n"), "Dólar: 5.1\nDólar: 5.2"; got != want {
+		t.Errorf("day file = %q, want %q", got, want)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "*")); len(matches) != 1 {
+		t.Errorf("files = %v, want only the day file", matches)
+	}
+}
+
+func TestDailyOutputCompressAfter(t *testing.T) {
+	o, clock, dir := newTestDailyOutput(t, 7, time.Date(2024, 6, 10, 8, 0, 0, 0, brt))
+	for _, name := range []string{
+		"cotacao-2024-05-31.txt", // 10 days old
+		"cotacao-2024-06-02.txt", // 8 days old
+		"cotacao-2024-06-03.txt", // 7 days old, kept
+		"cotacao-2024-06-09.txt",
+		"cotacao-latest.txt", // no date, ignored
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name+"\n"), 0660); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeLine(t, o, "Dólar: 5.1")
+
+	wantFiles := func(names ...string) {
+		t.Helper()
+		for _, name := range names {
+			if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+				t.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+	wantFiles("cotacao-2024-05-31.txt.gz", "cotacao-2024-06-02.txt.gz", "cotacao-2024-06-03.txt",
+		"cotacao-2024-06-09.txt", "cotacao-2024-06-10.txt", "cotacao-latest.txt")
+	for _, name := range []string{"cotacao-2024-05-31.txt", "cotacao-2024-06-02.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s still there after compression: %v", name, err)
+		}
+	}
+
+	f, err := os.Open(filepath.Join(dir, "cotacao-2024-05-31.txt.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(zr)
+	if err != nil || string(content) != "cotacao-2024-05-31.txt\n" || zr.Name != "cotacao-2024-05-31.txt" {
+		t.Errorf("gzip member %q = %q, %v", zr.Name, content, err)
+	}
+
+	// Only a change of day compresses: the next file to cross the limit waits
+	// for midnight.
+	clock.t = time.Date(2024, 6, 10, 23, 0, 0, 0, brt)
+	writeLine(t, o, "Dólar: 5.2")
+	wantFiles("cotacao-2024-06-03.txt")
+	clock.t = time.Date(2024, 6, 11, 0, 0, 1, 0, brt)
+	writeLine(t, o, "Dólar: 5.3")
+	wantFiles("cotacao-2024-06-03.txt.gz", "cotacao-2024-06-09.txt", "cotacao-2024-06-10.txt", "cotacao-2024-06-11.txt")
+}
+
+func TestDailyOutputCompressDisabled(t *testing.T) {
+	o, _, dir := newTestDailyOutput(t, 0, time.Date(2024, 6, 10, 8, 0, 0, 0, brt))
+	old := filepath.Join(dir, "cotacao-2020-01-01.txt")
+	if err := os.WriteFile(old, []byte("old\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	writeLine(t, o, "Dólar: 5.1")
+	if _, err := os.Stat(old); err != nil {
+		t.Errorf("compress-after 0 touched %s: %v", old, err)
+	}
+}
+
+func TestLockFileTimeout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cotacao.txt")
+	open := func() *os.File {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0660)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+		return f
+	}
+	unlock, err := lockFile(open(), time.Second)
+	if err != nil {
+		t.Fatalf("lockFile() error = %v", err)
+	}
+	defer unlock()
+
+	_, err = lockFile(open(), 50*time.Millisecond)
+	if !errors.Is(err, errLockTimeout) {
+		t.Fatalf("lockFile() error = %v, want %v", err, errLockTimeout)
+	}
+	if !strings.Contains(err.Error(), path) {
+		t.Errorf("lockFile() error = %q, want the path %s", err, path)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/twsm000/goxp-client-server-api/internal/tracing"
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+// pairResult is the outcome of fetching one of the -pairs.
+type pairResult struct {
+	pair      string
+	quotation upstream.Quotation
+	fetchedAt time.Time
+	err       error
+}
+
+// pairEntry is the value of a pair in the combined JSON Lines record: its
+// record, or the error that prevented fetching it.
+type pairEntry struct {
+	*upstream.Record
+	Error string `json:"error,omitempty"`
+}
+
+// fetchPairs requests every pair of -pairs concurrently, at most -parallel
+// at a time, then writes a single combined record from this goroutine, so
+// the lines of different pairs never interleave. The pairs that failed are
+// reported and left out of the local database.
+func fetchPairs() error {
+	results := make([]pairResult, len(pairs))
+	slots := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, pair := range pairs {
+		wg.Add(1)
+		go func(i int, pair string) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			results[i] = fetchPair(pair)
+		}(i, pair)
+	}
+	wg.Wait()
+
+	var (
+		failed   int
+		firstErr error
+	)
+	for _, res := range results {
+		if res.err != nil {
+			log.Printf("%s - %v\n", res.pair, res.err)
+			failed++
+			if firstErr == nil {
+				firstErr = res.err
+			}
+		}
+	}
+	if err := savePairs(results); err != nil {
+		return err
+	}
+	switch {
+	case failed == 0:
+		return nil
+	case failed == len(results):
+		return firstErr
+	default:
+		err := fmt.Errorf("%d de %d pares falharam", failed, len(results))
+		return exitError{exitPartialFailure, err}
+	}
+}
+
+// fetchPair requests pair with the retries of -retries, each attempt under
+// its own -rt timeout.
+func fetchPair(pair string) pairResult {
+	res := pairResult{pair: pair}
+	res.err = withRetries(func() error {
+		var err error
+		res.quotation, err = requestPair(pair)
+		res.fetchedAt = time.Now()
+		return err
+	})
+	return res
+}
+
+func requestPair(pair string) (q upstream.Quotation, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	query := url.Values{"pairs": {pair}}
+	if fullMode {
+		query.Set("full", "true")
+	}
+	endpoint, err := quotationURL(query)
+	if err != nil {
+		return q, err
+	}
+	ctx, span := tracer.Start(ctx, "GET /cotacao", tracing.KindClient)
+	span.SetAttribute("http.url", endpoint)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	req, err := newQuotationRequest(ctx, endpoint)
+	if err != nil {
+		return q, err
+	}
+	resp, err := sendRequest(req)
+	if err != nil {
+		return q, err
+	}
+	defer resp.Body.Close()
+	span.SetAttribute("http.status_code", resp.StatusCode)
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return q, retryableError{handleError(resp)}
+	default:
+		return q, handleError(resp)
+	}
+	warnIfStale(resp.Header)
+
+	var body map[string]upstream.Quotation
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	q = body[pair]
+	if err == nil && q.Bid == "" {
+		err = upstream.ErrMissingBid
+	}
+	if err != nil {
+		return q, exitError{exitDecodeFailure, fmt.Errorf("falha ao decodificar corpo da requisição: %w", err)}
+	}
+	// Without -full the server sends only the bid; the pair still has to
+	// reach the local database.
+	if q.Code == "" || q.CodeIn == "" {
+		q.Code, q.CodeIn, _ = strings.Cut(pair, "-")
+	}
+	return q, nil
+}
+
+// savePairs writes the combined record of a -pairs run: a row per fetched
+// pair in the local database, an object keyed by pair in the JSON Lines file
+// and a line per pair, failures included, in the text file.
+func savePairs(results []pairResult) error {
+	if databasePath != "" {
+		for _, res := range results {
+			if res.err != nil {
+				continue
+			}
+			if err := saveQuotationToDB(res.pair, &res.quotation, variation{}); err != nil {
+				return err
+			}
+		}
+	}
+	if jsonlPath != "" {
+		if err := savePairsToJSONL(results); err != nil {
+			return err
+		}
+	}
+	if (databasePath == "" && jsonlPath == "") || alsoFile {
+		return savePairsToFile(results)
+	}
+	return nil
+}
+
+func savePairsToJSONL(results []pairResult) error {
+	record := make(map[string]pairEntry, len(results))
+	for _, res := range results {
+		if res.err != nil {
+			record[res.pair] = pairEntry{Error: res.err.Error()}
+			continue
+		}
+		record[res.pair] = pairEntry{Record: &upstream.Record{Quotation: res.quotation, FetchedAt: res.fetchedAt.UTC()}}
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return exitError{exitWriteFailure, fmt.Errorf("falha ao codificar cotações: %w", err)}
+	}
+	err = appendJSONL(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+	log.Println("Registro salvo em", jsonlPath, strings.Join(pairs, ","))
+	return nil
+}
+
+func savePairsToFile(results []pairResult) error {
+	var prefix string
+	if lineLayout != "" {
+		prefix = time.Now().Format(lineLayout) + " | "
+	}
+	var text strings.Builder
+	for _, res := range results {
+		line := fmt.Sprint(res.pair, ": ", res.quotation.Bid)
+		if res.err != nil {
+			var srvErr serverError
+			msg := res.err.Error()
+			if errors.As(res.err, &srvErr) {
+				msg = srvErr.code()
+			}
+			line = fmt.Sprint(res.pair, ": falha (", msg, ")")
+		} else if fullMode {
+			line += fullDetails(&res.quotation)
+		}
+		text.WriteString(prefix + line + "\n")
+	}
+	err := appendOutput(text.String())
+	if err != nil {
+		return err
+	}
+	log.Print("Registro salvo em disco.\n", text.String())
+	return nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+const (
+	// lastLineWindow is how much of the end of a file is read to find its
+	// last line, comfortably more than a quotation line.
+	lastLineWindow int64 = 4 << 10
+	// unitsPerBasisPoint converts a percentage in Decimal units to
+	// hundredths of a percent.
+	unitsPerBasisPoint int64 = 1_000_000
+)
+
+// previousBid is the last saved bid, the base of the variation shown next to
+// each new quotation. Zero means there is no history yet.
+var previousBid upstream.Decimal
+
+// variation is the change of the bid since the previous saved quotation, in
+// units and in hundredths of a percent.
+type variation struct {
+	delta       upstream.Decimal
+	basisPoints int64
+	known       bool
+}
+
+// newVariation computes the change from prev to bid with integer math, so
+// "5.4321 - 5.4306" is exactly 0.0015. A zero prev gives an unknown
+// variation.
+func newVariation(prev, bid upstream.Decimal) variation {
+	if prev <= 0 {
+		return variation{}
+	}
+	delta := bid - prev
+	// Rounded half away from zero.
+	bp := int64(delta) * 20000 / int64(prev)
+	if bp < 0 {
+		bp--
+	} else {
+		bp++
+	}
+	return variation{delta: delta, basisPoints: bp / 2, known: true}
+}
+
+// String formats v like "(+0.0015 / +0.03%)", or "" when unknown.
+func (v variation) String() string {
+	if !v.known {
+		return ""
+	}
+	sign, bp := "+", v.basisPoints
+	if v.delta < 0 {
+		sign = ""
+	}
+	pctSign := "+"
+	if bp < 0 {
+		pctSign, bp = "-", -bp
+	}
+	return fmt.Sprintf("(%s%s / %s%d.%02d%%)", sign, v.delta, pctSign, bp/100, bp%100)
+}
+
+// exceeds reports whether the absolute variation reached thresholdBP
+// hundredths of a percent. A zero threshold never alerts.
+func (v variation) exceeds(thresholdBP int64) bool {
+	bp := v.basisPoints
+	if bp < 0 {
+		bp = -bp
+	}
+	return v.known && thresholdBP > 0 && bp >= thresholdBP
+}
+
+// parseThreshold reads -alert-threshold, a percentage like "0.5%", into
+// hundredths of a percent. Empty disables the alert.
+func parseThreshold(raw string) (int64, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	pct, err := upstream.ParseDecimal(strings.TrimSuffix(raw, "%"))
+	if err != nil || pct < 0 {
+		return 0, fmt.Errorf("limite inválido: %q", raw)
+	}
+	return int64(pct) / unitsPerBasisPoint, nil
+}
+
+// loadPreviousBid reads the last saved bid from the destination selected by
+// the flags: the local database, the JSON Lines file or the text file.
+// Without history, or when it can't be read, the first quotation is shown
+// without a variation.
+func loadPreviousBid() {
+	var (
+		raw string
+		err error
+	)
+	switch {
+	case databasePath != "":
+		raw, err = lastBidFromDB()
+	case jsonlPath != "":
+		raw, err = lastBidFromJSONL(jsonlPath)
+	default:
+		raw, err = lastBidFromFile(output.name())
+	}
+	if err == nil && raw != "" {
+		previousBid, err = upstream.ParseDecimal(raw)
+	}
+	if err != nil {
+		log.Println("Falha ao ler a última cotação salva, variação indisponível:", err)
+	}
+}
+
+func lastBidFromDB() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), databaseTimeout)
+	defer cancel()
+
+	var bid string
+	err := db.QueryRowContext(ctx, "SELECT bid FROM cotacao ORDER BY rowid DESC LIMIT 1").Scan(&bid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return bid, err
+}
+
+func lastBidFromJSONL(name string) (string, error) {
+	line, err := lastLine(name)
+	if err != nil || line == "" {
+		return "", err
+	}
+	var rec upstream.Record
+	if err := json.Unmarshal([]byte(line), &rec); err != nil {
+		return "", fmt.Errorf("última linha de %s inválida: %w", name, err)
+	}
+	return rec.Bid, nil
+}
+
+// lastBidFromFile finds the bid in the last line of the text file, written
+// by saveQuotationToFile as "... Dólar: 5.4321 ...".
+func lastBidFromFile(name string) (string, error) {
+	line, err := lastLine(name)
+	if err != nil || line == "" {
+		return "", err
+	}
+	_, after, found := strings.Cut(line, "Dólar: ")
+	if !found {
+		return "", fmt.Errorf("última linha de %s sem cotação: %q", name, line)
+	}
+	bid, _, _ := strings.Cut(after, " ")
+	return bid, nil
+}
+
+// lastLine returns the last non empty line of the file, "" when the file
+// doesn't exist or is empty. Only the end of the file is read.
+func lastLine(name string) (string, error) {
+	f, err := os.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	offset := info.Size() - lastLineWindow
+	if offset < 0 {
+		offset = 0
+	}
+	tail, err := io.ReadAll(io.NewSectionReader(f, offset, info.Size()-offset))
+	if err != nil {
+		return "", err
+	}
+	tail = bytes.TrimRight(tail, "\r\n")
+	if i := bytes.LastIndexByte(tail, '\n'); i >= 0 {
+		tail = tail[i+1:]
+	}
+	return string(tail), nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// acmeDomainName is a fully qualified host name: the CA issues neither for
+// single labels nor for IP addresses.
+var acmeDomainName = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]*[a-z0-9])?\.)+[a-z]([a-z0-9-]*[a-z0-9])?$`)
+
+// parseACMEDomains splits -acme-domain into the lowercase names of the
+// certificate, dropping repetitions.
+func parseACMEDomains(raw string) ([]string, error) {
+	var domains []string
+	seen := make(map[string]bool)
+	for _, d := range strings.Split(raw, ",") {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if !acmeDomainName.MatchString(d) {
+			return nil, fmt.Errorf("domínio inválido: %q", d)
+		}
+		if !seen[d] {
+			seen[d] = true
+			domains = append(domains, d)
+		}
+	}
+	return domains, nil
+}
+
+// newACMEManager builds the autocert manager of -acme-domain, issuing from
+// -acme-directory after accepting its terms of service and keeping the
+// account key and the certificates in -acme-cache. cfg is already validated.
+func newACMEManager(cfg *Config) (*autocert.Manager, error) {
+	domains, _ := parseACMEDomains(cfg.ACMEDomain)
+	if err := os.MkdirAll(cfg.ACMECache, 0o700); err != nil {
+		return nil, fmt.Errorf("%v - %s", err, acmeCacheUsage)
+	}
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cfg.ACMECache),
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Email:      cfg.ACMEEmail,
+		Client:     &acme.Client{DirectoryURL: cfg.ACMEDirectory},
+	}, nil
+}
+
+// startACMEServer serves the HTTP-01 challenges of acmeManager on
+// acmeHTTPAddr, redirecting everything else to HTTPS, until ctx is done. It
+// does nothing unless -acme-domain is set.
+func startACMEServer(ctx context.Context) {
+	if acmeManager == nil {
+		return
+	}
+
+	server := &http.Server{Addr: acmeHTTPAddr, Handler: acmeManager.HTTPHandler(http.HandlerFunc(redirectHTTPS))}
+
+	backgroundJobs.Add(2)
+	go func() {
+		defer backgroundJobs.Done()
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("Falha ao encerrar servidor HTTP do ACME", "err", err)
+		}
+	}()
+	go func() {
+		defer backgroundJobs.Done()
+		slog.Info("Desafios ACME e redirecionamento para HTTPS", "addr", acmeHTTPAddr)
+		err := server.ListenAndServe()
+		if !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Servidor HTTP do ACME falhou", "err", err)
+		}
+	}()
+}
+
+// redirectHTTPS sends the request to the same URL over HTTPS, on the port
+// of the main listener.
+func redirectHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if _, port, err := net.SplitHostPort(serverListenAddr); err == nil && port != "443" {
+		host = net.JoinHostPort(host, port)
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+}
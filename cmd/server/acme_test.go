@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseACMEDomains(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    []string
+		wantErr bool
+	}{
+		{"cotacao.example.com", []string{"cotacao.example.com"}, false},
+		{"Cotacao.Example.com, www.cotacao.example.com", []string{"cotacao.example.com", "www.cotacao.example.com"}, false},
+		{"cotacao.example.com,cotacao.example.com", []string{"cotacao.example.com"}, false},
+		{"localhost", nil, true},
+		{"192.0.2.1", nil, true},
+		{"*.example.com", nil, true},
+		{"cotacao.example.com,", nil, true},
+		{"-bad.example.com", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, err := parseACMEDomains(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseACMEDomains(%q) error = %v, wantErr %v", tt.raw, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseACMEDomains(%q) = %v, want %v", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewACMEManager(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.ACMEDomain = "cotacao.example.com,www.cotacao.example.com"
+	cfg.ACMEDirectory = "https://acme-staging-v02.api.letsencrypt.org/directory"
+	cfg.ACMEEmail = "ops@example.com"
+	cfg.ACMECache = filepath.Join(t.TempDir(), "acme")
+	m, err := newACMEManager(cfg)
+	if err != nil {
+		t.Fatalf("newACMEManager() error = %v", err)
+	}
+	if info, err := os.Stat(cfg.ACMECache); err != nil || !info.IsDir() || info.Mode().Perm() != 0o700 {
+		t.Errorf("cache directory = %v, %v; want a 0700 directory", info, err)
+	}
+	if m.Client.DirectoryURL != cfg.ACMEDirectory || m.Email != cfg.ACMEEmail {
+		t.Errorf("manager directory %q email %q", m.Client.DirectoryURL, m.Email)
+	}
+	for host, allowed := range map[string]bool{
+		"cotacao.example.com":     true,
+		"www.cotacao.example.com": true,
+		"evil.example.com":        false,
+	} {
+		if err := m.HostPolicy(context.Background(), host); (err == nil) != allowed {
+			t.Errorf("HostPolicy(%q) error = %v, want allowed %v", host, err, allowed)
+		}
+	}
+}
+
+func TestACMEHTTPHandler(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.ACMEDomain = "cotacao.example.com"
+	cfg.ACMECache = t.TempDir()
+	m, err := newACMEManager(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := m.HTTPHandler(http.HandlerFunc(redirectHTTPS))
+	defer func(orig string) { serverListenAddr = orig }(serverListenAddr)
+
+	tests := []struct {
+		name, listen, host, target string
+		wantStatus                 int
+		wantLocation               string
+	}{
+		{"redirect", ":443", "cotacao.example.com", "/cotacao?pair=EUR-BRL", http.StatusPermanentRedirect, "https://cotacao.example.com/cotacao?pair=EUR-BRL"},
+		{"redirect to another port", ":8443", "cotacao.example.com:80", "/cotacao", http.StatusPermanentRedirect, "https://cotacao.example.com:8443/cotacao"},
+		{"unknown challenge token", ":443", "cotacao.example.com", "/.well-known/acme-challenge/token", http.StatusNotFound, ""},
+		{"challenge for another host", ":443", "evil.example.com", "/.well-known/acme-challenge/token", http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverListenAddr = tt.listen
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			r.Host = tt.host
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultRequestLogLimit int   = 100
+	maxRequestLogLimit     int   = 1000
+	maxConfigPatchSize     int64 = 64 << 10
+)
+
+// requireAdminKey only lets through requests carrying adminKey, or a JWT with
+// the admin scope when -jwt-* is set, either in the X-API-Key header or as an
+// Authorization bearer token. A valid API key is answered 403: it is known,
+// just not allowed here. With -tls-client-ca, a verified client certificate
+// is required too.
+func requireAdminKey(next http.Handler) http.Handler {
+	return requireClientCert(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := requestAPIKey(r)
+		if adminKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(adminKey)) == 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err := authorize(r, scopeAdmin); err != nil {
+			sendAuthError(w, r, err, "admin")
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// adminRequestsHandler serves GET /admin/requests?limit=100, the newest rows
+// of the audit log.
+func adminRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	limit := defaultRequestLogLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 || n > maxRequestLogLimit {
+			msg := fmt.Sprintf("GET /admin/requests - limit deve estar entre 1 e %d: %q", maxRequestLogLimit, raw)
+			sendMsgError(w, codeBadRequest, msg, http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	entries, err := store.recentRequestLog(r.Context(), limit)
+	if err != nil {
+		statusCode, code := dbErrorStatus(err, codeDBReadFailed)
+		msg := fmt.Sprint("GET /admin/requests - ", err)
+		sendMsgError(w, code, msg, statusCode)
+		return
+	}
+
+	err = sendJSON(w, http.StatusOK, entries)
+	if err != nil {
+		slog.WarnContext(r.Context(), "GET /admin/requests - falha ao enviar resposta", "err", err)
+	}
+}
+
+// RollupResponse reports how many days a manual rollup consolidated.
+type RollupResponse struct {
+	Days int `json:"days"`
+}
+
+// adminRollupHandler serves POST /admin/rollup, consolidating the completed
+// days without waiting for the background job.
+func adminRollupHandler(w http.ResponseWriter, r *http.Request) {
+	days, err := store.rollupDays(r.Context(), time.Now())
+	if err != nil {
+		statusCode, code := dbErrorStatus(err, codeDBWriteFailed)
+		msg := fmt.Sprint("POST /admin/rollup - ", err)
+		sendMsgError(w, code, msg, statusCode)
+		return
+	}
+
+	err = sendJSON(w, http.StatusOK, RollupResponse{Days: days})
+	if err != nil {
+		slog.WarnContext(r.Context(), "POST /admin/rollup - falha ao enviar resposta", "err", err)
+	}
+}
+
+// adminConfigHandler serves GET /admin/config, the effective configuration
+// with secrets redacted, and PATCH /admin/config, which changes the
+// mutableSettings from a partial document like {"cache_ttl": "30s"} and
+// answers with the resulting configuration.
+func adminConfigHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPatch {
+		var patch map[string]json.RawMessage
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxConfigPatchSize)).Decode(&patch)
+		if err != nil {
+			msg := fmt.Sprint("PATCH /admin/config - documento inválido: ", err)
+			sendMsgError(w, codeBadRequest, msg, http.StatusBadRequest)
+			return
+		}
+		_, err = updateSettings(r.Context(), patch, r.RemoteAddr)
+		var settingsErr settingsError
+		if errors.As(err, &settingsErr) {
+			msg := fmt.Sprint("PATCH /admin/config - ", err)
+			sendMsgError(w, codeBadRequest, msg, http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			statusCode, code := dbErrorStatus(err, codeDBWriteFailed)
+			msg := fmt.Sprint("PATCH /admin/config - falha ao registrar alteração: ", err)
+			sendMsgError(w, code, msg, statusCode)
+			return
+		}
+	}
+
+	err := sendJSON(w, http.StatusOK, currentSettings().config.redacted())
+	if err != nil {
+		slog.WarnContext(r.Context(), r.Method+" /admin/config - falha ao enviar resposta", "err", err)
+	}
+}
+
+// adminReloadHandler serves POST /admin/reload, which reloads the settings
+// like SIGHUP does and answers with the resulting configuration.
+func adminReloadHandler(w http.ResponseWriter, r *http.Request) {
+	_, err := reloadSettings(r.Context(), r.RemoteAddr)
+	var settingsErr settingsError
+	if errors.As(err, &settingsErr) {
+		msg := fmt.Sprint("POST /admin/reload - ", err)
+		sendMsgError(w, codeBadRequest, msg, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		statusCode, code := dbErrorStatus(err, codeDBWriteFailed)
+		msg := fmt.Sprint("POST /admin/reload - falha ao registrar alteração: ", err)
+		sendMsgError(w, code, msg, statusCode)
+		return
+	}
+
+	err = sendJSON(w, http.StatusOK, currentSettings().config.redacted())
+	if err != nil {
+		slog.WarnContext(r.Context(), "POST /admin/reload - falha ao enviar resposta", "err", err)
+	}
+}
@@ -0,0 +1,622 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+const (
+	maxAlertRules     int           = 50
+	maxAlertBodySize  int64         = 4 << 10
+	alertSecretBytes  int           = 24
+	alertAttempts     int           = 4
+	alertBackoff      time.Duration = time.Second
+	alertTimeout      time.Duration = 5 * time.Second
+	alertSignatureHdr string        = "X-Cotacao-Signature"
+)
+
+// alertConditionPattern is the grammar of AlertRule.Condition: a field, a
+// comparison and a threshold, a percentage for pctChange.
+var alertConditionPattern = regexp.MustCompile(`^\s*(bid|ask|pctChange)\s*(>=|<=|>|<)\s*(-?\d+(?:\.\d+)?)\s*(%?)\s*$`)
+
+// errBlockedWebhook rejects a webhook resolving to an address of the server
+// network, which would let any caller reach internal services through it.
+var errBlockedWebhook = errors.New("webhook_url aponta para um endereço interno")
+
+// blockedWebhookNets are the ranges, beyond the loopback, private,
+// link-local and unspecified ones, that webhooks can't reach: "this
+// network" and the carrier-grade NAT space.
+var blockedWebhookNets = []*net.IPNet{
+	{IP: net.IPv4(0, 0, 0, 0), Mask: net.CIDRMask(8, 32)},
+	{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)},
+}
+
+// alertClient delivers the alerts. The address is checked after the name
+// is resolved, on every connection, redirects included, so a host that
+// resolves to an internal address, even only at delivery time, is refused.
+// It doesn't go through HTTP_PROXY, which would hide the address.
+var alertClient = &http.Client{
+	Timeout: alertTimeout,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: alertTimeout, Control: checkWebhookAddr}).DialContext,
+		TLSHandshakeTimeout: alertTimeout,
+		MaxIdleConns:        10,
+		IdleConnTimeout:     90 * time.Second,
+	},
+}
+
+// checkWebhookAddr is the net.Dialer Control of alertClient, refusing the
+// addresses of blockedWebhookIP.
+func checkWebhookAddr(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || blockedWebhookIP(ip) {
+		return fmt.Errorf("%w: %s", errBlockedWebhook, host)
+	}
+	return nil
+}
+
+// blockedWebhookIP reports whether ip is internal: loopback, private,
+// link-local, which covers the 169.254.169.254 metadata endpoint of the
+// clouds, unspecified, multicast or one of blockedWebhookNets.
+func blockedWebhookIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return true
+	}
+	for _, n := range blockedWebhookNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkWebhookHost refuses at registration a webhook_url naming an internal
+// address or localhost outright. Names are only checked at delivery.
+func checkWebhookHost(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if ip := net.ParseIP(host); (ip != nil && blockedWebhookIP(ip)) || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return errBlockedWebhook
+	}
+	return nil
+}
+
+// AlertRule fires its webhook when a polled quotation of Pair meets
+// Condition, like "bid > 5.40" or "pctChange < -1%". It fires once when the
+// condition starts to hold, at TriggeredAt, and is armed again once a
+// quotation fails it. Notifier picks what the webhook gets: the signed
+// AlertEvent ("webhook"), or Template rendered as a Slack or Discord
+// message. Rules belong to the API key or JWT subject that created them,
+// which /alerts always requires.
+type AlertRule struct {
+	ID          int64      `json:"id"`
+	Pair        string     `json:"pair"`
+	Condition   string     `json:"condition"`
+	WebhookURL  string     `json:"webhook_url"`
+	Notifier    string     `json:"notifier"`
+	Template    string     `json:"template,omitempty"`
+	Enabled     bool       `json:"enabled"`
+	CreatedAt   time.Time  `json:"created_at"`
+	TriggeredAt *time.Time `json:"triggered_at,omitempty"`
+
+	owner  string
+	secret string
+}
+
+// CreatedAlertRule is the answer to POST /alerts, the only time the secret
+// that signs the deliveries is shown.
+type CreatedAlertRule struct {
+	AlertRule
+	Secret string `json:"secret"`
+}
+
+// AlertEvent is the body POSTed to the webhook of a rule, and the data of
+// its Template. DeliveryID is the same on every attempt, so receivers can
+// drop duplicates. Message is the rendered Template.
+type AlertEvent struct {
+	DeliveryID  string             `json:"delivery_id"`
+	RuleID      int64              `json:"rule_id"`
+	Pair        string             `json:"pair"`
+	Condition   string             `json:"condition"`
+	TriggeredAt time.Time          `json:"triggered_at"`
+	Quotation   upstream.Quotation `json:"quotation"`
+	Message     string             `json:"message"`
+}
+
+// alertCondition is a parsed AlertRule.Condition.
+type alertCondition struct {
+	field     string
+	op        string
+	threshold upstream.Decimal
+}
+
+func parseAlertCondition(raw string) (alertCondition, error) {
+	m := alertConditionPattern.FindStringSubmatch(raw)
+	if m == nil {
+		return alertCondition{}, fmt.Errorf("condição inválida, use por exemplo \"bid > 5.40\" ou \"pctChange < -1%%\": %q", raw)
+	}
+	if m[4] == "%" && m[1] != "pctChange" {
+		return alertCondition{}, fmt.Errorf("%% só vale para pctChange: %q", raw)
+	}
+	threshold, err := upstream.ParseDecimal(m[3])
+	if err != nil {
+		return alertCondition{}, fmt.Errorf("limite inválido: %w", err)
+	}
+	return alertCondition{field: m[1], op: m[2], threshold: threshold}, nil
+}
+
+func (c alertCondition) String() string {
+	s := fmt.Sprint(c.field, " ", c.op, " ", c.threshold)
+	if c.field == "pctChange" {
+		s += "%"
+	}
+	return s
+}
+
+// matches reports whether q meets c. A pctChange the upstream didn't send
+// never does.
+func (c alertCondition) matches(q upstream.Quotation) bool {
+	var value upstream.Decimal
+	switch c.field {
+	case "bid":
+		value = q.BidValue
+	case "ask":
+		value = q.AskValue
+	case "pctChange":
+		v, err := upstream.ParseDecimal(q.PctChange)
+		if err != nil {
+			return false
+		}
+		value = v
+	}
+	switch c.op {
+	case ">":
+		return value > c.threshold
+	case ">=":
+		return value >= c.threshold
+	case "<":
+		return value < c.threshold
+	default:
+		return value <= c.threshold
+	}
+}
+
+// alertOwner names the caller of r as AlertRule owners: its API key or JWT
+// subject, empty without authentication.
+func alertOwner(r *http.Request) string {
+	info := requestInfoFrom(r.Context())
+	switch {
+	case info.APIKey != nil && info.APIKey.ID > 0:
+		return fmt.Sprint("key:", info.APIKey.ID)
+	case info.APIKey != nil:
+		return "key:" + info.APIKey.Prefix
+	case info.Subject != "":
+		return "sub:" + info.Subject
+	}
+	return ""
+}
+
+// AlertRuleRequest is the body of POST /alerts and PUT /alerts/{id}.
+// Notifier defaults to "webhook", Template to defaultAlertTemplate and
+// Enabled to true; an empty secret is generated on POST and kept on PUT.
+type AlertRuleRequest struct {
+	Pair       string `json:"pair"`
+	Condition  string `json:"condition"`
+	WebhookURL string `json:"webhook_url"`
+	Notifier   string `json:"notifier,omitempty"`
+	Template   string `json:"template,omitempty"`
+	Secret     string `json:"secret,omitempty"`
+	Enabled    *bool  `json:"enabled,omitempty"`
+}
+
+// decodeAlertRule reads the body of r into rule.
+func decodeAlertRule(w http.ResponseWriter, r *http.Request, rule *AlertRule) error {
+	var req AlertRuleRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAlertBodySize)).Decode(&req)
+	if err != nil {
+		return fmt.Errorf("documento inválido: %w", err)
+	}
+	pairs, err := upstream.ParsePairs(req.Pair)
+	if err != nil || len(pairs) != 1 {
+		return fmt.Errorf("par inválido: %q", req.Pair)
+	}
+	if err := checkSupportedPairs(pairs); err != nil {
+		return err
+	}
+	cond, err := parseAlertCondition(req.Condition)
+	if err != nil {
+		return err
+	}
+	if err := upstream.ValidateURL(req.WebhookURL); err != nil {
+		return fmt.Errorf("webhook_url inválida: %w", err)
+	}
+	if err := checkWebhookHost(req.WebhookURL); err != nil {
+		return err
+	}
+	notifier, err := checkAlertNotifier(req.Notifier, req.Template)
+	if err != nil {
+		return err
+	}
+	rule.Pair, rule.Condition, rule.WebhookURL = pairs[0], cond.String(), req.WebhookURL
+	rule.Notifier, rule.Template = notifier, req.Template
+	rule.Enabled = req.Enabled == nil || *req.Enabled
+	if req.Secret != "" {
+		rule.secret = req.Secret
+	}
+	return nil
+}
+
+// alertsHandler serves GET /alerts, the rules of the caller.
+func alertsHandler(w http.ResponseWriter, r *http.Request) {
+	rules, err := ownedAlertRules(r)
+	if err != nil {
+		statusCode, code := dbErrorStatus(err, codeDBReadFailed)
+		msg := fmt.Sprint("GET /alerts - ", err)
+		sendMsgError(w, code, msg, statusCode)
+		return
+	}
+	if err := sendJSON(w, http.StatusOK, rules); err != nil {
+		slog.WarnContext(r.Context(), "GET /alerts - falha ao enviar resposta", "err", err)
+	}
+}
+
+// createAlertHandler serves POST /alerts, answering 201 with the rule and
+// its secret.
+func createAlertHandler(w http.ResponseWriter, r *http.Request) {
+	rule := AlertRule{owner: alertOwner(r), CreatedAt: time.Now().UTC().Truncate(time.Second)}
+	if err := decodeAlertRule(w, r, &rule); err != nil {
+		msg := fmt.Sprint("POST /alerts - ", err)
+		sendMsgError(w, codeBadRequest, msg, http.StatusBadRequest)
+		return
+	}
+	owned, err := ownedAlertRules(r)
+	if err != nil {
+		statusCode, code := dbErrorStatus(err, codeDBReadFailed)
+		msg := fmt.Sprint("POST /alerts - ", err)
+		sendMsgError(w, code, msg, statusCode)
+		return
+	}
+	if len(owned) >= maxAlertRules {
+		msg := fmt.Sprintf("POST /alerts - no máximo %d alertas", maxAlertRules)
+		sendMsgError(w, codeBadRequest, msg, http.StatusBadRequest)
+		return
+	}
+	if rule.secret == "" {
+		b := make([]byte, alertSecretBytes)
+		if _, err := rand.Read(b); err != nil {
+			sendMsgError(w, codeInternal, fmt.Sprint("POST /alerts - falha ao gerar segredo: ", err), http.StatusInternalServerError)
+			return
+		}
+		rule.secret = hex.EncodeToString(b)
+	}
+
+	if err := store.insertAlertRule(r.Context(), &rule); err != nil {
+		statusCode, code := dbErrorStatus(err, codeDBWriteFailed)
+		msg := fmt.Sprint("POST /alerts - ", err)
+		sendMsgError(w, code, msg, statusCode)
+		return
+	}
+	slog.InfoContext(r.Context(), "Alertas - alerta criado", "id", rule.ID, "pair", rule.Pair, "condition", rule.Condition)
+	w.Header().Set("Location", fmt.Sprint("/v1/alerts/", rule.ID))
+	if err := sendJSON(w, http.StatusCreated, CreatedAlertRule{AlertRule: rule, Secret: rule.secret}); err != nil {
+		slog.WarnContext(r.Context(), "POST /alerts - falha ao enviar resposta", "err", err)
+	}
+}
+
+// alertHandler serves GET, PUT and DELETE /alerts/{id}. A PUT replaces the
+// rule and arms it again.
+func alertHandler(w http.ResponseWriter, r *http.Request) {
+	route := r.Method + " /alerts/{id}"
+	raw := strings.TrimPrefix(r.URL.Path, "/alerts/")
+	id, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || id <= 0 {
+		msg := fmt.Sprintf("%s - id inválido: %q", route, raw)
+		sendMsgError(w, codeBadRequest, msg, http.StatusBadRequest)
+		return
+	}
+
+	rule, err := store.alertRule(r.Context(), id)
+	if err == nil && rule.owner != alertOwner(r) {
+		err = sql.ErrNoRows
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		msg := fmt.Sprint(route, " - alerta não encontrado: ", id)
+		sendMsgError(w, codeNotFound, msg, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		statusCode, code := dbErrorStatus(err, codeDBReadFailed)
+		msg := fmt.Sprint(route, " - ", err)
+		sendMsgError(w, code, msg, statusCode)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		if err := decodeAlertRule(w, r, rule); err != nil {
+			msg := fmt.Sprint(route, " - ", err)
+			sendMsgError(w, codeBadRequest, msg, http.StatusBadRequest)
+			return
+		}
+		rule.TriggeredAt = nil
+		err = store.updateAlertRule(r.Context(), rule)
+	case http.MethodDelete:
+		err = store.deleteAlertRule(r.Context(), id)
+	}
+	if err != nil {
+		statusCode, code := dbErrorStatus(err, codeDBWriteFailed)
+		msg := fmt.Sprint(route, " - ", err)
+		sendMsgError(w, code, msg, statusCode)
+		return
+	}
+	if r.Method == http.MethodDelete {
+		slog.InfoContext(r.Context(), "Alertas - alerta removido", "id", id)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err := sendJSON(w, http.StatusOK, rule); err != nil {
+		slog.WarnContext(r.Context(), route+" - falha ao enviar resposta", "err", err)
+	}
+}
+
+// ownedAlertRules returns the rules of the caller of r.
+func ownedAlertRules(r *http.Request) ([]AlertRule, error) {
+	rules, err := store.alertRules(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	owner := alertOwner(r)
+	owned := []AlertRule{}
+	for _, rule := range rules {
+		if rule.owner == owner {
+			owned = append(owned, rule)
+		}
+	}
+	return owned, nil
+}
+
+// alertPairs are the pairs the poller fetches: the default pair, first,
+// and the pairs of the enabled rules.
+func alertPairs(rules []AlertRule) []string {
+	pairs := []string{upstream.DefaultPair}
+	for _, rule := range rules {
+		if rule.Enabled && !containsPair(pairs, rule.Pair) {
+			pairs = append(pairs, rule.Pair)
+		}
+	}
+	return pairs
+}
+
+// evaluateAlerts checks q, a new quotation, against the enabled rules of its
+// pair, firing the ones it starts to meet and arming again the ones it
+// fails.
+func evaluateAlerts(ctx context.Context, q upstream.Quotation, rules []AlertRule) {
+	pair := q.Code + "-" + q.CodeIn
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, rule := range rules {
+		if !rule.Enabled || rule.Pair != pair {
+			continue
+		}
+		cond, err := parseAlertCondition(rule.Condition)
+		if err != nil {
+			slog.Warn("Alertas - alerta com condição inválida", "id", rule.ID, "err", err)
+			continue
+		}
+		matched := cond.matches(q)
+		if matched == (rule.TriggeredAt != nil) {
+			continue
+		}
+		var at *time.Time
+		if matched {
+			at = &now
+		}
+		if err := store.setAlertTriggered(ctx, rule.ID, at); err != nil {
+			slog.Warn("Alertas - falha ao atualizar alerta", "id", rule.ID, "err", err)
+			continue
+		}
+		if matched {
+			slog.Info("Alertas - alerta disparado", "id", rule.ID, "pair", rule.Pair, "condition", rule.Condition, "bid", q.Bid)
+			startAlertDelivery(ctx, rule, q, now)
+		}
+	}
+}
+
+// startAlertDelivery sends the event of rule to its webhook in the
+// background, so a slow subscriber doesn't hold the poller.
+func startAlertDelivery(ctx context.Context, rule AlertRule, q upstream.Quotation, at time.Time) {
+	id := make([]byte, 16)
+	rand.Read(id)
+	event := AlertEvent{
+		DeliveryID:  hex.EncodeToString(id),
+		RuleID:      rule.ID,
+		Pair:        rule.Pair,
+		Condition:   rule.Condition,
+		TriggeredAt: at,
+		Quotation:   q,
+	}
+	backgroundJobs.Add(1)
+	go func() {
+		defer backgroundJobs.Done()
+		if err := deliverAlert(ctx, rule, event); err != nil {
+			alertDeliveries.WithLabelValues(rule.Notifier, "failed").Inc()
+			slog.Warn("Alertas - falha ao entregar alerta", "id", rule.ID, "webhook_url", rule.WebhookURL, "err", err)
+			return
+		}
+		alertDeliveries.WithLabelValues(rule.Notifier, "delivered").Inc()
+	}()
+}
+
+// deliverAlert makes up to alertAttempts POSTs of event with the notifier of
+// rule, waiting alertBackoff, doubled each time, after network errors, 5xx
+// and 429 responses, or longer when the service asks to. The waits go
+// through alertLimits, which also paces the other rules sharing the URL.
+func deliverAlert(ctx context.Context, rule AlertRule, event AlertEvent) error {
+	n, ok := alertNotifiers[rule.Notifier]
+	if !ok {
+		return fmt.Errorf("notifier desconhecido: %q", rule.Notifier)
+	}
+	message, err := alertMessage(rule, event)
+	if err != nil {
+		return fmt.Errorf("template inválido: %w", err)
+	}
+	event.Message = message
+	body, err := n.body(event)
+	if err != nil {
+		return err
+	}
+	delay := alertBackoff
+	for attempt := 1; ; attempt++ {
+		if waitErr := alertLimits.wait(ctx, rule.WebhookURL, n.interval); waitErr != nil {
+			if err == nil {
+				err = waitErr
+			}
+			return err
+		}
+		err = postAlert(ctx, rule, n, body)
+		if err == nil || attempt == alertAttempts || !transientDelivery(err) {
+			return err
+		}
+		alertLimits.delay(rule.WebhookURL, delay)
+		delay *= 2
+	}
+}
+
+// transientDelivery reports whether a failed delivery is worth another
+// attempt: anything but a 4xx other than 429, which the subscriber will
+// answer the same way again, or a blocked address.
+func transientDelivery(err error) bool {
+	if errors.Is(err, errBlockedWebhook) {
+		return false
+	}
+	var statusErr *upstream.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code >= http.StatusInternalServerError || statusErr.Code == http.StatusTooManyRequests
+	}
+	return !errors.Is(err, context.Canceled)
+}
+
+func (s *sqliteStore) insertAlertRule(ctx context.Context, rule *AlertRule) error {
+	dbCtx, cancel := context.WithTimeout(ctx, databaseTimeout)
+	defer cancel()
+
+	res, err := s.db.ExecContext(dbCtx, `
+		INSERT INTO alert_rule(owner, pair, condition, webhook_url, notifier, template, secret, enabled, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, rule.owner, rule.Pair, rule.Condition, rule.WebhookURL, rule.Notifier, rule.Template, rule.secret, rule.Enabled, rule.CreatedAt.Unix())
+	if err != nil {
+		return fmt.Errorf("falha ao executar query. %w", err)
+	}
+	rule.ID, err = res.LastInsertId()
+	return err
+}
+
+const alertRuleColumns string = " id, owner, pair, condition, webhook_url, notifier, template, secret, enabled, created_at, triggered_at"
+
+func scanAlertRule(row interface{ Scan(...any) error }) (*AlertRule, error) {
+	var rule AlertRule
+	var created int64
+	var triggered sql.NullInt64
+	err := row.Scan(&rule.ID, &rule.owner, &rule.Pair, &rule.Condition, &rule.WebhookURL, &rule.Notifier, &rule.Template, &rule.secret, &rule.Enabled, &created, &triggered)
+	if err != nil {
+		return nil, err
+	}
+	rule.CreatedAt = time.Unix(created, 0).UTC()
+	if triggered.Valid {
+		t := time.Unix(triggered.Int64, 0).UTC()
+		rule.TriggeredAt = &t
+	}
+	return &rule, nil
+}
+
+func (s *sqliteStore) alertRule(ctx context.Context, id int64) (*AlertRule, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, databaseTimeout)
+	defer cancel()
+
+	row := s.readDB.QueryRowContext(dbCtx, "SELECT"+alertRuleColumns+" FROM alert_rule WHERE id = ?", id)
+	return scanAlertRule(row)
+}
+
+func (s *sqliteStore) alertRules(ctx context.Context) ([]AlertRule, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, databaseTimeout)
+	defer cancel()
+
+	rows, err := s.readDB.QueryContext(dbCtx, "SELECT"+alertRuleColumns+" FROM alert_rule ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("falha ao executar query. %w", err)
+	}
+	defer rows.Close()
+
+	rules := []AlertRule{}
+	for rows.Next() {
+		rule, err := scanAlertRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, *rule)
+	}
+	return rules, rows.Err()
+}
+
+func (s *sqliteStore) updateAlertRule(ctx context.Context, rule *AlertRule) error {
+	return s.execAlertRule(ctx, `
+		UPDATE alert_rule SET pair = ?, condition = ?, webhook_url = ?, notifier = ?, template = ?, secret = ?, enabled = ?,
+			triggered_at = NULL
+		WHERE id = ?
+	`, rule.Pair, rule.Condition, rule.WebhookURL, rule.Notifier, rule.Template, rule.secret, rule.Enabled, rule.ID)
+}
+
+func (s *sqliteStore) deleteAlertRule(ctx context.Context, id int64) error {
+	return s.execAlertRule(ctx, `DELETE FROM alert_rule WHERE id = ?`, id)
+}
+
+// setAlertTriggered records when the rule fired, nil when it is armed again.
+func (s *sqliteStore) setAlertTriggered(ctx context.Context, id int64, at *time.Time) error {
+	var triggered sql.NullInt64
+	if at != nil {
+		triggered = sql.NullInt64{Int64: at.Unix(), Valid: true}
+	}
+	return s.execAlertRule(ctx, `UPDATE alert_rule SET triggered_at = ? WHERE id = ?`, triggered, id)
+}
+
+// execAlertRule runs a statement on a single rule, failing with
+// sql.ErrNoRows when it doesn't exist.
+func (s *sqliteStore) execAlertRule(ctx context.Context, query string, args ...any) error {
+	dbCtx, cancel := context.WithTimeout(ctx, databaseTimeout)
+	defer cancel()
+
+	res, err := s.db.ExecContext(dbCtx, query, args...)
+	if err != nil {
+		return fmt.Errorf("falha ao executar query. %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
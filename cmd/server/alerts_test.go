@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestBlockedWebhookIP(t *testing.T) {
+	tests := []struct {
+		ip      string
+		blocked bool
+	}{
+		{"127.0.0.1", true},
+		{"::1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.0.10", true},
+		{"169.254.169.254", true},
+		{"fe80::1", true},
+		{"fd00::1", true},
+		{"0.0.0.0", true},
+		{"0.1.2.3", true},
+		{"100.64.0.1", true},
+		{"224.0.0.1", true},
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:169.254.169.254", true},
+		{"8.8.8.8", false},
+		{"172.32.0.1", false},
+		{"100.128.0.1", false},
+		{"2001:4860:4860::8888", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if got := blockedWebhookIP(net.ParseIP(tt.ip)); got != tt.blocked {
+				t.Errorf("blockedWebhookIP(%s) = %v, want %v", tt.ip, got, tt.blocked)
+			}
+		})
+	}
+}
+
+func TestCheckWebhookHost(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{"https://hooks.slack.com/services/x", false},
+		{"https://example.com:8443/hook", false},
+		{"http://127.0.0.1:8080/hook", true},
+		{"http://[::1]/hook", true},
+		{"http://169.254.169.254/latest/meta-data/", true},
+		{"http://localhost/hook", true},
+		{"http://LOCALHOST./hook", true},
+		{"http://api.localhost/hook", true},
+		{"http://10.0.0.5/hook", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			err := checkWebhookHost(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkWebhookHost(%q) error = %v, wantErr %v", tt.url, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// A name can resolve to an internal address only at delivery time, so the
+// address is checked once connecting, where 127.0.0.1 stands for it.
+func TestDeliverAlertRefusesInternalAddress(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer srv.Close()
+
+	rule := AlertRule{ID: 1, Pair: "USD-BRL", Condition: "bid > 5", WebhookURL: srv.URL, Notifier: "webhook", secret: "s"}
+	err := deliverAlert(context.Background(), rule, AlertEvent{RuleID: 1, Pair: "USD-BRL"})
+	if !errors.Is(err, errBlockedWebhook) {
+		t.Fatalf("deliverAlert() error = %v, want errBlockedWebhook", err)
+	}
+	if transientDelivery(err) {
+		t.Error("a blocked delivery is retried")
+	}
+	if n := hits.Load(); n != 0 {
+		t.Errorf("webhook got %d requests, want 0", n)
+	}
+}
+
+func TestDecodeAlertRuleRejectsInternalWebhook(t *testing.T) {
+	body := `{"pair": "USD-BRL", "condition": "bid > 5.40", "webhook_url": "http://169.254.169.254/latest"}`
+	r := httptest.NewRequest(http.MethodPost, "/v1/alerts", strings.NewReader(body))
+	var rule AlertRule
+	if err := decodeAlertRule(httptest.NewRecorder(), r, &rule); !errors.Is(err, errBlockedWebhook) {
+		t.Errorf("decodeAlertRule() error = %v, want errBlockedWebhook", err)
+	}
+}
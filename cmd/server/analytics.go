@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+const (
+	defaultStatsWindows string = "7,30"
+	maxStatsWindow      int    = 365
+	maxStatsWindows     int    = 5
+)
+
+// parseStatsWindows reads ?windows=, the moving average windows in days with
+// quotations, "7,30" by default. "none" turns them off.
+func parseStatsWindows(raw string) ([]int, error) {
+	if raw == "" {
+		raw = defaultStatsWindows
+	}
+	if raw == "none" {
+		return nil, nil
+	}
+	var windows []int
+	for _, item := range strings.Split(raw, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(item))
+		if err != nil || n < 1 || n > maxStatsWindow {
+			return nil, fmt.Errorf("janela inválida em windows, use de 1 a %d dias: %q", maxStatsWindow, item)
+		}
+		windows = append(windows, n)
+	}
+	if len(windows) > maxStatsWindows {
+		return nil, fmt.Errorf("no máximo %d janelas em windows", maxStatsWindows)
+	}
+	return windows, nil
+}
+
+// summarizeDays adds to stats, the stats of f as a whole, the change and the
+// standard deviation of the daily average bid over the range and its moving
+// averages as of the last day.
+func summarizeDays(ctx context.Context, f historyFilter, windows []int, stats *QuotationStats) error {
+	days, err := store.dailyQuotationStats(ctx, f)
+	if err != nil || len(days) == 0 {
+		return err
+	}
+	stats.ChangePct = percentChange(days[0].AvgBid, days[len(days)-1].AvgBid)
+
+	var mean float64
+	for _, d := range days {
+		mean += float64(d.AvgBid)
+	}
+	mean /= float64(len(days))
+	var variance float64
+	for _, d := range days {
+		variance += math.Pow(float64(d.AvgBid)-mean, 2)
+	}
+	stdDev := upstream.Decimal(math.Round(math.Sqrt(variance / float64(len(days)))))
+	stats.StdDevBid = &stdDev
+
+	history, err := averagedHistory(ctx, f, windows, days)
+	if err != nil {
+		return err
+	}
+	last := history[len(history)-1]
+	stats.SMA, stats.EMA = last.SMA, last.EMA
+	return nil
+}
+
+// annotateDays adds to days, the daily stats of f, the change from the
+// previous day and the moving averages as of each day.
+func annotateDays(ctx context.Context, f historyFilter, windows []int, days []*QuotationStats) error {
+	for i := 1; i < len(days); i++ {
+		days[i].ChangePct = percentChange(days[i-1].AvgBid, days[i].AvgBid)
+	}
+	if len(days) == 0 {
+		return nil
+	}
+	history, err := averagedHistory(ctx, f, windows, days)
+	if err != nil {
+		return err
+	}
+	byDay := make(map[string]*QuotationStats, len(history))
+	for _, d := range history {
+		byDay[d.Day] = d
+	}
+	for _, d := range days {
+		if h, ok := byDay[d.Day]; ok {
+			d.SMA, d.EMA = h.SMA, h.EMA
+		}
+	}
+	return nil
+}
+
+// averagedHistory returns the daily stats of f with their moving averages.
+// The averages of the first days of the range need the days before it, so
+// from is moved back by twice the longest window, enough for weekends and
+// holidays; days, the stats of f itself, are reused when from isn't set.
+func averagedHistory(ctx context.Context, f historyFilter, windows []int, days []*QuotationStats) ([]*QuotationStats, error) {
+	history := days
+	if !f.From.IsZero() && len(windows) > 0 {
+		longest := 0
+		for _, n := range windows {
+			if n > longest {
+				longest = n
+			}
+		}
+		widened := f
+		widened.From = f.From.AddDate(0, 0, -2*longest)
+		var err error
+		history, err = store.dailyQuotationStats(ctx, widened)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, n := range windows {
+		movingAverages(history, n)
+	}
+	return history, nil
+}
+
+// movingAverages sets, on every day of days, ordered by day, from the nth on,
+// the simple and the exponential moving average of the average bid over n
+// days. The EMA starts from the SMA of the first n days and weighs each new
+// day by 2/(n+1).
+func movingAverages(days []*QuotationStats, n int) {
+	key := strconv.Itoa(n)
+	alpha := 2 / float64(n+1)
+	var sum, ema float64
+	for i, d := range days {
+		sum += float64(d.AvgBid)
+		if i >= n {
+			sum -= float64(days[i-n].AvgBid)
+		}
+		if i < n-1 {
+			continue
+		}
+		sma := sum / float64(n)
+		if i == n-1 {
+			ema = sma
+		} else {
+			ema = alpha*float64(d.AvgBid) + (1-alpha)*ema
+		}
+		if d.SMA == nil {
+			d.SMA = make(map[string]upstream.Decimal)
+			d.EMA = make(map[string]upstream.Decimal)
+		}
+		d.SMA[key] = upstream.Decimal(math.Round(sma))
+		d.EMA[key] = upstream.Decimal(math.Round(ema))
+	}
+}
+
+// percentChange is the change from prev to cur in percent, nil when prev is
+// zero.
+func percentChange(prev, cur upstream.Decimal) *upstream.Decimal {
+	if prev == 0 {
+		return nil
+	}
+	pct := (float64(cur)/float64(prev) - 1) * 100
+	change := upstream.Decimal(math.Round(pct * math.Pow10(upstream.DecimalScale)))
+	return &change
+}
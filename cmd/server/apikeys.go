@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	apiKeyPrefix      string = "cot_"
+	apiKeyBytes       int    = 20
+	apiKeyVisiblePart int    = len(apiKeyPrefix) + 8
+	staticAPIKeyName  string = "-api-keys"
+	maxAPIKeyNameSize int    = 64
+	maxAPIKeyBodySize int64  = 4 << 10
+)
+
+var (
+	errMissingAPIKey = errors.New("chave de acesso ausente")
+	errInvalidAPIKey = errors.New("chave de acesso inválida")
+	errRevokedAPIKey = errors.New("chave de acesso revogada")
+)
+
+// APIKey is an access key to the quotation endpoints. Only the SHA-256 of
+// the key is stored; Prefix, its first characters, tells keys apart in
+// listings and logs.
+type APIKey struct {
+	ID        int64      `json:"id"`
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+
+	hash string
+}
+
+// CreatedAPIKey is the answer to POST /admin/keys, the only time the key
+// itself is shown.
+type CreatedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// hashAPIKey is what api_key.key_hash holds for key.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func visiblePart(key string) string {
+	if len(key) > apiKeyVisiblePart {
+		return key[:apiKeyVisiblePart]
+	}
+	return key
+}
+
+// parseStaticAPIKeys reads -api-keys, keyed by hash like the stored ones.
+func parseStaticAPIKeys(raw string) map[string]*APIKey {
+	keys := make(map[string]*APIKey)
+	for _, key := range strings.Split(raw, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			hash := hashAPIKey(key)
+			keys[hash] = &APIKey{Name: staticAPIKeyName, Prefix: visiblePart(key), hash: hash}
+		}
+	}
+	return keys
+}
+
+// requestAPIKey returns the key sent in the X-API-Key header or as an
+// Authorization bearer token.
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// authenticate finds the API key of r among the -api-keys and the stored
+// ones. Unknown keys give errInvalidAPIKey and revoked ones
+// errRevokedAPIKey; other errors come from the database.
+func authenticate(r *http.Request) (*APIKey, error) {
+	key := requestAPIKey(r)
+	if key == "" {
+		return nil, errMissingAPIKey
+	}
+	hash := hashAPIKey(key)
+	if k, ok := staticAPIKeys[hash]; ok {
+		return k, nil
+	}
+	k, err := store.apiKeyByHash(r.Context(), hash)
+	switch {
+	case errors.Is(err, sql.ErrNoRows), errors.Is(err, errNoDatabase):
+		return nil, errInvalidAPIKey
+	case err != nil:
+		return nil, err
+	case k.RevokedAt != nil:
+		return nil, errRevokedAPIKey
+	}
+	return k, nil
+}
+
+// authorize checks that r may use scope: with a JWT granting it when -jwt-*
+// is set, or with an API key, which grants scopeQuotesRead only. The caller
+// is kept in the requestInfo, so the rate limiter counts per key or subject
+// instead of per IP.
+func authorize(r *http.Request, scope string) error {
+	info := requestInfoFrom(r.Context())
+	if credential := requestAPIKey(r); jwtAuth != nil && isJWT(credential) {
+		claims, err := jwtAuth.verify(r.Context(), credential, time.Now())
+		if err != nil {
+			return err
+		}
+		if !claims.hasScope(scope) {
+			return fmt.Errorf("%w: %s", errInsufficientScope, scope)
+		}
+		info.Subject = claims.Subject
+		return nil
+	}
+	k, err := authenticate(r)
+	if err != nil {
+		return err
+	}
+	if scope != scopeQuotesRead {
+		return fmt.Errorf("%w: %s", errInsufficientScope, scope)
+	}
+	info.APIKey = k
+	return nil
+}
+
+// authRequired reports whether the quotation endpoints need credentials.
+func authRequired() bool {
+	return apiKeyRequired || jwtAuth != nil
+}
+
+// requireAuth only lets through requests authorized for scopeQuotesRead,
+// with -require-api-key or -jwt-* set, answering 401 without valid
+// credentials and 403 with a revoked key or a token lacking the scope.
+func requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := authorize(r, scopeQuotesRead); err != nil {
+			sendAuthError(w, r, err, "cotacao")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sendAuthError answers a request that failed authorize.
+func sendAuthError(w http.ResponseWriter, r *http.Request, err error, realm string) {
+	msg := fmt.Sprint(r.Method, " ", r.URL.Path, " - ", err)
+	switch {
+	case errors.Is(err, errInsufficientScope):
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+realm+`", error="insufficient_scope"`)
+		sendMsgError(w, codeForbidden, msg, http.StatusForbidden)
+	case errors.Is(err, errRevokedAPIKey):
+		sendMsgError(w, codeForbidden, msg, http.StatusForbidden)
+	case errors.Is(err, errInvalidToken):
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+realm+`", error="invalid_token"`)
+		sendMsgError(w, codeUnauthorized, msg, http.StatusUnauthorized)
+	case errors.Is(err, errMissingAPIKey), errors.Is(err, errInvalidAPIKey):
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+realm+`"`)
+		sendMsgError(w, codeUnauthorized, msg, http.StatusUnauthorized)
+	default:
+		statusCode, code := dbErrorStatus(err, codeDBReadFailed)
+		msg := fmt.Sprint(r.Method, " ", r.URL.Path, " - falha ao consultar chave de acesso: ", err)
+		sendMsgError(w, code, msg, statusCode)
+	}
+}
+
+// newAPIKey generates a random key and its record.
+func newAPIKey(name string, now time.Time) (*APIKey, string, error) {
+	b := make([]byte, apiKeyBytes)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	key := apiKeyPrefix + hex.EncodeToString(b)
+	k := &APIKey{Name: name, Prefix: visiblePart(key), CreatedAt: now.UTC().Truncate(time.Second), hash: hashAPIKey(key)}
+	return k, key, nil
+}
+
+// adminKeysHandler serves GET /admin/keys, every stored key without the
+// secrets, and POST /admin/keys with a {"name": "..."} document, which
+// creates a key and answers 201 with it.
+func adminKeysHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		keys, err := store.listAPIKeys(r.Context())
+		if err != nil {
+			statusCode, code := dbErrorStatus(err, codeDBReadFailed)
+			msg := fmt.Sprint("GET /admin/keys - ", err)
+			sendMsgError(w, code, msg, statusCode)
+			return
+		}
+		if err := sendJSON(w, http.StatusOK, keys); err != nil {
+			slog.WarnContext(r.Context(), "GET /admin/keys - falha ao enviar resposta", "err", err)
+		}
+	case http.MethodPost:
+		var req struct {
+			Name string `json:"name"`
+		}
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIKeyBodySize)).Decode(&req)
+		if err != nil {
+			msg := fmt.Sprint("POST /admin/keys - documento inválido: ", err)
+			sendMsgError(w, codeBadRequest, msg, http.StatusBadRequest)
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" || len(req.Name) > maxAPIKeyNameSize {
+			msg := fmt.Sprintf("POST /admin/keys - name deve ter entre 1 e %d caracteres", maxAPIKeyNameSize)
+			sendMsgError(w, codeBadRequest, msg, http.StatusBadRequest)
+			return
+		}
+
+		k, key, err := newAPIKey(req.Name, time.Now())
+		if err != nil {
+			sendMsgError(w, codeInternal, fmt.Sprint("POST /admin/keys - falha ao gerar chave: ", err), http.StatusInternalServerError)
+			return
+		}
+		if err := store.insertAPIKey(r.Context(), k); err != nil {
+			statusCode, code := dbErrorStatus(err, codeDBWriteFailed)
+			msg := fmt.Sprint("POST /admin/keys - ", err)
+			sendMsgError(w, code, msg, statusCode)
+			return
+		}
+		slog.InfoContext(r.Context(), "Admin - chave de acesso criada", "id", k.ID, "name", k.Name, "prefix", k.Prefix, "remote_addr", r.RemoteAddr)
+		w.Header().Set("Location", fmt.Sprint("/v1/admin/keys/", k.ID))
+		if err := sendJSON(w, http.StatusCreated, CreatedAPIKey{APIKey: *k, Key: key}); err != nil {
+			slog.WarnContext(r.Context(), "POST /admin/keys - falha ao enviar resposta", "err", err)
+		}
+	}
+}
+
+// adminKeyHandler serves DELETE /admin/keys/{id}, which revokes the key.
+// Revoked keys stay listed, with their revoked_at.
+func adminKeyHandler(w http.ResponseWriter, r *http.Request) {
+	raw := strings.TrimPrefix(r.URL.Path, "/admin/keys/")
+	id, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || id <= 0 {
+		msg := fmt.Sprintf("DELETE /admin/keys/{id} - id inválido: %q", raw)
+		sendMsgError(w, codeBadRequest, msg, http.StatusBadRequest)
+		return
+	}
+
+	err = store.revokeAPIKey(r.Context(), id, time.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		msg := fmt.Sprint("DELETE /admin/keys/{id} - chave não encontrada: ", id)
+		sendMsgError(w, codeNotFound, msg, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		statusCode, code := dbErrorStatus(err, codeDBWriteFailed)
+		msg := fmt.Sprint("DELETE /admin/keys/{id} - ", err)
+		sendMsgError(w, code, msg, statusCode)
+		return
+	}
+	slog.InfoContext(r.Context(), "Admin - chave de acesso revogada", "id", id, "remote_addr", r.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *sqliteStore) insertAPIKey(ctx context.Context, k *APIKey) error {
+	dbCtx, cancel := context.WithTimeout(ctx, databaseTimeout)
+	defer cancel()
+
+	res, err := s.db.ExecContext(dbCtx, `
+		INSERT INTO api_key(name, prefix, key_hash, created_at)
+		VALUES (?, ?, ?, ?)
+	`, k.Name, k.Prefix, k.hash, k.CreatedAt.Unix())
+	if err != nil {
+		return fmt.Errorf("falha ao executar query. %w", err)
+	}
+	k.ID, err = res.LastInsertId()
+	return err
+}
+
+const apiKeyColumns string = " id, name, prefix, key_hash, created_at, revoked_at"
+
+func scanAPIKey(row interface{ Scan(...any) error }) (*APIKey, error) {
+	var k APIKey
+	var created int64
+	var revoked sql.NullInt64
+	if err := row.Scan(&k.ID, &k.Name, &k.Prefix, &k.hash, &created, &revoked); err != nil {
+		return nil, err
+	}
+	k.CreatedAt = time.Unix(created, 0).UTC()
+	if revoked.Valid {
+		t := time.Unix(revoked.Int64, 0).UTC()
+		k.RevokedAt = &t
+	}
+	return &k, nil
+}
+
+func (s *sqliteStore) apiKeyByHash(ctx context.Context, hash string) (*APIKey, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, databaseTimeout)
+	defer cancel()
+
+	row := s.readDB.QueryRowContext(dbCtx, "SELECT"+apiKeyColumns+" FROM api_key WHERE key_hash = ?", hash)
+	return scanAPIKey(row)
+}
+
+func (s *sqliteStore) listAPIKeys(ctx context.Context) ([]APIKey, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, databaseTimeout)
+	defer cancel()
+
+	rows, err := s.readDB.QueryContext(dbCtx, "SELECT"+apiKeyColumns+" FROM api_key ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("falha ao executar query. %w", err)
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *k)
+	}
+	return keys, rows.Err()
+}
+
+// revokeAPIKey marks the key revoked at now, keeping the first revocation
+// time, and fails with sql.ErrNoRows for an unknown id.
+func (s *sqliteStore) revokeAPIKey(ctx context.Context, id int64, now time.Time) error {
+	dbCtx, cancel := context.WithTimeout(ctx, databaseTimeout)
+	defer cancel()
+
+	res, err := s.db.ExecContext(dbCtx,
+		`UPDATE api_key SET revoked_at = COALESCE(revoked_at, ?) WHERE id = ?`, now.Unix(), id)
+	if err != nil {
+		return fmt.Errorf("falha ao executar query. %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	auditQueueSize int = 1024
+	auditBatchSize int = 100
+)
+
+// requestInfo is filled by the handlers with what only they know about a
+// request, for the audit log written by logRequests. RequestID and Lang, the
+// language of the error responses, are set by logRequests itself.
+type requestInfo struct {
+	RequestID        string
+	Pair             string
+	ErrorCode        string
+	Lang             string
+	APIKey           *APIKey
+	Subject          string
+	UpstreamDuration time.Duration
+	DBDuration       time.Duration
+}
+
+type requestInfoKey struct{}
+
+// requestInfoFrom returns the requestInfo of ctx. Outside logRequests it
+// returns a throwaway value, so handlers never need to check.
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		return info
+	}
+	return &requestInfo{}
+}
+
+// responseInfo finds the requestInfo behind w, for code that only has the
+// ResponseWriter, like sendMsgError.
+func responseInfo(w http.ResponseWriter) *requestInfo {
+	for {
+		switch v := w.(type) {
+		case *statusRecorder:
+			return v.info
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = v.Unwrap()
+		default:
+			return &requestInfo{}
+		}
+	}
+}
+
+// RequestLogEntry is a row of the request_log table.
+type RequestLogEntry struct {
+	ID                 int64     `json:"id"`
+	Timestamp          time.Time `json:"timestamp"`
+	Method             string    `json:"method"`
+	Path               string    `json:"path"`
+	Pair               string    `json:"pair,omitempty"`
+	StatusCode         int       `json:"status_code"`
+	ErrorCode          string    `json:"error_code,omitempty"`
+	UpstreamDurationMs int64     `json:"upstream_duration_ms"`
+	DBDurationMs       int64     `json:"db_duration_ms"`
+	RemoteAddr         string    `json:"remote_addr"`
+}
+
+// auditLog writes request_log rows in batches from a dedicated goroutine, so
+// the audit never adds latency to a response. Entries are dropped, and
+// counted, when the queue is full.
+type auditLog struct {
+	mu      sync.RWMutex
+	closed  bool
+	queue   chan RequestLogEntry
+	done    chan struct{}
+	dropped atomic.Int64
+}
+
+func newAuditLog(size int) *auditLog {
+	a := &auditLog{
+		queue: make(chan RequestLogEntry, size),
+		done:  make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+func (a *auditLog) run() {
+	defer close(a.done)
+	for entry := range a.queue {
+		batch := []RequestLogEntry{entry}
+	drain:
+		for len(batch) < auditBatchSize {
+			select {
+			case e, ok := <-a.queue:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, e)
+			default:
+				break drain
+			}
+		}
+		if err := store.insertRequestLog(batch); err != nil {
+			slog.Warn("Auditoria - falha ao gravar registros", "count", len(batch), "err", err)
+		}
+		if n := a.dropped.Swap(0); n > 0 {
+			slog.Warn("Auditoria - fila cheia, registros descartados", "count", n)
+		}
+	}
+}
+
+func (a *auditLog) enqueue(e RequestLogEntry) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		return
+	}
+	select {
+	case a.queue <- e:
+	default:
+		a.dropped.Add(1)
+	}
+}
+
+// close stops accepting entries and waits until the queued ones are written.
+func (a *auditLog) close() {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.queue)
+	}
+	a.mu.Unlock()
+	<-a.done
+}
+
+func (s *sqliteStore) insertRequestLog(batch []RequestLogEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), retentionBatchTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO request_log(
+			timestamp,
+			method,
+			path,
+			pair,
+			status_code,
+			error_code,
+			upstream_duration_ms,
+			db_duration_ms,
+			remote_addr
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return fmt.Errorf("falha ao preparar query. %w", err)
+	}
+	defer stmt.Close()
+
+	for _, e := range batch {
+		_, err = stmt.ExecContext(ctx,
+			e.Timestamp.Unix(),
+			e.Method,
+			e.Path,
+			e.Pair,
+			e.StatusCode,
+			e.ErrorCode,
+			e.UpstreamDurationMs,
+			e.DBDurationMs,
+			e.RemoteAddr,
+		)
+		if err != nil {
+			return fmt.Errorf("falha ao executar query. %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// recentRequestLog returns the newest limit rows of request_log.
+func (s *sqliteStore) recentRequestLog(ctx context.Context, limit int) ([]RequestLogEntry, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, databaseTimeout)
+	defer cancel()
+
+	rows, err := s.readDB.QueryContext(dbCtx, `
+		SELECT
+			id,
+			timestamp,
+			method,
+			path,
+			pair,
+			status_code,
+			error_code,
+			upstream_duration_ms,
+			db_duration_ms,
+			remote_addr
+		FROM request_log
+		ORDER BY id DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao consultar registros de requisições. %w", err)
+	}
+	defer rows.Close()
+
+	entries := []RequestLogEntry{}
+	for rows.Next() {
+		var (
+			e  RequestLogEntry
+			ts int64
+		)
+		err = rows.Scan(
+			&e.ID,
+			&ts,
+			&e.Method,
+			&e.Path,
+			&e.Pair,
+			&e.StatusCode,
+			&e.ErrorCode,
+			&e.UpstreamDurationMs,
+			&e.DBDurationMs,
+			&e.RemoteAddr,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("falha ao ler registro de requisição. %w", err)
+		}
+		e.Timestamp = time.Unix(ts, 0).UTC()
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
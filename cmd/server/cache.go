@@ -0,0 +1,130 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+// quotationCache is a least recently used cache of upstream quotations keyed
+// by pair. Entries expire the cache ttl of the current settings after being
+// fetched, a zero ttl disabling the cache; when the cache is full the least
+// recently used pair is evicted.
+type quotationCache struct {
+	mu       sync.Mutex
+	size     int
+	order    *list.List // front is the most recently used
+	entries  map[string]*list.Element
+	counters CacheStats
+}
+
+type cacheEntry struct {
+	pair      string
+	quotation upstream.Quotation
+	fetchedAt time.Time
+}
+
+// CacheStats are the quotation cache counters, as reported by /healthz.
+type CacheStats struct {
+	Size      int    `json:"size"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+}
+
+// newQuotationCache creates a cache holding up to size pairs.
+func newQuotationCache(size int) *quotationCache {
+	return &quotationCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element, size),
+	}
+}
+
+// get returns the cached quotation of pair if it is still fresh. Expired
+// entries are dropped and count as misses.
+func (c *quotationCache) get(pair string, now time.Time) (upstream.Quotation, bool) {
+	ttl := currentSettings().cacheTTL
+	if ttl <= 0 {
+		return upstream.Quotation{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[pair]
+	if ok && now.Sub(elem.Value.(*cacheEntry).fetchedAt) >= ttl {
+		c.remove(elem)
+		ok = false
+	}
+	if !ok {
+		c.counters.Misses++
+		return upstream.Quotation{}, false
+	}
+	c.counters.Hits++
+	c.order.MoveToFront(elem)
+	return elem.Value.(*cacheEntry).quotation, true
+}
+
+// put stores q as the quotation of pair fetched at now, evicting the least
+// recently used pair if the cache is full. Nothing is stored while the cache
+// is disabled.
+func (c *quotationCache) put(pair string, q upstream.Quotation, now time.Time) {
+	if currentSettings().cacheTTL <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[pair]; ok {
+		entry := elem.Value.(*cacheEntry)
+		entry.quotation, entry.fetchedAt = q, now
+		c.order.MoveToFront(elem)
+		return
+	}
+	if c.order.Len() >= c.size {
+		c.remove(c.order.Back())
+		c.counters.Evictions++
+	}
+	entry := &cacheEntry{pair: pair, quotation: q, fetchedAt: now}
+	c.entries[pair] = c.order.PushFront(entry)
+}
+
+// remove drops elem. The caller must hold c.mu.
+func (c *quotationCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).pair)
+}
+
+// stats returns a snapshot of the counters.
+func (c *quotationCache) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := c.counters
+	s.Size = c.order.Len()
+	return s
+}
+
+// cacheBypass reports whether r asks for a fresh upstream quotation, with
+// ?nocache=true or a Cache-Control: no-cache header.
+func cacheBypass(r *http.Request) (bool, error) {
+	if raw := r.URL.Query().Get("nocache"); raw != "" {
+		bypass, err := strconv.ParseBool(raw)
+		if err != nil {
+			return false, fmt.Errorf("parâmetro nocache inválido: %q", raw)
+		}
+		return bypass, nil
+	}
+	for _, directive := range strings.Split(r.Header.Get("Cache-Control"), ",") {
+		if strings.EqualFold(strings.TrimSpace(directive), "no-cache") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
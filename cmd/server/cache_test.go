@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+func useCacheTTL(t *testing.T, ttl time.Duration) {
+	t.Helper()
+	useSettings(t, func(c *Config) { c.CacheTTL = Duration(ttl) })
+}
+
+func TestQuotationCacheTTL(t *testing.T) {
+	useCacheTTL(t, 10*time.Second)
+	c := newQuotationCache(4)
+	start := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+	c.put("USD-BRL", upstream.Quotation{Bid: "5.1"}, start)
+
+	tests := []struct {
+		after time.Duration
+		hit   bool
+	}{
+		{0, true},
+		{9*time.Second + 999*time.Millisecond, true},
+		{10 * time.Second, false},
+		// The expired entry was dropped: it doesn't come back.
+		{time.Second, false},
+	}
+	for _, tt := range tests {
+		q, ok := c.get("USD-BRL", start.Add(tt.after))
+		if ok != tt.hit || (ok && q.Bid != "5.1") {
+			t.Errorf("get() %v after put = %+v, %v; want hit %v", tt.after, q, ok, tt.hit)
+		}
+	}
+	if s := c.stats(); s.Size != 0 || s.Hits != 2 || s.Misses != 2 || s.Evictions != 0 {
+		t.Errorf("stats() = %+v, want 2 hits, 2 misses and no entries", s)
+	}
+
+	// A put refreshes the entry.
+	c.put("USD-BRL", upstream.Quotation{Bid: "5.2"}, start)
+	c.put("USD-BRL", upstream.Quotation{Bid: "5.3"}, start.Add(8*time.Second))
+	if q, ok := c.get("USD-BRL", start.Add(15*time.Second)); !ok || q.Bid != "5.3" {
+		t.Errorf("get() of the refreshed entry = %+v, %v; want bid 5.3", q, ok)
+	}
+}
+
+func TestQuotationCacheDisabled(t *testing.T) {
+	useCacheTTL(t, 0)
+	c := newQuotationCache(4)
+	c.put("USD-BRL", upstream.Quotation{Bid: "5.1"}, time.Now())
+	if _, ok := c.get("USD-BRL", time.Now()); ok {
+		t.Error("get() hit with a zero ttl")
+	}
+	if s := c.stats(); s.Size != 0 {
+		t.Errorf("stats() = %+v, want nothing stored", s)
+	}
+}
+
+func TestQuotationCacheEviction(t *testing.T) {
+	useCacheTTL(t, time.Minute)
+	c := newQuotationCache(3)
+	now := time.Now()
+	for _, pair := range []string{"USD-BRL", "EUR-BRL", "GBP-BRL"} {
+		c.put(pair, upstream.Quotation{Code: pair[:3]}, now)
+	}
+	// USD-BRL becomes the most recently used, EUR-BRL the least.
+	if _, ok := c.get("USD-BRL", now); !ok {
+		t.Fatal("get(USD-BRL) missed")
+	}
+	c.put("JPY-BRL", upstream.Quotation{Code: "JPY"}, now)
+	// Refreshing an entry doesn't evict.
+	c.put("GBP-BRL", upstream.Quotation{Code: "GBP"}, now)
+
+	for pair, want := range map[string]bool{"USD-BRL": true, "EUR-BRL": false, "GBP-BRL": true, "JPY-BRL": true} {
+		if _, ok := c.get(pair, now); ok != want {
+			t.Errorf("get(%s) hit = %v, want %v", pair, ok, want)
+		}
+	}
+	if s := c.stats(); s.Size != 3 || s.Evictions != 1 {
+		t.Errorf("stats() = %+v, want 3 entries and 1 eviction", s)
+	}
+}
+
+// TestQuotationCacheConcurrent is meant for go test -race: the counters must
+// add up whatever the interleaving.
+func TestQuotationCacheConcurrent(t *testing.T) {
+	useCacheTTL(t, time.Minute)
+	const size, workers, ops = 8, 16, 500
+	c := newQuotationCache(size)
+	now := time.Now()
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < ops; i++ {
+				pair := "C" + strconv.Itoa((w+i)%(2*size)) + "-BRL"
+				if q, ok := c.get(pair, now); ok && q.Code+"-BRL" != pair {
+					t.Errorf("get(%s) = %s", pair, q.Code)
+				}
+				c.put(pair, upstream.Quotation{Code: pair[:len(pair)-4]}, now)
+				c.stats()
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	s := c.stats()
+	if s.Hits+s.Misses != workers*ops {
+		t.Errorf("hits %d + misses %d, want %d lookups", s.Hits, s.Misses, workers*ops)
+	}
+	// A miss is followed by an insert, unless another worker inserted the
+	// pair in between.
+	if s.Size != size || s.Evictions == 0 || s.Evictions > s.Misses-size {
+		t.Errorf("stats() = %+v, want %d entries and at most an eviction per miss beyond them", s, size)
+	}
+}
+
+func TestCacheBypass(t *testing.T) {
+	tests := []struct {
+		target       string
+		cacheControl string
+		want         bool
+		wantErr      bool
+	}{
+		{"/cotacao", "", false, false},
+		{"/cotacao?nocache=true", "", true, false},
+		{"/cotacao?nocache=1", "", true, false},
+		{"/cotacao?nocache=false", "no-cache", false, false},
+		{"/cotacao", "no-cache", true, false},
+		{"/cotacao", "max-age=0, No-Cache", true, false},
+		{"/cotacao", "no-store", false, false},
+		{"/cotacao?nocache=talvez", "", false, true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		if tt.cacheControl != "" {
+			r.Header.Set("Cache-Control", tt.cacheControl)
+		}
+		got, err := cacheBypass(r)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("cacheBypass(%s, %q) = %v, %v; want %v", tt.target, tt.cacheControl, got, err, tt.want)
+		}
+	}
+}
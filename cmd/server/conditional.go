@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+// quotationValidators derives the ETag and Last-Modified values of a response
+// from the upstream timestamp and create_date of each quotation in it. variant
+// identifies the response shape, so different representations of the same
+// quotations never share an ETag.
+func quotationValidators(variant string, pairs []string, quotations map[string]upstream.Quotation) (string, time.Time) {
+	var lastModified time.Time
+	h := sha1.New()
+	io.WriteString(h, variant+"\n")
+	for _, pair := range pairs {
+		q := quotations[pair]
+		io.WriteString(h, pair+"|"+q.Timestamp+"|"+q.CreateDate+"\n")
+		if sec, err := strconv.ParseInt(q.Timestamp, 10, 64); err == nil {
+			if t := time.Unix(sec, 0); t.After(lastModified) {
+				lastModified = t
+			}
+		}
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)) + `"`, lastModified
+}
+
+// setValidators writes the ETag and Last-Modified headers and reports whether
+// the request preconditions allow answering with 304 Not Modified.
+func setValidators(w http.ResponseWriter, r *http.Request, etag string, lastModified time.Time) bool {
+	w.Header().Set("ETag", etag)
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	// If-None-Match takes precedence over If-Modified-Since (RFC 9110).
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(ims)
+		return err == nil && !lastModified.After(t)
+	}
+	return false
+}
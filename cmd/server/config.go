@@ -0,0 +1,744 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	requestTimeoutUsage  string = "request timout usage: -rt 200ms or -rt 1s or -rt 1m"
+	maxTimeoutUsage      string = "max request timeout usage: -max-rt 5s (ceiling for X-Request-Timeout and ?timeout=, must be >= -rt)"
+	databaseTimeoutUsage string = "database timetout usage: -dbt 10ms or -dbt 1s"
+	dbFileUsage          string = "database file usage: -db cotacao.db (:memory: keeps everything in memory, lost on exit; empty disables persistence, like -no-db)"
+	noDBUsage            string = "disable persistence: quotations aren't stored and history endpoints answer 501"
+	drainTimeoutUsage    string = "drain timeout usage: -drain-timeout 5s (on SIGINT/SIGTERM, how long in-flight requests may take before their connections are closed)"
+	busyTimeoutUsage     string = "database busy timeout usage: -dbbt 5s (how long sqlite waits for a lock)"
+	dbReadersUsage       string = "database readers usage: -db-readers 4 (read-only sqlite connections, from 1 to 64; writes always use a single one)"
+	serverPortUsage      string = "server port usage: -p 8080 or -p 3000 (range from 0 to 65535)"
+	listenUsage          string = "listen usage: -listen :8080 or -listen unix:///var/run/cotacao.sock (overrides -p)"
+	socketModeUsage      string = "socket permissions usage: -socket-mode 0660 (octal, only with -listen unix://)"
+	upstreamURLUsage     string = "upstream url usage: -upstream https://economia.awesomeapi.com.br"
+	providersUsage       string = "providers usage: -providers awesomeapi,file (tried in order until one succeeds)"
+	providerRTUsage      string = "provider timeouts usage: -provider-rt awesomeapi=300ms,file=50ms (deadline of each provider attempt, so the next one still fits in -rt)"
+	supportedPairsUsage  string = "supported pairs usage: -supported-pairs USD-BRL,EUR-BRL,BTC-BRL (pairs served by /cotacao, must include USD-BRL, empty accepts any)"
+	providerFileUsage    string = "file provider usage: -provider-file cotacao.json (AwesomeAPI formatted payload)"
+	rateUsage            string = "rate limit usage: -rate 10/s or -rate 100/m (requests per client IP, empty disables)"
+	burstUsage           string = "rate limit burst usage: -burst 20"
+	trustProxyUsage      string = "use the rightmost X-Forwarded-For entry, the one the proxy appended, as the client IP (only behind a single trusted proxy)"
+	corsOriginsUsage     string = "cors usage: -cors-origins \"https://myapp.example,*\" (empty disables CORS)"
+	corsMethodsUsage     string = "cors methods usage: -cors-methods \"GET, HEAD, OPTIONS\" (methods browsers may use cross-origin, checked on preflight)"
+	corsHeadersUsage     string = "cors headers usage: -cors-headers \"Authorization, X-API-Key\" (request headers browsers may send cross-origin, checked on preflight)"
+	retentionUsage       string = "retention usage: -retention 720h (quotations older than this are purged, 0 keeps everything)"
+	streamIntervalUsage  string = "stream interval usage: -stream-interval 5s (upstream polling while /cotacao/stream or /cotacao/events have subscribers, 0 disables)"
+	rollupIntervalUsage  string = "rollup usage: -rollup-interval 1h (consolidate completed days into cotacao_daily, 0 disables)"
+	pollUsage            string = "poll usage: -poll 1m (fetch and store the quotation periodically, 0 disables)"
+	asyncSaveUsage       string = "save quotations in a background writer instead of before responding"
+	flushIntervalUsage   string = "flush interval usage: -flush-interval 500ms (with -async-save, longest a quotation waits in the buffer)"
+	flushSizeUsage       string = "flush size usage: -flush-size 50 (with -async-save, quotations saved per transaction)"
+	strictSaveUsage      string = "answer 503 when the request deadline (-rt) runs out before the save ends, instead of serving the quotation while it is saved"
+	breakerUsage         string = "circuit breaker usage: -breaker 5 (consecutive upstream failures that open it, 0 disables)"
+	breakerWaitUsage     string = "circuit breaker cool-down usage: -breaker-wait 30s (time open before a probe request)"
+	retriesUsage         string = "upstream retries usage: -retries 2 (extra awesomeapi attempts on network errors, 5xx and 429, within -rt)"
+	retryBackoffUsage    string = "retry backoff usage: -retry-backoff 50ms (wait before the first retry, doubled after each one)"
+	retryJitterUsage     string = "retry jitter usage: -retry-jitter 0.5 (fraction from 0 to 1 randomly taken off each backoff)"
+	maxUpstreamUsage     string = "upstream concurrency usage: -max-upstream 5 (simultaneous upstream calls across pairs, 0 disables the limit)"
+	upstreamWaitUsage    string = "upstream queue usage: -upstream-wait 1s (how long a call waits for a -max-upstream slot, 0 fails fast)"
+	upstreamRateUsage    string = "upstream budget usage: -upstream-rate 1/s or -upstream-rate 30/m (awesomeapi calls across every client, stored quotations are served past it, empty disables)"
+	upstreamBurstUsage   string = "upstream budget burst usage: -upstream-burst 5 (awesomeapi calls allowed at once within -upstream-rate)"
+	cacheSizeUsage       string = "cache size usage: -cache-size 32 (pairs kept in the quotation cache)"
+	cacheTTLUsage        string = "cache ttl usage: -cache-ttl 10s (how long a fetched quotation is served from the cache, 0 disables)"
+	maxStaleUsage        string = "max stale usage: -max-stale 1m (GET /cotacao serves the latest stored quotation while younger than this, 0 always fetches)"
+	auditUsage           string = "record every request outcome in the request_log table"
+	adminKeyUsage        string = "admin key usage: -admin-key s3cret (enables /admin/*, empty disables)"
+	requireAPIKeyUsage   string = "require an API key, in X-API-Key or Authorization: Bearer, on the quotation endpoints (keys from -api-keys or POST /admin/keys, which needs -admin-key and a database)"
+	apiKeysUsage         string = "api keys usage: -api-keys key1,key2 (static keys accepted with -require-api-key, next to the stored ones)"
+	jwtSecretUsage       string = "jwt secret usage: -jwt-secret <32+ bytes> (accept HS256 tokens from -jwt-issuer on the quotation and admin endpoints)"
+	jwksURLUsage         string = "jwks usage: -jwks-url https://idp.example/.well-known/jwks.json (accept RS256 tokens signed by these keys)"
+	jwtIssuerUsage       string = "jwt issuer usage: -jwt-issuer https://idp.example (required iss claim, needed by -jwt-secret and -jwks-url)"
+	jwtAudienceUsage     string = "jwt audience usage: -jwt-audience cotacao (required aud claim, empty skips the check)"
+	grpcPortUsage        string = "grpc port usage: -grpc-port 9090 (QuotationService, over TLS when the HTTP server has it, plaintext otherwise, 0 disables)"
+	noMetricsUsage       string = "disable the Prometheus /metrics endpoint"
+	tlsCertUsage         string = "tls certificate usage: -tls-cert server.crt (requires -tls-key)"
+	tlsKeyUsage          string = "tls private key usage: -tls-key server.key (requires -tls-cert)"
+	tlsSelfSignedUsage   string = "serve HTTPS with a certificate generated at startup for localhost (development only, excludes -tls-cert)"
+	tlsClientCAUsage     string = "tls client ca usage: -tls-client-ca ca.pem (require a client certificate signed by this CA on /admin/*, needs TLS)"
+	acmeDomainUsage      string = "acme domain usage: -acme-domain cotacao.example.com,www.cotacao.example.com (obtain and renew the certificate from -acme-directory, excludes -tls-cert and -tls-self-signed)"
+	acmeEmailUsage       string = "acme email usage: -acme-email ops@example.com (account contact, warned about expiring certificates)"
+	acmeDirectoryUsage   string = "acme directory usage: -acme-directory https://acme-staging-v02.api.letsencrypt.org/directory"
+	acmeCacheUsage       string = "acme cache usage: -acme-cache /var/lib/cotacao/acme (keeps the account key and the certificates across restarts)"
+	acmeHTTPUsage        string = "acme http usage: -acme-http :80 (answers the HTTP-01 challenges and redirects everything else to HTTPS)"
+	debugUsage           string = "expose the pprof handlers on the debug address"
+	debugAddrUsage       string = "debug address usage: -debug-addr 127.0.0.1:6060"
+	configFileUsage      string = "config file usage: -config config.json or -config config.toml (flags override the file values)"
+	versionUsage         string = "print the version and build info and exit"
+	langUsage            string = "language usage: -lang pt-BR or -lang en (of the error responses without a supported Accept-Language)"
+	noWarmupUsage        string = "skip the startup fetch of the default pair that fills the cache and checks the upstream"
+	strictWarmupUsage    string = "exit instead of only logging a warning when the startup warm-up fails"
+	warmupTimeoutUsage   string = "warm-up timeout usage: -warmup-timeout 5s (deadline of the startup fetch)"
+	logFormatUsage       string = "log format usage: -log-format text (key=value lines) or -log-format json (JSON Lines); every line of a request carries its request_id"
+	logLevelUsage        string = "log level usage: -log-level info (debug adds request details to the access log, warn only logs failed requests)"
+	otlpEndpointUsage    string = "tracing usage: -otlp-endpoint http://localhost:4318 (OTLP/HTTP collector receiving the spans, empty disables)"
+	reportCronUsage      string = "report schedule usage: -report-cron \"0 8 * * 1-5\" (minute hour day month weekday in UTC, or @daily and @weekly; emails the summary of the stored quotations, needs a database, empty disables)"
+	reportPeriodUsage    string = "report period usage: -report-period daily or -report-period weekly (the 24 hours or the 7 days before each run)"
+	reportPairsUsage     string = "report pairs usage: -report-pairs USD-BRL,EUR-BRL (pairs summarized in the report)"
+	reportFromUsage      string = "report sender usage: -report-from \"Cotação <cotacao@example.com>\" (required by -report-cron)"
+	reportToUsage        string = "report recipients usage: -report-to ops@example.com,fin@example.com (required by -report-cron)"
+	smtpAddrUsage        string = "smtp usage: -smtp-addr smtp.example.com:587 (server the report goes through, with STARTTLS when offered, required by -report-cron)"
+	smtpUserUsage        string = "smtp user usage: -smtp-user cotacao (PLAIN authentication with -smtp-password, over TLS or to localhost only; empty skips it)"
+	smtpPasswordUsage    string = "smtp password usage: -smtp-password s3cret"
+)
+
+// flagEnv maps each flag to the environment variable used when the flag isn't
+// given on the command line. The client reads COTACAO_CLIENT_ names, so
+// none of these configure it when both binaries share a shell.
+var flagEnv = map[string]string{
+	"config":          "COTACAO_CONFIG",
+	"rt":              "COTACAO_REQUEST_TIMEOUT",
+	"max-rt":          "COTACAO_MAX_REQUEST_TIMEOUT",
+	"dbt":             "COTACAO_DB_TIMEOUT",
+	"db":              "COTACAO_DB",
+	"no-db":           "COTACAO_NO_DB",
+	"dbbt":            "COTACAO_DB_BUSY_TIMEOUT",
+	"db-readers":      "COTACAO_DB_READERS",
+	"drain-timeout":   "COTACAO_DRAIN_TIMEOUT",
+	"p":               "COTACAO_PORT",
+	"listen":          "COTACAO_LISTEN",
+	"socket-mode":     "COTACAO_SOCKET_MODE",
+	"upstream":        "COTACAO_UPSTREAM_URL",
+	"providers":       "COTACAO_PROVIDERS",
+	"provider-file":   "COTACAO_PROVIDER_FILE",
+	"provider-rt":     "COTACAO_PROVIDER_TIMEOUTS",
+	"supported-pairs": "COTACAO_SUPPORTED_PAIRS",
+	"rate":            "COTACAO_RATE",
+	"burst":           "COTACAO_BURST",
+	"trust-proxy":     "COTACAO_TRUST_PROXY",
+	"cors-origins":    "COTACAO_CORS_ORIGINS",
+	"cors-methods":    "COTACAO_CORS_METHODS",
+	"cors-headers":    "COTACAO_CORS_HEADERS",
+	"retention":       "COTACAO_RETENTION",
+	"stream-interval": "COTACAO_STREAM_INTERVAL",
+	"poll":            "COTACAO_POLL",
+	"rollup-interval": "COTACAO_ROLLUP_INTERVAL",
+	"async-save":      "COTACAO_ASYNC_SAVE",
+	"flush-interval":  "COTACAO_FLUSH_INTERVAL",
+	"flush-size":      "COTACAO_FLUSH_SIZE",
+	"strict-save":     "COTACAO_STRICT_SAVE",
+	"breaker":         "COTACAO_BREAKER",
+	"breaker-wait":    "COTACAO_BREAKER_WAIT",
+	"retries":         "COTACAO_RETRIES",
+	"retry-backoff":   "COTACAO_RETRY_BACKOFF",
+	"retry-jitter":    "COTACAO_RETRY_JITTER",
+	"max-upstream":    "COTACAO_MAX_UPSTREAM",
+	"upstream-wait":   "COTACAO_UPSTREAM_WAIT",
+	"upstream-rate":   "COTACAO_UPSTREAM_RATE",
+	"upstream-burst":  "COTACAO_UPSTREAM_BURST",
+	"cache-size":      "COTACAO_CACHE_SIZE",
+	"cache-ttl":       "COTACAO_CACHE_TTL",
+	"max-stale":       "COTACAO_MAX_STALE",
+	"audit":           "COTACAO_AUDIT",
+	"admin-key":       "COTACAO_ADMIN_KEY",
+	"require-api-key": "COTACAO_REQUIRE_API_KEY",
+	"api-keys":        "COTACAO_API_KEYS",
+	"jwt-secret":      "COTACAO_JWT_SECRET",
+	"jwks-url":        "COTACAO_JWKS_URL",
+	"jwt-issuer":      "COTACAO_JWT_ISSUER",
+	"jwt-audience":    "COTACAO_JWT_AUDIENCE",
+	"grpc-port":       "COTACAO_GRPC_PORT",
+	"no-metrics":      "COTACAO_NO_METRICS",
+	"tls-cert":        "COTACAO_TLS_CERT",
+	"tls-key":         "COTACAO_TLS_KEY",
+	"tls-self-signed": "COTACAO_TLS_SELF_SIGNED",
+	"tls-client-ca":   "COTACAO_TLS_CLIENT_CA",
+	"acme-domain":     "COTACAO_ACME_DOMAIN",
+	"acme-email":      "COTACAO_ACME_EMAIL",
+	"acme-directory":  "COTACAO_ACME_DIRECTORY",
+	"acme-cache":      "COTACAO_ACME_CACHE",
+	"acme-http":       "COTACAO_ACME_HTTP",
+	"debug":           "COTACAO_DEBUG",
+	"debug-addr":      "COTACAO_DEBUG_ADDR",
+	"lang":            "COTACAO_LANG",
+	"log-level":       "COTACAO_LOG_LEVEL",
+	"log-format":      "COTACAO_LOG_FORMAT",
+	"otlp-endpoint":   "COTACAO_OTLP_ENDPOINT",
+	"no-warmup":       "COTACAO_NO_WARMUP",
+	"strict-warmup":   "COTACAO_STRICT_WARMUP",
+	"warmup-timeout":  "COTACAO_WARMUP_TIMEOUT",
+	"report-cron":     "COTACAO_REPORT_CRON",
+	"report-period":   "COTACAO_REPORT_PERIOD",
+	"report-pairs":    "COTACAO_REPORT_PAIRS",
+	"report-from":     "COTACAO_REPORT_FROM",
+	"report-to":       "COTACAO_REPORT_TO",
+	"smtp-addr":       "COTACAO_SMTP_ADDR",
+	"smtp-user":       "COTACAO_SMTP_USER",
+	"smtp-password":   "COTACAO_SMTP_PASSWORD",
+}
+
+// Config holds every server setting. Fields tagged secret are redacted when
+// the configuration is printed.
+type Config struct {
+	ConfigFile      string   `json:"-" toml:"-"`
+	Version         bool     `json:"-" toml:"-"`
+	RequestTimeout  Duration `json:"request_timeout" toml:"request_timeout"`
+	MaxTimeout      Duration `json:"max_request_timeout" toml:"max_request_timeout"`
+	DatabaseTimeout Duration `json:"database_timeout" toml:"database_timeout"`
+	BusyTimeout     Duration `json:"busy_timeout" toml:"busy_timeout"`
+	DBReaders       uint     `json:"db_readers" toml:"db_readers"`
+	DrainTimeout    Duration `json:"drain_timeout" toml:"drain_timeout"`
+	DBFile          string   `json:"db" toml:"db"`
+	NoDB            bool     `json:"no_db" toml:"no_db"`
+	Port            uint     `json:"port" toml:"port"`
+	Listen          string   `json:"listen" toml:"listen"`
+	SocketMode      string   `json:"socket_mode" toml:"socket_mode"`
+	UpstreamURL     string   `json:"upstream_url" toml:"upstream_url"`
+	Providers       string   `json:"providers" toml:"providers"`
+	ProviderFile    string   `json:"provider_file" toml:"provider_file"`
+	ProviderTimeout string   `json:"provider_timeouts" toml:"provider_timeouts"`
+	SupportedPairs  string   `json:"supported_pairs" toml:"supported_pairs"`
+	Rate            string   `json:"rate" toml:"rate"`
+	Burst           uint     `json:"burst" toml:"burst"`
+	TrustProxy      bool     `json:"trust_proxy" toml:"trust_proxy"`
+	CORSOrigins     string   `json:"cors_origins" toml:"cors_origins"`
+	CORSMethods     string   `json:"cors_methods" toml:"cors_methods"`
+	CORSHeaders     string   `json:"cors_headers" toml:"cors_headers"`
+	Retention       Duration `json:"retention" toml:"retention"`
+	StreamInterval  Duration `json:"stream_interval" toml:"stream_interval"`
+	PollInterval    Duration `json:"poll_interval" toml:"poll_interval"`
+	RollupInterval  Duration `json:"rollup_interval" toml:"rollup_interval"`
+	AsyncSave       bool     `json:"async_save" toml:"async_save"`
+	FlushInterval   Duration `json:"flush_interval" toml:"flush_interval"`
+	FlushSize       uint     `json:"flush_size" toml:"flush_size"`
+	StrictSave      bool     `json:"strict_save" toml:"strict_save"`
+	Breaker         uint     `json:"breaker" toml:"breaker"`
+	BreakerWait     Duration `json:"breaker_wait" toml:"breaker_wait"`
+	Retries         uint     `json:"retries" toml:"retries"`
+	RetryBackoff    Duration `json:"retry_backoff" toml:"retry_backoff"`
+	RetryJitter     float64  `json:"retry_jitter" toml:"retry_jitter"`
+	MaxUpstream     uint     `json:"max_upstream" toml:"max_upstream"`
+	UpstreamWait    Duration `json:"upstream_wait" toml:"upstream_wait"`
+	UpstreamRate    string   `json:"upstream_rate" toml:"upstream_rate"`
+	UpstreamBurst   uint     `json:"upstream_burst" toml:"upstream_burst"`
+	CacheSize       uint     `json:"cache_size" toml:"cache_size"`
+	CacheTTL        Duration `json:"cache_ttl" toml:"cache_ttl"`
+	MaxStale        Duration `json:"max_stale" toml:"max_stale"`
+	Audit           bool     `json:"audit" toml:"audit"`
+	AdminKey        string   `json:"admin_key" toml:"admin_key" secret:"true"`
+	RequireAPIKey   bool     `json:"require_api_key" toml:"require_api_key"`
+	APIKeys         string   `json:"api_keys" toml:"api_keys" secret:"true"`
+	JWTSecret       string   `json:"jwt_secret" toml:"jwt_secret" secret:"true"`
+	JWKSURL         string   `json:"jwks_url" toml:"jwks_url"`
+	JWTIssuer       string   `json:"jwt_issuer" toml:"jwt_issuer"`
+	JWTAudience     string   `json:"jwt_audience" toml:"jwt_audience"`
+	GRPCPort        uint     `json:"grpc_port" toml:"grpc_port"`
+	NoMetrics       bool     `json:"no_metrics" toml:"no_metrics"`
+	TLSCert         string   `json:"tls_cert" toml:"tls_cert"`
+	TLSKey          string   `json:"tls_key" toml:"tls_key"`
+	TLSSelfSigned   bool     `json:"tls_self_signed" toml:"tls_self_signed"`
+	TLSClientCA     string   `json:"tls_client_ca" toml:"tls_client_ca"`
+	ACMEDomain      string   `json:"acme_domain" toml:"acme_domain"`
+	ACMEEmail       string   `json:"acme_email" toml:"acme_email"`
+	ACMEDirectory   string   `json:"acme_directory" toml:"acme_directory"`
+	ACMECache       string   `json:"acme_cache" toml:"acme_cache"`
+	ACMEHTTP        string   `json:"acme_http" toml:"acme_http"`
+	Debug           bool     `json:"debug" toml:"debug"`
+	DebugAddr       string   `json:"debug_addr" toml:"debug_addr"`
+	Lang            string   `json:"lang" toml:"lang"`
+	LogLevel        string   `json:"log_level" toml:"log_level"`
+	LogFormat       string   `json:"log_format" toml:"log_format"`
+	OTLPEndpoint    string   `json:"otlp_endpoint" toml:"otlp_endpoint"`
+	NoWarmup        bool     `json:"no_warmup" toml:"no_warmup"`
+	StrictWarmup    bool     `json:"strict_warmup" toml:"strict_warmup"`
+	WarmupTimeout   Duration `json:"warmup_timeout" toml:"warmup_timeout"`
+	ReportCron      string   `json:"report_cron" toml:"report_cron"`
+	ReportPeriod    string   `json:"report_period" toml:"report_period"`
+	ReportPairs     string   `json:"report_pairs" toml:"report_pairs"`
+	ReportFrom      string   `json:"report_from" toml:"report_from"`
+	ReportTo        string   `json:"report_to" toml:"report_to"`
+	SMTPAddr        string   `json:"smtp_addr" toml:"smtp_addr"`
+	SMTPUser        string   `json:"smtp_user" toml:"smtp_user"`
+	SMTPPassword    string   `json:"smtp_password" toml:"smtp_password" secret:"true"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		RequestTimeout:  Duration(200 * time.Millisecond),
+		MaxTimeout:      Duration(5 * time.Second),
+		DatabaseTimeout: Duration(10 * time.Millisecond),
+		BusyTimeout:     Duration(5 * time.Second),
+		DBReaders:       4,
+		DrainTimeout:    Duration(5 * time.Second),
+		DBFile:          "cotacao.db",
+		Port:            8080,
+		SocketMode:      "0660",
+		UpstreamURL:     upstream.DefaultURL,
+		Providers:       "awesomeapi",
+		ProviderFile:    "cotacao.json",
+		Burst:           20,
+		CORSMethods:     defaultCORSMethods,
+		CORSHeaders:     defaultCORSHeaders,
+		StreamInterval:  Duration(5 * time.Second),
+		RollupInterval:  Duration(time.Hour),
+		ACMEDirectory:   autocert.DefaultACMEDirectory,
+		ACMECache:       "acme",
+		ACMEHTTP:        ":80",
+		DebugAddr:       "127.0.0.1:6060",
+		FlushInterval:   Duration(500 * time.Millisecond),
+		FlushSize:       50,
+		Breaker:         5,
+		BreakerWait:     Duration(30 * time.Second),
+		RetryBackoff:    Duration(50 * time.Millisecond),
+		RetryJitter:     0.5,
+		MaxUpstream:     5,
+		UpstreamWait:    Duration(time.Second),
+		UpstreamBurst:   5,
+		CacheSize:       32,
+		Audit:           true,
+		Lang:            langPortuguese,
+		LogLevel:        "info",
+		LogFormat:       logFormatText,
+		WarmupTimeout:   Duration(5 * time.Second),
+		ReportPeriod:    reportDaily,
+		ReportPairs:     upstream.DefaultPair,
+	}
+}
+
+// LoadConfig builds the configuration from the command line arguments and the
+// optional config file. Precedence: flags, then environment variables, then
+// the file, then the defaults.
+func LoadConfig(args []string) (*Config, error) {
+	cfg := defaultConfig()
+	fs := newFlagSet(cfg)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if err := applyEnv(fs); err != nil {
+		return nil, err
+	}
+	if cfg.Version {
+		return cfg, nil
+	}
+
+	if cfg.ConfigFile != "" {
+		fileCfg := defaultConfig()
+		if err := loadConfigFile(cfg.ConfigFile, fileCfg); err != nil {
+			return nil, err
+		}
+		// Parsing the flags again on top of the file values makes the
+		// explicitly given flags win.
+		fs = newFlagSet(fileCfg)
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if err := applyEnv(fs); err != nil {
+			return nil, err
+		}
+		cfg = fileCfg
+	}
+	return cfg, cfg.validate()
+}
+
+func newFlagSet(cfg *Config) *flag.FlagSet {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.StringVar(&cfg.ConfigFile, "config", cfg.ConfigFile, configFileUsage)
+	fs.BoolVar(&cfg.Version, "version", cfg.Version, versionUsage)
+	fs.Var(&cfg.RequestTimeout, "rt", requestTimeoutUsage)
+	fs.Var(&cfg.MaxTimeout, "max-rt", maxTimeoutUsage)
+	fs.Var(&cfg.DatabaseTimeout, "dbt", databaseTimeoutUsage)
+	fs.Var(&cfg.BusyTimeout, "dbbt", busyTimeoutUsage)
+	fs.UintVar(&cfg.DBReaders, "db-readers", cfg.DBReaders, dbReadersUsage)
+	fs.Var(&cfg.DrainTimeout, "drain-timeout", drainTimeoutUsage)
+	fs.StringVar(&cfg.DBFile, "db", cfg.DBFile, dbFileUsage)
+	fs.BoolVar(&cfg.NoDB, "no-db", cfg.NoDB, noDBUsage)
+	fs.UintVar(&cfg.Port, "p", cfg.Port, serverPortUsage)
+	fs.StringVar(&cfg.Listen, "listen", cfg.Listen, listenUsage)
+	fs.StringVar(&cfg.SocketMode, "socket-mode", cfg.SocketMode, socketModeUsage)
+	fs.StringVar(&cfg.UpstreamURL, "upstream", cfg.UpstreamURL, upstreamURLUsage)
+	fs.StringVar(&cfg.Providers, "providers", cfg.Providers, providersUsage)
+	fs.StringVar(&cfg.ProviderFile, "provider-file", cfg.ProviderFile, providerFileUsage)
+	fs.StringVar(&cfg.ProviderTimeout, "provider-rt", cfg.ProviderTimeout, providerRTUsage)
+	fs.StringVar(&cfg.SupportedPairs, "supported-pairs", cfg.SupportedPairs, supportedPairsUsage)
+	fs.StringVar(&cfg.Rate, "rate", cfg.Rate, rateUsage)
+	fs.UintVar(&cfg.Burst, "burst", cfg.Burst, burstUsage)
+	fs.BoolVar(&cfg.TrustProxy, "trust-proxy", cfg.TrustProxy, trustProxyUsage)
+	fs.StringVar(&cfg.CORSOrigins, "cors-origins", cfg.CORSOrigins, corsOriginsUsage)
+	fs.StringVar(&cfg.CORSMethods, "cors-methods", cfg.CORSMethods, corsMethodsUsage)
+	fs.StringVar(&cfg.CORSHeaders, "cors-headers", cfg.CORSHeaders, corsHeadersUsage)
+	fs.Var(&cfg.Retention, "retention", retentionUsage)
+	fs.Var(&cfg.StreamInterval, "stream-interval", streamIntervalUsage)
+	fs.Var(&cfg.PollInterval, "poll", pollUsage)
+	fs.Var(&cfg.RollupInterval, "rollup-interval", rollupIntervalUsage)
+	fs.BoolVar(&cfg.AsyncSave, "async-save", cfg.AsyncSave, asyncSaveUsage)
+	fs.Var(&cfg.FlushInterval, "flush-interval", flushIntervalUsage)
+	fs.UintVar(&cfg.FlushSize, "flush-size", cfg.FlushSize, flushSizeUsage)
+	fs.BoolVar(&cfg.StrictSave, "strict-save", cfg.StrictSave, strictSaveUsage)
+	fs.UintVar(&cfg.Breaker, "breaker", cfg.Breaker, breakerUsage)
+	fs.Var(&cfg.BreakerWait, "breaker-wait", breakerWaitUsage)
+	fs.UintVar(&cfg.Retries, "retries", cfg.Retries, retriesUsage)
+	fs.Var(&cfg.RetryBackoff, "retry-backoff", retryBackoffUsage)
+	fs.Float64Var(&cfg.RetryJitter, "retry-jitter", cfg.RetryJitter, retryJitterUsage)
+	fs.UintVar(&cfg.MaxUpstream, "max-upstream", cfg.MaxUpstream, maxUpstreamUsage)
+	fs.Var(&cfg.UpstreamWait, "upstream-wait", upstreamWaitUsage)
+	fs.StringVar(&cfg.UpstreamRate, "upstream-rate", cfg.UpstreamRate, upstreamRateUsage)
+	fs.UintVar(&cfg.UpstreamBurst, "upstream-burst", cfg.UpstreamBurst, upstreamBurstUsage)
+	fs.UintVar(&cfg.CacheSize, "cache-size", cfg.CacheSize, cacheSizeUsage)
+	fs.Var(&cfg.CacheTTL, "cache-ttl", cacheTTLUsage)
+	fs.Var(&cfg.MaxStale, "max-stale", maxStaleUsage)
+	fs.BoolVar(&cfg.Audit, "audit", cfg.Audit, auditUsage)
+	fs.StringVar(&cfg.AdminKey, "admin-key", cfg.AdminKey, adminKeyUsage)
+	fs.BoolVar(&cfg.RequireAPIKey, "require-api-key", cfg.RequireAPIKey, requireAPIKeyUsage)
+	fs.StringVar(&cfg.APIKeys, "api-keys", cfg.APIKeys, apiKeysUsage)
+	fs.StringVar(&cfg.JWTSecret, "jwt-secret", cfg.JWTSecret, jwtSecretUsage)
+	fs.StringVar(&cfg.JWKSURL, "jwks-url", cfg.JWKSURL, jwksURLUsage)
+	fs.StringVar(&cfg.JWTIssuer, "jwt-issuer", cfg.JWTIssuer, jwtIssuerUsage)
+	fs.StringVar(&cfg.JWTAudience, "jwt-audience", cfg.JWTAudience, jwtAudienceUsage)
+	fs.UintVar(&cfg.GRPCPort, "grpc-port", cfg.GRPCPort, grpcPortUsage)
+	fs.BoolVar(&cfg.NoMetrics, "no-metrics", cfg.NoMetrics, noMetricsUsage)
+	fs.StringVar(&cfg.TLSCert, "tls-cert", cfg.TLSCert, tlsCertUsage)
+	fs.StringVar(&cfg.TLSKey, "tls-key", cfg.TLSKey, tlsKeyUsage)
+	fs.BoolVar(&cfg.TLSSelfSigned, "tls-self-signed", cfg.TLSSelfSigned, tlsSelfSignedUsage)
+	fs.StringVar(&cfg.TLSClientCA, "tls-client-ca", cfg.TLSClientCA, tlsClientCAUsage)
+	fs.StringVar(&cfg.ACMEDomain, "acme-domain", cfg.ACMEDomain, acmeDomainUsage)
+	fs.StringVar(&cfg.ACMEEmail, "acme-email", cfg.ACMEEmail, acmeEmailUsage)
+	fs.StringVar(&cfg.ACMEDirectory, "acme-directory", cfg.ACMEDirectory, acmeDirectoryUsage)
+	fs.StringVar(&cfg.ACMECache, "acme-cache", cfg.ACMECache, acmeCacheUsage)
+	fs.StringVar(&cfg.ACMEHTTP, "acme-http", cfg.ACMEHTTP, acmeHTTPUsage)
+	fs.BoolVar(&cfg.Debug, "debug", cfg.Debug, debugUsage)
+	fs.StringVar(&cfg.DebugAddr, "debug-addr", cfg.DebugAddr, debugAddrUsage)
+	fs.StringVar(&cfg.Lang, "lang", cfg.Lang, langUsage)
+	fs.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, logLevelUsage)
+	fs.StringVar(&cfg.LogFormat, "log-format", cfg.LogFormat, logFormatUsage)
+	fs.StringVar(&cfg.OTLPEndpoint, "otlp-endpoint", cfg.OTLPEndpoint, otlpEndpointUsage)
+	fs.BoolVar(&cfg.NoWarmup, "no-warmup", cfg.NoWarmup, noWarmupUsage)
+	fs.BoolVar(&cfg.StrictWarmup, "strict-warmup", cfg.StrictWarmup, strictWarmupUsage)
+	fs.Var(&cfg.WarmupTimeout, "warmup-timeout", warmupTimeoutUsage)
+	fs.StringVar(&cfg.ReportCron, "report-cron", cfg.ReportCron, reportCronUsage)
+	fs.StringVar(&cfg.ReportPeriod, "report-period", cfg.ReportPeriod, reportPeriodUsage)
+	fs.StringVar(&cfg.ReportPairs, "report-pairs", cfg.ReportPairs, reportPairsUsage)
+	fs.StringVar(&cfg.ReportFrom, "report-from", cfg.ReportFrom, reportFromUsage)
+	fs.StringVar(&cfg.ReportTo, "report-to", cfg.ReportTo, reportToUsage)
+	fs.StringVar(&cfg.SMTPAddr, "smtp-addr", cfg.SMTPAddr, smtpAddrUsage)
+	fs.StringVar(&cfg.SMTPUser, "smtp-user", cfg.SMTPUser, smtpUserUsage)
+	fs.StringVar(&cfg.SMTPPassword, "smtp-password", cfg.SMTPPassword, smtpPasswordUsage)
+	fs.VisitAll(func(f *flag.Flag) {
+		if env, ok := flagEnv[f.Name]; ok {
+			f.Usage += " (env " + env + ")"
+		}
+	})
+	return fs
+}
+
+// applyEnv sets every flag that wasn't given on the command line from its
+// environment variable, going through the flag parsing so bad values fail
+// the same way.
+func applyEnv(fs *flag.FlagSet) error {
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(flagEnv[f.Name])
+		if err != nil || given[f.Name] || !ok {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("%s=%q - %s", flagEnv[f.Name], value, f.Usage)
+		}
+	})
+	return err
+}
+
+// loadConfigFile decodes the JSON, or with a .toml extension TOML, file in
+// path over cfg. Unknown keys are rejected.
+func loadConfigFile(path string, cfg *Config) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("falha ao abrir arquivo de configuração. %w", err)
+	}
+	defer file.Close()
+
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		md, err := toml.NewDecoder(file).Decode(cfg)
+		if err != nil {
+			return fmt.Errorf("arquivo de configuração %s inválido. %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("arquivo de configuração %s inválido. chave desconhecida %q", path, undecoded[0].String())
+		}
+		return nil
+	}
+	dec := json.NewDecoder(file)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return fmt.Errorf("arquivo de configuração %s inválido. %w", path, err)
+	}
+	return nil
+}
+
+// validate checks the settings that can't be expressed by their types,
+// answering with the same usage messages the flags document.
+func (c *Config) validate() error {
+	durations := []struct {
+		value Duration
+		usage string
+	}{
+		{c.RequestTimeout, requestTimeoutUsage},
+		{c.DatabaseTimeout, databaseTimeoutUsage},
+		{c.BusyTimeout, busyTimeoutUsage},
+		{c.Retention, retentionUsage},
+		{c.StreamInterval, streamIntervalUsage},
+		{c.PollInterval, pollUsage},
+		{c.RollupInterval, rollupIntervalUsage},
+		{c.BreakerWait, breakerWaitUsage},
+		{c.UpstreamWait, upstreamWaitUsage},
+		{c.RetryBackoff, retryBackoffUsage},
+		{c.CacheTTL, cacheTTLUsage},
+		{c.MaxStale, maxStaleUsage},
+	}
+	for _, d := range durations {
+		if d.value < 0 {
+			return errors.New(d.usage)
+		}
+	}
+	if c.DrainTimeout <= 0 {
+		return errors.New(drainTimeoutUsage)
+	}
+	if c.WarmupTimeout <= 0 {
+		return errors.New(warmupTimeoutUsage)
+	}
+	if c.NoWarmup && c.StrictWarmup {
+		return errors.New("-no-warmup e -strict-warmup não podem ser usados juntos")
+	}
+	if c.RetryJitter < 0 || c.RetryJitter > 1 {
+		return errors.New(retryJitterUsage)
+	}
+	if c.Retries > 10 {
+		return errors.New(retriesUsage)
+	}
+	if c.MaxTimeout < c.RequestTimeout {
+		return errors.New(maxTimeoutUsage)
+	}
+	if c.FlushInterval <= 0 {
+		return errors.New(flushIntervalUsage)
+	}
+	if c.FlushSize == 0 {
+		return errors.New(flushSizeUsage)
+	}
+	if c.DBReaders == 0 || c.DBReaders > 64 {
+		return errors.New(dbReadersUsage)
+	}
+	if c.CacheTTL > 0 && c.CacheSize == 0 {
+		return errors.New(cacheSizeUsage)
+	}
+	if c.Port > 65535 {
+		return errors.New(serverPortUsage)
+	}
+	if _, _, err := parseListen(c.Listen, c.Port); err != nil {
+		return fmt.Errorf("%v - %s", err, listenUsage)
+	}
+	if mode, err := strconv.ParseUint(c.SocketMode, 8, 32); err != nil || mode > 0o777 {
+		return errors.New(socketModeUsage)
+	}
+	if err := upstream.ValidateURL(c.UpstreamURL); err != nil {
+		return fmt.Errorf("%v - %s", err, upstreamURLUsage)
+	}
+	for _, name := range strings.Split(c.Providers, ",") {
+		if !upstream.KnownProviders[strings.TrimSpace(name)] {
+			return fmt.Errorf("provedor desconhecido: %q - %s", name, providersUsage)
+		}
+	}
+	if _, err := upstream.ParseTimeouts(c.ProviderTimeout); err != nil {
+		return fmt.Errorf("%v - %s", err, providerRTUsage)
+	}
+	pairs, err := parseSupportedPairs(c.SupportedPairs)
+	if err != nil {
+		return fmt.Errorf("%v - %s", err, supportedPairsUsage)
+	}
+	if pairs != nil && !containsPair(pairs, upstream.DefaultPair) {
+		return errors.New(supportedPairsUsage)
+	}
+	if _, err := parseCORSList(c.CORSMethods); err != nil {
+		return fmt.Errorf("%v - %s", err, corsMethodsUsage)
+	}
+	if _, err := parseCORSList(c.CORSHeaders); err != nil {
+		return fmt.Errorf("%v - %s", err, corsHeadersUsage)
+	}
+	if c.Rate != "" {
+		if _, err := parseRate(c.Rate); err != nil {
+			return fmt.Errorf("%v - %s", err, rateUsage)
+		}
+		if c.Burst == 0 || c.Burst > 65535 {
+			return errors.New(burstUsage)
+		}
+	}
+	if c.UpstreamRate != "" {
+		if _, err := parseRate(c.UpstreamRate); err != nil {
+			return fmt.Errorf("%v - %s", err, upstreamRateUsage)
+		}
+		if c.UpstreamBurst == 0 || c.UpstreamBurst > 1000 {
+			return errors.New(upstreamBurstUsage)
+		}
+	}
+	if c.OTLPEndpoint != "" {
+		if err := upstream.ValidateURL(c.OTLPEndpoint); err != nil {
+			return fmt.Errorf("%v - %s", err, otlpEndpointUsage)
+		}
+	}
+	if _, ok := parseLang(c.Lang); !ok {
+		return errors.New(langUsage)
+	}
+	if _, ok := parseLogLevel(c.LogLevel); !ok {
+		return errors.New(logLevelUsage)
+	}
+	if c.LogFormat != logFormatText && c.LogFormat != logFormatJSON {
+		return errors.New(logFormatUsage)
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return errors.New("-tls-cert e -tls-key devem ser informados juntos")
+	}
+	if c.TLSSelfSigned && c.TLSCert != "" {
+		return errors.New(tlsSelfSignedUsage)
+	}
+	if c.ACMEDomain != "" {
+		if c.TLSCert != "" || c.TLSSelfSigned {
+			return errors.New(acmeDomainUsage)
+		}
+		if _, err := parseACMEDomains(c.ACMEDomain); err != nil {
+			return fmt.Errorf("%v - %s", err, acmeDomainUsage)
+		}
+		if err := upstream.ValidateURL(c.ACMEDirectory); err != nil {
+			return fmt.Errorf("%v - %s", err, acmeDirectoryUsage)
+		}
+		if c.ACMECache == "" {
+			return errors.New(acmeCacheUsage)
+		}
+		if _, _, err := net.SplitHostPort(c.ACMEHTTP); err != nil {
+			return fmt.Errorf("%v - %s", err, acmeHTTPUsage)
+		}
+	}
+	tlsEnabled := c.TLSCert != "" || c.TLSSelfSigned || c.ACMEDomain != ""
+	if c.TLSClientCA != "" && !tlsEnabled {
+		return errors.New(tlsClientCAUsage)
+	}
+	if c.GRPCPort > 65535 {
+		return errors.New(grpcPortUsage)
+	}
+	jwtEnabled := c.JWTSecret != "" || c.JWKSURL != ""
+	// Without static keys or tokens, the keys can only come from POST
+	// /admin/keys.
+	if c.RequireAPIKey && !jwtEnabled && strings.TrimSpace(c.APIKeys) == "" && (c.AdminKey == "" || c.NoDB || c.DBFile == "") {
+		return errors.New(requireAPIKeyUsage)
+	}
+	if c.JWTSecret != "" && len(c.JWTSecret) < 32 {
+		return errors.New(jwtSecretUsage)
+	}
+	if c.JWKSURL != "" {
+		if err := upstream.ValidateURL(c.JWKSURL); err != nil {
+			return fmt.Errorf("%v - %s", err, jwksURLUsage)
+		}
+	}
+	if jwtEnabled && c.JWTIssuer == "" {
+		return errors.New(jwtIssuerUsage)
+	}
+	if c.ReportCron != "" {
+		return c.validateReport()
+	}
+	return nil
+}
+
+// validateReport checks the settings of the -report-cron email.
+func (c *Config) validateReport() error {
+	if _, err := parseCron(c.ReportCron); err != nil {
+		return fmt.Errorf("%v - %s", err, reportCronUsage)
+	}
+	if c.NoDB || c.DBFile == "" {
+		return errors.New(reportCronUsage)
+	}
+	if c.ReportPeriod != reportDaily && c.ReportPeriod != reportWeekly {
+		return errors.New(reportPeriodUsage)
+	}
+	if _, err := upstream.ParsePairs(c.ReportPairs); err != nil {
+		return fmt.Errorf("%v - %s", err, reportPairsUsage)
+	}
+	if _, err := mail.ParseAddress(c.ReportFrom); err != nil {
+		return fmt.Errorf("%v - %s", err, reportFromUsage)
+	}
+	if _, err := mail.ParseAddressList(c.ReportTo); err != nil {
+		return fmt.Errorf("%v - %s", err, reportToUsage)
+	}
+	if _, _, err := net.SplitHostPort(c.SMTPAddr); err != nil {
+		return fmt.Errorf("%v - %s", err, smtpAddrUsage)
+	}
+	return nil
+}
+
+// String renders the configuration as JSON with secrets redacted, suitable
+// for logging.
+func (c Config) String() string {
+	b, err := json.Marshal(c.redacted())
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
+
+// redacted returns a copy of c with the secret fields masked.
+func (c Config) redacted() Config {
+	v := reflect.ValueOf(&c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if v.Type().Field(i).Tag.Get("secret") == "true" && field.Kind() == reflect.String && field.Len() > 0 {
+			field.SetString("***")
+		}
+	}
+	return c
+}
+
+// Duration is a time.Duration written as "200ms" in config files and flags.
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// Set implements flag.Value.
+func (d *Duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, used by the TOML
+// decoder.
+func (d *Duration) UnmarshalText(b []byte) error {
+	return d.Set(string(b))
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duração deve ser uma string como \"200ms\": %w", err)
+	}
+	return d.Set(s)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigUpstreamURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		env     string
+		want    string
+		wantErr bool
+	}{
+		{"default", nil, "", "https://economia.awesomeapi.com.br", false},
+		{"flag", []string{"-upstream", "http://localhost:9911"}, "", "http://localhost:9911", false},
+		{"env", nil, "https://proxy.example.com/awesomeapi", "https://proxy.example.com/awesomeapi", false},
+		{"flag over env", []string{"-upstream", "http://localhost:9911"}, "https://proxy.example.com", "http://localhost:9911", false},
+		{"not http", []string{"-upstream", "ftp://economia.awesomeapi.com.br"}, "", "", true},
+		{"relative", []string{"-upstream", "economia.awesomeapi.com.br"}, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				t.Setenv("COTACAO_UPSTREAM_URL", tt.env)
+			}
+			cfg, err := LoadConfig(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadConfig(%q) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			}
+			if err == nil && cfg.UpstreamURL != tt.want {
+				t.Errorf("UpstreamURL = %q, want %q", cfg.UpstreamURL, tt.want)
+			}
+		})
+	}
+}
+
+// writeConfigFile writes content to a file called name in a temporary
+// directory, returning its path.
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	jsonFile := writeConfigFile(t, "config.json", `{"port": 9000, "request_timeout": "300ms", "cache_ttl": "1m", "rate": "10/s"}`)
+	tomlFile := writeConfigFile(t, "config.toml", "# comentário\nport = 9001\nrequest_timeout = \"400ms\"\ncache_ttl = \"2m\"\n")
+	tests := []struct {
+		name     string
+		args     []string
+		env      map[string]string
+		wantPort uint
+		wantRT   time.Duration
+		wantTTL  time.Duration
+	}{
+		{"defaults", nil, nil, 8080, 200 * time.Millisecond, 0},
+		{"file", []string{"-config", jsonFile}, nil, 9000, 300 * time.Millisecond, time.Minute},
+		{"toml file", []string{"-config", tomlFile}, nil, 9001, 400 * time.Millisecond, 2 * time.Minute},
+		{"file from env", nil, map[string]string{"COTACAO_CONFIG": jsonFile}, 9000, 300 * time.Millisecond, time.Minute},
+		{"env over file", []string{"-config", jsonFile}, map[string]string{"COTACAO_PORT": "9100"}, 9100, 300 * time.Millisecond, time.Minute},
+		{"flag over file", []string{"-config", jsonFile, "-rt", "1s"}, nil, 9000, time.Second, time.Minute},
+		{"flag over env and file", []string{"-config", jsonFile, "-p", "9200"}, map[string]string{"COTACAO_PORT": "9100"}, 9200, 300 * time.Millisecond, time.Minute},
+		// A flag given with the default value still wins over the file.
+		{"flag with the default value", []string{"-config", jsonFile, "-p", "8080"}, nil, 8080, 300 * time.Millisecond, time.Minute},
+		{"env without file", nil, map[string]string{"COTACAO_REQUEST_TIMEOUT": "250ms"}, 8080, 250 * time.Millisecond, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			cfg, err := LoadConfig(tt.args)
+			if err != nil {
+				t.Fatalf("LoadConfig(%q) error = %v", tt.args, err)
+			}
+			if cfg.Port != tt.wantPort || time.Duration(cfg.RequestTimeout) != tt.wantRT || time.Duration(cfg.CacheTTL) != tt.wantTTL {
+				t.Errorf("port %d rt %v ttl %v, want %d %v %v", cfg.Port, cfg.RequestTimeout, cfg.CacheTTL, tt.wantPort, tt.wantRT, tt.wantTTL)
+			}
+			// Everything else keeps its default.
+			if cfg.Burst != 20 || cfg.DBFile != "cotacao.db" {
+				t.Errorf("burst %d db %q, want the defaults", cfg.Burst, cfg.DBFile)
+			}
+		})
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		file string
+	}{
+		{"unknown flag", []string{"-nope"}, nil, ""},
+		{"malformed duration flag", []string{"-rt", "soon"}, nil, ""},
+		{"negative duration", []string{"-dbt", "-1s"}, nil, ""},
+		{"max-rt below rt", []string{"-rt", "2s", "-max-rt", "1s"}, nil, ""},
+		{"port out of range", []string{"-p", "70000"}, nil, ""},
+		{"log format", []string{"-log-format", "xml"}, nil, ""},
+		{"rate", []string{"-rate", "lots"}, nil, ""},
+		{"cache ttl without cache", []string{"-cache-ttl", "1m", "-cache-size", "0"}, nil, ""},
+		{"short jwt secret", []string{"-jwt-secret", "short", "-jwt-issuer", "https://issuer.example.com"}, nil, ""},
+		{"warmup flags together", []string{"-no-warmup", "-strict-warmup"}, nil, ""},
+		{"bad env", nil, map[string]string{"COTACAO_PORT": "eighty"}, ""},
+		{"missing file", []string{"-config", filepath.Join(t.TempDir(), "missing.json")}, nil, ""},
+		{"malformed json", nil, nil, `{"port": 9000`},
+		{"unknown key", nil, nil, `{"port": 9000, "prot": 9001}`},
+		{"duration as number", nil, nil, `{"request_timeout": 300}`},
+		{"invalid value in file", nil, nil, `{"log_format": "xml"}`},
+		{"toml table", nil, nil, "[server]\nport = 9000\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			args := tt.args
+			if tt.file != "" {
+				name := "config.json"
+				if strings.HasPrefix(tt.file, "[") {
+					name = "config.toml"
+				}
+				args = append([]string{"-config", writeConfigFile(t, name, tt.file)}, args...)
+			}
+			if cfg, err := LoadConfig(args); err == nil {
+				t.Errorf("LoadConfig(%q) = %v, want error", args, cfg)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFileTOML(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    func(*Config) bool
+		wantErr bool
+	}{
+		{"basic string with escapes", `cors_origins = "https://a.example,\thttps://b.example"`,
+			func(c *Config) bool { return c.CORSOrigins == "https://a.example,\thttps://b.example" }, false},
+		{"literal string", `admin_key = 'C:\keys\admin'`,
+			func(c *Config) bool { return c.AdminKey == `C:\keys\admin` }, false},
+		{"hash inside a string", `jwt_issuer = "https://issuer.example/#main" # comentário`,
+			func(c *Config) bool { return c.JWTIssuer == "https://issuer.example/#main" }, false},
+		{"comments and blank lines", "# topo\n\nport = 9000 # porta\n",
+			func(c *Config) bool { return c.Port == 9000 }, false},
+		{"integer with underscores and float", "cache_size = 1_000\nretry_jitter = 0.25\n",
+			func(c *Config) bool { return c.CacheSize == 1000 && c.RetryJitter == 0.25 }, false},
+		{"boolean and duration", "async_save = true\nflush_interval = \"1s\"\n",
+			func(c *Config) bool { return c.AsyncSave && time.Duration(c.FlushInterval) == time.Second }, false},
+		{"array", `providers = ["awesomeapi", "file"]`, nil, true},
+		{"unknown key", "prot = 9000", nil, true},
+		{"table", "[server]\nport = 9000\n", nil, true},
+		{"unterminated string", `listen = "/tmp/cotacao.sock`, nil, true},
+		{"missing value", "port =", nil, true},
+		{"duplicate key", "port = 9000\nport = 9001\n", nil, true},
+		{"wrong type", `port = "9000"`, nil, true},
+		{"invalid duration", `request_timeout = "soon"`, nil, true},
+		{"duration as number", "request_timeout = 300", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			err := loadConfigFile(writeConfigFile(t, "config.toml", tt.content), cfg)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("loadConfigFile(%q) = %+v, want error", tt.content, cfg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadConfigFile(%q) error = %v", tt.content, err)
+			}
+			if !tt.want(cfg) {
+				t.Errorf("loadConfigFile(%q) = %+v", tt.content, cfg)
+			}
+		})
+	}
+}
+
+func TestConfigStringRedactsSecrets(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.AdminKey = "admin-s3cret"
+	cfg.APIKeys = "ops:key-s3cret"
+	cfg.JWTSecret = "jwt-s3cret-jwt-s3cret-jwt-s3cret-jwt"
+	cfg.SMTPPassword = "smtp-s3cret"
+	s := cfg.String()
+	if strings.Contains(s, "s3cret") {
+		t.Errorf("String() leaks a secret: %s", s)
+	}
+	if !strings.Contains(s, `"admin_key":"***"`) || !strings.Contains(s, `"port":8080`) {
+		t.Errorf("String() = %s, want the secrets masked and the rest kept", s)
+	}
+	if cfg.AdminKey != "admin-s3cret" {
+		t.Error("String() changed the configuration")
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+const defaultConvertDecimals int = 2
+
+// roundingModes are the values of ?rounding= on /convert.
+var roundingModes = map[string]upstream.RoundingMode{
+	"half-up":   upstream.RoundHalfUp,
+	"half-even": upstream.RoundHalfEven,
+	"down":      upstream.RoundDown,
+	"up":        upstream.RoundUp,
+}
+
+// ConversionResponse is the body of GET /convert: Amount of From is Value of
+// To at Rate, the bid or the ask of the quotation given by Side. Date is only
+// set for historical conversions.
+type ConversionResponse struct {
+	From      string           `json:"from"`
+	To        string           `json:"to"`
+	Amount    upstream.Decimal `json:"amount"`
+	Side      string           `json:"side"`
+	Rate      upstream.Decimal `json:"rate"`
+	Value     upstream.Decimal `json:"value"`
+	Decimals  int              `json:"decimals"`
+	Rounding  string           `json:"rounding"`
+	Date      string           `json:"date,omitempty"`
+	Timestamp string           `json:"timestamp"`
+	Source    string           `json:"source"`
+	// Derived and Components show a cross rate, as on /cotacao.
+	Derived    bool            `json:"derived,omitempty"`
+	Components []ComponentRate `json:"components,omitempty"`
+}
+
+// conversionRequest is a parsed GET /convert query.
+type conversionRequest struct {
+	pair     string
+	amount   upstream.Decimal
+	ask      bool
+	day      time.Time
+	decimals int
+	rounding string
+}
+
+// convertHandler serves GET /convert?from=USD&to=BRL&amount=123.45, the
+// amount multiplied by the current bid of the pair, or the ask with
+// ?side=ask. ?date=2006-01-02 uses the last quotation stored that day (UTC)
+// instead. The value is rounded to ?decimals= places, 2 by default, with
+// ?rounding= half-up (default), half-even, down or up.
+func convertHandler(w http.ResponseWriter, r *http.Request) {
+	req, err := parseConversion(r)
+	if err != nil {
+		msg := fmt.Sprint("GET /convert - ", err)
+		sendMsgError(w, codeBadRequest, msg, http.StatusBadRequest)
+		return
+	}
+
+	timeout, clamped, err := requestedTimeout(r)
+	if err != nil {
+		msg := fmt.Sprint("GET /convert - ", err)
+		sendMsgError(w, codeBadRequest, msg, http.StatusBadRequest)
+		return
+	}
+	if clamped {
+		w.Header().Set("X-Effective-Timeout", timeout.String())
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	info := requestInfoFrom(r.Context())
+	info.Pair = req.pair
+
+	var (
+		q        upstream.Quotation
+		provider string
+	)
+	if req.day.IsZero() {
+		fetchStart := time.Now()
+		q, provider, err = currentQuotation(ctx, timeout, req.pair)
+		info.UpstreamDuration = time.Since(fetchStart)
+		if err != nil {
+			setUpstreamRetryAfter(w, err)
+			statusCode, code := fetchErrorStatus(err)
+			msg := fmt.Sprint("GET /convert - ", err)
+			if code == codeUpstreamTimeout {
+				msg = fmt.Sprint("GET /convert - requisição ultrapassou o tempo máximo de ", timeout)
+			}
+			sendMsgError(w, code, msg, statusCode)
+			return
+		}
+	} else {
+		dbStart := time.Now()
+		q, err = quotationOfDay(ctx, req.pair, req.day)
+		info.DBDuration = time.Since(dbStart)
+		if errors.Is(err, sql.ErrNoRows) {
+			msg := fmt.Sprintf("GET /convert - nenhuma cotação de %s armazenada em %s", req.pair, req.day.Format("2006-01-02"))
+			sendMsgError(w, codeNotFound, msg, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			statusCode, code := dbErrorStatus(err, codeDBReadFailed)
+			msg := fmt.Sprint("GET /convert - ", err)
+			sendMsgError(w, code, msg, statusCode)
+			return
+		}
+		provider = "database"
+	}
+
+	resp := ConversionResponse{
+		From:       q.Code,
+		To:         q.CodeIn,
+		Amount:     req.amount,
+		Side:       "bid",
+		Rate:       q.BidValue,
+		Decimals:   req.decimals,
+		Rounding:   req.rounding,
+		Timestamp:  q.Timestamp,
+		Source:     provider,
+		Derived:    q.Derived,
+		Components: componentRates(q),
+	}
+	if req.ask {
+		resp.Side, resp.Rate = "ask", q.AskValue
+	}
+	if !req.day.IsZero() {
+		resp.Date = req.day.Format("2006-01-02")
+	}
+	resp.Value, err = req.amount.Mul(resp.Rate, req.decimals, roundingModes[req.rounding])
+	if err != nil {
+		msg := fmt.Sprint("GET /convert - amount grande demais: ", err)
+		sendMsgError(w, codeBadRequest, msg, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("X-Quotation-Provider", provider)
+	w.Header().Set("X-Quotation-Source", quotationSource(provider))
+	if err := sendJSON(w, http.StatusOK, resp); err != nil {
+		slog.WarnContext(r.Context(), "GET /convert - falha ao enviar resposta", "err", err)
+	}
+}
+
+func parseConversion(r *http.Request) (conversionRequest, error) {
+	query := r.URL.Query()
+	req := conversionRequest{decimals: defaultConvertDecimals, rounding: "half-up"}
+
+	from, to := query.Get("from"), query.Get("to")
+	if from == "" || to == "" {
+		return req, errors.New("informe as moedas em from e to")
+	}
+	pairs, err := upstream.ParsePairs(from + "-" + to)
+	if err != nil || len(pairs) != 1 {
+		return req, fmt.Errorf("moedas inválidas: %q e %q", from, to)
+	}
+	if err := checkSupportedPairs(pairs); err != nil {
+		return req, err
+	}
+	req.pair = pairs[0]
+
+	raw := query.Get("amount")
+	if raw == "" {
+		return req, errors.New("informe o valor em amount")
+	}
+	req.amount, err = upstream.ParseDecimal(raw)
+	if err != nil || req.amount < 0 {
+		return req, fmt.Errorf("parâmetro amount inválido: %q", raw)
+	}
+
+	switch side := query.Get("side"); side {
+	case "", "bid":
+	case "ask":
+		req.ask = true
+	default:
+		return req, fmt.Errorf("parâmetro side deve ser bid ou ask: %q", side)
+	}
+	if raw := query.Get("date"); raw != "" {
+		req.day, err = time.Parse("2006-01-02", raw)
+		if err != nil {
+			return req, fmt.Errorf("parâmetro date inválido, use 2006-01-02: %q", raw)
+		}
+	}
+	if raw := query.Get("decimals"); raw != "" {
+		req.decimals, err = strconv.Atoi(raw)
+		if err != nil || req.decimals < 0 || req.decimals > upstream.DecimalScale {
+			return req, fmt.Errorf("parâmetro decimals deve estar entre 0 e %d: %q", upstream.DecimalScale, raw)
+		}
+	}
+	if raw := query.Get("rounding"); raw != "" {
+		if _, ok := roundingModes[raw]; !ok {
+			return req, fmt.Errorf("parâmetro rounding deve ser half-up, half-even, down ou up: %q", raw)
+		}
+		req.rounding = raw
+	}
+	return req, nil
+}
+
+// currentQuotation is the quotation of pair /cotacao would serve: cached,
+// else fetched and cached, else, with the breaker open or the upstream
+// budget spent, the last stored one. Conversions don't store what they fetch.
+func currentQuotation(ctx context.Context, timeout time.Duration, pair string) (upstream.Quotation, string, error) {
+	pairs := []string{pair}
+	if cached, missing := cachedQuotations(pairs); len(missing) == 0 {
+		return cached[pair], "cache", nil
+	}
+	fetched, provider, err := fetchUpstream(ctx, timeout, pairs)
+	if errors.Is(err, upstream.ErrCircuitOpen) || errors.Is(err, upstream.ErrBudgetExhausted) {
+		if stored, dbErr := storedQuotations(ctx, pairs); dbErr == nil {
+			return stored[pair], "database", nil
+		}
+	}
+	if err != nil {
+		return upstream.Quotation{}, "", err
+	}
+	return fetched[pair], provider, nil
+}
+
+// quotationOfDay returns the last quotation of pair stored on day, a UTC
+// midnight, or sql.ErrNoRows. A cross rate is derived from the last of each
+// leg that day.
+func quotationOfDay(ctx context.Context, pair string, day time.Time) (upstream.Quotation, error) {
+	q, err := lastStoredOfDay(ctx, pair, day)
+	first, second, cross := upstream.CrossLegs(pair)
+	if !errors.Is(err, sql.ErrNoRows) || !cross {
+		return q, err
+	}
+	firstQ, err := lastStoredOfDay(ctx, first, day)
+	if err != nil {
+		return upstream.Quotation{}, err
+	}
+	secondQ, err := lastStoredOfDay(ctx, second, day)
+	if err != nil {
+		return upstream.Quotation{}, err
+	}
+	return upstream.CrossRate(pair, firstQ, secondQ)
+}
+
+func lastStoredOfDay(ctx context.Context, pair string, day time.Time) (upstream.Quotation, error) {
+	filter := historyFilter{Pair: pair, From: day, To: day.AddDate(0, 0, 1)}
+	page, _, err := store.quotationPage(ctx, filter, historyPage{Desc: true, Limit: 1})
+	if err != nil {
+		return upstream.Quotation{}, err
+	}
+	if len(page) == 0 {
+		return upstream.Quotation{}, sql.ErrNoRows
+	}
+	return page[0].Quotation, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+// TestConvertHandlerRounding converts at the bid 5.1234 and ask 5.1240 of
+// the fake upstream.
+func TestConvertHandlerRounding(t *testing.T) {
+	useSettings(t, nil)
+	useStore(t, newMemoryStore())
+	fake := newFakeUpstream(t, http.StatusOK)
+	useChain(t, "awesomeapi", upstream.ChainConfig{BaseURL: fake.URL})
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"amount=100", `"value":512.34`},
+		{"amount=0.0001&decimals=8", `"value":0.00051234`},
+		{"amount=0.0001&decimals=4", `"value":0.0005`},
+		{"amount=0.0001&decimals=4&rounding=up", `"value":0.0006`},
+		{"amount=0.0001", `"value":0`},
+		{"amount=1.50&side=ask", `"value":7.69`},
+		{"amount=0.25&decimals=4", `"value":1.2809`},
+		{"amount=0.25&decimals=4&rounding=half-even", `"value":1.2808`},
+		{"amount=0.25&decimals=4&rounding=down", `"value":1.2808`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			convertHandler(w, httptest.NewRequest(http.MethodGet, "/convert?from=USD&to=BRL&"+tt.query, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %s", w.Code, w.Body)
+			}
+			var raw map[string]json.RawMessage
+			if err := json.Unmarshal(w.Body.Bytes(), &raw); err != nil {
+				t.Fatal(err)
+			}
+			if got := `"value":` + string(raw["value"]); got != tt.want {
+				t.Errorf("GET /convert?%s = %s, want %s", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertHandlerInvalidAmount(t *testing.T) {
+	useSettings(t, nil)
+	useStore(t, newMemoryStore())
+	for _, amount := range []string{"", "-1", "1e3", "0.000000001", "1,5", ".5", "99999999999"} {
+		t.Run(amount, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			convertHandler(w, httptest.NewRequest(http.MethodGet, "/convert?from=USD&to=BRL&amount="+amount, nil))
+			var p Problem
+			if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil || w.Code != http.StatusBadRequest || p.Code != codeBadRequest {
+				t.Errorf("status = %d, body %s; want 400 %s", w.Code, w.Body, codeBadRequest)
+			}
+		})
+	}
+}
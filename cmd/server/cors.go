@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const (
+	defaultCORSMethods string = "GET, HEAD, OPTIONS"
+	defaultCORSHeaders string = "Accept, Accept-Language, Authorization, Content-Type, If-Modified-Since, If-None-Match, Last-Event-ID, X-API-Key, X-Request-ID, X-Request-Timeout"
+	corsExposedHeaders string = "ETag, Last-Modified, Retry-After, X-Request-ID, X-Effective-Timeout, X-Quotation-Age, X-Quotation-Provider, X-Quotation-Source, X-Quotation-Stale"
+	corsMaxAge         string = "600"
+)
+
+// httpToken is a method or header name (RFC 9110, section 5.6.2).
+var httpToken = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+// corsPolicy answers preflight requests and sets the CORS headers for the
+// configured origins. "*" allows any origin. Preflights asking for a method
+// or a header outside the configured ones are refused.
+type corsPolicy struct {
+	anyOrigin bool
+	origins   map[string]bool
+
+	methods      map[string]bool
+	headers      map[string]bool
+	allowMethods string
+	allowHeaders string
+}
+
+// newCORSPolicy parses the comma separated lists of origins, methods and
+// headers, the last two already checked by parseCORSList. An empty list of
+// origins returns nil, meaning CORS is disabled.
+func newCORSPolicy(origins, methods, headers string) *corsPolicy {
+	c := &corsPolicy{origins: make(map[string]bool)}
+	for _, origin := range strings.Split(origins, ",") {
+		origin = strings.TrimRight(strings.TrimSpace(origin), "/")
+		switch origin {
+		case "":
+		case "*":
+			c.anyOrigin = true
+		default:
+			c.origins[origin] = true
+		}
+	}
+	if !c.anyOrigin && len(c.origins) == 0 {
+		return nil
+	}
+
+	methodList, _ := parseCORSList(methods)
+	headerList, _ := parseCORSList(headers)
+	c.methods = make(map[string]bool, len(methodList))
+	for _, m := range methodList {
+		c.methods[m] = true
+	}
+	c.headers = make(map[string]bool, len(headerList))
+	for _, h := range headerList {
+		c.headers[http.CanonicalHeaderKey(h)] = true
+	}
+	c.allowMethods = strings.Join(methodList, ", ")
+	c.allowHeaders = strings.Join(headerList, ", ")
+	return c
+}
+
+// parseCORSList splits a comma separated list of methods or headers.
+func parseCORSList(raw string) ([]string, error) {
+	var list []string
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !httpToken.MatchString(item) {
+			return nil, fmt.Errorf("nome inválido: %q", item)
+		}
+		list = append(list, item)
+	}
+	return list, nil
+}
+
+func (c *corsPolicy) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		if !c.allowsOrigin(origin) {
+			msg := fmt.Sprint("origem não permitida: ", origin)
+			sendMsgError(w, codeOriginNotAllowed, msg, http.StatusForbidden)
+			return
+		}
+
+		if c.anyOrigin {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+
+		method := r.Header.Get("Access-Control-Request-Method")
+		if r.Method == http.MethodOptions && method != "" {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			if err := c.checkPreflight(method, r.Header.Get("Access-Control-Request-Headers")); err != nil {
+				sendMsgError(w, codeCORSNotAllowed, err.Error(), http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Methods", c.allowMethods)
+			w.Header().Set("Access-Control-Allow-Headers", c.allowHeaders)
+			w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowsOrigin tells whether origin is one of the configured origins.
+func (c *corsPolicy) allowsOrigin(origin string) bool {
+	return c.anyOrigin || c.origins[origin]
+}
+
+// checkPreflight tells whether the method and the headers a preflight asks
+// for are all allowed.
+func (c *corsPolicy) checkPreflight(method, headers string) error {
+	if !c.methods[method] {
+		return fmt.Errorf("método não permitido via CORS: %s", method)
+	}
+	for _, h := range strings.Split(headers, ",") {
+		if h = strings.TrimSpace(h); h != "" && !c.headers[http.CanonicalHeaderKey(h)] {
+			return fmt.Errorf("cabeçalho não permitido via CORS: %s", h)
+		}
+	}
+	return nil
+}
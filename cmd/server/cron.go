@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed five field cron expression, "minute hour day
+// month weekday", evaluated in UTC. Each field is a bit set of the values it
+// matches.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny record a day field starting with "*": when both day
+	// fields are restricted, a day matching either one runs, as in cron.
+	domAny, dowAny bool
+}
+
+// cronMacros are the shorthands accepted for whole expressions.
+var cronMacros = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+}
+
+var (
+	cronMonths   = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	cronWeekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// cronSearchLimit bounds the search of cronSchedule.next, so an expression
+// like "0 0 30 2 *" that never matches gives up.
+const cronSearchLimit int = 5
+
+// parseCron parses expr: five fields of numbers, names (jan, mon), "*",
+// lists, ranges and steps like "*/15" or "1-5", or one of cronMacros. A
+// weekday of 7 is Sunday, like 0.
+func parseCron(expr string) (cronSchedule, error) {
+	if macro, ok := cronMacros[strings.TrimSpace(expr)]; ok {
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return cronSchedule{}, fmt.Errorf("expressão cron deve ter 5 campos (minuto hora dia mês dia-da-semana): %q", expr)
+	}
+	var s cronSchedule
+	specs := []struct {
+		name     string
+		min, max int
+		names    []string
+		bits     *uint64
+	}{
+		{"minuto", 0, 59, nil, &s.minute},
+		{"hora", 0, 23, nil, &s.hour},
+		{"dia", 1, 31, nil, &s.dom},
+		{"mês", 1, 12, cronMonths, &s.month},
+		{"dia da semana", 0, 7, cronWeekdays, &s.dow},
+	}
+	for i, spec := range specs {
+		bits, err := parseCronField(fields[i], spec.min, spec.max, spec.names)
+		if err != nil {
+			return cronSchedule{}, fmt.Errorf("campo %s inválido em %q: %w", spec.name, expr, err)
+		}
+		*spec.bits = bits
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow = s.dow&^(1<<7) | 1
+	}
+	s.domAny = strings.HasPrefix(fields[2], "*")
+	s.dowAny = strings.HasPrefix(fields[4], "*")
+	if s.next(time.Now()).IsZero() {
+		return cronSchedule{}, fmt.Errorf("expressão cron nunca dispara: %q", expr)
+	}
+	return s, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges and steps
+// between min and max. "5/15" steps from 5 up to max.
+func parseCronField(raw string, min, max int, names []string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(raw, ",") {
+		rng, rawStep, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(rawStep)
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("passo inválido: %q", part)
+			}
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			rawLo, rawHi, isRange := strings.Cut(rng, "-")
+			var err error
+			lo, err = cronValue(rawLo, min, max, names)
+			if err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = cronValue(rawHi, min, max, names); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				hi = max
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("intervalo invertido: %q", part)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// cronValue parses a number between min and max, or one of names, the
+// first of which is min.
+func cronValue(raw string, min, max int, names []string) (int, error) {
+	for i, name := range names {
+		if strings.EqualFold(raw, name) {
+			return min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v < min || v > max {
+		return 0, fmt.Errorf("valor fora de %d-%d: %q", min, max, raw)
+	}
+	return v, nil
+}
+
+// next returns the first minute after t, in UTC, matching s, or the zero
+// time when none does within cronSearchLimit years.
+func (s cronSchedule) next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(cronSearchLimit, 0, 0)
+	for t.Before(limit) {
+		year, month, day := t.Date()
+		switch {
+		case s.month&(1<<uint(month)) == 0:
+			t = time.Date(year, month+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.dayMatches(t):
+			t = time.Date(year, month, day+1, 0, 0, 0, 0, time.UTC)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(year, month, day, t.Hour()+1, 0, 0, 0, time.UTC)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (s cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/twsm000/goxp-client-server-api/internal/tracing"
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+const databaseStartupTimeout time.Duration = 5 * time.Second
+
+// sqliteStore is the storage backed by the sqlite database file.
+type sqliteStore struct {
+	db     *sql.DB
+	readDB *sql.DB
+}
+
+// startDatabase opens and migrates the database in file, exiting on failure.
+func startDatabase(file string) *sqliteStore {
+	dsn := fmt.Sprintf("file:%s?_journal_mode=WAL&_busy_timeout=%d", file, busyTimeout.Milliseconds())
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		fatal("Falhou abrir o banco de dados", "err", err)
+	}
+	// sqlite serializes writers anyway; a single connection avoids
+	// "database is locked" errors between connections of the same pool.
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	db.SetConnMaxLifetime(0)
+
+	// Reads go through their own read-only pool of -db-readers connections:
+	// with WAL they don't block the writer, and a long export doesn't hold
+	// the only write connection.
+	readDSN := fmt.Sprintf("file:%s?mode=ro&_busy_timeout=%d", file, busyTimeout.Milliseconds())
+	readDB, err := sql.Open("sqlite3", readDSN)
+	if err != nil {
+		fatal("Falhou abrir o banco de dados para leitura", "err", err)
+	}
+	readDB.SetMaxOpenConns(databaseReaders)
+	readDB.SetMaxIdleConns(databaseReaders)
+
+	ctx, cancel := context.WithTimeout(context.Background(), databaseStartupTimeout)
+	defer cancel()
+	err = db.PingContext(ctx)
+	if err != nil {
+		fatal("Falha ao conectar ao banco de dados", "err", err)
+	}
+
+	err = runMigrations(ctx, db)
+	if err != nil {
+		fatal("Falha ao migrar o banco de dados", "err", err)
+	}
+	return &sqliteStore{db: db, readDB: readDB}
+}
+
+// ping checks the database can still be read. It goes through the read
+// pool so a long write holding the only write connection doesn't fail it.
+func (s *sqliteStore) ping(ctx context.Context) error {
+	var version int
+	return s.readDB.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+}
+
+func (s *sqliteStore) close() error {
+	readErr := s.readDB.Close()
+	if err := s.db.Close(); err != nil {
+		return err
+	}
+	return readErr
+}
+
+// quotationColumns is the select list read by scanQuotation. NULL units
+// (rows a migration couldn't parse) read as zero.
+const quotationColumns string = `
+			code,
+			code_in,
+			name,
+			high,
+			low,
+			var_bid,
+			pct_change,
+			bid,
+			ask,
+			timestamp,
+			create_date,
+			COALESCE(high_units, 0),
+			COALESCE(low_units, 0),
+			COALESCE(bid_units, 0),
+			COALESCE(ask_units, 0)`
+
+// scanQuotation scans a row selected with quotationColumns into q. Any extra
+// destinations come first, for columns selected before quotationColumns.
+func scanQuotation(row interface{ Scan(...any) error }, q *upstream.Quotation, extra ...any) error {
+	dest := append(extra,
+		&q.Code,
+		&q.CodeIn,
+		&q.Name,
+		&q.High,
+		&q.Low,
+		&q.VarBid,
+		&q.PctChange,
+		&q.Bid,
+		&q.Ask,
+		&q.Timestamp,
+		&q.CreateDate,
+		&q.HighValue,
+		&q.LowValue,
+		&q.BidValue,
+		&q.AskValue,
+	)
+	return row.Scan(dest...)
+}
+
+// insertQuotation inserts a quotation, ignoring one already stored with the
+// same pair and timestamp.
+const insertQuotation string = `
+	INSERT OR IGNORE INTO cotacao(
+		code,
+		code_in,
+		name,
+		high,
+		low,
+		var_bid,
+		pct_change,
+		bid,
+		ask,
+		timestamp,
+		create_date,
+		high_units,
+		low_units,
+		bid_units,
+		ask_units,
+		timestamp_unix,
+		create_date_unix
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+// insertQuotationArgs are the arguments of insertQuotation for cotacao.
+func insertQuotationArgs(cotacao *upstream.Quotation) []any {
+	return []any{
+		cotacao.Code,
+		cotacao.CodeIn,
+		cotacao.Name,
+		cotacao.High,
+		cotacao.Low,
+		cotacao.VarBid,
+		cotacao.PctChange,
+		cotacao.Bid,
+		cotacao.Ask,
+		cotacao.Timestamp,
+		cotacao.CreateDate,
+		int64(cotacao.HighValue),
+		int64(cotacao.LowValue),
+		int64(cotacao.BidValue),
+		int64(cotacao.AskValue),
+		nullUnix(cotacao.Time()),
+		nullUnix(cotacao.CreatedAt()),
+	}
+}
+
+// nullUnix is the unix time of a typed datetime column, NULL when the
+// upstream string didn't parse.
+func nullUnix(t time.Time, err error) sql.NullInt64 {
+	if err != nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: t.Unix(), Valid: true}
+}
+
+func (s *sqliteStore) saveQuotation(ctx context.Context, cotacao *upstream.Quotation) error {
+	ctx, span := tracing.Start(ctx, "sqlite INSERT cotacao", tracing.KindInternal)
+	defer span.End()
+	dbCtx, cancel := context.WithTimeout(ctx, databaseTimeout)
+	defer cancel()
+
+	stmt, err := s.db.PrepareContext(dbCtx, insertQuotation)
+	if err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("falha ao preparar query. %w", err)
+	}
+	defer stmt.Close()
+
+	insertStart := time.Now()
+	result, err := stmt.ExecContext(dbCtx, insertQuotationArgs(cotacao)...)
+	observeSince(dbInsertDuration, insertStart)
+	if err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("falha ao executar query. %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	span.SetAttribute("db.rows_affected", n)
+	if err == nil && n == 0 {
+		slog.InfoContext(ctx, "Cotação já armazenada, inserção ignorada", "pair", cotacao.Code+"-"+cotacao.CodeIn, "timestamp", cotacao.Timestamp)
+	} else {
+		slog.InfoContext(ctx, "Cotação armazenada", "pair", cotacao.Code+"-"+cotacao.CodeIn, "timestamp", cotacao.Timestamp)
+	}
+	return nil
+}
+
+// saveQuotations inserts batch in a single transaction, so sqlite syncs the
+// journal once for the whole batch instead of once per row. Either every row
+// is saved or none is.
+func (s *sqliteStore) saveQuotations(ctx context.Context, batch []upstream.Quotation) error {
+	dbCtx, cancel := context.WithTimeout(ctx, databaseTimeout)
+	defer cancel()
+
+	defer observeSince(dbInsertDuration, time.Now())
+	tx, err := s.db.BeginTx(dbCtx, nil)
+	if err != nil {
+		return fmt.Errorf("falha ao iniciar transação. %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(dbCtx, insertQuotation)
+	if err != nil {
+		return fmt.Errorf("falha ao preparar query. %w", err)
+	}
+	defer stmt.Close()
+
+	var inserted int64
+	for i := range batch {
+		result, err := stmt.ExecContext(dbCtx, insertQuotationArgs(&batch[i])...)
+		if err != nil {
+			return fmt.Errorf("falha ao executar query. %w", err)
+		}
+		if n, err := result.RowsAffected(); err == nil {
+			inserted += n
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("falha ao confirmar transação. %w", err)
+	}
+	slog.Info("Cotações armazenadas em lote", "inserted", inserted, "duplicates", int64(len(batch))-inserted)
+	return nil
+}
+
+// latestQuotation returns the newest stored quotation, optionally
+// restricted to a pair. It returns sql.ErrNoRows when nothing matches.
+func (s *sqliteStore) latestQuotation(ctx context.Context, pair string) (*upstream.Quotation, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, databaseTimeout)
+	defer cancel()
+
+	query, args := latestQuery(pair)
+	row := s.readDB.QueryRowContext(dbCtx, query, args...)
+
+	var cotacao upstream.Quotation
+	err := scanQuotation(row, &cotacao)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("falha ao consultar cotação. %w", err)
+	}
+	return &cotacao, nil
+}
+
+// StoredQuotation is a quotation as stored, with its rowid.
+type StoredQuotation struct {
+	ID int64 `json:"id" xml:"id"`
+	upstream.Quotation
+}
+
+// quotationByID returns the row with the given rowid, sql.ErrNoRows if there
+// is none.
+func (s *sqliteStore) quotationByID(ctx context.Context, id int64) (*StoredQuotation, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, databaseTimeout)
+	defer cancel()
+
+	row := s.readDB.QueryRowContext(dbCtx, `
+		SELECT rowid,`+quotationColumns+`
+		FROM cotacao
+		WHERE rowid = ?
+	`, id)
+
+	var stored StoredQuotation
+	err := scanQuotation(row, &stored.Quotation, &stored.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("falha ao consultar cotação. %w", err)
+	}
+	return &stored, nil
+}
+
+// storedQuotation returns the latest stored quotation of pair. A cross rate,
+// whose legs are stored instead, is derived from the latest of each leg.
+func storedQuotation(ctx context.Context, pair string) (*upstream.Quotation, error) {
+	q, err := store.latestQuotation(ctx, pair)
+	first, second, cross := upstream.CrossLegs(pair)
+	if !errors.Is(err, sql.ErrNoRows) || !cross {
+		return q, err
+	}
+	firstQ, err := store.latestQuotation(ctx, first)
+	if err != nil {
+		return nil, err
+	}
+	secondQ, err := store.latestQuotation(ctx, second)
+	if err != nil {
+		return nil, err
+	}
+	derived, err := upstream.CrossRate(pair, *firstQ, *secondQ)
+	if err != nil {
+		return nil, err
+	}
+	return &derived, nil
+}
+
+// storedQuotations returns the latest stored quotation of every pair, failing
+// if any of them was never stored.
+func storedQuotations(ctx context.Context, pairs []string) (map[string]upstream.Quotation, error) {
+	quotations := make(map[string]upstream.Quotation, len(pairs))
+	for _, pair := range pairs {
+		q, err := storedQuotation(ctx, pair)
+		if err != nil {
+			return nil, err
+		}
+		quotations[pair] = *q
+	}
+	return quotations, nil
+}
+
+// recentStoredQuotations returns the pairs whose latest stored quotation is
+// younger than maxStale, with that quotation, and the pairs that still have
+// to be fetched. A pair that can't be read is simply fetched.
+func recentStoredQuotations(ctx context.Context, pairs []string, now time.Time) (map[string]upstream.Quotation, []string) {
+	quotations := make(map[string]upstream.Quotation, len(pairs))
+	var missing []string
+	for _, pair := range pairs {
+		q, err := storedQuotation(ctx, pair)
+		if err == nil {
+			t, timeErr := q.Time()
+			if timeErr == nil && now.Sub(t) < maxStale {
+				quotations[pair] = *q
+				continue
+			}
+		} else if !errors.Is(err, sql.ErrNoRows) && !errors.Is(err, errNoDatabase) {
+			slog.WarnContext(ctx, "GET /cotacao - falha ao consultar última cotação, buscando no upstream", "pair", pair, "err", err)
+		}
+		missing = append(missing, pair)
+	}
+	return quotations, missing
+}
+
+// queryQuotations streams the quotations matching f, oldest first, calling fn
+// for each row. It stops at the first error returned by fn.
+func (s *sqliteStore) queryQuotations(ctx context.Context, f historyFilter, fn func(*upstream.Quotation) error) error {
+	query, args := exportQuery(f)
+	rows, err := s.readDB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("falha ao consultar cotações. %w", err)
+	}
+	defer rows.Close()
+
+	var cotacao upstream.Quotation
+	for rows.Next() {
+		err = scanQuotation(rows, &cotacao)
+		if err != nil {
+			return fmt.Errorf("falha ao ler cotação. %w", err)
+		}
+		if err = fn(&cotacao); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// quotationPage returns a page of the quotations matching f, ordered by
+// timestamp as p asks, and how many match in total.
+func (s *sqliteStore) quotationPage(ctx context.Context, f historyFilter, p historyPage) ([]StoredQuotation, int64, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, databaseTimeout)
+	defer cancel()
+
+	query, args := countQuery(f)
+	var total int64
+	err := s.readDB.QueryRowContext(dbCtx, query, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("falha ao contar cotações. %w", err)
+	}
+
+	query, args = pageQuery(f, p)
+	rows, err := s.readDB.QueryContext(dbCtx, query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("falha ao consultar cotações. %w", err)
+	}
+	defer rows.Close()
+
+	items := make([]StoredQuotation, 0, p.Limit)
+	for rows.Next() {
+		var stored StoredQuotation
+		if err := scanQuotation(rows, &stored.Quotation, &stored.ID); err != nil {
+			return nil, 0, fmt.Errorf("falha ao ler cotação. %w", err)
+		}
+		items = append(items, stored)
+	}
+	return items, total, rows.Err()
+}
+
+// latestQuery selects the newest quotation, of pair when not empty.
+func latestQuery(pair string) (string, []any) {
+	where, args := historyWhere(historyFilter{Pair: pair})
+	return "SELECT" + quotationColumns + " FROM cotacao" + where +
+		" ORDER BY timestamp_unix DESC, rowid DESC LIMIT 1", args
+}
+
+// exportQuery selects every quotation matching f, oldest first.
+func exportQuery(f historyFilter) (string, []any) {
+	where, args := historyWhere(f)
+	return "SELECT" + quotationColumns + " FROM cotacao" + where + " ORDER BY timestamp_unix, rowid", args
+}
+
+// countQuery counts the quotations matching f.
+func countQuery(f historyFilter) (string, []any) {
+	where, args := historyWhere(f)
+	return "SELECT COUNT(*) FROM cotacao" + where, args
+}
+
+// pageQuery selects the page p of the quotations matching f, with their
+// rowid.
+func pageQuery(f historyFilter, p historyPage) (string, []any) {
+	where, args := historyWhere(f)
+	order := " ORDER BY timestamp_unix, rowid"
+	if p.Desc {
+		order = " ORDER BY timestamp_unix DESC, rowid DESC"
+	}
+	return "SELECT rowid," + quotationColumns + " FROM cotacao" + where + order + " LIMIT ? OFFSET ?",
+		append(args, p.Limit, p.Offset)
+}
+
+// historyWhere translates f into a WHERE clause and its arguments. The
+// conditions are written so sqlite can use the cotacao_pair_time and
+// cotacao_time indexes.
+func historyWhere(f historyFilter) (string, []any) {
+	where := " WHERE 1 = 1"
+	var args []any
+	if f.Pair != "" {
+		code, codeIn, _ := strings.Cut(f.Pair, "-")
+		where += " AND code = ? AND code_in = ?"
+		args = append(args, code, codeIn)
+	}
+	if !f.From.IsZero() {
+		where += " AND timestamp_unix >= ?"
+		args = append(args, f.From.Unix())
+	}
+	if !f.To.IsZero() {
+		where += " AND timestamp_unix < ?"
+		args = append(args, f.To.Unix())
+	}
+	return where, args
+}
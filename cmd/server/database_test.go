@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+// TestCotacaoHandlerConcurrentWrites fires parallel requests for distinct
+// pairs, each one inserting, while others read the latest quotation, against
+// the sqlite database: none may fail with a locked database.
+func TestCotacaoHandlerConcurrentWrites(t *testing.T) {
+	useSettings(t, func(c *Config) { c.RequestTimeout = Duration(5 * time.Second) })
+	db := useDatabase(t)
+	fake := newFakeUpstream(t, http.StatusOK)
+	useChain(t, "awesomeapi", upstream.ChainConfig{BaseURL: fake.URL})
+	seed := upstream.Quotation{Code: "USD", CodeIn: "BRL", Bid: "5.1", Timestamp: "1791968680"}
+	if err := db.saveQuotation(context.Background(), &seed); err != nil {
+		t.Fatal(err)
+	}
+
+	const writers, readers = 64, 16
+	var wg sync.WaitGroup
+	failures := make(chan string, writers+readers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			target := fmt.Sprintf("/cotacao?pair=C%02d-BRL&nocache=true", i)
+			if w := getCotacao(t, target); w.Code != http.StatusOK {
+				failures <- fmt.Sprintf("GET %s = %d %s", target, w.Code, w.Body)
+			}
+		}(i)
+	}
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				w := httptest.NewRecorder()
+				latestHandler(w, httptest.NewRequest(http.MethodGet, "/cotacao/latest", nil))
+				if w.Code != http.StatusOK {
+					failures <- fmt.Sprintf("GET /cotacao/latest = %d %s", w.Code, w.Body)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(failures)
+	for f := range failures {
+		t.Error(f)
+	}
+
+	var rows int
+	if err := db.readDB.QueryRowContext(context.Background(), `SELECT COUNT(*) FROM cotacao`).Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if rows != writers+1 {
+		t.Errorf("cotacao has %d rows, want %d", rows, writers+1)
+	}
+}
+
+func TestStartDatabaseSettings(t *testing.T) {
+	db := useDatabase(t)
+	var mode string
+	if err := db.db.QueryRowContext(context.Background(), `PRAGMA journal_mode`).Scan(&mode); err != nil || mode != "wal" {
+		t.Errorf("journal_mode = %q, %v; want wal", mode, err)
+	}
+	var timeout int
+	if err := db.db.QueryRowContext(context.Background(), `PRAGMA busy_timeout`).Scan(&timeout); err != nil || timeout != 5000 {
+		t.Errorf("busy_timeout = %d, %v; want 5000", timeout, err)
+	}
+	if got := db.db.Stats().MaxOpenConnections; got != 1 {
+		t.Errorf("writer pool MaxOpenConnections = %d, want 1", got)
+	}
+	if got := db.readDB.Stats().MaxOpenConnections; got != databaseReaders {
+		t.Errorf("reader pool MaxOpenConnections = %d, want %d", got, databaseReaders)
+	}
+}
+
+// queryPlan returns the EXPLAIN QUERY PLAN details of query.
+func queryPlan(t testing.TB, db *sqliteStore, query string, args []any) []string {
+	t.Helper()
+	rows, err := db.readDB.QueryContext(context.Background(), "EXPLAIN QUERY PLAN "+query, args...)
+	if err != nil {
+		t.Fatalf("EXPLAIN QUERY PLAN: %v", err)
+	}
+	defer rows.Close()
+	var details []string
+	for rows.Next() {
+		var id, parent, notUsed int
+		var detail string
+		if err := rows.Scan(&id, &parent, &notUsed, &detail); err != nil {
+			t.Fatal(err)
+		}
+		details = append(details, detail)
+	}
+	return details
+}
+
+// TestReadQueriesUseIndexes keeps the latest, history, export and stats
+// queries on the cotacao_pair_time and cotacao_time indexes: no full scan
+// of cotacao and no sort of its rows.
+func TestReadQueriesUseIndexes(t *testing.T) {
+	db := useDatabase(t)
+	from := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(24 * time.Hour)
+	tests := []struct {
+		name   string
+		filter historyFilter
+		index  string
+	}{
+		{"no filter", historyFilter{}, "cotacao_time"},
+		{"pair", historyFilter{Pair: "USD-BRL"}, "cotacao_pair_time"},
+		{"range", historyFilter{From: from, To: to}, "cotacao_time"},
+		{"from only", historyFilter{From: from}, "cotacao_time"},
+		{"pair and range", historyFilter{Pair: "USD-BRL", From: from, To: to}, "cotacao_pair_time"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queries := map[string]func() (string, []any){
+				"latest": func() (string, []any) { return latestQuery(tt.filter.Pair) },
+				"export": func() (string, []any) { return exportQuery(tt.filter) },
+				"count":  func() (string, []any) { return countQuery(tt.filter) },
+				"page":   func() (string, []any) { return pageQuery(tt.filter, historyPage{Limit: 100}) },
+				"page desc": func() (string, []any) {
+					return pageQuery(tt.filter, historyPage{Limit: 100, Desc: true})
+				},
+			}
+			// Aggregating every row is a full scan whatever the index.
+			if tt.filter != (historyFilter{}) {
+				queries["stats"] = func() (string, []any) {
+					source, args, err := db.statsSource(context.Background(), tt.filter)
+					if err != nil {
+						t.Fatal(err)
+					}
+					return "SELECT ''," + statsColumns + " FROM " + source, args
+				}
+			}
+			for name, build := range queries {
+				query, args := build()
+				plan := queryPlan(t, db, query, args)
+				indexed := false
+				for _, detail := range plan {
+					indexed = indexed || strings.Contains(detail+" ", " INDEX "+tt.index+" ")
+					if detail == "SCAN cotacao" || strings.Contains(detail, "TEMP B-TREE FOR ORDER BY") {
+						t.Errorf("%s: %q in plan %q", name, detail, plan)
+					}
+				}
+				if !indexed {
+					t.Errorf("%s doesn't use %s: plan %q", name, tt.index, plan)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkReadQueries runs the read queries over a million rows of three
+// pairs spread over about 35 days.
+//
+//	go test ./cmd/server -run '^$' -bench ReadQueries -benchtime 200x
+func BenchmarkReadQueries(b *testing.B) {
+	useSettings(b, nil)
+	db := useDatabase(b)
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	seedQuotations(b, db, 1000000, start, 3*time.Second, "USD-BRL", "EUR-BRL", "BTC-BRL")
+	day := historyFilter{Pair: "EUR-BRL", From: start.Add(10 * 24 * time.Hour), To: start.Add(11 * 24 * time.Hour)}
+	ctx := context.Background()
+
+	b.Run("latest", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := db.latestQuotation(ctx, "EUR-BRL"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("history page", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, _, err := db.quotationPage(ctx, day, historyPage{Limit: 100, Desc: true}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("stats of a day", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := db.quotationStats(ctx, day); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("export a day", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			err := db.queryQuotations(ctx, day, func(*upstream.Quotation) error { return nil })
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+)
+
+// startDebugServer serves the pprof handlers on debugAddr, a listener apart
+// from the public one, until ctx is done. It does nothing unless -debug is
+// set.
+func startDebugServer(ctx context.Context) {
+	if !debugEnabled {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	server := &http.Server{Addr: debugAddr, Handler: mux}
+
+	backgroundJobs.Add(2)
+	go func() {
+		defer backgroundJobs.Done()
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("Falha ao encerrar servidor de debug", "err", err)
+		}
+	}()
+	go func() {
+		defer backgroundJobs.Done()
+		slog.Info("Servidor de debug (pprof) iniciado", "addr", debugAddr)
+		err := server.ListenAndServe()
+		if !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Servidor de debug falhou", "err", err)
+		}
+	}()
+}
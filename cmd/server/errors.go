@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+// Machine readable error codes sent in Problem.Code. They are part of
+// the API contract: clients switch on them, so never rename one.
+const (
+	codeBadRequest          string = "bad_request"
+	codeUnauthorized        string = "unauthorized"
+	codeForbidden           string = "forbidden"
+	codeNotFound            string = "not_found"
+	codeMethodNotAllowed    string = "method_not_allowed"
+	codeNotAcceptable       string = "not_acceptable"
+	codeOriginNotAllowed    string = "origin_not_allowed"
+	codeCORSNotAllowed      string = "cors_not_allowed"
+	codeRateLimited         string = "rate_limited"
+	codeUpstreamTimeout     string = "upstream_timeout"
+	codeUpstreamUnavailable string = "upstream_unavailable"
+	codeUpstreamBusy        string = "upstream_busy"
+	codeUpstreamBudget      string = "upstream_budget_exhausted"
+	codeUpstreamError       string = "upstream_error"
+	codeUpstreamInvalid     string = "upstream_invalid_response"
+	codeDBTimeout           string = "db_timeout"
+	codeDBUnavailable       string = "db_unavailable"
+	codeDBWriteFailed       string = "db_write_failed"
+	codeDBReadFailed        string = "db_read_failed"
+	codeDBDisabled          string = "db_disabled"
+	codeInternal            string = "internal_error"
+)
+
+// fetchErrorStatus maps an upstream fetch error to its HTTP status and code.
+func fetchErrorStatus(err error) (int, string) {
+	var urlErr *url.Error
+	switch {
+	case errors.Is(err, upstream.ErrCircuitOpen):
+		return http.StatusServiceUnavailable, codeUpstreamUnavailable
+	case errors.Is(err, errUpstreamBusy):
+		return http.StatusServiceUnavailable, codeUpstreamBusy
+	case errors.Is(err, upstream.ErrBudgetExhausted):
+		return http.StatusServiceUnavailable, codeUpstreamBudget
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, codeUpstreamTimeout
+	case errors.Is(err, upstream.ErrStatus):
+		return http.StatusBadGateway, codeUpstreamError
+	case errors.Is(err, upstream.ErrInvalidPayload):
+		return http.StatusBadGateway, codeUpstreamInvalid
+	case errors.As(err, &urlErr):
+		return http.StatusBadGateway, codeUpstreamUnavailable
+	default:
+		return http.StatusInternalServerError, codeInternal
+	}
+}
+
+// dbErrorStatus maps a database error to its HTTP status and code. Timeouts
+// and a locked or unreachable database are reported as 503 so clients can
+// tell an unavailable database from a failing query, which gets failedCode.
+// Without a database (-no-db) the answer is 501.
+func dbErrorStatus(err error, failedCode string) (int, string) {
+	if errors.Is(err, errNoDatabase) {
+		return http.StatusNotImplemented, codeDBDisabled
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusServiceUnavailable, codeDBTimeout
+	}
+	if isDBUnavailable(err) {
+		return http.StatusServiceUnavailable, codeDBUnavailable
+	}
+	return http.StatusInternalServerError, failedCode
+}
+
+// isBusy reports whether err means another connection holds the database
+// lock: SQLITE_BUSY or SQLITE_LOCKED once busy_timeout ran out, or
+// databaseTimeout expiring first.
+func isBusy(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// isDBUnavailable reports whether err comes from the database being busy or
+// out of reach, like a file that can't be opened or a failing disk, rather
+// than from the query.
+func isDBUnavailable(err error) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	switch sqliteErr.Code {
+	case sqlite3.ErrBusy, sqlite3.ErrLocked, sqlite3.ErrCantOpen, sqlite3.ErrIoErr, sqlite3.ErrFull:
+		return true
+	default:
+		return false
+	}
+}
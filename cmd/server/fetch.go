@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/twsm000/goxp-client-server-api/internal/tracing"
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+// errUpstreamBusy reports a fetch that waited -upstream-wait for one of the
+// -max-upstream slots without getting it.
+var errUpstreamBusy = errors.New("limite de chamadas simultâneas ao upstream atingido")
+
+// fetches coalesces the concurrent cache misses of /cotacao for the same
+// pairs into a single upstream request and a single insert.
+var fetches = newFlightGroup()
+
+// conversions coalesces the upstream requests of the conversions, which
+// unlike /cotacao don't store what they fetch.
+var conversions = newFlightGroup()
+
+// flightResult is the outcome of a coalesced call, shared by every caller
+// that waited on it. The quotations must not be modified. saving reports a
+// caller whose ctx ended after the fetch, while the quotations were still
+// being stored.
+type flightResult struct {
+	quotations map[string]upstream.Quotation
+	provider   string
+	err        error
+	saving     bool
+}
+
+// flightCall is a call in flight: fetched is closed once result is set, done
+// once the quotations are also stored, with saveErr.
+type flightCall struct {
+	fetched chan struct{}
+	done    chan struct{}
+	result  flightResult
+	saveErr error
+}
+
+// flightGroup runs at most one call per key at a time, in the spirit of
+// golang.org/x/sync/singleflight.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: make(map[string]*flightCall)}
+}
+
+// do runs fetch for key, then save, when not nil, on what it fetched, unless
+// a call for the same key is in flight, in which case it waits for that one.
+// shared reports whether the result came from an earlier caller. Both run on
+// their own goroutine, so a caller giving up when its ctx is done cancels
+// neither for the others, and the quotations are stored even when every
+// caller gave up. A save error is the err of the result.
+func (g *flightGroup) do(ctx context.Context, key string, fetch func() flightResult, save func(flightResult) error) (result flightResult, shared bool, err error) {
+	g.mu.Lock()
+	call, shared := g.calls[key]
+	if !shared {
+		call = &flightCall{fetched: make(chan struct{}), done: make(chan struct{})}
+		g.calls[key] = call
+		go g.run(key, call, fetch, save)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-call.fetched:
+	case <-ctx.Done():
+		return flightResult{}, shared, ctx.Err()
+	}
+	result = call.result
+	if result.err != nil || save == nil {
+		return result, shared, nil
+	}
+	select {
+	case <-call.done:
+		result.err = call.saveErr
+	case <-ctx.Done():
+		result.saving = true
+	}
+	return result, shared, nil
+}
+
+func (g *flightGroup) run(key string, call *flightCall, fetch func() flightResult, save func(flightResult) error) {
+	defer close(call.done)
+	call.result = fetch()
+	if call.result.err != nil || save == nil {
+		g.forget(key)
+		close(call.fetched)
+		return
+	}
+	close(call.fetched)
+	call.saveErr = save(call.result)
+	g.forget(key)
+}
+
+func (g *flightGroup) forget(key string) {
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+}
+
+// fetchAndStore fetches pairs from the providers, then caches, stores and
+// publishes them, sharing the call with the concurrent requests for the same
+// pairs, so N concurrent misses make a single upstream call and a single
+// insert. The caller stops waiting when ctx is done; the shared call goes on
+// under its own timeout.
+func fetchAndStore(ctx context.Context, timeout time.Duration, pairs []string) (flightResult, bool, error) {
+	return fetches.do(ctx, strings.Join(pairs, ","), func() flightResult {
+		return fetchAndCache(ctx, timeout, pairs)
+	}, func(result flightResult) error {
+		return storeQuotations(ctx, pairs, result.quotations)
+	})
+}
+
+// fetchUpstream fetches and caches pairs for a conversion, sharing the call
+// with the concurrent conversions of the same pairs.
+func fetchUpstream(ctx context.Context, timeout time.Duration, pairs []string) (quotations map[string]upstream.Quotation, provider string, err error) {
+	result, _, err := conversions.do(ctx, strings.Join(pairs, ","), func() flightResult {
+		return fetchAndCache(ctx, timeout, pairs)
+	}, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	return result.quotations, result.provider, result.err
+}
+
+// fetchAndCache is limitedFetch, putting the quotations fetched in the cache.
+func fetchAndCache(parent context.Context, timeout time.Duration, pairs []string) flightResult {
+	fetchStart := time.Now()
+	result := limitedFetch(parent, timeout, pairs)
+	if result.err != nil || cache == nil {
+		return result
+	}
+	for _, pair := range pairs {
+		if q, ok := result.quotations[pair]; ok {
+			cache.put(pair, q, fetchStart)
+		}
+	}
+	return result
+}
+
+// storeQuotations saves and publishes the quotations of pairs. It is detached
+// from the cancellation of parent, keeping only its span.
+func storeQuotations(parent context.Context, pairs []string, quotations map[string]upstream.Quotation) error {
+	ctx := tracing.WithSpanFrom(context.Background(), parent)
+	for _, pair := range pairs {
+		cotacao := quotations[pair]
+		if err := persistQuotation(ctx, &cotacao); err != nil {
+			return saveError{err}
+		}
+		hub.publish(cotacao)
+	}
+	return nil
+}
+
+// limitedFetch calls the providers once a -max-upstream slot is free. It is
+// detached from the cancellation of parent, keeping only its span, so the
+// trace still shows the upstream call.
+func limitedFetch(parent context.Context, timeout time.Duration, pairs []string) flightResult {
+	ctx, cancel := context.WithTimeout(tracing.WithSpanFrom(context.Background(), parent), timeout)
+	defer cancel()
+
+	if err := acquireUpstream(ctx); err != nil {
+		return flightResult{err: err}
+	}
+	defer releaseUpstream()
+	quotations, provider, err := providers.Fetch(ctx, pairs...)
+	return flightResult{quotations: quotations, provider: provider, err: err}
+}
+
+// acquireUpstream takes a slot of upstreamSlots, waiting up to upstreamWait.
+// Without -max-upstream there is no limit.
+func acquireUpstream(ctx context.Context) error {
+	if upstreamSlots == nil {
+		return nil
+	}
+	select {
+	case upstreamSlots <- struct{}{}:
+		return nil
+	default:
+	}
+	if upstreamWait <= 0 {
+		return errUpstreamBusy
+	}
+	timer := time.NewTimer(upstreamWait)
+	defer timer.Stop()
+	select {
+	case upstreamSlots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return errUpstreamBusy
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func releaseUpstream() {
+	if upstreamSlots != nil {
+		<-upstreamSlots
+	}
+}
+
+// setUpstreamRetryAfter tells the client when the upstream may be called
+// again after err, for a fetch refused by the open breaker or the exhausted
+// budget.
+func setUpstreamRetryAfter(w http.ResponseWriter, err error) {
+	var wait time.Duration
+	switch {
+	case errors.Is(err, upstream.ErrCircuitOpen):
+		wait = providers.Breaker().RetryAfter()
+	case errors.Is(err, upstream.ErrBudgetExhausted):
+		wait = providers.Budget().RetryAfter()
+	default:
+		return
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+var testQuotations = map[string]upstream.Quotation{
+	"USD-BRL": {Code: "USD", CodeIn: "BRL", Bid: "5.1234"},
+}
+
+func TestFlightGroupCoalesces(t *testing.T) {
+	g := newFlightGroup()
+	release := make(chan struct{})
+	var fetched, saved int32
+	fetch := func() flightResult {
+		atomic.AddInt32(&fetched, 1)
+		<-release
+		return flightResult{quotations: testQuotations, provider: "awesomeapi"}
+	}
+	save := func(flightResult) error {
+		atomic.AddInt32(&saved, 1)
+		return nil
+	}
+
+	const callers = 20
+	var wg sync.WaitGroup
+	var sharedCount int32
+	errs := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, shared, err := g.do(context.Background(), "USD-BRL", fetch, save)
+			if err == nil {
+				err = result.err
+			}
+			if err == nil && result.quotations["USD-BRL"].Bid != "5.1234" {
+				err = errors.New("result without the quotation")
+			}
+			if shared {
+				atomic.AddInt32(&sharedCount, 1)
+			}
+			errs <- err
+		}()
+	}
+	// Let every caller join the call before it ends.
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		g.mu.Lock()
+		n := len(g.calls)
+		g.mu.Unlock()
+		if n == 1 && atomic.LoadInt32(&fetched) == 1 {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fetched != 1 || saved != 1 {
+		t.Errorf("fetched %d and saved %d times, want 1 and 1", fetched, saved)
+	}
+	if sharedCount != callers-1 {
+		t.Errorf("%d callers shared the result, want %d", sharedCount, callers-1)
+	}
+}
+
+func TestFlightGroupSavesAfterCallersGiveUp(t *testing.T) {
+	tests := []struct {
+		name       string
+		cancelWhen string
+		wantErr    bool
+		wantSaving bool
+	}{
+		{"during the fetch", "fetch", true, false},
+		{"during the save", "save", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newFlightGroup()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			release := make(chan struct{})
+			saved := make(chan struct{})
+			fetch := func() flightResult {
+				if tt.cancelWhen == "fetch" {
+					cancel()
+					<-release
+				}
+				return flightResult{quotations: testQuotations}
+			}
+			save := func(flightResult) error {
+				if tt.cancelWhen == "save" {
+					cancel()
+					<-release
+				}
+				close(saved)
+				return nil
+			}
+
+			result, _, err := g.do(ctx, "USD-BRL", fetch, save)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("do() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if result.saving != tt.wantSaving {
+				t.Errorf("saving = %v, want %v", result.saving, tt.wantSaving)
+			}
+			close(release)
+			select {
+			case <-saved:
+			case <-time.After(time.Second):
+				t.Fatal("the quotations weren't saved after the caller gave up")
+			}
+		})
+	}
+}
+
+func TestFlightGroupErrors(t *testing.T) {
+	errFetch := errors.New("fetch failed")
+	errSave := errors.New("save failed")
+	tests := []struct {
+		name      string
+		fetchErr  error
+		saveErr   error
+		wantErr   error
+		wantSaved bool
+	}{
+		{"ok", nil, nil, nil, true},
+		{"fetch error skips the save", errFetch, nil, errFetch, false},
+		{"save error", nil, errSave, errSave, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newFlightGroup()
+			saved := false
+			result, _, err := g.do(context.Background(), "USD-BRL", func() flightResult {
+				return flightResult{quotations: testQuotations, err: tt.fetchErr}
+			}, func(flightResult) error {
+				saved = true
+				return tt.saveErr
+			})
+			if err != nil {
+				t.Fatalf("do() error = %v", err)
+			}
+			if !errors.Is(result.err, tt.wantErr) {
+				t.Errorf("result.err = %v, want %v", result.err, tt.wantErr)
+			}
+			if saved != tt.wantSaved {
+				t.Errorf("saved = %v, want %v", saved, tt.wantSaved)
+			}
+		})
+	}
+}
+
+func TestFlightGroupSequentialCallsRunAgain(t *testing.T) {
+	g := newFlightGroup()
+	calls := 0
+	fetch := func() flightResult {
+		calls++
+		return flightResult{quotations: testQuotations}
+	}
+	for i := 0; i < 2; i++ {
+		if _, shared, _ := g.do(context.Background(), "USD-BRL", fetch, nil); shared {
+			t.Errorf("call %d shared a finished call", i+1)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("fetch ran %d times, want 2", calls)
+	}
+}
+
+// useSlowUpstream serves the test from a fake upstream taking delay per
+// request, with the request timeouts raised above it.
+func useSlowUpstream(t *testing.T, status int, delay time.Duration) (*fakeUpstream, *memoryStore) {
+	t.Helper()
+	useSettings(t, func(c *Config) {
+		c.RequestTimeout = Duration(5 * time.Second)
+		c.MaxTimeout = Duration(5 * time.Second)
+	})
+	mem := newMemoryStore()
+	useStore(t, mem)
+	fake := newFakeUpstream(t, status)
+	fake.delay.Store(int64(delay))
+	useChain(t, "awesomeapi", upstream.ChainConfig{BaseURL: fake.URL})
+	return fake, mem
+}
+
+// useUpstreamLimit sets -max-upstream to slots and -upstream-wait to wait.
+func useUpstreamLimit(t *testing.T, slots int, wait time.Duration) {
+	t.Helper()
+	prevSlots, prevWait := upstreamSlots, upstreamWait
+	t.Cleanup(func() { upstreamSlots, upstreamWait = prevSlots, prevWait })
+	upstreamSlots, upstreamWait = make(chan struct{}, slots), wait
+}
+
+// getConcurrently serves the targets with cotacaoHandler at the same time,
+// returning the responses in the same order.
+func getConcurrently(t *testing.T, targets []string) []*httptest.ResponseRecorder {
+	t.Helper()
+	responses := make([]*httptest.ResponseRecorder, len(targets))
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target string) {
+			defer wg.Done()
+			<-start
+			responses[i] = getCotacao(t, target)
+		}(i, target)
+	}
+	close(start)
+	wg.Wait()
+	return responses
+}
+
+func repeat(target string, n int) []string {
+	targets := make([]string, n)
+	for i := range targets {
+		targets[i] = target
+	}
+	return targets
+}
+
+func problemCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var p Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+		t.Errorf("body %q isn't a problem document: %v", w.Body, err)
+	}
+	return p.Code
+}
+
+func TestCotacaoHandlerDeduplicatesUpstream(t *testing.T) {
+	fake, mem := useSlowUpstream(t, http.StatusOK, 200*time.Millisecond)
+
+	const clients = 100
+	for i, w := range getConcurrently(t, repeat("/cotacao", clients)) {
+		var resp QuotationResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); w.Code != http.StatusOK || err != nil || resp.Bid != "5.1234" {
+			t.Errorf("client %d: status %d, body %s", i, w.Code, w.Body)
+		}
+	}
+	if n := fake.hits.Load(); n != 1 {
+		t.Errorf("upstream got %d requests from %d clients, want 1", n, clients)
+	}
+	var rows int
+	mem.queryQuotations(context.Background(), historyFilter{}, func(*upstream.Quotation) error { rows++; return nil })
+	if rows != 1 {
+		t.Errorf("%d rows stored, want 1", rows)
+	}
+
+	// Once the call is over the next miss calls the upstream again.
+	if w := getCotacao(t, "/cotacao"); w.Code != http.StatusOK || fake.hits.Load() != 2 {
+		t.Errorf("status %d after %d upstream requests, want 200 after 2", w.Code, fake.hits.Load())
+	}
+}
+
+func TestCotacaoHandlerSharesUpstreamError(t *testing.T) {
+	fake, _ := useSlowUpstream(t, http.StatusInternalServerError, 200*time.Millisecond)
+
+	const clients = 50
+	for i, w := range getConcurrently(t, repeat("/cotacao", clients)) {
+		if w.Code != http.StatusBadGateway || problemCode(t, w) != codeUpstreamError {
+			t.Errorf("client %d: status %d, body %s; want 502 %s", i, w.Code, w.Body, codeUpstreamError)
+		}
+	}
+	if n := fake.hits.Load(); n != 1 {
+		t.Errorf("upstream got %d requests from %d clients, want 1", n, clients)
+	}
+}
+
+// TestCotacaoHandlerDeadlineWhileShared has a client with a short timeout
+// give up on the call it shares with a patient one.
+func TestCotacaoHandlerDeadlineWhileShared(t *testing.T) {
+	fake, _ := useSlowUpstream(t, http.StatusOK, 300*time.Millisecond)
+
+	start := time.Now()
+	var impatient time.Duration
+	var wg sync.WaitGroup
+	var patient *httptest.ResponseRecorder
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		patient = getCotacao(t, "/cotacao")
+	}()
+	time.Sleep(20 * time.Millisecond)
+	w := getCotacao(t, "/cotacao?timeout=50ms")
+	impatient = time.Since(start)
+	wg.Wait()
+
+	if w.Code != http.StatusGatewayTimeout || problemCode(t, w) != codeUpstreamTimeout {
+		t.Errorf("short timeout: status %d, body %s; want 504 %s", w.Code, w.Body, codeUpstreamTimeout)
+	}
+	if impatient >= 300*time.Millisecond {
+		t.Errorf("short timeout answered after %v, waiting for the shared call", impatient)
+	}
+	if patient.Code != http.StatusOK {
+		t.Errorf("long timeout: status %d, body %s; want 200", patient.Code, patient.Body)
+	}
+	if n := fake.hits.Load(); n != 1 {
+		t.Errorf("upstream got %d requests, want 1", n)
+	}
+}
+
+func TestCotacaoHandlerMaxUpstream(t *testing.T) {
+	pairs := make([]string, 6)
+	for i := range pairs {
+		pairs[i] = "/cotacao?pair=C0" + strconv.Itoa(i) + "-BRL"
+	}
+
+	t.Run("waits for a slot", func(t *testing.T) {
+		fake, _ := useSlowUpstream(t, http.StatusOK, 100*time.Millisecond)
+		useUpstreamLimit(t, 2, time.Second)
+		for i, w := range getConcurrently(t, pairs) {
+			if w.Code != http.StatusOK {
+				t.Errorf("%s: status %d, body %s", pairs[i], w.Code, w.Body)
+			}
+		}
+		if n, max := fake.hits.Load(), fake.maxInFlight.Load(); n != int32(len(pairs)) || max != 2 {
+			t.Errorf("upstream got %d requests, at most %d at once; want %d, 2 at once", n, max, len(pairs))
+		}
+	})
+
+	tests := []struct {
+		name string
+		wait time.Duration
+	}{
+		{"fails fast", 0},
+		{"gives up waiting", 50 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, _ := useSlowUpstream(t, http.StatusOK, 300*time.Millisecond)
+			useUpstreamLimit(t, 2, tt.wait)
+			responses := getConcurrently(t, pairs[:4])
+			var ok, busy int
+			for i, w := range responses {
+				switch {
+				case w.Code == http.StatusOK:
+					ok++
+				case w.Code == http.StatusServiceUnavailable && problemCode(t, w) == codeUpstreamBusy:
+					busy++
+				default:
+					t.Errorf("%s: status %d, body %s", pairs[i], w.Code, w.Body)
+				}
+			}
+			if ok != 2 || busy != 2 {
+				t.Errorf("%d served and %d refused, want 2 and 2", ok, busy)
+			}
+			if n := fake.hits.Load(); n != 2 {
+				t.Errorf("upstream got %d requests, want 2", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+// Response formats of /cotacao and /cotacao/history, the values of ?format=.
+const (
+	formatJSON string = "json"
+	formatXML  string = "xml"
+	formatCSV  string = "csv"
+
+	xmlContentType string = "application/xml; charset=utf-8"
+	csvContentType string = "text/csv; charset=utf-8"
+)
+
+var (
+	errNotAcceptable = errors.New("nenhum formato suportado em Accept: use application/json, application/xml ou text/csv")
+
+	// quotationCSVHeader is csvHeader plus the source of the quotation.
+	quotationCSVHeader = append(append([]string(nil), csvHeader...), "source")
+)
+
+// acceptFormats maps the media types of Accept to a format; the wildcards
+// fall back to JSON, or CSV for text/*.
+var acceptFormats = map[string]string{
+	"application/json": formatJSON,
+	"application/xml":  formatXML,
+	"text/xml":         formatXML,
+	"text/csv":         formatCSV,
+	"*/*":              formatJSON,
+	"application/*":    formatJSON,
+	"text/*":           formatCSV,
+}
+
+// negotiateFormat picks the response format: ?format= when present, else the
+// media type of Accept with the highest q, the first one on ties. No Accept
+// means JSON; an Accept listing only other types is errNotAcceptable.
+func negotiateFormat(r *http.Request) (string, error) {
+	if raw := r.URL.Query().Get("format"); raw != "" {
+		switch raw {
+		case formatJSON, formatXML, formatCSV:
+			return raw, nil
+		}
+		return "", fmt.Errorf("parâmetro format deve ser json, xml ou csv: %q", raw)
+	}
+	accept := strings.Join(r.Header.Values("Accept"), ",")
+	if strings.TrimSpace(accept) == "" {
+		return formatJSON, nil
+	}
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		format, ok := acceptFormats[strings.ToLower(strings.TrimSpace(params[0]))]
+		if !ok {
+			continue
+		}
+		q := 1.0
+		for _, p := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(p), "=")
+			if strings.EqualFold(name, "q") {
+				if v, err := strconv.ParseFloat(value, 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	if best == "" {
+		return "", errNotAcceptable
+	}
+	return best, nil
+}
+
+// sendFormatError answers a failed negotiateFormat: 406 for Accept, 400 for
+// ?format=.
+func sendFormatError(w http.ResponseWriter, route string, err error) {
+	msg := fmt.Sprint(route, " - ", err)
+	if errors.Is(err, errNotAcceptable) {
+		sendMsgError(w, codeNotAcceptable, msg, http.StatusNotAcceptable)
+		return
+	}
+	sendMsgError(w, codeBadRequest, msg, http.StatusBadRequest)
+}
+
+// sendXML is sendJSON for XML bodies.
+func sendXML(w http.ResponseWriter, statusCode int, v any) error {
+	body, err := xml.Marshal(v)
+	if err != nil {
+		msg := fmt.Sprint("falha ao codificar resposta: ", err)
+		sendMsgError(w, codeInternal, msg, http.StatusInternalServerError)
+		return err
+	}
+	w.Header().Set("Content-Type", xmlContentType)
+	w.WriteHeader(statusCode)
+	_, err = w.Write(append([]byte(xml.Header), append(body, '\n')...))
+	return err
+}
+
+// sendCSV writes header and rows. The bodies are a page at most, so they are
+// encoded before the status is sent, like sendJSON does.
+func sendCSV(w http.ResponseWriter, statusCode int, header []string, rows [][]string) error {
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	cw.Write(header)
+	cw.WriteAll(rows)
+	if err := cw.Error(); err != nil {
+		msg := fmt.Sprint("falha ao codificar resposta: ", err)
+		sendMsgError(w, codeInternal, msg, http.StatusInternalServerError)
+		return err
+	}
+	w.Header().Set("Content-Type", csvContentType)
+	w.WriteHeader(statusCode)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// csvRecord is the row of q under csvHeader.
+func csvRecord(q *upstream.Quotation) []string {
+	return []string{
+		q.Code,
+		q.CodeIn,
+		q.Name,
+		q.High,
+		q.Low,
+		q.VarBid,
+		q.PctChange,
+		q.Bid,
+		q.Ask,
+		q.Timestamp,
+		q.CreateDate,
+	}
+}
+
+// quotationsXML is the XML body of ?pairs=: the quotations in the order
+// asked, each with its pair attribute.
+type quotationsXML struct {
+	XMLName xml.Name `xml:"quotations"`
+	Items   []any
+}
+
+// sendQuotation writes the /cotacao body in format. XML can't hold the map
+// of ?pairs=, so it gets a list instead; CSV always has the full quotation,
+// one row per pair, with the source in the last column.
+func sendQuotation(w http.ResponseWriter, format string, pairs []string, quotations map[string]upstream.Quotation, body any, source string) error {
+	switch format {
+	case formatXML:
+		byPair, ok := body.(map[string]any)
+		if !ok {
+			return sendXML(w, http.StatusOK, body)
+		}
+		list := quotationsXML{Items: make([]any, len(pairs))}
+		for i, pair := range pairs {
+			list.Items[i] = byPair[pair]
+		}
+		return sendXML(w, http.StatusOK, list)
+	case formatCSV:
+		rows := make([][]string, len(pairs))
+		for i, pair := range pairs {
+			q := quotations[pair]
+			rows[i] = append(csvRecord(&q), source)
+		}
+		return sendCSV(w, http.StatusOK, quotationCSVHeader, rows)
+	default:
+		return sendJSON(w, http.StatusOK, body)
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+
+	"github.com/twsm000/goxp-client-server-api/internal/quotationpb"
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+// startGRPCServer serves QuotationService on grpcAddr until ctx is done, over
+// TLS when the HTTP server has it and in plaintext HTTP/2 otherwise. It does
+// nothing unless -grpc-port is set.
+func startGRPCServer(ctx context.Context) {
+	if grpcAddr == "" {
+		return
+	}
+	ln, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		fatal("Servidor gRPC falhou", "err", err)
+	}
+	server := newGRPCServer()
+
+	backgroundJobs.Add(2)
+	go func() {
+		defer backgroundJobs.Done()
+		<-ctx.Done()
+		// The streams only end once the hub closes; GracefulStop waits for
+		// them.
+		hub.close()
+		stopped := make(chan struct{})
+		go func() {
+			server.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(shutdownTimeout):
+			slog.Warn("Falha ao encerrar servidor gRPC, fechando conexões restantes")
+			server.Stop()
+		}
+	}()
+	go func() {
+		defer backgroundJobs.Done()
+		slog.Info("Servidor gRPC iniciado", "addr", grpcAddr, "tls", tlsConfig != nil)
+		if err := server.Serve(ln); err != nil {
+			slog.Error("Servidor gRPC falhou", "err", err)
+		}
+	}()
+}
+
+// newGRPCServer registers QuotationService and the reflection service, so
+// tools like grpcurl can list and call the methods without the .proto.
+func newGRPCServer() *grpc.Server {
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(grpcUnaryInterceptor),
+		grpc.StreamInterceptor(grpcStreamInterceptor),
+	}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	server := grpc.NewServer(opts...)
+	quotationpb.RegisterQuotationServiceServer(server, quotationService{})
+	reflection.Register(server)
+	return server
+}
+
+// quotationService implements QuotationService on the store and the hub of
+// the HTTP API.
+type quotationService struct {
+	quotationpb.UnimplementedQuotationServiceServer
+}
+
+func (quotationService) GetLatest(ctx context.Context, req *quotationpb.GetLatestRequest) (*quotationpb.Quotation, error) {
+	pair, err := grpcPair(req.GetPair())
+	if err != nil {
+		return nil, err
+	}
+	requestInfoFrom(ctx).Pair = pair
+
+	dbStart := time.Now()
+	cotacao, err := storedQuotation(ctx, pair)
+	requestInfoFrom(ctx).DBDuration = time.Since(dbStart)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, status.Error(codes.NotFound, "nenhuma cotação armazenada")
+	}
+	if err != nil {
+		return nil, grpcDBStatus(err)
+	}
+	return quotationMessage(cotacao, 0), nil
+}
+
+func (quotationService) GetHistory(ctx context.Context, req *quotationpb.GetHistoryRequest) (*quotationpb.GetHistoryResponse, error) {
+	var filter historyFilter
+	var err error
+	if filter.Pair, err = grpcPair(req.GetPair()); err != nil {
+		return nil, err
+	}
+	if req.GetFrom() != 0 {
+		filter.From = time.Unix(req.GetFrom(), 0)
+	}
+	if req.GetTo() != 0 {
+		filter.To = time.Unix(req.GetTo(), 0)
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return nil, status.Error(codes.InvalidArgument, "from deve ser anterior a to")
+	}
+	page := historyPage{Desc: req.GetDesc(), Limit: defaultHistoryLimit, Offset: int(req.GetOffset())}
+	if req.GetLimit() != 0 {
+		page.Limit = int(req.GetLimit())
+	}
+	if page.Limit < 0 || page.Limit > maxHistoryLimit {
+		return nil, status.Errorf(codes.InvalidArgument, "limit deve estar entre 1 e %d: %d", maxHistoryLimit, req.GetLimit())
+	}
+	if page.Offset < 0 {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprint("offset inválido: ", req.GetOffset()))
+	}
+	requestInfoFrom(ctx).Pair = filter.Pair
+
+	dbStart := time.Now()
+	items, total, err := store.quotationPage(ctx, filter, page)
+	requestInfoFrom(ctx).DBDuration = time.Since(dbStart)
+	if err != nil {
+		return nil, grpcDBStatus(err)
+	}
+	resp := &quotationpb.GetHistoryResponse{Total: total}
+	for i := range items {
+		resp.Quotations = append(resp.Quotations, quotationMessage(&items[i].Quotation, items[i].ID))
+	}
+	return resp, nil
+}
+
+// StreamQuotes sends the quotations published to the hub until the client
+// cancels the call or the server shuts down.
+func (quotationService) StreamQuotes(req *quotationpb.StreamQuotesRequest, stream grpc.ServerStreamingServer[quotationpb.Quotation]) error {
+	ctx := stream.Context()
+	pair, err := grpcPair(req.GetPair())
+	if err != nil {
+		return err
+	}
+	requestInfoFrom(ctx).Pair = pair
+
+	events, unsubscribe := hub.subscribe()
+	defer unsubscribe()
+	// The headers go out now, so the client knows the call was accepted
+	// before the first quotation.
+	if err := stream.SendHeader(nil); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e, ok := <-events:
+			if !ok {
+				return status.Error(codes.Unavailable, "servidor encerrando")
+			}
+			q := e.quotation
+			if pair != "" && q.Code+"-"+q.CodeIn != pair {
+				continue
+			}
+			if err := stream.Send(quotationMessage(&q, 0)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// quotationMessage converts q, id included when not zero.
+func quotationMessage(q *upstream.Quotation, id int64) *quotationpb.Quotation {
+	return &quotationpb.Quotation{
+		Code:       q.Code,
+		Codein:     q.CodeIn,
+		Name:       q.Name,
+		High:       q.High,
+		Low:        q.Low,
+		VarBid:     q.VarBid,
+		PctChange:  q.PctChange,
+		Bid:        q.Bid,
+		Ask:        q.Ask,
+		Timestamp:  q.Timestamp,
+		CreateDate: q.CreateDate,
+		Id:         id,
+	}
+}
+
+// grpcUnaryInterceptor and grpcStreamInterceptor give the calls what
+// logRequests and requireAuth give the HTTP requests: a request id, the
+// access log, the audit entry and, with -require-api-key or -jwt-*, the
+// credentials check.
+func grpcUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	var resp any
+	err := observeGRPCCall(ctx, info.FullMethod, func(ctx context.Context) error {
+		var err error
+		resp, err = handler(ctx, req)
+		return err
+	})
+	return resp, err
+}
+
+func grpcStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return observeGRPCCall(ss.Context(), info.FullMethod, func(ctx context.Context) error {
+		return handler(srv, &grpcContextStream{ServerStream: ss, ctx: ctx})
+	})
+}
+
+// grpcContextStream replaces the context of a stream with the one carrying
+// the requestInfo.
+type grpcContextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *grpcContextStream) Context() context.Context {
+	return s.ctx
+}
+
+// observeGRPCCall runs call with a requestInfo in its context, once its
+// credentials are checked, then logs and audits it.
+func observeGRPCCall(ctx context.Context, method string, call func(context.Context) error) error {
+	start := time.Now()
+	r := grpcHTTPRequest(ctx, method)
+	info := &requestInfo{
+		RequestID: requestID(r),
+		Lang:      negotiateLang(r.Header.Get("Accept-Language"), defaultLang),
+	}
+	ctx = context.WithValue(ctx, requestInfoKey{}, info)
+	grpc.SetHeader(ctx, metadata.Pairs(requestIDHeader, info.RequestID))
+
+	err := grpcAuthenticate(r.WithContext(ctx), method)
+	if err == nil {
+		err = call(ctx)
+	}
+	code := status.Code(err)
+	if code != codes.OK {
+		info.ErrorCode = code.String()
+	}
+	logGRPCCall(r, info, code, err, time.Since(start))
+	if audit != nil {
+		audit.enqueue(RequestLogEntry{
+			Timestamp:          start,
+			Method:             http.MethodPost,
+			Path:               method,
+			Pair:               info.Pair,
+			StatusCode:         http.StatusOK,
+			ErrorCode:          info.ErrorCode,
+			UpstreamDurationMs: info.UpstreamDuration.Milliseconds(),
+			DBDurationMs:       info.DBDuration.Milliseconds(),
+			RemoteAddr:         r.RemoteAddr,
+		})
+	}
+	return err
+}
+
+// grpcHTTPRequest presents the metadata of a call as the headers of a
+// request, for the helpers shared with the HTTP API: x-api-key,
+// authorization, x-request-id and accept-language.
+func grpcHTTPRequest(ctx context.Context, method string) *http.Request {
+	r := &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Path: method},
+		Header: make(http.Header),
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for key, values := range md {
+		for _, v := range values {
+			r.Header.Add(key, v)
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+	}
+	return r.WithContext(ctx)
+}
+
+// logGRPCCall writes the access log line of a call, at the levels
+// logRequests uses: failed calls are logged at info too.
+func logGRPCCall(r *http.Request, info *requestInfo, code codes.Code, err error, d time.Duration) {
+	level := currentSettings().logLevel
+	if level != logDebug && level != logInfo && code == codes.OK {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.String("request_id", info.RequestID),
+		slog.String("method", r.URL.Path),
+		slog.String("remote_addr", r.RemoteAddr),
+		slog.String("grpc_status", code.String()),
+		slog.Float64("duration_ms", float64(d.Microseconds())/1000),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("detail", status.Convert(err).Message()))
+	}
+	if level == logDebug {
+		attrs = append(attrs,
+			slog.String("pair", info.Pair),
+			slog.Float64("db_ms", float64(info.DBDuration.Microseconds())/1000),
+			slog.String("lang", info.Lang))
+	}
+	slog.LogAttrs(context.Background(), slog.LevelInfo, "chamada gRPC", attrs...)
+}
+
+// grpcAuthenticate checks the API key or JWT of the call, sent in the
+// x-api-key or authorization metadata, when -require-api-key or -jwt-* is
+// set. The reflection service only describes the API and stays open.
+func grpcAuthenticate(r *http.Request, method string) error {
+	if !authRequired() || !isQuotationServiceMethod(method) {
+		return nil
+	}
+	err := authorize(r, scopeQuotesRead)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, errRevokedAPIKey), errors.Is(err, errInsufficientScope):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, errMissingAPIKey), errors.Is(err, errInvalidAPIKey), errors.Is(err, errInvalidToken):
+		return status.Error(codes.Unauthenticated, err.Error())
+	default:
+		return grpcDBStatus(err)
+	}
+}
+
+// isQuotationServiceMethod tells whether method, a full gRPC method name,
+// belongs to QuotationService. Every one of them reads quotations, so they
+// share scopeQuotesRead.
+func isQuotationServiceMethod(method string) bool {
+	return strings.HasPrefix(method, "/"+quotationpb.QuotationService_ServiceDesc.ServiceName+"/")
+}
+
+// grpcPair validates the optional pair of a request.
+func grpcPair(raw string) (string, error) {
+	if raw == "" {
+		return "", nil
+	}
+	pairs, err := upstream.ParsePairs(raw)
+	if err != nil || len(pairs) != 1 {
+		return "", status.Error(codes.InvalidArgument, "par inválido: "+raw)
+	}
+	return pairs[0], nil
+}
+
+// grpcDBStatus maps a database error like dbErrorStatus does for HTTP.
+func grpcDBStatus(err error) error {
+	switch statusCode, _ := dbErrorStatus(err, codeDBReadFailed); statusCode {
+	case http.StatusNotImplemented:
+		return status.Error(codes.Unimplemented, err.Error())
+	case http.StatusServiceUnavailable:
+		return status.Error(codes.Unavailable, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/twsm000/goxp-client-server-api/internal/quotationpb"
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+// useGRPCServer serves newGRPCServer in plaintext on a local port, returning
+// a connection to it.
+func useGRPCServer(t *testing.T) *grpc.ClientConn {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newGRPCServer()
+	go server.Serve(ln)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient(ln.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestGRPCQuotationService(t *testing.T) {
+	useSettings(t, nil)
+	mem := newMemoryStore()
+	useStore(t, mem)
+	stored := []upstream.Quotation{
+		{Code: "USD", CodeIn: "BRL", Name: "Dólar", Bid: "5.1", Ask: "5.11", Timestamp: "1791968680", CreateDate: "2026-10-14 10:04:40"},
+		{Code: "EUR", CodeIn: "BRL", Name: "Euro", Bid: "6.01", Ask: "6.02", Timestamp: "1791968690", CreateDate: "2026-10-14 10:04:50"},
+	}
+	if err := mem.saveQuotations(context.Background(), stored); err != nil {
+		t.Fatal(err)
+	}
+	client := quotationpb.NewQuotationServiceClient(useGRPCServer(t))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.Run("GetLatest", func(t *testing.T) {
+		var header metadata.MD
+		got, err := client.GetLatest(ctx, &quotationpb.GetLatestRequest{Pair: "usd-brl"}, grpc.Header(&header))
+		if err != nil {
+			t.Fatalf("GetLatest() error = %v", err)
+		}
+		want := quotationMessage(&stored[0], 0)
+		if !proto.Equal(got, want) {
+			t.Errorf("GetLatest() = %v, want %v", got, want)
+		}
+		if len(header.Get(requestIDHeader)) != 1 {
+			t.Errorf("header %s = %q, want a request id", requestIDHeader, header.Get(requestIDHeader))
+		}
+	})
+
+	errorTests := []struct {
+		name string
+		call func() error
+		want codes.Code
+	}{
+		{"GetLatest nothing stored", func() error {
+			_, err := client.GetLatest(ctx, &quotationpb.GetLatestRequest{Pair: "GBP-BRL"})
+			return err
+		}, codes.NotFound},
+		{"GetLatest invalid pair", func() error {
+			_, err := client.GetLatest(ctx, &quotationpb.GetLatestRequest{Pair: "XX"})
+			return err
+		}, codes.InvalidArgument},
+		{"GetHistory limit too large", func() error {
+			_, err := client.GetHistory(ctx, &quotationpb.GetHistoryRequest{Limit: int32(maxHistoryLimit + 1)})
+			return err
+		}, codes.InvalidArgument},
+		{"GetHistory from after to", func() error {
+			_, err := client.GetHistory(ctx, &quotationpb.GetHistoryRequest{From: 20, To: 10})
+			return err
+		}, codes.InvalidArgument},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := status.Code(tt.call()); got != tt.want {
+				t.Errorf("status = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("GetHistory", func(t *testing.T) {
+		got, err := client.GetHistory(ctx, &quotationpb.GetHistoryRequest{Limit: 1, Desc: true})
+		if err != nil {
+			t.Fatalf("GetHistory() error = %v", err)
+		}
+		if got.GetTotal() != int64(len(stored)) || len(got.GetQuotations()) != 1 {
+			t.Fatalf("GetHistory() = %d quotations of %d, want 1 of %d", len(got.GetQuotations()), got.GetTotal(), len(stored))
+		}
+		if q := got.GetQuotations()[0]; q.GetCode() != "EUR" || q.GetId() == 0 {
+			t.Errorf("GetHistory() first = %v, want the EUR row with its id", q)
+		}
+	})
+}
+
+func TestGRPCStreamQuotes(t *testing.T) {
+	useSettings(t, nil)
+	client := quotationpb.NewQuotationServiceClient(useGRPCServer(t))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.StreamQuotes(ctx, &quotationpb.StreamQuotesRequest{Pair: "EUR-BRL"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The header arrives once the server subscribed to the hub.
+	if _, err := stream.Header(); err != nil {
+		t.Fatal(err)
+	}
+	hub.publish(upstream.Quotation{Code: "USD", CodeIn: "BRL", Bid: "5.1"})
+	hub.publish(upstream.Quotation{Code: "EUR", CodeIn: "BRL", Bid: "6.01"})
+	got, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Recv() error = %v", err)
+	}
+	if got.GetCode() != "EUR" || got.GetBid() != "6.01" {
+		t.Errorf("Recv() = %v, want the EUR-BRL quotation only", got)
+	}
+}
+
+func TestGRPCAuthentication(t *testing.T) {
+	useSettings(t, nil)
+	useStore(t, newMemoryStore())
+	prevRequired, prevKeys := apiKeyRequired, staticAPIKeys
+	t.Cleanup(func() { apiKeyRequired, staticAPIKeys = prevRequired, prevKeys })
+	apiKeyRequired = true
+	staticAPIKeys = parseStaticAPIKeys("grpc-test-key")
+
+	conn := useGRPCServer(t)
+	client := quotationpb.NewQuotationServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tests := []struct {
+		name string
+		md   metadata.MD
+		want codes.Code
+	}{
+		{"no key", nil, codes.Unauthenticated},
+		{"invalid key", metadata.Pairs("x-api-key", "nope"), codes.Unauthenticated},
+		{"x-api-key", metadata.Pairs("x-api-key", "grpc-test-key"), codes.NotFound},
+		{"bearer", metadata.Pairs("authorization", "Bearer grpc-test-key"), codes.NotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			callCtx := metadata.NewOutgoingContext(ctx, tt.md)
+			_, err := client.GetLatest(callCtx, &quotationpb.GetLatestRequest{})
+			if got := status.Code(err); got != tt.want {
+				t.Errorf("GetLatest() status = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("reflection stays open", func(t *testing.T) {
+		if got := listGRPCServices(t, conn); len(got) == 0 {
+			t.Error("reflection listed no services without a key")
+		}
+	})
+}
+
+func TestGRPCReflection(t *testing.T) {
+	useSettings(t, nil)
+	got := listGRPCServices(t, useGRPCServer(t))
+	want := quotationpb.QuotationService_ServiceDesc.ServiceName
+	for _, name := range got {
+		if name == want {
+			return
+		}
+	}
+	t.Errorf("reflection services = %q, want %s among them", got, want)
+}
+
+// listGRPCServices asks the reflection service for the services of conn.
+func listGRPCServices(t *testing.T, conn *grpc.ClientConn) []string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	}
+	if err := stream.Send(req); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, s := range resp.GetListServicesResponse().GetService() {
+		names = append(names, s.GetName())
+	}
+	return names
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/twsm000/goxp-client-server-api/internal/buildinfo"
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+// HealthResponse is the body of /healthz.
+type HealthResponse struct {
+	Status          string      `json:"status"`
+	UpstreamBreaker string      `json:"upstream_breaker"`
+	Warmup          string      `json:"warmup"`
+	Cache           *CacheStats `json:"cache,omitempty"`
+}
+
+// healthHandler reports liveness. With ?ready=true it is a light readiness
+// check, answering 503 until the startup warm-up has finished, whatever its
+// outcome; /readyz also checks the dependencies.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	var ready bool
+	if raw := r.URL.Query().Get("ready"); raw != "" {
+		var err error
+		ready, err = strconv.ParseBool(raw)
+		if err != nil {
+			msg := fmt.Sprintf("GET /healthz - parâmetro ready inválido: %q", raw)
+			sendMsgError(w, codeBadRequest, msg, http.StatusBadRequest)
+			return
+		}
+	}
+
+	resp := HealthResponse{Status: "ok", UpstreamBreaker: "disabled", Warmup: warmupStatus()}
+	statusCode := http.StatusOK
+	if ready && warmupState.Load() == warmupPending {
+		resp.Status, statusCode = "starting", http.StatusServiceUnavailable
+	}
+	if b := providers.Breaker(); b != nil {
+		resp.UpstreamBreaker = b.State().String()
+	}
+	if cache != nil && currentSettings().cacheTTL > 0 {
+		stats := cache.stats()
+		resp.Cache = &stats
+	}
+
+	err := sendJSON(w, statusCode, resp)
+	if err != nil {
+		slog.WarnContext(r.Context(), "GET /healthz - falha ao enviar resposta", "err", err)
+	}
+}
+
+// readyProbeTimeout bounds each dependency check of /readyz, well below the
+// timeouts orchestrators give their probes.
+const readyProbeTimeout time.Duration = time.Second
+
+// ReadyResponse is the body of /readyz. Database is "ok", "disabled" or
+// "unavailable"; Upstream is "ok", "unknown" before the first fetch,
+// "budget exhausted", "breaker open" or "unavailable". The errors behind
+// them are only logged.
+type ReadyResponse struct {
+	Status   string `json:"status"`
+	Warmup   string `json:"warmup"`
+	Database string `json:"database"`
+	Upstream string `json:"upstream"`
+}
+
+// readyHandler reports readiness: 200 once the warm-up has finished, the
+// database answers within readyProbeTimeout and the upstream isn't failing,
+// 503 otherwise. The upstream isn't called, so probes can't spend the
+// -upstream-rate budget: with -breaker it fails while the breaker is open,
+// otherwise while the last fetch failed. An exhausted budget doesn't fail it,
+// stored quotations are still served. Without a database (-no-db) only the
+// upstream is checked.
+func readyHandler(w http.ResponseWriter, r *http.Request) {
+	resp := ReadyResponse{Status: "ok", Warmup: warmupStatus(), Database: "ok"}
+	if warmupState.Load() == warmupPending {
+		resp.Status = "starting"
+	}
+	var upstreamOK bool
+	if resp.Upstream, upstreamOK = upstreamReadiness(); !upstreamOK {
+		resp.Status = "unavailable"
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readyProbeTimeout)
+	err := store.ping(ctx)
+	cancel()
+	if errors.Is(err, errNoDatabase) {
+		resp.Database = "disabled"
+	} else if err != nil {
+		resp.Database, resp.Status = "unavailable", "unavailable"
+		slog.WarnContext(r.Context(), "GET /readyz - banco de dados indisponível", "err", err)
+	}
+
+	statusCode := http.StatusOK
+	if resp.Status != "ok" {
+		statusCode = http.StatusServiceUnavailable
+		slog.WarnContext(r.Context(), "GET /readyz - servidor não está pronto", "status", resp.Status)
+	}
+	err = sendJSON(w, statusCode, resp)
+	if err != nil {
+		slog.WarnContext(r.Context(), "GET /readyz - falha ao enviar resposta", "err", err)
+	}
+}
+
+// upstreamReadiness is the Upstream check of /readyz, from the breaker state
+// or, without a breaker, the outcome of the last fetch. ok is false when it
+// fails the readiness.
+func upstreamReadiness() (check string, ok bool) {
+	if b := providers.Breaker(); b != nil {
+		if b.State() == upstream.BreakerOpen {
+			return "breaker " + upstream.BreakerOpen.String(), false
+		}
+		return "ok", true
+	}
+	last, fetched := providers.LastFetch()
+	switch {
+	case !fetched:
+		return "unknown", true
+	case errors.Is(last.Err, upstream.ErrBudgetExhausted):
+		return "budget exhausted", true
+	case last.Err != nil:
+		return "unavailable", false
+	}
+	return "ok", true
+}
+
+// versionHandler reports the build of the running server.
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	if err := sendJSON(w, http.StatusOK, buildinfo.Get()); err != nil {
+		slog.WarnContext(r.Context(), "GET /version - falha ao enviar resposta", "err", err)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+// downStore is a database that doesn't answer.
+type downStore struct {
+	noopStore
+}
+
+func (downStore) ping(context.Context) error {
+	return errors.New("disk I/O error at /var/lib/cotacao/cotacao.db")
+}
+
+func getReady(t *testing.T) (int, ReadyResponse, string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	readyHandler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	var resp ReadyResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decoding %q: %v", w.Body, err)
+	}
+	return w.Code, resp, w.Body.String()
+}
+
+func TestReadyHandler(t *testing.T) {
+	quotationsFile := filepath.Join(t.TempDir(), "cotacoes.json")
+	err := os.WriteFile(quotationsFile, []byte(`{"USDBRL": {"code": "USD", "codein": "BRL", "name": "Fake", "high": "5.2", "low": "5.0", "varBid": "0.01", "pctChange": "0.2", "bid": "5.1", "ask": "5.11", "timestamp": "1791968680", "create_date": "2026-10-14 10:00:00"}}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		store        storage
+		file         string
+		fetch        bool
+		wantStatus   int
+		wantDatabase string
+		wantUpstream string
+	}{
+		{"before the first fetch", noopStore{}, quotationsFile, false, http.StatusOK, "disabled", "unknown"},
+		{"last fetch ok", noopStore{}, quotationsFile, true, http.StatusOK, "disabled", "ok"},
+		{"last fetch failed", noopStore{}, filepath.Join(t.TempDir(), "missing.json"), true, http.StatusServiceUnavailable, "disabled", "unavailable"},
+		{"database down", downStore{}, quotationsFile, true, http.StatusServiceUnavailable, "unavailable", "ok"},
+	}
+	defer func(orig int32) { warmupState.Store(orig) }(warmupState.Load())
+	warmupState.Store(warmupOK)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useStore(t, tt.store)
+			chain := useChain(t, "file", upstream.ChainConfig{File: tt.file})
+			if tt.fetch {
+				chain.Fetch(context.Background(), upstream.DefaultPair)
+			}
+			code, resp, body := getReady(t)
+			if code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", code, tt.wantStatus)
+			}
+			if resp.Database != tt.wantDatabase || resp.Upstream != tt.wantUpstream {
+				t.Errorf("database %q upstream %q, want %q %q", resp.Database, resp.Upstream, tt.wantDatabase, tt.wantUpstream)
+			}
+			// The errors behind a failed check stay in the server log.
+			if strings.Contains(body, "missing.json") || strings.Contains(body, "/var/lib") {
+				t.Errorf("body leaks the error: %s", body)
+			}
+		})
+	}
+}
+
+func TestReadyHandlerDoesNotCallUpstream(t *testing.T) {
+	defer func(orig int32) { warmupState.Store(orig) }(warmupState.Load())
+	warmupState.Store(warmupOK)
+	useStore(t, noopStore{})
+	fake := newFakeUpstream(t, http.StatusInternalServerError)
+	chain := useChain(t, "awesomeapi", upstream.ChainConfig{BaseURL: fake.URL, BreakerThreshold: 1, BreakerCooldown: time.Minute})
+
+	for i := 0; i < 5; i++ {
+		if code, resp, _ := getReady(t); code != http.StatusOK || resp.Upstream != "ok" {
+			t.Fatalf("closed breaker: status %d, upstream %q", code, resp.Upstream)
+		}
+	}
+	if n := fake.hits.Load(); n != 0 {
+		t.Errorf("probes made %d upstream calls, want 0", n)
+	}
+
+	chain.Fetch(context.Background(), upstream.DefaultPair)
+	code, resp, _ := getReady(t)
+	if code != http.StatusServiceUnavailable || resp.Upstream != "breaker open" {
+		t.Errorf("open breaker: status %d, upstream %q, want 503 breaker open", code, resp.Upstream)
+	}
+	if n := fake.hits.Load(); n != 1 {
+		t.Errorf("upstream got %d calls, want only the 1 fetch", n)
+	}
+}
+
+func TestReadyHandlerStarting(t *testing.T) {
+	defer func(orig int32) { warmupState.Store(orig) }(warmupState.Load())
+	warmupState.Store(warmupPending)
+	useStore(t, noopStore{})
+	useChain(t, "file", upstream.ChainConfig{})
+	if code, resp, _ := getReady(t); code != http.StatusServiceUnavailable || resp.Status != "starting" {
+		t.Errorf("status %d %q, want 503 starting", code, resp.Status)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+// useSettings puts the default configuration, changed by mutate, in effect
+// for the test, restoring the previous settings afterwards.
+func useSettings(t testing.TB, mutate func(*Config)) {
+	t.Helper()
+	cfg := defaultConfig()
+	if mutate != nil {
+		mutate(cfg)
+	}
+	s, err := newRuntimeSettings(cfg)
+	if err != nil {
+		t.Fatalf("newRuntimeSettings() error = %v", err)
+	}
+	// Not through storeSettings, which closes the changed channel of the
+	// snapshot it replaces: prev goes back in effect afterwards.
+	prev := settings.Swap(s)
+	t.Cleanup(func() { settings.Store(prev) })
+}
+
+// useStore makes s the store of the test.
+func useStore(t testing.TB, s storage) {
+	t.Helper()
+	prev := store
+	store = s
+	t.Cleanup(func() { store = prev })
+}
+
+// useDatabase makes a migrated sqlite database in a temporary directory the
+// store of the test, with the database timeouts of the default settings
+// raised to 5s so a loaded test machine doesn't fail the queries.
+func useDatabase(t testing.TB) *sqliteStore {
+	t.Helper()
+	prevTimeout, prevBusy, prevReaders := databaseTimeout, busyTimeout, databaseReaders
+	databaseTimeout, busyTimeout, databaseReaders = 5*time.Second, 5*time.Second, 4
+	t.Cleanup(func() { databaseTimeout, busyTimeout, databaseReaders = prevTimeout, prevBusy, prevReaders })
+	s := startDatabase(filepath.Join(t.TempDir(), "cotacao.db"))
+	t.Cleanup(func() { s.close() })
+	useStore(t, s)
+	return s
+}
+
+// seedQuotations stores n quotations in s, cycling through pairs, the i-th
+// timestamped start plus i steps with a bid of 5.0000 plus i ten
+// thousandths, in transactions of up to 10000 rows.
+func seedQuotations(t testing.TB, s storage, n int, start time.Time, step time.Duration, pairs ...string) {
+	t.Helper()
+	const batchSize = 10000
+	batch := make([]upstream.Quotation, 0, batchSize)
+	for i := 0; i < n; i++ {
+		code, codeIn, _ := strings.Cut(pairs[i%len(pairs)], "-")
+		price := fmt.Sprintf("%d.%04d", 5+i/10000%1000, i%10000)
+		q := upstream.Quotation{
+			Code: code, CodeIn: codeIn, Name: "Seed",
+			High: price, Low: price, Bid: price, Ask: price,
+			Timestamp: strconv.FormatInt(start.Add(time.Duration(i)*step).Unix(), 10),
+		}
+		if err := upstream.ParseValues(&q); err != nil {
+			t.Fatal(err)
+		}
+		batch = append(batch, q)
+		if len(batch) == batchSize || i == n-1 {
+			if err := s.saveQuotations(context.Background(), batch); err != nil {
+				t.Fatalf("saveQuotations() error = %v", err)
+			}
+			batch = batch[:0]
+		}
+	}
+}
+
+// useChain makes the chain of names, built from cfg, the providers of the
+// test.
+func useChain(t testing.TB, names string, cfg upstream.ChainConfig) *upstream.Chain {
+	t.Helper()
+	chain, err := upstream.NewChain(names, cfg)
+	if err != nil {
+		t.Fatalf("NewChain() error = %v", err)
+	}
+	prev := providers
+	providers = chain
+	t.Cleanup(func() { providers = prev })
+	return chain
+}
+
+// fakeUpstream is an AwesomeAPI answering status, with a quotation of every
+// pair asked when it is 200. A request with a pair of reject gets a 404, as
+// the real one answers an unknown pair. hits counts the requests it got and
+// maxInFlight the most it served at once, each taking delay.
+type fakeUpstream struct {
+	*httptest.Server
+	status      atomic.Int32
+	hits        atomic.Int32
+	delay       atomic.Int64
+	inFlight    atomic.Int32
+	maxInFlight atomic.Int32
+	reject      map[string]bool
+}
+
+func newFakeUpstream(t testing.TB, status int, reject ...string) *fakeUpstream {
+	t.Helper()
+	u := &fakeUpstream{reject: make(map[string]bool)}
+	for _, pair := range reject {
+		u.reject[pair] = true
+	}
+	u.status.Store(int32(status))
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u.hits.Add(1)
+		n := u.inFlight.Add(1)
+		defer u.inFlight.Add(-1)
+		for max := u.maxInFlight.Load(); n > max && !u.maxInFlight.CompareAndSwap(max, n); max = u.maxInFlight.Load() {
+		}
+		time.Sleep(time.Duration(u.delay.Load()))
+		if status := int(u.status.Load()); status != http.StatusOK {
+			http.Error(w, "fake upstream failure", status)
+			return
+		}
+		pairs := strings.Split(strings.TrimPrefix(r.URL.Path, "/json/last/"), ",")
+		body := make(map[string]any, len(pairs))
+		for _, pair := range pairs {
+			if u.reject[pair] {
+				http.Error(w, `{"status": 404, "code": "CoinNotExists"}`, http.StatusNotFound)
+				return
+			}
+			code, codeIn, _ := strings.Cut(pair, "-")
+			body[code+codeIn] = map[string]string{
+				"code": code, "codein": codeIn, "name": "Fake", "high": "5.2", "low": "5.0",
+				"varBid": "0.01", "pctChange": "0.2", "bid": "5.1234", "ask": "5.1240",
+				"timestamp": strconv.FormatInt(time.Now().Unix(), 10), "create_date": "2026-10-14 10:00:00",
+			}
+		}
+		json.NewEncoder(w).Encode(body)
+	}))
+	t.Cleanup(u.Close)
+	return u
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+var csvHeader = []string{
+	"code",
+	"codein",
+	"name",
+	"high",
+	"low",
+	"varBid",
+	"pctChange",
+	"bid",
+	"ask",
+	"timestamp",
+	"create_date",
+}
+
+const (
+	defaultHistoryLimit int = 100
+	maxHistoryLimit     int = 1000
+)
+
+// PurgeResponse reports how many rows a manual purge removed.
+type PurgeResponse struct {
+	Deleted int64 `json:"deleted"`
+}
+
+// HistoryResponse is a page of GET /cotacao/history. Filters echoes the
+// effective filters and Total counts every matching row, not only the page.
+type HistoryResponse struct {
+	XMLName xml.Name          `json:"-" xml:"history"`
+	Filters HistoryFilters    `json:"filters" xml:"filters"`
+	Total   int64             `json:"total" xml:"total"`
+	Items   []StoredQuotation `json:"items" xml:"items>quotation"`
+}
+
+// HistoryFilters are the filters and the page applied to a history query.
+type HistoryFilters struct {
+	Pair   string     `json:"pair,omitempty" xml:"pair,omitempty"`
+	From   *time.Time `json:"from,omitempty" xml:"from,omitempty"`
+	To     *time.Time `json:"to,omitempty" xml:"to,omitempty"`
+	Order  string     `json:"order" xml:"order"`
+	Limit  int        `json:"limit" xml:"limit"`
+	Offset int        `json:"offset" xml:"offset"`
+}
+
+// historyPage selects the page of a history query.
+type historyPage struct {
+	Desc   bool
+	Limit  int
+	Offset int
+}
+
+// listHistoryHandler serves GET /cotacao/history?pair=USD-BRL&from=...&to=...
+// &order=asc&limit=100&offset=0, the stored quotations in [from, to), in
+// the format negotiated by Accept or ?format=. The CSV has the columns of
+// /cotacao/history.csv and no total.
+func listHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept")
+	format, err := negotiateFormat(r)
+	if err != nil {
+		sendFormatError(w, "GET /cotacao/history", err)
+		return
+	}
+	query := r.URL.Query()
+	filter, err := parseHistoryFilter(query)
+	if err != nil {
+		msg := fmt.Sprint("GET /cotacao/history - ", err)
+		sendMsgError(w, codeBadRequest, msg, http.StatusBadRequest)
+		return
+	}
+	page, err := parseHistoryPage(query)
+	if err != nil {
+		msg := fmt.Sprint("GET /cotacao/history - ", err)
+		sendMsgError(w, codeBadRequest, msg, http.StatusBadRequest)
+		return
+	}
+
+	info := requestInfoFrom(r.Context())
+	info.Pair = filter.Pair
+	dbStart := time.Now()
+	items, total, err := store.quotationPage(r.Context(), filter, page)
+	info.DBDuration = time.Since(dbStart)
+	if err != nil {
+		statusCode, code := dbErrorStatus(err, codeDBReadFailed)
+		msg := fmt.Sprint("GET /cotacao/history - ", err)
+		sendMsgError(w, code, msg, statusCode)
+		return
+	}
+
+	filters := HistoryFilters{Pair: filter.Pair, Order: "asc", Limit: page.Limit, Offset: page.Offset}
+	if page.Desc {
+		filters.Order = "desc"
+	}
+	if !filter.From.IsZero() {
+		from := filter.From.UTC()
+		filters.From = &from
+	}
+	if !filter.To.IsZero() {
+		to := filter.To.UTC()
+		filters.To = &to
+	}
+	body := HistoryResponse{Filters: filters, Total: total, Items: items}
+	switch format {
+	case formatXML:
+		err = sendXML(w, http.StatusOK, body)
+	case formatCSV:
+		rows := make([][]string, len(items))
+		for i := range items {
+			rows[i] = csvRecord(&items[i].Quotation)
+		}
+		err = sendCSV(w, http.StatusOK, csvHeader, rows)
+	default:
+		err = sendJSON(w, http.StatusOK, body)
+	}
+	if err != nil {
+		slog.WarnContext(r.Context(), "GET /cotacao/history - falha ao enviar resposta", "err", err)
+	}
+}
+
+// parseHistoryPage reads order (asc, the default, or desc), limit and
+// offset.
+func parseHistoryPage(query url.Values) (historyPage, error) {
+	p := historyPage{Limit: defaultHistoryLimit}
+	switch order := query.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		p.Desc = true
+	default:
+		return p, fmt.Errorf("parâmetro order deve ser asc ou desc: %q", order)
+	}
+	if raw := query.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 || n > maxHistoryLimit {
+			return p, fmt.Errorf("limit deve estar entre 1 e %d: %q", maxHistoryLimit, raw)
+		}
+		p.Limit = n
+	}
+	if raw := query.Get("offset"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return p, fmt.Errorf("offset inválido: %q", raw)
+		}
+		p.Offset = n
+	}
+	return p, nil
+}
+
+// historyItemHandler serves /cotacao/history/{id}, the stored row with the
+// given rowid.
+func historyItemHandler(w http.ResponseWriter, r *http.Request) {
+	raw := strings.TrimPrefix(r.URL.Path, "/cotacao/history/")
+	id, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || id <= 0 {
+		msg := fmt.Sprintf("GET /cotacao/history/{id} - id inválido: %q", raw)
+		sendMsgError(w, codeBadRequest, msg, http.StatusBadRequest)
+		return
+	}
+
+	dbStart := time.Now()
+	stored, err := store.quotationByID(r.Context(), id)
+	requestInfoFrom(r.Context()).DBDuration = time.Since(dbStart)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			msg := fmt.Sprint("GET /cotacao/history/{id} - cotação não encontrada: ", id)
+			sendMsgError(w, codeNotFound, msg, http.StatusNotFound)
+			return
+		}
+		statusCode, code := dbErrorStatus(err, codeDBReadFailed)
+		msg := fmt.Sprint("GET /cotacao/history/{id} - ", err)
+		sendMsgError(w, code, msg, statusCode)
+		return
+	}
+
+	err = sendJSON(w, http.StatusOK, stored)
+	if err != nil {
+		slog.WarnContext(r.Context(), "GET /cotacao/history/{id} - falha ao enviar resposta", "err", err)
+	}
+}
+
+func purgeHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	raw := r.URL.Query().Get("before")
+	before, err := parseTimeParam(raw)
+	if err != nil {
+		msg := fmt.Sprintf("DELETE /cotacao/history - parâmetro before inválido: %q", raw)
+		sendMsgError(w, codeBadRequest, msg, http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := store.purgeQuotationsBefore(r.Context(), before)
+	if err != nil {
+		statusCode, code := dbErrorStatus(err, codeDBWriteFailed)
+		msg := fmt.Sprint("DELETE /cotacao/history - falha ao remover cotações: ", err)
+		sendMsgError(w, code, msg, statusCode)
+		return
+	}
+	slog.InfoContext(r.Context(), "DELETE /cotacao/history - cotações antigas removidas", "deleted", deleted, "before", before.Format(time.RFC3339))
+
+	err = sendJSON(w, http.StatusOK, PurgeResponse{Deleted: deleted})
+	if err != nil {
+		slog.WarnContext(r.Context(), "DELETE /cotacao/history - falha ao enviar resposta", "err", err)
+	}
+}
+
+// historyFilter restricts history queries to a pair and to the half-open
+// interval [From, To). Zero values mean no restriction.
+type historyFilter struct {
+	Pair string
+	From time.Time
+	To   time.Time
+}
+
+func parseHistoryFilter(query url.Values) (historyFilter, error) {
+	var (
+		f   historyFilter
+		err error
+	)
+	if raw := query.Get("pair"); raw != "" {
+		pairs, err := upstream.ParsePairs(raw)
+		if err != nil || len(pairs) != 1 {
+			return f, fmt.Errorf("par inválido: %q", raw)
+		}
+		f.Pair = pairs[0]
+	}
+	if raw := query.Get("from"); raw != "" {
+		f.From, err = parseTimeParam(raw)
+		if err != nil {
+			return f, fmt.Errorf("parâmetro from inválido: %q", raw)
+		}
+	}
+	if raw := query.Get("to"); raw != "" {
+		f.To, err = parseTimeParam(raw)
+		if err != nil {
+			return f, fmt.Errorf("parâmetro to inválido: %q", raw)
+		}
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To) {
+		return f, errors.New("from deve ser anterior a to")
+	}
+	return f, nil
+}
+
+func historyCSVHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseHistoryFilter(r.URL.Query())
+	if err != nil {
+		msg := fmt.Sprint("GET /cotacao/history.csv - ", err)
+		sendMsgError(w, codeBadRequest, msg, http.StatusBadRequest)
+		return
+	}
+
+	// The response starts with the first row, so errors before it (a
+	// failing query, -no-db) still get a proper status.
+	cw := csv.NewWriter(w)
+	started := false
+	start := func() error {
+		started = true
+		w.Header().Set("Content-Type", csvContentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, csvFileName(filter)))
+		return cw.Write(csvHeader)
+	}
+	// Rows are written while scanning; csv.Writer flushes its buffer to the
+	// response as it fills, so memory stays constant.
+	err = store.queryQuotations(r.Context(), filter, func(q *upstream.Quotation) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		return cw.Write(csvRecord(q))
+	})
+	if err != nil && !started {
+		statusCode, code := dbErrorStatus(err, codeDBReadFailed)
+		msg := fmt.Sprint("GET /cotacao/history.csv - ", err)
+		sendMsgError(w, code, msg, statusCode)
+		return
+	}
+	if err == nil && !started {
+		err = start()
+	}
+	cw.Flush()
+	if err == nil {
+		err = cw.Error()
+	}
+	if err != nil {
+		// The status line is already sent, all we can do is log and cut
+		// the body short.
+		slog.WarnContext(r.Context(), "GET /cotacao/history.csv - exportação interrompida", "err", err)
+	}
+}
+
+func csvFileName(f historyFilter) string {
+	from, to := "inicio", time.Now().UTC().Format("2006-01-02")
+	if !f.From.IsZero() {
+		from = f.From.UTC().Format("2006-01-02")
+	}
+	if !f.To.IsZero() {
+		to = f.To.UTC().Format("2006-01-02")
+	}
+	return fmt.Sprintf("cotacao_%s_%s.csv", from, to)
+}
+
+// parseTimeParam accepts a date (2006-01-02, UTC midnight), an RFC3339
+// timestamp or unix epoch seconds.
+func parseTimeParam(raw string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", raw); err == nil {
+		return t, nil
+	}
+	if sec, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Parse(time.RFC3339, raw)
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+// seedStart is when the seeded quotations begin.
+var seedStart = time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+func getHistoryCSV(t *testing.T, target string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	historyCSVHandler(w, httptest.NewRequest(http.MethodGet, target, nil))
+	return w
+}
+
+func TestHistoryCSVHandler(t *testing.T) {
+	useSettings(t, nil)
+	db := useDatabase(t)
+	// Every 6 hours from 2024-06-01, alternating pairs: USD-BRL at 00:00 and
+	// 12:00, EUR-BRL at 06:00 and 18:00, for 3 days.
+	seedQuotations(t, db, 12, seedStart, 6*time.Hour, "USD-BRL", "EUR-BRL")
+
+	tests := []struct {
+		name         string
+		target       string
+		wantRows     int
+		wantFilename string
+	}{
+		{"everything", "/cotacao/history.csv", 12, "cotacao_inicio_" + time.Now().UTC().Format("2006-01-02") + ".csv"},
+		{"pair", "/cotacao/history.csv?pair=EUR-BRL", 6, ""},
+		{"range", "/cotacao/history.csv?from=2024-06-02&to=2024-06-03", 4, "cotacao_2024-06-02_2024-06-03.csv"},
+		{"pair and range", "/cotacao/history.csv?pair=USD-BRL&from=2024-06-02T12:00:00Z&to=1717416000", 2, "cotacao_2024-06-02_2024-06-03.csv"},
+		{"no match", "/cotacao/history.csv?pair=GBP-BRL", 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := getHistoryCSV(t, tt.target)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %s", w.Code, w.Body)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != csvContentType {
+				t.Errorf("Content-Type = %q", ct)
+			}
+			if tt.wantFilename != "" {
+				want := `attachment; filename="` + tt.wantFilename + `"`
+				if got := w.Header().Get("Content-Disposition"); got != want {
+					t.Errorf("Content-Disposition = %q, want %q", got, want)
+				}
+			}
+			records, err := csv.NewReader(w.Body).ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) == 0 || strings.Join(records[0], ",") != strings.Join(csvHeader, ",") {
+				t.Fatalf("first record = %v, want the header", records)
+			}
+			if got := len(records) - 1; got != tt.wantRows {
+				t.Errorf("got %d rows, want %d", got, tt.wantRows)
+			}
+			for i := 2; i < len(records); i++ {
+				if records[i][9] < records[i-1][9] {
+					t.Errorf("row %d timestamp %s before the previous %s", i, records[i][9], records[i-1][9])
+				}
+			}
+		})
+	}
+}
+
+func TestHistoryCSVHandlerErrors(t *testing.T) {
+	useSettings(t, nil)
+	tests := []struct {
+		name       string
+		store      storage
+		target     string
+		wantStatus int
+		wantCode   string
+	}{
+		{"from after to", newMemoryStore(), "/cotacao/history.csv?from=2024-06-02&to=2024-06-01", http.StatusBadRequest, codeBadRequest},
+		{"bad from", newMemoryStore(), "/cotacao/history.csv?from=yesterday", http.StatusBadRequest, codeBadRequest},
+		{"bad pair", newMemoryStore(), "/cotacao/history.csv?pair=USD", http.StatusBadRequest, codeBadRequest},
+		{"no database", noopStore{}, "/cotacao/history.csv", http.StatusNotImplemented, codeDBDisabled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useStore(t, tt.store)
+			w := getHistoryCSV(t, tt.target)
+			var p Problem
+			if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil || w.Code != tt.wantStatus || p.Code != tt.wantCode {
+				t.Errorf("status = %d, body %s; want %d %s", w.Code, w.Body, tt.wantStatus, tt.wantCode)
+			}
+			if got := w.Header().Get("Content-Disposition"); got != "" {
+				t.Errorf("error response has Content-Disposition %q", got)
+			}
+		})
+	}
+}
+
+// streamRecorder counts the CSV it receives without keeping it, sampling
+// the heap as the body grows.
+type streamRecorder struct {
+	header   http.Header
+	status   int
+	bytes    int
+	lines    int
+	writes   int
+	baseHeap uint64
+	peakHeap uint64
+}
+
+func (r *streamRecorder) Header() http.Header { return r.header }
+
+func (r *streamRecorder) WriteHeader(status int) { r.status = status }
+
+func (r *streamRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.bytes += len(p)
+	r.lines += bytes.Count(p, []byte{'\n'})
+	r.writes++
+	if r.writes%100 == 0 {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		if m.HeapAlloc > r.peakHeap {
+			r.peakHeap = m.HeapAlloc
+		}
+	}
+	return len(p), nil
+}
+
+// TestHistoryCSVHandlerLargeExport streams a few hundred thousand rows and
+// checks the heap never holds more than a fraction of the body.
+func TestHistoryCSVHandlerLargeExport(t *testing.T) {
+	if testing.Short() {
+		t.Skip("seeds 300000 rows")
+	}
+	useSettings(t, nil)
+	db := useDatabase(t)
+	const rows = 300000
+	seedQuotations(t, db, rows, seedStart, time.Second, "USD-BRL", "EUR-BRL", "GBP-BRL")
+
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	w := &streamRecorder{header: make(http.Header), baseHeap: m.HeapAlloc}
+	historyCSVHandler(w, httptest.NewRequest(http.MethodGet, "/cotacao/history.csv", nil))
+
+	if w.status != http.StatusOK || w.lines != rows+1 {
+		t.Fatalf("status %d with %d lines, want 200 with %d", w.status, w.lines, rows+1)
+	}
+	if w.writes < 100 {
+		t.Errorf("body written in %d writes, want it streamed", w.writes)
+	}
+	if growth := int64(w.peakHeap) - int64(w.baseHeap); growth > int64(w.bytes/2) {
+		t.Errorf("heap grew %d bytes while streaming %d; the export is being buffered", growth, w.bytes)
+	}
+}
+
+func getHistoryItem(t *testing.T, target string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	historyItemHandler(w, httptest.NewRequest(http.MethodGet, target, nil))
+	return w
+}
+
+func TestHistoryItemHandler(t *testing.T) {
+	useSettings(t, nil)
+	stores := map[string]func(*testing.T) storage{
+		"sqlite": func(t *testing.T) storage { return useDatabase(t) },
+		"memory": func(*testing.T) storage { return newMemoryStore() },
+	}
+	for name, open := range stores {
+		t.Run(name, func(t *testing.T) {
+			s := open(t)
+			useStore(t, s)
+			seedQuotations(t, s, 3, seedStart, time.Hour, "USD-BRL", "EUR-BRL")
+
+			tests := []struct {
+				target     string
+				wantStatus int
+				wantCode   string
+				wantPair   string
+			}{
+				{"/cotacao/history/1", http.StatusOK, "", "USD-BRL"},
+				{"/cotacao/history/2", http.StatusOK, "", "EUR-BRL"},
+				{"/cotacao/history/3", http.StatusOK, "", "USD-BRL"},
+				{"/cotacao/history/4", http.StatusNotFound, codeNotFound, ""},
+				{"/cotacao/history/0", http.StatusBadRequest, codeBadRequest, ""},
+				{"/cotacao/history/-1", http.StatusBadRequest, codeBadRequest, ""},
+				{"/cotacao/history/abc", http.StatusBadRequest, codeBadRequest, ""},
+				{"/cotacao/history/", http.StatusBadRequest, codeBadRequest, ""},
+				{"/cotacao/history/99999999999999999999", http.StatusBadRequest, codeBadRequest, ""},
+			}
+			for _, tt := range tests {
+				t.Run(tt.target, func(t *testing.T) {
+					w := getHistoryItem(t, tt.target)
+					if w.Code != tt.wantStatus {
+						t.Fatalf("status = %d, want %d; body %s", w.Code, tt.wantStatus, w.Body)
+					}
+					if tt.wantCode != "" {
+						var p Problem
+						if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil || p.Code != tt.wantCode {
+							t.Errorf("body %s, want problem code %s", w.Body, tt.wantCode)
+						}
+						return
+					}
+					var got StoredQuotation
+					if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+						t.Fatal(err)
+					}
+					wantID := tt.target[len("/cotacao/history/"):]
+					if strconv.FormatInt(got.ID, 10) != wantID || got.Code+"-"+got.CodeIn != tt.wantPair {
+						t.Errorf("got id %d pair %s-%s, want id %s pair %s", got.ID, got.Code, got.CodeIn, wantID, tt.wantPair)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestHistoryItemHandlerNoDatabase(t *testing.T) {
+	useSettings(t, nil)
+	useStore(t, noopStore{})
+	w := getHistoryItem(t, "/cotacao/history/1")
+	var p Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil || w.Code != http.StatusNotImplemented || p.Code != codeDBDisabled {
+		t.Errorf("status = %d, body %s; want 501 %s", w.Code, w.Body, codeDBDisabled)
+	}
+}
+
+func getHistory(t *testing.T, target string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	listHistoryHandler(w, httptest.NewRequest(http.MethodGet, target, nil))
+	return w
+}
+
+// TestListHistoryHandlerFilters seeds a row every 6 hours from 2024-06-01,
+// ids 1 to 12 over 3 days: USD-BRL at 00:00 and 12:00 (odd ids), EUR-BRL at
+// 06:00 and 18:00 (even ids).
+func TestListHistoryHandlerFilters(t *testing.T) {
+	useSettings(t, nil)
+	tests := []struct {
+		name        string
+		query       string
+		wantIDs     []int64
+		wantTotal   int64
+		wantFilters string
+	}{
+		{"everything", "", []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}, 12,
+			`{"order":"asc","limit":100,"offset":0}`},
+		{"pair", "pair=usd-brl", []int64{1, 3, 5, 7, 9, 11}, 6,
+			`{"pair":"USD-BRL","order":"asc","limit":100,"offset":0}`},
+		{"inclusive from exclusive to", "from=2024-06-02T00:00:00Z&to=2024-06-03T00:00:00Z", []int64{5, 6, 7, 8}, 4,
+			`{"from":"2024-06-02T00:00:00Z","to":"2024-06-03T00:00:00Z","order":"asc","limit":100,"offset":0}`},
+		{"pair and range", "pair=USD-BRL&from=2024-06-02T00:00:00Z&to=2024-06-03T00:00:00Z", []int64{5, 7}, 2,
+			`{"pair":"USD-BRL","from":"2024-06-02T00:00:00Z","to":"2024-06-03T00:00:00Z","order":"asc","limit":100,"offset":0}`},
+		{"from a second after a row", "from=2024-06-02T00:00:01Z&to=2024-06-03T00:00:00Z", []int64{6, 7, 8}, 3, ""},
+		{"to a second after a row", "to=2024-06-02T00:00:01Z", []int64{1, 2, 3, 4, 5}, 5, ""},
+		{"epoch", "from=1717286400&to=1717308000", []int64{5}, 1,
+			`{"from":"2024-06-02T00:00:00Z","to":"2024-06-02T06:00:00Z","order":"asc","limit":100,"offset":0}`},
+		{"offset in RFC3339", "from=2024-06-02T21:00:00-03:00", []int64{9, 10, 11, 12}, 4,
+			`{"from":"2024-06-03T00:00:00Z","order":"asc","limit":100,"offset":0}`},
+		{"a second wide", "from=2024-06-01T12:00:00Z&to=2024-06-01T12:00:01Z", []int64{3}, 1, ""},
+		{"desc page", "pair=EUR-BRL&order=desc&limit=2", []int64{12, 10}, 6,
+			`{"pair":"EUR-BRL","order":"desc","limit":2,"offset":0}`},
+		{"second page", "limit=2&offset=2", []int64{3, 4}, 12, ""},
+		{"past the end", "limit=2&offset=12", nil, 12, ""},
+		{"no match", "from=2025-01-01T00:00:00Z", nil, 0, ""},
+	}
+	stores := map[string]func(*testing.T) storage{
+		"sqlite": func(t *testing.T) storage { return useDatabase(t) },
+		"memory": func(*testing.T) storage { return newMemoryStore() },
+	}
+	for name, open := range stores {
+		t.Run(name, func(t *testing.T) {
+			s := open(t)
+			useStore(t, s)
+			seedQuotations(t, s, 12, seedStart, 6*time.Hour, "USD-BRL", "EUR-BRL")
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					w := getHistory(t, "/cotacao/history?"+tt.query)
+					if w.Code != http.StatusOK {
+						t.Fatalf("status = %d, body %s", w.Code, w.Body)
+					}
+					var resp struct {
+						Filters json.RawMessage   `json:"filters"`
+						Total   int64             `json:"total"`
+						Items   []StoredQuotation `json:"items"`
+					}
+					if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+						t.Fatal(err)
+					}
+					var ids []int64
+					for _, item := range resp.Items {
+						ids = append(ids, item.ID)
+					}
+					if !reflect.DeepEqual(ids, tt.wantIDs) || resp.Total != tt.wantTotal {
+						t.Errorf("ids %v of %d, want %v of %d", ids, resp.Total, tt.wantIDs, tt.wantTotal)
+					}
+					if tt.wantFilters != "" && string(resp.Filters) != tt.wantFilters {
+						t.Errorf("filters = %s, want %s", resp.Filters, tt.wantFilters)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestListHistoryHandlerInvalid(t *testing.T) {
+	useSettings(t, nil)
+	useStore(t, newMemoryStore())
+	for _, query := range []string{
+		"from=2024-06-02T00:00:00Z&to=2024-06-01T00:00:00Z",
+		"from=2024-06-02T00:00:00Z&to=2024-06-02T00:00:00Z",
+		"from=1717286400&to=1717286399",
+		"from=ontem",
+		"to=2024-06-02%2000:00:00",
+		"pair=USD",
+		"pair=USD-BRL,EUR-BRL",
+		"order=sideways",
+		"limit=0",
+		"offset=-1",
+	} {
+		t.Run(query, func(t *testing.T) {
+			w := getHistory(t, "/cotacao/history?"+query)
+			if w.Code != http.StatusBadRequest || problemCode(t, w) != codeBadRequest {
+				t.Errorf("status = %d, body %s; want 400 %s", w.Code, w.Body, codeBadRequest)
+			}
+		})
+	}
+}
+
+// TestListHistoryHandlerReadsSavedQuotations fetches quotations through
+// /cotacao and reads back from the cotacao table what it saved.
+func TestListHistoryHandlerReadsSavedQuotations(t *testing.T) {
+	useSettings(t, nil)
+	db := useDatabase(t)
+	fake := newFakeUpstream(t, http.StatusOK)
+	useChain(t, "awesomeapi", upstream.ChainConfig{BaseURL: fake.URL})
+
+	before := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	after := time.Now().Add(time.Minute).UTC().Format(time.RFC3339)
+	pairs := []string{"USD-BRL", "EUR-BRL", "GBP-BRL"}
+	for _, pair := range pairs {
+		if w := getCotacao(t, "/cotacao?nocache=true&pair="+pair); w.Code != http.StatusOK {
+			t.Fatalf("GET /cotacao?pair=%s status = %d, body %s", pair, w.Code, w.Body)
+		}
+	}
+	var rows int
+	if err := db.readDB.QueryRowContext(context.Background(), `SELECT COUNT(*) FROM cotacao`).Scan(&rows); err != nil || rows != len(pairs) {
+		t.Fatalf("cotacao has %d rows, %v; want %d", rows, err, len(pairs))
+	}
+
+	tests := []struct {
+		query     string
+		wantPairs []string
+		wantTotal int64
+	}{
+		{"", pairs, 3},
+		{"limit=2", pairs[:2], 3},
+		{"from=" + before + "&to=" + after, pairs, 3},
+		{"from=" + before + "&to=" + after + "&limit=1", pairs[:1], 3},
+		{"from=" + after, nil, 0},
+		{"to=" + before, nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			w := getHistory(t, "/cotacao/history?"+tt.query)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %s", w.Code, w.Body)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != jsonContentType {
+				t.Errorf("Content-Type = %q, want %q", ct, jsonContentType)
+			}
+			var resp HistoryResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, item := range resp.Items {
+				got = append(got, item.Code+"-"+item.CodeIn)
+				if item.Bid != "5.1234" || item.BidValue.String() != "5.1234" || item.CreateDate != "2026-10-14 10:00:00" {
+					t.Errorf("item %d = %+v, want what the upstream answered", item.ID, item.Quotation)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.wantPairs) || resp.Total != tt.wantTotal {
+				t.Errorf("pairs %v of %d, want %v of %d", got, resp.Total, tt.wantPairs, tt.wantTotal)
+			}
+		})
+	}
+
+	for _, query := range []string{"limit=0", "limit=1001", "limit=many", "from=" + after + "&to=" + before} {
+		if w := getHistory(t, "/cotacao/history?"+query); w.Code != http.StatusBadRequest {
+			t.Errorf("GET /cotacao/history?%s status = %d, want 400", query, w.Code)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Languages of the error responses. Log lines stay in Portuguese.
+const (
+	langPortuguese string = "pt-BR"
+	langEnglish    string = "en"
+)
+
+// errorMessages is the catalog of the Problem.Title messages, keyed by
+// error code and then language. Every code has every language.
+var errorMessages = map[string]map[string]string{
+	codeBadRequest: {
+		langPortuguese: "requisição inválida",
+		langEnglish:    "invalid request",
+	},
+	codeUnauthorized: {
+		langPortuguese: "credencial de acesso ausente ou inválida",
+		langEnglish:    "missing or invalid credentials",
+	},
+	codeForbidden: {
+		langPortuguese: "credencial sem permissão para este recurso",
+		langEnglish:    "the credentials don't allow this resource",
+	},
+	codeNotFound: {
+		langPortuguese: "recurso não encontrado",
+		langEnglish:    "resource not found",
+	},
+	codeMethodNotAllowed: {
+		langPortuguese: "método não permitido",
+		langEnglish:    "method not allowed",
+	},
+	codeNotAcceptable: {
+		langPortuguese: "formato de resposta não suportado",
+		langEnglish:    "response format not supported",
+	},
+	codeOriginNotAllowed: {
+		langPortuguese: "origem não permitida",
+		langEnglish:    "origin not allowed",
+	},
+	codeCORSNotAllowed: {
+		langPortuguese: "método ou cabeçalho não permitido entre origens",
+		langEnglish:    "method or header not allowed cross-origin",
+	},
+	codeRateLimited: {
+		langPortuguese: "limite de requisições excedido",
+		langEnglish:    "rate limit exceeded",
+	},
+	codeUpstreamTimeout: {
+		langPortuguese: "o serviço de cotações demorou demais para responder",
+		langEnglish:    "the quotation service took too long to answer",
+	},
+	codeUpstreamUnavailable: {
+		langPortuguese: "o serviço de cotações está indisponível",
+		langEnglish:    "the quotation service is unavailable",
+	},
+	codeUpstreamBusy: {
+		langPortuguese: "muitas consultas simultâneas ao serviço de cotações",
+		langEnglish:    "too many simultaneous calls to the quotation service",
+	},
+	codeUpstreamBudget: {
+		langPortuguese: "limite de consultas ao serviço de cotações atingido",
+		langEnglish:    "the quotation service call budget is exhausted",
+	},
+	codeUpstreamError: {
+		langPortuguese: "o serviço de cotações respondeu com erro",
+		langEnglish:    "the quotation service answered with an error",
+	},
+	codeUpstreamInvalid: {
+		langPortuguese: "o serviço de cotações respondeu dados inválidos",
+		langEnglish:    "the quotation service answered invalid data",
+	},
+	codeDBTimeout: {
+		langPortuguese: "o banco de dados demorou demais para responder",
+		langEnglish:    "the database took too long to answer",
+	},
+	codeDBUnavailable: {
+		langPortuguese: "o banco de dados está indisponível",
+		langEnglish:    "the database is unavailable",
+	},
+	codeDBWriteFailed: {
+		langPortuguese: "falha ao salvar no banco de dados",
+		langEnglish:    "failed to write to the database",
+	},
+	codeDBReadFailed: {
+		langPortuguese: "falha ao consultar o banco de dados",
+		langEnglish:    "failed to read from the database",
+	},
+	codeDBDisabled: {
+		langPortuguese: "persistência desativada no servidor",
+		langEnglish:    "persistence is disabled on the server",
+	},
+	codeInternal: {
+		langPortuguese: "erro interno do servidor",
+		langEnglish:    "internal server error",
+	},
+}
+
+// errorMessage returns the catalog message of code in lang, falling back to
+// Portuguese and then to the code itself.
+func errorMessage(code, lang string) string {
+	messages := errorMessages[code]
+	if msg, ok := messages[lang]; ok {
+		return msg
+	}
+	if msg, ok := messages[langPortuguese]; ok {
+		return msg
+	}
+	return code
+}
+
+// parseLang maps a language tag to a supported language: any "pt" tag is
+// pt-BR and any "en" tag is en. ok is false for the other languages.
+func parseLang(tag string) (lang string, ok bool) {
+	primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+	switch primary {
+	case "pt":
+		return langPortuguese, true
+	case "en":
+		return langEnglish, true
+	default:
+		return "", false
+	}
+}
+
+// negotiateLang picks the supported language with the highest weight in an
+// Accept-Language header, like "en-US,en;q=0.9,pt;q=0.8". Without one it is
+// fallback.
+func negotiateLang(header, fallback string) string {
+	best, bestQ := fallback, 0.0
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		lang, ok := parseLang(tag)
+		if !ok {
+			continue
+		}
+		q := 1.0
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			v, err := strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64)
+			if err != nil {
+				continue
+			}
+			q = v
+		}
+		if q > bestQ {
+			best, bestQ = lang, q
+		}
+	}
+	return best
+}
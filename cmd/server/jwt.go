@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// jwtLeeway absorbs the clock skew between the server and the issuer.
+	jwtLeeway      time.Duration = 30 * time.Second
+	jwksRefresh    time.Duration = time.Hour
+	jwksMinRefresh time.Duration = time.Minute
+	jwksTimeout    time.Duration = 5 * time.Second
+	maxJWKSSize    int64         = 1 << 20
+
+	scopeQuotesRead string = "quotes:read"
+	scopeAdmin      string = "admin"
+)
+
+var (
+	errInvalidToken      = errors.New("token inválido")
+	errInsufficientScope = errors.New("credencial sem o escopo necessário")
+)
+
+// jwtVerifier validates the JWTs of -jwt-secret (HS256) or -jwks-url (RS256)
+// issued by -jwt-issuer, and for -jwt-audience when set.
+type jwtVerifier struct {
+	secret   []byte
+	jwks     *jwksCache
+	issuer   string
+	audience string
+}
+
+// newJWTVerifier returns nil when neither -jwt-secret nor -jwks-url is set.
+func newJWTVerifier(cfg *Config) *jwtVerifier {
+	if cfg.JWTSecret == "" && cfg.JWKSURL == "" {
+		return nil
+	}
+	v := &jwtVerifier{issuer: cfg.JWTIssuer, audience: cfg.JWTAudience}
+	if cfg.JWTSecret != "" {
+		v.secret = []byte(cfg.JWTSecret)
+	}
+	if cfg.JWKSURL != "" {
+		v.jwks = &jwksCache{url: cfg.JWKSURL, client: &http.Client{Timeout: jwksTimeout}}
+	}
+	return v
+}
+
+// jwtClaims are the registered claims checked by verify and the scopes, in
+// the OAuth 2.0 scope claim (space separated) or in scp (a string or a list).
+type jwtClaims struct {
+	Issuer    string          `json:"iss"`
+	Subject   string          `json:"sub"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt *float64        `json:"exp"`
+	NotBefore *float64        `json:"nbf"`
+	Scope     string          `json:"scope"`
+	Scp       json.RawMessage `json:"scp"`
+}
+
+// hasScope reports whether the token grants scope.
+func (c *jwtClaims) hasScope(scope string) bool {
+	scopes := strings.Fields(c.Scope)
+	var list []string
+	if json.Unmarshal(c.Scp, &list) == nil {
+		scopes = append(scopes, list...)
+	} else {
+		var s string
+		if json.Unmarshal(c.Scp, &s) == nil {
+			scopes = append(scopes, strings.Fields(s)...)
+		}
+	}
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAudience reports whether aud, a string or a list, names audience.
+func (c *jwtClaims) hasAudience(audience string) bool {
+	var list []string
+	if json.Unmarshal(c.Audience, &list) != nil {
+		var s string
+		if json.Unmarshal(c.Audience, &s) != nil {
+			return false
+		}
+		list = []string{s}
+	}
+	for _, a := range list {
+		if a == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// isJWT tells a JWT from an API key, which never has dots.
+func isJWT(credential string) bool {
+	return strings.Count(credential, ".") == 2
+}
+
+// verify checks the signature and the claims of token. Every failure wraps
+// errInvalidToken.
+func (v *jwtVerifier) verify(ctx context.Context, token string, now time.Time) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: formato", errInvalidToken)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: cabeçalho: %v", errInvalidToken, err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: assinatura: %v", errInvalidToken, err)
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+
+	switch {
+	case header.Alg == "HS256" && v.secret != nil:
+		mac := hmac.New(sha256.New, v.secret)
+		mac.Write(signed)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return nil, fmt.Errorf("%w: assinatura não confere", errInvalidToken)
+		}
+	case header.Alg == "RS256" && v.jwks != nil:
+		key, err := v.jwks.key(ctx, header.Kid)
+		if err != nil {
+			return nil, err
+		}
+		digest := sha256.Sum256(signed)
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) != nil {
+			return nil, fmt.Errorf("%w: assinatura não confere", errInvalidToken)
+		}
+	default:
+		return nil, fmt.Errorf("%w: algoritmo não aceito: %q", errInvalidToken, header.Alg)
+	}
+
+	var claims jwtClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", errInvalidToken, err)
+	}
+	if claims.Issuer != v.issuer {
+		return nil, fmt.Errorf("%w: emissor não aceito: %q", errInvalidToken, claims.Issuer)
+	}
+	if claims.ExpiresAt == nil {
+		return nil, fmt.Errorf("%w: exp ausente", errInvalidToken)
+	}
+	if now.After(unixSeconds(*claims.ExpiresAt).Add(jwtLeeway)) {
+		return nil, fmt.Errorf("%w: expirado", errInvalidToken)
+	}
+	if claims.NotBefore != nil && now.Add(jwtLeeway).Before(unixSeconds(*claims.NotBefore)) {
+		return nil, fmt.Errorf("%w: ainda não válido", errInvalidToken)
+	}
+	if v.audience != "" && !claims.hasAudience(v.audience) {
+		return nil, fmt.Errorf("%w: audiência não aceita", errInvalidToken)
+	}
+	return &claims, nil
+}
+
+func decodeJWTPart(part string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func unixSeconds(sec float64) time.Time {
+	return time.Unix(0, int64(sec*float64(time.Second)))
+}
+
+// jwksCache holds the RSA keys of -jwks-url. They are fetched on first use,
+// again after jwksRefresh, and when a token names an unknown kid, at most
+// once per jwksMinRefresh so bogus tokens can't hammer the issuer. The fetch
+// runs outside mu: tokens signed with a known key are verified meanwhile,
+// and only the callers needing the new set wait for it.
+type jwksCache struct {
+	url    string
+	client *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+	// refreshing is closed when the fetch in progress ends, nil when none
+	// is.
+	refreshing chan struct{}
+}
+
+func (c *jwksCache) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	c.mu.Lock()
+	key, ok := c.lookup(kid)
+	done := c.refreshing
+	if done == nil && (!ok || time.Since(c.fetched) > jwksRefresh) && time.Since(c.fetched) > jwksMinRefresh {
+		done = make(chan struct{})
+		c.refreshing, c.fetched = done, time.Now()
+		go c.refresh(done)
+	}
+	c.mu.Unlock()
+
+	if !ok && done != nil {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		c.mu.Lock()
+		key, ok = c.lookup(kid)
+		c.mu.Unlock()
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: chave %q desconhecida", errInvalidToken, kid)
+	}
+	return key, nil
+}
+
+// lookup finds kid; a token without kid is accepted when the set has a
+// single key. c.mu must be held.
+func (c *jwksCache) lookup(kid string) (*rsa.PublicKey, bool) {
+	if kid == "" && len(c.keys) == 1 {
+		for _, key := range c.keys {
+			return key, true
+		}
+	}
+	key, ok := c.keys[kid]
+	return key, ok
+}
+
+// refresh replaces the key set with the one fetched from c.url, keeping the
+// current set on failure, and closes done. It isn't bound to the request
+// that started it, whose client may go away; jwksTimeout bounds it instead.
+func (c *jwksCache) refresh(done chan struct{}) {
+	keys, err := c.fetch()
+	c.mu.Lock()
+	if err == nil {
+		c.keys = keys
+	}
+	c.refreshing = nil
+	c.mu.Unlock()
+	close(done)
+	if err != nil {
+		slog.Warn("Falha ao atualizar chaves JWKS", "url", c.url, "err", err)
+		return
+	}
+	slog.Info("Chaves JWKS atualizadas", "url", c.url, "keys", len(keys))
+}
+
+// fetch downloads and decodes the key set. Keys other than RSA signing keys
+// are skipped.
+func (c *jwksCache) fetch() (map[string]*rsa.PublicKey, error) {
+	req, err := http.NewRequest(http.MethodGet, c.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %s", resp.Status)
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxJWKSSize)).Decode(&set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("chave %q inválida", k.Kid)
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	return keys, nil
+}
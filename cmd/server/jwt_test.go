@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// jwtNow is the clock of the verify tests.
+var jwtNow = time.Unix(1791968680, 0)
+
+func encodeJWTPart(t *testing.T, v any) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func hs256Token(t *testing.T, secret string, header, claims map[string]any) string {
+	t.Helper()
+	signed := encodeJWTPart(t, header) + "." + encodeJWTPart(t, claims)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func rs256Token(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]any) string {
+	t.Helper()
+	signed := encodeJWTPart(t, map[string]any{"alg": "RS256", "kid": kid}) + "." + encodeJWTPart(t, claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// validClaims are claims verify accepts at jwtNow, changed by mutate.
+func validClaims(mutate func(map[string]any)) map[string]any {
+	claims := map[string]any{
+		"iss": "https://auth.example.com",
+		"sub": "client-1",
+		"exp": jwtNow.Add(time.Hour).Unix(),
+	}
+	if mutate != nil {
+		mutate(claims)
+	}
+	return claims
+}
+
+func TestJWTVerifyHS256(t *testing.T) {
+	const secret = "s3cr3t"
+	hs256 := map[string]any{"alg": "HS256", "typ": "JWT"}
+	tests := []struct {
+		name     string
+		token    string
+		audience string
+		wantErr  bool
+	}{
+		{"valid", hs256Token(t, secret, hs256, validClaims(nil)), "", false},
+		{"wrong secret", hs256Token(t, "other", hs256, validClaims(nil)), "", true},
+		{"alg none", encodeJWTPart(t, map[string]any{"alg": "none"}) + "." + encodeJWTPart(t, validClaims(nil)) + ".", "", true},
+		{"RS256 without -jwks-url", hs256Token(t, secret, map[string]any{"alg": "RS256"}, validClaims(nil)), "", true},
+		{"two parts", "a.b", "", true},
+		{"missing exp", hs256Token(t, secret, hs256, validClaims(func(c map[string]any) { delete(c, "exp") })), "", true},
+		{"expired within the leeway", hs256Token(t, secret, hs256, validClaims(func(c map[string]any) {
+			c["exp"] = jwtNow.Add(-jwtLeeway / 2).Unix()
+		})), "", false},
+		{"expired", hs256Token(t, secret, hs256, validClaims(func(c map[string]any) {
+			c["exp"] = jwtNow.Add(-2 * jwtLeeway).Unix()
+		})), "", true},
+		{"not yet valid within the leeway", hs256Token(t, secret, hs256, validClaims(func(c map[string]any) {
+			c["nbf"] = jwtNow.Add(jwtLeeway / 2).Unix()
+		})), "", false},
+		{"not yet valid", hs256Token(t, secret, hs256, validClaims(func(c map[string]any) {
+			c["nbf"] = jwtNow.Add(2 * jwtLeeway).Unix()
+		})), "", true},
+		{"other issuer", hs256Token(t, secret, hs256, validClaims(func(c map[string]any) { c["iss"] = "https://evil.example.com" })), "", true},
+		{"audience string", hs256Token(t, secret, hs256, validClaims(func(c map[string]any) { c["aud"] = "cotacao" })), "cotacao", false},
+		{"audience list", hs256Token(t, secret, hs256, validClaims(func(c map[string]any) { c["aud"] = []string{"other", "cotacao"} })), "cotacao", false},
+		{"other audience", hs256Token(t, secret, hs256, validClaims(func(c map[string]any) { c["aud"] = "other" })), "cotacao", true},
+		{"missing audience", hs256Token(t, secret, hs256, validClaims(nil)), "cotacao", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &jwtVerifier{secret: []byte(secret), issuer: "https://auth.example.com", audience: tt.audience}
+			claims, err := v.verify(context.Background(), tt.token, jwtNow)
+			if tt.wantErr {
+				if !errors.Is(err, errInvalidToken) {
+					t.Fatalf("verify() error = %v, want errInvalidToken", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("verify() error = %v", err)
+			}
+			if claims.Subject != "client-1" {
+				t.Errorf("sub = %q, want client-1", claims.Subject)
+			}
+		})
+	}
+}
+
+func TestJWTClaimsHasScope(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims string
+		want   bool
+	}{
+		{"scope", `{"scope": "admin quotes:read"}`, true},
+		{"scp list", `{"scp": ["admin", "quotes:read"]}`, true},
+		{"scp string", `{"scp": "admin quotes:read"}`, true},
+		{"other scopes", `{"scope": "admin", "scp": ["quotes:write"]}`, false},
+		{"prefix only", `{"scope": "quotes:read-only"}`, false},
+		{"none", `{}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c jwtClaims
+			if err := json.Unmarshal([]byte(tt.claims), &c); err != nil {
+				t.Fatal(err)
+			}
+			if got := c.hasScope(scopeQuotesRead); got != tt.want {
+				t.Errorf("hasScope(%q) = %v, want %v", scopeQuotesRead, got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeJWKS serves the public halves of keys, by kid. Requests wait while
+// the ready channel is open, when one is set.
+type fakeJWKS struct {
+	*httptest.Server
+	hits atomic.Int32
+
+	mu    sync.Mutex
+	keys  map[string]*rsa.PrivateKey
+	ready chan struct{}
+}
+
+func newFakeJWKS(t *testing.T, keys map[string]*rsa.PrivateKey) *fakeJWKS {
+	t.Helper()
+	j := &fakeJWKS{keys: keys}
+	j.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		j.hits.Add(1)
+		j.mu.Lock()
+		ready := j.ready
+		j.mu.Unlock()
+		if ready != nil {
+			<-ready
+		}
+		type jwk struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		}
+		var set struct {
+			Keys []jwk `json:"keys"`
+		}
+		j.mu.Lock()
+		for kid, key := range j.keys {
+			set.Keys = append(set.Keys, jwk{
+				Kty: "RSA", Kid: kid, Use: "sig",
+				N: base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		j.mu.Unlock()
+		json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(j.Close)
+	return j
+}
+
+func (j *fakeJWKS) setKey(kid string, key *rsa.PrivateKey) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.keys[kid] = key
+}
+
+func generateRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newJWKSVerifier(url string) *jwtVerifier {
+	return &jwtVerifier{
+		jwks:   &jwksCache{url: url, client: &http.Client{Timeout: jwksTimeout}},
+		issuer: "https://auth.example.com",
+	}
+}
+
+func TestJWTVerifyRS256Rotation(t *testing.T) {
+	k1, k2 := generateRSAKey(t), generateRSAKey(t)
+	jwks := newFakeJWKS(t, map[string]*rsa.PrivateKey{"k1": k1})
+	v := newJWKSVerifier(jwks.URL)
+	ctx := context.Background()
+
+	if _, err := v.verify(ctx, rs256Token(t, k1, "k1", validClaims(nil)), jwtNow); err != nil {
+		t.Fatalf("verify() with k1 error = %v", err)
+	}
+	if _, err := v.verify(ctx, rs256Token(t, k2, "k1", validClaims(nil)), jwtNow); !errors.Is(err, errInvalidToken) {
+		t.Fatalf("verify() signed by another key under k1: error = %v, want errInvalidToken", err)
+	}
+
+	// k2 is published, but the set was fetched less than jwksMinRefresh
+	// ago: the unknown kid must not reach the issuer.
+	jwks.setKey("k2", k2)
+	token := rs256Token(t, k2, "k2", validClaims(nil))
+	if _, err := v.verify(ctx, token, jwtNow); !errors.Is(err, errInvalidToken) {
+		t.Fatalf("verify() with k2 right after a fetch: error = %v, want errInvalidToken", err)
+	}
+	if got := jwks.hits.Load(); got != 1 {
+		t.Fatalf("JWKS fetched %d times, want 1", got)
+	}
+
+	v.jwks.mu.Lock()
+	v.jwks.fetched = time.Now().Add(-2 * jwksMinRefresh)
+	v.jwks.mu.Unlock()
+	if _, err := v.verify(ctx, token, jwtNow); err != nil {
+		t.Fatalf("verify() with k2 after jwksMinRefresh error = %v", err)
+	}
+	if got := jwks.hits.Load(); got != 2 {
+		t.Errorf("JWKS fetched %d times, want 2", got)
+	}
+}
+
+func TestJWKSRefreshDoesNotBlockKnownKeys(t *testing.T) {
+	k1, k2 := generateRSAKey(t), generateRSAKey(t)
+	jwks := newFakeJWKS(t, map[string]*rsa.PrivateKey{"k1": k1, "k2": k2})
+	ready := make(chan struct{})
+	jwks.ready = ready
+	var once sync.Once
+	release := func() { once.Do(func() { close(ready) }) }
+	defer release()
+
+	v := newJWKSVerifier(jwks.URL)
+	v.jwks.keys = map[string]*rsa.PublicKey{"k1": &k1.PublicKey}
+	v.jwks.fetched = time.Now().Add(-2 * jwksRefresh)
+
+	// The unknown k2 starts a refresh, stuck in the issuer.
+	waiting := make(chan error, 1)
+	go func() {
+		_, err := v.verify(context.Background(), rs256Token(t, k2, "k2", validClaims(nil)), jwtNow)
+		waiting <- err
+	}()
+	for jwks.hits.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := v.verify(ctx, rs256Token(t, k1, "k1", validClaims(nil)), jwtNow); err != nil {
+		t.Fatalf("verify() with the known k1 during a refresh: error = %v", err)
+	}
+	canceled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	if _, err := v.verify(canceled, rs256Token(t, k2, "k2", validClaims(nil)), jwtNow); !errors.Is(err, context.Canceled) {
+		t.Errorf("verify() waiting on the refresh with a canceled context: error = %v, want context.Canceled", err)
+	}
+
+	release()
+	if err := <-waiting; err != nil {
+		t.Fatalf("verify() with k2 after the refresh: error = %v", err)
+	}
+	if got := jwks.hits.Load(); got != 1 {
+		t.Errorf("JWKS fetched %d times, want 1", got)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+)
+
+const unixScheme string = "unix://"
+
+// parseListen translates -listen into the network and address to listen on.
+// An empty listen means the TCP port given by -p.
+func parseListen(listen string, port uint) (network, address string, err error) {
+	if listen == "" {
+		return "tcp", fmt.Sprint(":", port), nil
+	}
+	if strings.HasPrefix(listen, unixScheme) {
+		path := strings.TrimPrefix(listen, unixScheme)
+		if path == "" {
+			return "", "", fmt.Errorf("caminho do socket vazio: %q", listen)
+		}
+		return "unix", path, nil
+	}
+	if _, _, err := net.SplitHostPort(listen); err != nil {
+		return "", "", fmt.Errorf("endereço inválido: %q", listen)
+	}
+	return "tcp", listen, nil
+}
+
+// listen opens the listener of the server. A unix socket gets socketMode
+// permissions; closing the listener, which server.Shutdown does, removes it.
+func listen(network, address string) (net.Listener, error) {
+	if network != "unix" {
+		return net.Listen(network, address)
+	}
+
+	err := removeStaleSocket(address)
+	if err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(address, socketMode)
+	if err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("falha ao ajustar permissões do socket %s. %w", address, err)
+	}
+	return ln, nil
+}
+
+// removeStaleSocket removes the socket left behind by a server that didn't
+// shut down cleanly. Anything that isn't a socket, or a socket that still
+// accepts connections, is left alone.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s já existe e não é um socket", path)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("socket %s já está em uso", path)
+	}
+	slog.Info("Removendo socket antigo", "path", path)
+	return os.Remove(path)
+}
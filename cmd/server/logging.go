@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	logFormatText string = "text"
+	logFormatJSON string = "json"
+
+	requestIDHeader string = "X-Request-ID"
+	maxRequestIDLen int    = 64
+)
+
+// setupLogging makes a slog handler writing to out, as text or as JSON Lines
+// with -log-format json, the default logger. The plain log package goes
+// through it too. Durations are written like "1.5s" in both formats.
+func setupLogging(format string, out io.Writer) {
+	opts := &slog.HandlerOptions{ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+		if a.Value.Kind() == slog.KindDuration {
+			a.Value = slog.StringValue(a.Value.Duration().String())
+		}
+		return a
+	}}
+	var h slog.Handler
+	if format == logFormatJSON {
+		h = slog.NewJSONHandler(out, opts)
+	} else {
+		h = slog.NewTextHandler(out, opts)
+	}
+	slog.SetDefault(slog.New(requestIDHandler{h}))
+}
+
+// requestIDHandler adds the id of the request of the context, when there is
+// one, to every record logged with it.
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := requestInfoFrom(ctx).RequestID; id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+// fatal logs msg and args at the error level and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// requestID returns the id of r: the X-Request-ID sent by the caller, when
+// it is short and printable, so ids can follow a request across services, or
+// a new random one.
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); id != "" && len(id) <= maxRequestIDLen && isPrintableASCII(id) {
+		return id
+	}
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func isPrintableASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] <= ' ' || s[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// logAccess writes the access log line of a request. Debug adds what the
+// handler reported.
+func logAccess(r *http.Request, rec *statusRecorder, d time.Duration, debug bool) {
+	info := rec.info
+	attrs := []slog.Attr{
+		slog.String("request_id", info.RequestID),
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.String("remote_addr", r.RemoteAddr),
+		slog.Int("status", rec.status),
+		slog.Int("bytes", rec.size),
+		slog.Float64("duration_ms", float64(d.Microseconds())/1000),
+	}
+	if debug {
+		attrs = append(attrs,
+			slog.String("pair", info.Pair),
+			slog.String("error_code", info.ErrorCode),
+			slog.Float64("upstream_ms", float64(info.UpstreamDuration.Microseconds())/1000),
+			slog.Float64("db_ms", float64(info.DBDuration.Microseconds())/1000),
+			slog.String("lang", info.Lang))
+	}
+	slog.LogAttrs(context.Background(), slog.LevelInfo, "requisição", attrs...)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureLogs sends the logs of the test, in format, to the returned
+// buffer.
+func captureLogs(t *testing.T, format string) *bytes.Buffer {
+	t.Helper()
+	prev := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	var buf bytes.Buffer
+	setupLogging(format, &buf)
+	return &buf
+}
+
+func decodeLogLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var m map[string]any
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("log line %q isn't JSON: %v", line, err)
+		}
+		lines = append(lines, m)
+	}
+	return lines
+}
+
+func TestLoggingRequestID(t *testing.T) {
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		wantID any
+	}{
+		{"request context", context.WithValue(context.Background(), requestInfoKey{}, &requestInfo{RequestID: "abc123"}), "abc123"},
+		{"no request", context.Background(), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := captureLogs(t, logFormatJSON)
+			slog.With("component", "test").WarnContext(tt.ctx, "falha", "pair", "USD-BRL", "wait", 1500*time.Millisecond)
+			line := decodeLogLines(t, buf)[0]
+			if line["msg"] != "falha" || line["level"] != "WARN" || line["pair"] != "USD-BRL" || line["component"] != "test" || line["wait"] != "1.5s" {
+				t.Errorf("line = %v", line)
+			}
+			if line["request_id"] != tt.wantID {
+				t.Errorf("request_id = %v, want %v", line["request_id"], tt.wantID)
+			}
+		})
+	}
+}
+
+func TestLoggingTextFormat(t *testing.T) {
+	buf := captureLogs(t, logFormatText)
+	ctx := context.WithValue(context.Background(), requestInfoKey{}, &requestInfo{RequestID: "abc123"})
+	slog.InfoContext(ctx, "Cotação armazenada", "pair", "USD-BRL")
+	got := buf.String()
+	for _, want := range []string{"level=INFO", `msg="Cotação armazenada"`, "pair=USD-BRL", "request_id=abc123"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("line %q lacks %s", got, want)
+		}
+	}
+}
+
+func TestLogAccess(t *testing.T) {
+	tests := []struct {
+		debug     bool
+		wantDebug bool
+	}{
+		{false, false},
+		{true, true},
+	}
+	for _, tt := range tests {
+		buf := captureLogs(t, logFormatJSON)
+		r := httptest.NewRequest(http.MethodGet, "/cotacao?pair=EUR-BRL", nil)
+		rec := &statusRecorder{status: http.StatusBadGateway, size: 42, info: &requestInfo{RequestID: "abc123", Pair: "EUR-BRL"}}
+		logAccess(r, rec, 1500*time.Microsecond, tt.debug)
+
+		line := decodeLogLines(t, buf)[0]
+		want := map[string]any{"request_id": "abc123", "method": "GET", "path": "/cotacao", "status": 502.0, "bytes": 42.0, "duration_ms": 1.5}
+		for k, v := range want {
+			if line[k] != v {
+				t.Errorf("debug=%v: %s = %v, want %v", tt.debug, k, line[k], v)
+			}
+		}
+		if _, ok := line["pair"]; ok != tt.wantDebug {
+			t.Errorf("debug=%v: pair logged = %v", tt.debug, ok)
+		}
+	}
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+// memoryDBFile is the -db value that keeps everything in memory instead of
+// a sqlite file.
+const memoryDBFile string = ":memory:"
+
+// memoryStore is the storage of -db :memory:: the same behavior as
+// sqliteStore, kept in process and lost on restart. Quotations are kept in
+// insertion order, their index plus one being the id; purged ones leave a
+// nil behind so the ids stay stable.
+type memoryStore struct {
+	mu         sync.RWMutex
+	quotations []*StoredQuotation
+	stored     map[string]bool
+	requestLog []RequestLogEntry
+	lastLogID  int64
+	changes    []ConfigChange
+	apiKeys    []APIKey
+	alerts     []*AlertRule
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{stored: make(map[string]bool)}
+}
+
+// quotationKey identifies a quotation like the cotacao_pair_timestamp index.
+func quotationKey(q *upstream.Quotation) string {
+	return q.Code + "-" + q.CodeIn + "@" + q.Timestamp
+}
+
+// quotationUnix is the timestamp_unix column of q, zero instead of NULL.
+func quotationUnix(q *upstream.Quotation) int64 {
+	t, err := q.Time()
+	if err != nil {
+		return 0
+	}
+	return t.Unix()
+}
+
+func matchesFilter(q *upstream.Quotation, f historyFilter) bool {
+	if f.Pair != "" && q.Code+"-"+q.CodeIn != f.Pair {
+		return false
+	}
+	sec := quotationUnix(q)
+	if !f.From.IsZero() && sec < f.From.Unix() {
+		return false
+	}
+	return f.To.IsZero() || sec < f.To.Unix()
+}
+
+func (s *memoryStore) saveQuotation(ctx context.Context, q *upstream.Quotation) error {
+	insertStart := time.Now()
+	defer observeSince(dbInsertDuration, insertStart)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.insertLocked(q) {
+		slog.InfoContext(ctx, "Cotação já armazenada, inserção ignorada", "pair", q.Code+"-"+q.CodeIn, "timestamp", q.Timestamp)
+	}
+	return nil
+}
+
+func (s *memoryStore) saveQuotations(_ context.Context, batch []upstream.Quotation) error {
+	defer observeSince(dbInsertDuration, time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range batch {
+		s.insertLocked(&batch[i])
+	}
+	return nil
+}
+
+// insertLocked stores q unless one with the same pair and timestamp already
+// is. s.mu must be held.
+func (s *memoryStore) insertLocked(q *upstream.Quotation) bool {
+	key := quotationKey(q)
+	if s.stored[key] {
+		return false
+	}
+	s.stored[key] = true
+	s.quotations = append(s.quotations, &StoredQuotation{ID: int64(len(s.quotations) + 1), Quotation: *q})
+	return true
+}
+
+// matching returns the quotations matching f ordered by timestamp, then id,
+// as the sqlite queries do.
+func (s *memoryStore) matching(f historyFilter) []StoredQuotation {
+	s.mu.RLock()
+	var items []StoredQuotation
+	for _, stored := range s.quotations {
+		if stored != nil && matchesFilter(&stored.Quotation, f) {
+			items = append(items, *stored)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return quotationUnix(&items[i].Quotation) < quotationUnix(&items[j].Quotation)
+	})
+	return items
+}
+
+func (s *memoryStore) latestQuotation(_ context.Context, pair string) (*upstream.Quotation, error) {
+	items := s.matching(historyFilter{Pair: pair})
+	if len(items) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &items[len(items)-1].Quotation, nil
+}
+
+func (s *memoryStore) quotationByID(_ context.Context, id int64) (*StoredQuotation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if id < 1 || id > int64(len(s.quotations)) || s.quotations[id-1] == nil {
+		return nil, sql.ErrNoRows
+	}
+	stored := *s.quotations[id-1]
+	return &stored, nil
+}
+
+func (s *memoryStore) queryQuotations(ctx context.Context, f historyFilter, fn func(*upstream.Quotation) error) error {
+	for _, stored := range s.matching(f) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(&stored.Quotation); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) quotationPage(_ context.Context, f historyFilter, p historyPage) ([]StoredQuotation, int64, error) {
+	items := s.matching(f)
+	total := int64(len(items))
+	if p.Desc {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+	}
+	if p.Offset >= len(items) {
+		return []StoredQuotation{}, total, nil
+	}
+	items = items[p.Offset:]
+	if len(items) > p.Limit {
+		items = items[:p.Limit]
+	}
+	return items, total, nil
+}
+
+func (s *memoryStore) quotationStats(_ context.Context, f historyFilter) (*QuotationStats, error) {
+	return computeStats("", s.matching(f)), nil
+}
+
+func (s *memoryStore) dailyQuotationStats(_ context.Context, f historyFilter) ([]*QuotationStats, error) {
+	stats := []*QuotationStats{}
+	items := s.matching(f)
+	for start := 0; start < len(items); {
+		day := time.Unix(quotationUnix(&items[start].Quotation), 0).UTC().Format(dayLayout)
+		end := start + 1
+		for end < len(items) && time.Unix(quotationUnix(&items[end].Quotation), 0).UTC().Format(dayLayout) == day {
+			end++
+		}
+		stats = append(stats, computeStats(day, items[start:end]))
+		start = end
+	}
+	return stats, nil
+}
+
+// computeStats aggregates items, ordered by timestamp, like the stats
+// queries: averages of the units rounded back to a Decimal.
+func computeStats(day string, items []StoredQuotation) *QuotationStats {
+	stats := &QuotationStats{Day: day, Count: int64(len(items))}
+	if len(items) == 0 {
+		return stats
+	}
+	var sumBid, sumAsk float64
+	for i := range items {
+		q := &items[i].Quotation
+		if i == 0 || q.BidValue < stats.MinBid {
+			stats.MinBid = q.BidValue
+		}
+		if i == 0 || q.BidValue > stats.MaxBid {
+			stats.MaxBid = q.BidValue
+		}
+		if i == 0 || q.AskValue < stats.MinAsk {
+			stats.MinAsk = q.AskValue
+		}
+		if i == 0 || q.AskValue > stats.MaxAsk {
+			stats.MaxAsk = q.AskValue
+		}
+		sumBid += float64(q.BidValue)
+		sumAsk += float64(q.AskValue)
+	}
+	stats.AvgBid = upstream.Decimal(math.Round(sumBid / float64(len(items))))
+	stats.AvgAsk = upstream.Decimal(math.Round(sumAsk / float64(len(items))))
+	first := time.Unix(quotationUnix(&items[0].Quotation), 0).UTC()
+	last := time.Unix(quotationUnix(&items[len(items)-1].Quotation), 0).UTC()
+	stats.FirstTimestamp, stats.LastTimestamp = &first, &last
+	return stats
+}
+
+// rollupDays has nothing to consolidate: the stats are always computed from
+// the quotations themselves.
+func (s *memoryStore) rollupDays(context.Context, time.Time) (int, error) {
+	return 0, nil
+}
+
+func (s *memoryStore) purgeQuotationsBefore(_ context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	for i, stored := range s.quotations {
+		if stored != nil && quotationUnix(&stored.Quotation) < before.Unix() {
+			delete(s.stored, quotationKey(&stored.Quotation))
+			s.quotations[i] = nil
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (s *memoryStore) purgeRequestLogBefore(_ context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.requestLog[:0]
+	for _, e := range s.requestLog {
+		if !e.Timestamp.Before(before) {
+			kept = append(kept, e)
+		}
+	}
+	deleted := int64(len(s.requestLog) - len(kept))
+	s.requestLog = kept
+	return deleted, nil
+}
+
+func (s *memoryStore) insertRequestLog(batch []RequestLogEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range batch {
+		s.lastLogID++
+		e.ID = s.lastLogID
+		s.requestLog = append(s.requestLog, e)
+	}
+	return nil
+}
+
+func (s *memoryStore) recentRequestLog(_ context.Context, limit int) ([]RequestLogEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := []RequestLogEntry{}
+	for i := len(s.requestLog) - 1; i >= 0 && len(entries) < limit; i-- {
+		entries = append(entries, s.requestLog[i])
+	}
+	return entries, nil
+}
+
+func (s *memoryStore) insertConfigChanges(_ context.Context, changes []ConfigChange) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.changes = append(s.changes, changes...)
+	return nil
+}
+
+func (s *memoryStore) insertAPIKey(_ context.Context, k *APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k.ID = int64(len(s.apiKeys) + 1)
+	s.apiKeys = append(s.apiKeys, *k)
+	return nil
+}
+
+func (s *memoryStore) apiKeyByHash(_ context.Context, hash string) (*APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, k := range s.apiKeys {
+		if k.hash == hash {
+			return &k, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *memoryStore) listAPIKeys(context.Context) ([]APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]APIKey{}, s.apiKeys...), nil
+}
+
+func (s *memoryStore) revokeAPIKey(_ context.Context, id int64, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id < 1 || id > int64(len(s.apiKeys)) {
+		return sql.ErrNoRows
+	}
+	if k := &s.apiKeys[id-1]; k.RevokedAt == nil {
+		revoked := now.UTC().Truncate(time.Second)
+		k.RevokedAt = &revoked
+	}
+	return nil
+}
+
+func (s *memoryStore) insertAlertRule(_ context.Context, rule *AlertRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rule.ID = int64(len(s.alerts) + 1)
+	stored := *rule
+	s.alerts = append(s.alerts, &stored)
+	return nil
+}
+
+// alert returns the rule id, nil once deleted, which leaves a nil behind like
+// purged quotations. s.mu must be held.
+func (s *memoryStore) alert(id int64) *AlertRule {
+	if id < 1 || id > int64(len(s.alerts)) {
+		return nil
+	}
+	return s.alerts[id-1]
+}
+
+func (s *memoryStore) alertRule(_ context.Context, id int64) (*AlertRule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rule := s.alert(id)
+	if rule == nil {
+		return nil, sql.ErrNoRows
+	}
+	copied := *rule
+	return &copied, nil
+}
+
+func (s *memoryStore) alertRules(context.Context) ([]AlertRule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rules := []AlertRule{}
+	for _, rule := range s.alerts {
+		if rule != nil {
+			rules = append(rules, *rule)
+		}
+	}
+	return rules, nil
+}
+
+func (s *memoryStore) updateAlertRule(_ context.Context, rule *AlertRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := s.alert(rule.ID)
+	if stored == nil {
+		return sql.ErrNoRows
+	}
+	*stored = *rule
+	stored.TriggeredAt = nil
+	return nil
+}
+
+func (s *memoryStore) deleteAlertRule(_ context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.alert(id) == nil {
+		return sql.ErrNoRows
+	}
+	s.alerts[id-1] = nil
+	return nil
+}
+
+func (s *memoryStore) setAlertTriggered(_ context.Context, id int64, at *time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rule := s.alert(id)
+	if rule == nil {
+		return sql.ErrNoRows
+	}
+	rule.TriggeredAt = at
+	return nil
+}
+
+func (s *memoryStore) ping(context.Context) error { return nil }
+
+func (s *memoryStore) close() error { return nil }
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+var (
+	// httpBuckets are centered on the 200ms budget of /cotacao.
+	httpBuckets = []float64{.005, .01, .025, .05, .1, .2, .5, 1, 2.5, 5}
+	dbBuckets   = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1}
+)
+
+// The metrics served on /metrics. -no-metrics leaves out the endpoint and the
+// request instrumentation; the others are cheap enough to always record.
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cotacao_http_requests_total",
+		Help: "HTTP requests served, by route, method and status code.",
+	}, []string{"route", "method", "code"})
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "cotacao_http_request_duration_seconds",
+		Help:    "Time to serve an HTTP request, by route.",
+		Buckets: httpBuckets,
+	}, []string{"route"})
+	upstreamDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "cotacao_upstream_fetch_duration_seconds",
+		Help:    "Time of each provider attempt, retries included, by provider.",
+		Buckets: httpBuckets,
+	}, []string{"provider"})
+	upstreamErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cotacao_upstream_errors_total",
+		Help: "Failed provider attempts, by provider.",
+	}, []string{"provider"})
+	dbInsertDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "cotacao_db_insert_duration_seconds",
+		Help:    "Time to insert a quotation, or a batch of them with -async-save.",
+		Buckets: dbBuckets,
+	})
+	latestBid = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cotacao_bid",
+		Help: "Bid of the last quotation fetched, by pair.",
+	}, []string{"pair"})
+	httpPanics = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "cotacao_http_panics_total",
+		Help: "Panics recovered from HTTP handlers.",
+	})
+	alertDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cotacao_alert_deliveries_total",
+		Help: "Alert deliveries, after their retries, by notifier and result (delivered or failed).",
+	}, []string{"notifier", "result"})
+)
+
+var (
+	cacheLookupsDesc = prometheus.NewDesc("cotacao_cache_lookups_total",
+		"Quotation cache lookups, by result (hit or miss).", []string{"result"}, nil)
+	cacheEvictionsDesc = prometheus.NewDesc("cotacao_cache_evictions_total",
+		"Pairs evicted from the full quotation cache.", nil, nil)
+	cacheEntriesDesc = prometheus.NewDesc("cotacao_cache_entries",
+		"Pairs in the quotation cache.", nil, nil)
+	breakerStateDesc = prometheus.NewDesc("cotacao_upstream_breaker_state",
+		"State of the upstream circuit breaker, 1 for the current one (closed, open or half-open).", []string{"state"}, nil)
+)
+
+// metricsHandler serves every metric in the Prometheus text format. The
+// registry comes after the descriptors statsCollector describes: nothing
+// else orders their initialization before it.
+var (
+	metricsRegistry = newMetricsRegistry()
+	metricsHandler  = promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}).ServeHTTP
+)
+
+// newMetricsRegistry registers the metrics above, the runtime and process
+// ones, and the stats collector.
+func newMetricsRegistry() *prometheus.Registry {
+	r := prometheus.NewRegistry()
+	r.MustRegister(
+		httpRequests, httpDuration, upstreamDuration, upstreamErrors, dbInsertDuration,
+		latestBid, httpPanics, alertDeliveries,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		statsCollector{},
+	)
+	return r
+}
+
+// observeSince records the time elapsed since start in o.
+func observeSince(o prometheus.Observer, start time.Time) {
+	o.Observe(time.Since(start).Seconds())
+}
+
+// statsCollector reports at scrape time the counters kept elsewhere: the
+// cache ones, reported by /healthz too, and the breaker state. Without a
+// cache or a breaker their series are left out.
+type statsCollector struct{}
+
+func (statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cacheLookupsDesc
+	ch <- cacheEvictionsDesc
+	ch <- cacheEntriesDesc
+	ch <- breakerStateDesc
+}
+
+func (statsCollector) Collect(ch chan<- prometheus.Metric) {
+	if cache != nil {
+		stats := cache.stats()
+		ch <- prometheus.MustNewConstMetric(cacheLookupsDesc, prometheus.CounterValue, float64(stats.Hits), "hit")
+		ch <- prometheus.MustNewConstMetric(cacheLookupsDesc, prometheus.CounterValue, float64(stats.Misses), "miss")
+		ch <- prometheus.MustNewConstMetric(cacheEvictionsDesc, prometheus.CounterValue, float64(stats.Evictions))
+		ch <- prometheus.MustNewConstMetric(cacheEntriesDesc, prometheus.GaugeValue, float64(stats.Size))
+	}
+	if b := providers.Breaker(); b != nil {
+		current := b.State()
+		for _, state := range []upstream.BreakerState{upstream.BreakerClosed, upstream.BreakerOpen, upstream.BreakerHalfOpen} {
+			v := 0.0
+			if state == current {
+				v = 1
+			}
+			ch <- prometheus.MustNewConstMetric(breakerStateDesc, prometheus.GaugeValue, v, state.String())
+		}
+	}
+}
+
+// instrumentRequests counts and times the requests by the pattern of mux
+// that serves them, not by path, so ids and pairs in the path don't create
+// a series each.
+func instrumentRequests(mux *http.ServeMux) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			_, route := mux.Handler(r)
+			rec := &statusRecorder{ResponseWriter: w, info: responseInfo(w)}
+			next.ServeHTTP(rec, r)
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).Inc()
+			observeSince(httpDuration.WithLabelValues(route), start)
+		})
+	}
+}
+
+// observeUpstream records a provider attempt, passed to the provider chain.
+func observeUpstream(provider string, d time.Duration, err error) {
+	upstreamDuration.WithLabelValues(provider).Observe(d.Seconds())
+	if err != nil {
+		upstreamErrors.WithLabelValues(provider).Inc()
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+func scrapeMetrics(t *testing.T) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	metricsHandler(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /metrics status = %d", w.Code)
+	}
+	return w.Body.String()
+}
+
+func TestMetricsCacheAndBreaker(t *testing.T) {
+	useSettings(t, func(c *Config) { c.CacheTTL = Duration(time.Minute) })
+	defer func(orig *quotationCache) { cache = orig }(cache)
+	cache = newQuotationCache(1)
+	fake := newFakeUpstream(t, http.StatusInternalServerError)
+	chain := useChain(t, "awesomeapi", upstream.ChainConfig{BaseURL: fake.URL, BreakerThreshold: 1, BreakerCooldown: time.Minute})
+
+	now := time.Now()
+	cache.get("USD-BRL", now)
+	cache.put("USD-BRL", upstream.Quotation{Bid: "5.1"}, now)
+	cache.get("USD-BRL", now)
+	cache.get("USD-BRL", now)
+	cache.put("EUR-BRL", upstream.Quotation{Bid: "5.9"}, now)
+
+	body := scrapeMetrics(t)
+	for _, want := range []string{
+		`cotacao_cache_lookups_total{result="hit"} 2`,
+		`cotacao_cache_lookups_total{result="miss"} 1`,
+		`cotacao_cache_evictions_total 1`,
+		`cotacao_cache_entries 1`,
+		`cotacao_upstream_breaker_state{state="closed"} 1`,
+		`cotacao_upstream_breaker_state{state="open"} 0`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("/metrics is missing %q", want)
+		}
+	}
+
+	chain.Fetch(context.Background(), upstream.DefaultPair)
+	body = scrapeMetrics(t)
+	for _, want := range []string{
+		`cotacao_upstream_breaker_state{state="closed"} 0`,
+		`cotacao_upstream_breaker_state{state="open"} 1`,
+		`cotacao_upstream_breaker_state{state="half-open"} 0`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("/metrics is missing %q after the breaker opened", want)
+		}
+	}
+}
+
+func TestMetricsRequests(t *testing.T) {
+	useChain(t, "awesomeapi", upstream.ChainConfig{BaseURL: newFakeUpstream(t, http.StatusOK).URL})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics-test/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	h := instrumentRequests(mux)(mux)
+	for _, path := range []string{"/metrics-test/a", "/metrics-test/b"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	body := scrapeMetrics(t)
+	for _, want := range []string{
+		`cotacao_http_requests_total{code="418",method="GET",route="/metrics-test/"} 2`,
+		`cotacao_http_request_duration_seconds_count{route="/metrics-test/"} 2`,
+		`# TYPE go_goroutines gauge`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("/metrics is missing %q", want)
+		}
+	}
+}
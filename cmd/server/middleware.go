@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/twsm000/goxp-client-server-api/internal/tracing"
+)
+
+// middleware wraps a handler with cross-cutting behavior.
+type middleware func(http.Handler) http.Handler
+
+// chain wraps h with mws, the first one being the outermost.
+func chain(h http.Handler, mws ...middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// statusRecorder captures the status code and body size written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+	info   *requestInfo
+}
+
+func (r *statusRecorder) WriteHeader(statusCode int) {
+	if r.status == 0 {
+		r.status = statusCode
+	}
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.size += n
+	return n, err
+}
+
+// Flush keeps streaming handlers working behind the recorder.
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets the WebSocket stream take over the connection, which is logged
+// as switching protocols.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijack não suportado")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil && r.status == 0 {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// logRequests gives every request an id, sent back in X-Request-ID, writes
+// one access log line per request, after it completes, and hands the outcome
+// to the audit log when enabled. With -log-level warn
+// only failed requests are logged; debug adds what the handler reported.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &requestInfo{
+			RequestID: requestID(r),
+			Lang:      negotiateLang(r.Header.Get("Accept-Language"), defaultLang),
+		}
+		w.Header().Set(requestIDHeader, info.RequestID)
+		rec := &statusRecorder{ResponseWriter: w, info: info}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)))
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		switch level := currentSettings().logLevel; {
+		case level == logDebug:
+			logAccess(r, rec, time.Since(start), true)
+		case level == logInfo || rec.status >= http.StatusBadRequest:
+			logAccess(r, rec, time.Since(start), false)
+		}
+
+		if audit != nil {
+			audit.enqueue(RequestLogEntry{
+				Timestamp:          start,
+				Method:             r.Method,
+				Path:               r.URL.Path,
+				Pair:               info.Pair,
+				StatusCode:         rec.status,
+				ErrorCode:          info.ErrorCode,
+				UpstreamDurationMs: info.UpstreamDuration.Milliseconds(),
+				DBDurationMs:       info.DBDuration.Milliseconds(),
+				RemoteAddr:         r.RemoteAddr,
+			})
+		}
+	})
+}
+
+// traceRequests opens the server span of every request, continuing the trace
+// of the caller when it sends a traceparent header. Only installed when
+// tracing is enabled.
+func traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := tracing.Extract(r.Context(), r.Header)
+		ctx, span := tracer.Start(ctx, r.Method+" "+r.URL.Path, tracing.KindServer)
+		defer span.End()
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.target", r.URL.RequestURI())
+
+		rec := &statusRecorder{ResponseWriter: w, info: responseInfo(w)}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		span.SetAttribute("http.status_code", rec.status)
+		if rec.status >= http.StatusInternalServerError {
+			span.RecordError(errors.New(http.StatusText(rec.status)))
+		}
+	})
+}
+
+// recoverPanics turns a panicking handler into a 500 problem document instead
+// of leaving net/http to drop the connection, logging the panic and its stack
+// in a single entry with the request id. When the response had already
+// started, the status can't change anymore and the connection is aborted as
+// before.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, info: responseInfo(w)}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			httpPanics.Inc()
+			slog.Error("Panic ao atender a requisição", "request_id", rec.info.RequestID,
+				"method", r.Method, "path", r.URL.Path, "panic", fmt.Sprint(v), "stack", string(debug.Stack()))
+			if rec.status != 0 {
+				panic(http.ErrAbortHandler)
+			}
+			msg := fmt.Sprint(r.Method, " ", r.URL.Path, " - falha interna ao atender a requisição")
+			sendMsgError(rec, codeInternal, msg, http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+// migration is a schema change, applied once inside its own transaction.
+type migration struct {
+	version     int
+	description string
+	up          func(ctx context.Context, tx *sql.Tx) error
+}
+
+// migrations lists every schema change in order. Versions must only be
+// appended; a released migration is never edited.
+var migrations = []migration{
+	{1, "cria tabela cotacao", createQuotationTable},
+	{2, "cria índice único (code, code_in, timestamp)", createUniqueQuotationIndex},
+	{3, "cria índices de leitura por par e timestamp", createReadIndexes},
+	{4, "adiciona preços decimais (*_units)", addPriceUnits},
+	{5, "cria tabela request_log", createRequestLogTable},
+	{6, "cria tabela cotacao_daily", createDailyTable},
+	{7, "cria tabela config_change", createConfigChangeTable},
+	{8, "adiciona datas tipadas (*_unix)", addTypedTimes},
+	{9, "cria tabela api_key", createAPIKeyTable},
+	{10, "cria tabela alert_rule", createAlertRuleTable},
+	{11, "adiciona notifier e template a alert_rule", addAlertNotifier},
+}
+
+// runMigrations brings the database up to the latest known version. It
+// refuses to touch a database migrated by a newer binary.
+func runMigrations(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations(
+			version INTEGER PRIMARY KEY,
+			applied_at TEXT NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("falha ao criar tabela schema_migrations. %w", err)
+	}
+
+	var current int
+	err = db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		return fmt.Errorf("falha ao consultar versão do banco. %w", err)
+	}
+	latest := migrations[len(migrations)-1].version
+	if current > latest {
+		return fmt.Errorf("banco de dados na versão %d, mais nova que a suportada por este binário (%d)", current, latest)
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := applyMigration(ctx, db, m); err != nil {
+			return fmt.Errorf("migração %d (%s) falhou. %w", m.version, m.description, err)
+		}
+		slog.Info("Migração aplicada", "version", m.version, "description", m.description)
+	}
+	return nil
+}
+
+func applyMigration(ctx context.Context, db *sql.DB, m migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.up(ctx, tx); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO schema_migrations(version, applied_at) VALUES (?, ?)`,
+		m.version, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// createQuotationTable is the original schema. IF NOT EXISTS lets databases
+// created before the migrations existed go through it unchanged.
+func createQuotationTable(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS cotacao(
+		code TEXT, 
+		code_in TEXT, 
+		name TEXT, 
+		high TEXT, 
+		low TEXT,
+		var_bid TEXT,
+		pct_change TEXT,
+		bid TEXT,
+		ask TEXT,
+		timestamp TEXT,
+		create_date TEXT
+	)`)
+	return err
+}
+
+// createUniqueQuotationIndex makes (code, code_in, timestamp) unique. Databases
+// created before the index existed may hold duplicates, which are removed
+// first, keeping the oldest row of each group.
+func createUniqueQuotationIndex(ctx context.Context, tx *sql.Tx) error {
+	result, err := tx.ExecContext(ctx, `
+		DELETE FROM cotacao
+		WHERE rowid NOT IN (
+			SELECT MIN(rowid) FROM cotacao
+			GROUP BY code, code_in, timestamp
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("falha ao remover duplicadas. %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+		CREATE UNIQUE INDEX IF NOT EXISTS cotacao_pair_timestamp
+		ON cotacao(code, code_in, timestamp)
+	`)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		slog.Info("Cotações duplicadas removidas", "count", n)
+	}
+	return nil
+}
+
+// createReadIndexes indexes the numeric timestamp used by the read queries,
+// with and without the pair. The expression must match the queries exactly
+// (CAST(timestamp AS INTEGER)) for sqlite to use them.
+func createReadIndexes(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+		CREATE INDEX IF NOT EXISTS cotacao_pair_unix
+		ON cotacao(code, code_in, CAST(timestamp AS INTEGER))
+	`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		CREATE INDEX IF NOT EXISTS cotacao_unix
+		ON cotacao(CAST(timestamp AS INTEGER))
+	`)
+	return err
+}
+
+// addPriceUnits stores the prices as integers of 10^-8 units (see
+// upstream.Decimal) next to the original strings, filling the existing rows.
+// Rows whose prices don't parse keep NULL units.
+func addPriceUnits(ctx context.Context, tx *sql.Tx) error {
+	for _, column := range []string{"high_units", "low_units", "bid_units", "ask_units"} {
+		_, err := tx.ExecContext(ctx, "ALTER TABLE cotacao ADD COLUMN "+column+" INTEGER")
+		if err != nil {
+			return err
+		}
+	}
+
+	type legacyRow struct {
+		id                  int64
+		high, low, bid, ask string
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT rowid, COALESCE(high, ''), COALESCE(low, ''), COALESCE(bid, ''), COALESCE(ask, '') FROM cotacao`)
+	if err != nil {
+		return err
+	}
+	var legacy []legacyRow
+	for rows.Next() {
+		var r legacyRow
+		if err := rows.Scan(&r.id, &r.high, &r.low, &r.bid, &r.ask); err != nil {
+			rows.Close()
+			return err
+		}
+		legacy = append(legacy, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// The rows are read before updating because the transaction holds a
+	// single connection.
+	stmt, err := tx.PrepareContext(ctx, `
+		UPDATE cotacao SET high_units = ?, low_units = ?, bid_units = ?, ask_units = ?
+		WHERE rowid = ?
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	skipped := 0
+	for _, r := range legacy {
+		q := upstream.Quotation{High: r.high, Low: r.low, Bid: r.bid, Ask: r.ask}
+		if err := upstream.ParseValues(&q); err != nil {
+			skipped++
+			continue
+		}
+		_, err = stmt.ExecContext(ctx, int64(q.HighValue), int64(q.LowValue), int64(q.BidValue), int64(q.AskValue), r.id)
+		if err != nil {
+			return err
+		}
+	}
+	if skipped > 0 {
+		slog.Warn("Cotações com preços inválidos ficaram sem valores decimais", "count", skipped)
+	}
+	return nil
+}
+
+func createRequestLogTable(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+		CREATE TABLE request_log(
+			id INTEGER PRIMARY KEY,
+			timestamp INTEGER NOT NULL,
+			method TEXT NOT NULL,
+			path TEXT NOT NULL,
+			pair TEXT NOT NULL,
+			status_code INTEGER NOT NULL,
+			error_code TEXT NOT NULL,
+			upstream_duration_ms INTEGER NOT NULL,
+			db_duration_ms INTEGER NOT NULL,
+			remote_addr TEXT NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `CREATE INDEX request_log_timestamp ON request_log(timestamp)`)
+	return err
+}
+
+// createDailyTable holds one row per UTC day and pair, consolidated from
+// cotacao once the day is over. Prices are in units, like the *_units
+// columns.
+func createDailyTable(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+		CREATE TABLE cotacao_daily(
+			day TEXT NOT NULL,
+			code TEXT NOT NULL,
+			code_in TEXT NOT NULL,
+			open_bid INTEGER,
+			close_bid INTEGER,
+			min_bid INTEGER,
+			max_bid INTEGER,
+			avg_bid REAL,
+			min_ask INTEGER,
+			max_ask INTEGER,
+			avg_ask REAL,
+			samples INTEGER NOT NULL,
+			first_timestamp INTEGER NOT NULL,
+			last_timestamp INTEGER NOT NULL,
+			PRIMARY KEY (day, code, code_in)
+		)
+	`)
+	return err
+}
+
+// createConfigChangeTable holds the audit trail of PATCH /admin/config, one
+// row per changed field.
+func createConfigChangeTable(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+		CREATE TABLE config_change(
+			id INTEGER PRIMARY KEY,
+			timestamp INTEGER NOT NULL,
+			field TEXT NOT NULL,
+			old_value TEXT NOT NULL,
+			new_value TEXT NOT NULL,
+			remote_addr TEXT NOT NULL
+		)
+	`)
+	return err
+}
+
+// addTypedTimes stores timestamp and create_date as unix seconds next to the
+// original strings, filling the existing rows, and moves the read indexes
+// from CAST(timestamp AS INTEGER) to timestamp_unix. timestamp_unix falls
+// back to create_date like Quotation.Time; datetimes that don't parse stay
+// NULL.
+func addTypedTimes(ctx context.Context, tx *sql.Tx) error {
+	for _, column := range []string{"timestamp_unix", "create_date_unix"} {
+		_, err := tx.ExecContext(ctx, "ALTER TABLE cotacao ADD COLUMN "+column+" INTEGER")
+		if err != nil {
+			return err
+		}
+	}
+
+	type legacyRow struct {
+		id                    int64
+		timestamp, createDate string
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT rowid, COALESCE(timestamp, ''), COALESCE(create_date, '') FROM cotacao`)
+	if err != nil {
+		return err
+	}
+	var legacy []legacyRow
+	for rows.Next() {
+		var r legacyRow
+		if err := rows.Scan(&r.id, &r.timestamp, &r.createDate); err != nil {
+			rows.Close()
+			return err
+		}
+		legacy = append(legacy, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `
+		UPDATE cotacao SET timestamp_unix = ?, create_date_unix = ?
+		WHERE rowid = ?
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	skipped := 0
+	for _, r := range legacy {
+		q := upstream.Quotation{Timestamp: r.timestamp, CreateDate: r.createDate}
+		timestamp := nullUnix(q.Time())
+		if !timestamp.Valid {
+			skipped++
+		}
+		_, err = stmt.ExecContext(ctx, timestamp, nullUnix(q.CreatedAt()), r.id)
+		if err != nil {
+			return err
+		}
+	}
+	if skipped > 0 {
+		slog.Warn("Cotações com datas inválidas ficaram sem timestamp_unix", "count", skipped)
+	}
+
+	for _, stmt := range []string{
+		`DROP INDEX IF EXISTS cotacao_pair_unix`,
+		`DROP INDEX IF EXISTS cotacao_unix`,
+		`CREATE INDEX cotacao_pair_time ON cotacao(code, code_in, timestamp_unix)`,
+		`CREATE INDEX cotacao_time ON cotacao(timestamp_unix)`,
+	} {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createAPIKeyTable holds the keys created by POST /admin/keys. Only their
+// SHA-256 is stored; revoked keys keep their row with revoked_at set.
+func createAPIKeyTable(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+		CREATE TABLE api_key(
+			id INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			prefix TEXT NOT NULL,
+			key_hash TEXT NOT NULL UNIQUE,
+			created_at INTEGER NOT NULL,
+			revoked_at INTEGER
+		)
+	`)
+	return err
+}
+
+func createAlertRuleTable(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+		CREATE TABLE alert_rule(
+			id INTEGER PRIMARY KEY,
+			owner TEXT NOT NULL,
+			pair TEXT NOT NULL,
+			condition TEXT NOT NULL,
+			webhook_url TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			enabled INTEGER NOT NULL,
+			created_at INTEGER NOT NULL,
+			triggered_at INTEGER
+		)
+	`)
+	return err
+}
+
+// addAlertNotifier lets rules notify Slack or Discord; the existing ones keep
+// their signed webhook.
+func addAlertNotifier(ctx context.Context, tx *sql.Tx) error {
+	for _, stmt := range []string{
+		`ALTER TABLE alert_rule ADD COLUMN notifier TEXT NOT NULL DEFAULT 'webhook'`,
+		`ALTER TABLE alert_rule ADD COLUMN template TEXT NOT NULL DEFAULT ''`,
+	} {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/twsm000/goxp-client-server-api/internal/buildinfo"
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+const (
+	defaultAlertNotifier string        = "webhook"
+	maxAlertDelay        time.Duration = time.Minute
+	discordContentLimit  int           = 2000
+)
+
+// defaultAlertTemplate is the message of the rules without a Template.
+const defaultAlertTemplate string = `Alerta {{.RuleID}}: {{.Pair}} {{.Condition}} (bid {{.Quotation.Bid}}, ask {{.Quotation.Ask}} em {{.Quotation.CreateDate}})`
+
+// alertNotifier delivers the events of the rules naming it in
+// AlertRule.Notifier.
+type alertNotifier struct {
+	// body is the JSON POSTed for event, whose Message is already rendered.
+	body func(event AlertEvent) ([]byte, error)
+	// signed adds the X-Cotacao-Signature header, which only our own
+	// receivers check.
+	signed bool
+	// interval spaces the messages sent to a single URL, under the limit
+	// the service documents.
+	interval time.Duration
+}
+
+// alertNotifiers are the notifiers by name. Slack accepts one message per
+// second per incoming webhook; Discord allows 5 every 2 seconds and tells
+// the rest in its X-RateLimit-* headers.
+var alertNotifiers = map[string]alertNotifier{
+	"webhook": {body: webhookMessage, signed: true},
+	"slack":   {body: slackMessage, interval: time.Second},
+	"discord": {body: discordMessage, interval: 400 * time.Millisecond},
+}
+
+// alertNotifierNames lists alertNotifiers for error messages.
+func alertNotifierNames() string {
+	names := make([]string, 0, len(alertNotifiers))
+	for name := range alertNotifiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func webhookMessage(event AlertEvent) ([]byte, error) {
+	return json.Marshal(event)
+}
+
+func slackMessage(event AlertEvent) ([]byte, error) {
+	return json.Marshal(map[string]string{"text": event.Message})
+}
+
+// discordMessage cuts the message to the limit of a Discord message and
+// turns off mentions, so a template can't ping @everyone.
+func discordMessage(event AlertEvent) ([]byte, error) {
+	content := event.Message
+	if utf8.RuneCountInString(content) > discordContentLimit {
+		content = string([]rune(content)[:discordContentLimit-1]) + "…"
+	}
+	return json.Marshal(map[string]any{
+		"content":          content,
+		"allowed_mentions": map[string][]string{"parse": {}},
+	})
+}
+
+// parseAlertTemplate parses the Template of a rule, defaultAlertTemplate
+// when it is empty, and executes it once on a sample event, so a template
+// naming an unknown field fails when the rule is saved and not when it
+// fires.
+func parseAlertTemplate(raw string) (*template.Template, error) {
+	if raw == "" {
+		raw = defaultAlertTemplate
+	}
+	tmpl, err := template.New("alert").Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := tmpl.Execute(io.Discard, AlertEvent{}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// alertMessage renders the message of event for rule.
+func alertMessage(rule AlertRule, event AlertEvent) (string, error) {
+	tmpl, err := parseAlertTemplate(rule.Template)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, event); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// alertLimiter paces the deliveries to each URL. Every POST reserves a slot
+// interval after the previous one, and the answers asking to slow down, a
+// 429 or a Discord bucket running out, push the next slot back.
+type alertLimiter struct {
+	mu   sync.Mutex
+	next map[string]time.Time
+}
+
+var alertLimits = &alertLimiter{next: make(map[string]time.Time)}
+
+// wait blocks until url may get another message.
+func (l *alertLimiter) wait(ctx context.Context, url string, interval time.Duration) error {
+	l.mu.Lock()
+	now := time.Now()
+	for u, at := range l.next {
+		if at.Before(now) {
+			delete(l.next, u)
+		}
+	}
+	at := l.next[url]
+	if at.Before(now) {
+		at = now
+	}
+	if interval > 0 {
+		l.next[url] = at.Add(interval)
+	}
+	l.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Until(at)):
+		return nil
+	}
+}
+
+// delay keeps url from getting messages for d, at most maxAlertDelay.
+func (l *alertLimiter) delay(url string, d time.Duration) {
+	if d > maxAlertDelay {
+		d = maxAlertDelay
+	}
+	at := time.Now().Add(d)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if at.After(l.next[url]) {
+		l.next[url] = at
+	}
+}
+
+// postAlert POSTs body to the webhook of rule. A signed body carries
+// X-Cotacao-Signature: "t=<unix time>,sha256=<hex>", the HMAC-SHA256 of
+// "<unix time>.<body>" with the secret of the rule, so receivers can reject
+// forged and replayed deliveries.
+func postAlert(ctx context.Context, rule AlertRule, n alertNotifier, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rule.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "cotacao-server/"+buildinfo.Get().Version)
+	if n.signed {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(rule.secret))
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(body)
+		req.Header.Set(alertSignatureHdr, "t="+timestamp+",sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := alertClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if d, ok := rateLimitDelay(resp); ok {
+		alertLimits.delay(rule.WebhookURL, d)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &upstream.StatusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+	return nil
+}
+
+// rateLimitDelay reads how long resp asks to wait before the next message:
+// the Retry-After of a 429, in seconds, or the retry_after of a Discord 429
+// body, or the X-RateLimit-Reset-After of a Discord bucket with nothing
+// remaining.
+func rateLimitDelay(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode == http.StatusTooManyRequests {
+		if d, ok := parseSeconds(resp.Header.Get("Retry-After")); ok {
+			return d, true
+		}
+		var body struct {
+			RetryAfter float64 `json:"retry_after"`
+		}
+		if err := json.NewDecoder(io.LimitReader(resp.Body, maxAlertBodySize)).Decode(&body); err == nil && body.RetryAfter > 0 {
+			return time.Duration(body.RetryAfter * float64(time.Second)), true
+		}
+		return 0, false
+	}
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		return parseSeconds(resp.Header.Get("X-RateLimit-Reset-After"))
+	}
+	return 0, false
+}
+
+// parseSeconds reads a count of seconds, fractional for Discord.
+func parseSeconds(raw string) (time.Duration, bool) {
+	s, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+	if err != nil || s < 0 {
+		return 0, false
+	}
+	return time.Duration(s * float64(time.Second)), true
+}
+
+// checkAlertNotifier validates the notifier and the template of a rule,
+// returning the notifier name, defaultAlertNotifier when empty.
+func checkAlertNotifier(name, tmpl string) (string, error) {
+	if name == "" {
+		name = defaultAlertNotifier
+	}
+	if _, ok := alertNotifiers[name]; !ok {
+		return "", fmt.Errorf("notifier inválido, use %s: %q", alertNotifierNames(), name)
+	}
+	if _, err := parseAlertTemplate(tmpl); err != nil {
+		return "", fmt.Errorf("template inválido: %w", err)
+	}
+	return name, nil
+}
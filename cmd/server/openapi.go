@@ -0,0 +1,428 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/twsm000/goxp-client-server-api/internal/buildinfo"
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+// swaggerUIVersion pins the swagger-ui-dist release loaded by /docs.
+const swaggerUIVersion string = "5.17.14"
+
+// docsPage is /docs, Swagger UI over /openapi.json. The assets come from the
+// CDN, so the page needs a browser with internet access but the binary
+// carries nothing besides this page.
+const docsPage string = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Cotação API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@` + swaggerUIVersion + `/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@` + swaggerUIVersion + `/swagger-ui-bundle.js" crossorigin></script>
+<script>
+SwaggerUIBundle({url: "openapi.json", dom_id: "#swagger-ui"});
+</script>
+</body>
+</html>
+`
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	decimalType = reflect.TypeOf(upstream.Decimal(0))
+	xmlNameType = reflect.TypeOf(xml.Name{})
+)
+
+// openAPISpec builds the OpenAPI 3 document of the public endpoints of /v1
+// and of the unversioned health and version ones. The paths are listed here,
+// next to the routes of startHTTPServer; the schemas are reflected from the
+// response types, so they follow the handlers.
+func openAPISpec() map[string]any {
+	s := &specBuilder{schemas: make(map[string]any)}
+
+	pairParam := s.query("pair", "Par de moedas, como USD-BRL.", "string")
+	historyParams := []any{
+		pairParam,
+		s.query("from", "Início do intervalo, RFC 3339 ou YYYY-MM-DD, inclusivo.", "string"),
+		s.query("to", "Fim do intervalo, RFC 3339 ou YYYY-MM-DD, exclusivo.", "string"),
+	}
+	idParam := map[string]any{"name": "id", "in": "path", "required": true, "schema": map[string]any{"type": "integer", "format": "int64"}}
+	pageParams := []any{
+		s.query("order", "Ordem por timestamp: asc ou desc (padrão).", "string"),
+		s.query("limit", "Tamanho da página, até 1000 (padrão 100).", "integer"),
+		s.query("offset", "Linhas puladas antes da página.", "integer"),
+	}
+	formatParam := s.query("format", "json, xml ou csv; tem precedência sobre Accept.", "string")
+	quotation := s.ref(QuotationResponse{})
+	fullQuotation := s.ref(FullQuotationResponse{})
+
+	paths := map[string]any{
+		"/cotacao": map[string]any{
+			"get": s.operation("getQuotation", "Cotação atual de um ou mais pares",
+				append([]any{
+					pairParam,
+					s.query("pairs", "Vários pares separados por vírgula; a resposta é indexada por par.", "string"),
+					s.query("full", "Devolve a cotação completa em vez de só o bid.", "boolean"),
+					s.query("nocache", "Ignora o cache e consulta o provedor.", "boolean"),
+					s.query("timeout", "Tempo máximo da requisição, como 300ms; o mesmo que X-Request-Timeout.", "string"),
+				}, formatParam),
+				s.formats(map[string]any{"oneOf": []any{
+					quotation,
+					fullQuotation,
+					map[string]any{"type": "object", "additionalProperties": map[string]any{"oneOf": []any{quotation, fullQuotation}}},
+				}}),
+				http.StatusBadRequest, http.StatusNotAcceptable, http.StatusTooManyRequests,
+				http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout),
+		},
+		"/cotacao/{pair}": map[string]any{
+			"get": s.operation("getQuotationByPair", "Cotação atual do par do caminho",
+				[]any{
+					map[string]any{"name": "pair", "in": "path", "required": true, "schema": map[string]any{"type": "string"}},
+					s.query("full", "Devolve a cotação completa em vez de só o bid.", "boolean"),
+					formatParam,
+				},
+				s.formats(map[string]any{"oneOf": []any{quotation, fullQuotation}}),
+				http.StatusBadRequest, http.StatusNotAcceptable, http.StatusTooManyRequests,
+				http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout),
+		},
+		"/cotacao/latest": map[string]any{
+			"get": s.operation("getLatestQuotation", "Última cotação armazenada",
+				[]any{pairParam}, s.json(upstream.Quotation{}),
+				http.StatusBadRequest, http.StatusNotFound),
+		},
+		"/cotacao/history": map[string]any{
+			"get": s.operation("listHistory", "Cotações armazenadas, paginadas",
+				append(append(historyParams, pageParams...), formatParam), s.formats(s.ref(HistoryResponse{})),
+				http.StatusBadRequest, http.StatusNotAcceptable),
+		},
+		"/cotacao/history/{id}": map[string]any{
+			"get": s.operation("getHistoryItem", "Cotação armazenada pelo id",
+				[]any{idParam},
+				s.json(StoredQuotation{}), http.StatusBadRequest, http.StatusNotFound),
+		},
+		"/cotacao/history.csv": map[string]any{
+			"get": s.operation("exportHistory", "Cotações armazenadas em CSV",
+				historyParams, s.content("text/csv", map[string]any{"type": "string"}),
+				http.StatusBadRequest),
+		},
+		"/cotacao/stats": map[string]any{
+			"get": s.operation("getStats", "Estatísticas das cotações armazenadas",
+				append(historyParams,
+					s.query("group", "day agrupa por dia e devolve uma lista.", "string"),
+					s.query("windows", "Janelas das médias móveis, em dias com cotações (padrão 7,30; none desativa). Com pair.", "string")),
+				s.content("application/json", map[string]any{"oneOf": []any{
+					s.ref(QuotationStats{}),
+					map[string]any{"type": "array", "items": s.ref(QuotationStats{})},
+				}}),
+				http.StatusBadRequest),
+		},
+		"/cotacao/events": map[string]any{
+			"get": s.operation("streamEvents", "Cotações novas via Server-Sent Events",
+				[]any{pairParam}, s.content("text/event-stream", map[string]any{"type": "string"}),
+				http.StatusBadRequest),
+		},
+		"/cotacao/stream": map[string]any{
+			"get": s.operation("streamQuotations", "Cotações novas via WebSocket, ou Server-Sent Events sem Upgrade",
+				[]any{pairParam}, s.content("text/event-stream", map[string]any{"type": "string"}),
+				http.StatusBadRequest),
+		},
+		"/convert": map[string]any{
+			"get": s.operation("convert", "Converte um valor pela cotação atual ou de uma data",
+				[]any{
+					s.requiredQuery("from", "Moeda de origem, como USD.", "string"),
+					s.requiredQuery("to", "Moeda de destino, como BRL.", "string"),
+					s.requiredQuery("amount", "Valor a converter, como 123.45.", "string"),
+					s.query("side", "bid (padrão) ou ask.", "string"),
+					s.query("date", "Usa a última cotação armazenada no dia, YYYY-MM-DD (UTC).", "string"),
+					s.query("decimals", "Casas decimais do resultado, 0 a 8 (padrão 2).", "integer"),
+					s.query("rounding", "half-up (padrão), half-even, down ou up.", "string"),
+				},
+				s.json(ConversionResponse{}),
+				http.StatusBadRequest, http.StatusNotFound, http.StatusTooManyRequests,
+				http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout),
+		},
+		"/alerts": map[string]any{
+			"get": s.secured(s.operation("listAlerts", "Alertas do chamador",
+				nil, s.content("application/json", map[string]any{"type": "array", "items": s.ref(AlertRule{})}))),
+			"post": s.secured(s.status(s.body(s.operation("createAlert", "Cria um alerta; o segredo das assinaturas só aparece aqui",
+				nil, s.json(CreatedAlertRule{}), http.StatusBadRequest), AlertRuleRequest{}), http.StatusCreated)),
+		},
+		"/alerts/{id}": map[string]any{
+			"parameters": []any{idParam},
+			"get": s.secured(s.operation("getAlert", "Alerta pelo id",
+				nil, s.json(AlertRule{}), http.StatusBadRequest, http.StatusNotFound)),
+			"put": s.secured(s.body(s.operation("updateAlert", "Substitui um alerta e o rearma",
+				nil, s.json(AlertRule{}), http.StatusBadRequest, http.StatusNotFound), AlertRuleRequest{})),
+			"delete": s.secured(s.status(s.operation("deleteAlert", "Remove um alerta",
+				nil, nil, http.StatusBadRequest, http.StatusNotFound), http.StatusNoContent)),
+		},
+		"/healthz": map[string]any{
+			"get": s.public(s.operation("health", "Liveness",
+				[]any{s.query("ready", "Responde 503 até o fim do aquecimento.", "boolean")},
+				s.json(HealthResponse{}), http.StatusServiceUnavailable)),
+		},
+		"/readyz": map[string]any{
+			"get": s.public(s.operation("ready", "Readiness: banco e provedor",
+				nil, s.json(ReadyResponse{}), http.StatusServiceUnavailable)),
+		},
+		"/version": map[string]any{
+			"get": s.public(s.operation("version", "Versão do servidor",
+				nil, s.json(buildinfo.Info{}))),
+		},
+	}
+
+	s.ref(Problem{})
+	spec := map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "Cotação API",
+			"description": "Cotações de moedas consultadas na AwesomeAPI e demais provedores.",
+			"version":     buildinfo.Get().Version,
+		},
+		"servers": []any{map[string]any{"url": "/v1"}},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": s.schemas,
+			"securitySchemes": map[string]any{
+				"apiKey": map[string]any{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+				"bearer": map[string]any{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+	if authRequired() {
+		spec["security"] = []any{
+			map[string]any{"apiKey": []any{}},
+			map[string]any{"bearer": []any{}},
+		}
+	}
+	return spec
+}
+
+// specBuilder collects the component schemas referenced by the operations.
+type specBuilder struct {
+	schemas map[string]any
+}
+
+func (s *specBuilder) query(name, description, typ string) map[string]any {
+	return map[string]any{
+		"name":        name,
+		"in":          "query",
+		"description": description,
+		"schema":      map[string]any{"type": typ},
+	}
+}
+
+func (s *specBuilder) requiredQuery(name, description, typ string) map[string]any {
+	param := s.query(name, description, typ)
+	param["required"] = true
+	return param
+}
+
+// operation describes a GET answering ok with 200 and the given error
+// statuses with a Problem.
+func (s *specBuilder) operation(id, summary string, params []any, ok map[string]any, errorStatuses ...int) map[string]any {
+	responses := map[string]any{"200": ok}
+	problem := map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/Problem"}}
+	if authRequired() {
+		errorStatuses = append(errorStatuses, http.StatusUnauthorized, http.StatusForbidden)
+	}
+	for _, status := range errorStatuses {
+		responses[strconv.Itoa(status)] = map[string]any{
+			"description": http.StatusText(status),
+			"content":     map[string]any{"application/problem+json": problem},
+		}
+	}
+	op := map[string]any{
+		"operationId": id,
+		"summary":     summary,
+		"responses":   responses,
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+	return op
+}
+
+// public marks op as served at the root, outside /v1, and open to every
+// caller, even when authentication is required.
+func (s *specBuilder) public(op map[string]any) map[string]any {
+	op["servers"] = []any{map[string]any{"url": "/"}}
+	op["security"] = []any{}
+	responses := op["responses"].(map[string]any)
+	delete(responses, strconv.Itoa(http.StatusUnauthorized))
+	delete(responses, strconv.Itoa(http.StatusForbidden))
+	return op
+}
+
+// secured marks op as needing credentials even when authentication isn't
+// required elsewhere.
+func (s *specBuilder) secured(op map[string]any) map[string]any {
+	op["security"] = []any{
+		map[string]any{"apiKey": []any{}},
+		map[string]any{"bearer": []any{}},
+	}
+	responses := op["responses"].(map[string]any)
+	problem := map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/Problem"}}
+	for _, status := range []int{http.StatusUnauthorized, http.StatusForbidden} {
+		responses[strconv.Itoa(status)] = map[string]any{
+			"description": http.StatusText(status),
+			"content":     map[string]any{"application/problem+json": problem},
+		}
+	}
+	return op
+}
+
+// body adds the JSON encoding of v as the request body of op.
+func (s *specBuilder) body(op map[string]any, v any) map[string]any {
+	op["requestBody"] = map[string]any{
+		"required": true,
+		"content":  map[string]any{"application/json": map[string]any{"schema": s.ref(v)}},
+	}
+	return op
+}
+
+// status makes op answer statusCode instead of 200, without a body for 204.
+func (s *specBuilder) status(op map[string]any, statusCode int) map[string]any {
+	responses := op["responses"].(map[string]any)
+	ok, _ := responses["200"].(map[string]any)
+	delete(responses, "200")
+	if statusCode == http.StatusNoContent || ok == nil {
+		ok = map[string]any{}
+	}
+	ok["description"] = http.StatusText(statusCode)
+	responses[strconv.Itoa(statusCode)] = ok
+	return op
+}
+
+// content is a 200 response of the given media type.
+func (s *specBuilder) content(mediaType string, schema map[string]any) map[string]any {
+	return map[string]any{
+		"description": http.StatusText(http.StatusOK),
+		"content":     map[string]any{mediaType: map[string]any{"schema": schema}},
+	}
+}
+
+// json is a 200 response with the JSON encoding of v.
+func (s *specBuilder) json(v any) map[string]any {
+	return s.content("application/json", s.ref(v))
+}
+
+// formats is a 200 response negotiated by negotiateFormat.
+func (s *specBuilder) formats(schema map[string]any) map[string]any {
+	return map[string]any{
+		"description": http.StatusText(http.StatusOK),
+		"content": map[string]any{
+			"application/json": map[string]any{"schema": schema},
+			"application/xml":  map[string]any{"schema": schema},
+			"text/csv":         map[string]any{"schema": map[string]any{"type": "string"}},
+		},
+	}
+}
+
+// ref registers the schema of v and returns a reference to it.
+func (s *specBuilder) ref(v any) map[string]any {
+	return s.schemaOf(reflect.TypeOf(v))
+}
+
+// schemaOf describes t as encoding/json writes it. Named structs become
+// components, referenced by name.
+func (s *specBuilder) schemaOf(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t {
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case decimalType:
+		return map[string]any{"type": "number"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer"}
+	case reflect.Int64, reflect.Uint, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": s.schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": s.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.structSchema(t)
+		}
+		if _, ok := s.schemas[t.Name()]; !ok {
+			s.schemas[t.Name()] = nil // breaks recursion
+			s.schemas[t.Name()] = s.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]any{}
+}
+
+// structSchema lists the fields of t under their JSON names, embedded
+// structs inlined as encoding/json does. Fields without omitempty are
+// required.
+func (s *specBuilder) structSchema(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	var required []string
+	var collect func(t reflect.Type)
+	collect = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" || f.Type == xmlNameType || (!f.IsExported() && !f.Anonymous) {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+				collect(f.Type)
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			properties[name] = s.schemaOf(f.Type)
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+	}
+	collect(t)
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// openAPIHandler serves the document built by openAPISpec.
+func openAPIHandler(spec map[string]any) http.HandlerFunc {
+	body, err := json.Marshal(spec)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err != nil {
+			sendMsgError(w, codeInternal, "GET /openapi.json - falha ao codificar especificação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", jsonContentType)
+		w.Write(append(body, '\n'))
+	}
+}
+
+func docsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(docsPage))
+}
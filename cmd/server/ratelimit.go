@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const rateLimiterEvictInterval time.Duration = time.Minute
+
+// rateLimiter is a token bucket limiter keyed by client IP, or by API key or
+// JWT subject for the requests requireAuth authorized. The rate and burst
+// come from the current settings; a zero rate lets every request through.
+type rateLimiter struct {
+	mu         sync.Mutex
+	trustProxy bool
+	visitors   map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// newRateLimiter creates a limiter. Idle clients are only evicted once
+// startEviction runs.
+func newRateLimiter(trustProxy bool) *rateLimiter {
+	return &rateLimiter{
+		trustProxy: trustProxy,
+		visitors:   make(map[string]*tokenBucket),
+	}
+}
+
+// startEviction evicts idle clients in the background until ctx is done.
+func (l *rateLimiter) startEviction(ctx context.Context) {
+	backgroundJobs.Add(1)
+	go func() {
+		defer backgroundJobs.Done()
+		l.evictLoop(ctx, rateLimiterEvictInterval)
+	}()
+}
+
+// allow consumes a token for key. When no token is available it returns how
+// long the client should wait for the next one.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	s := currentSettings()
+	if s.rate <= 0 {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.visitors[key]
+	if !ok {
+		b = &tokenBucket{tokens: s.burst, lastSeen: now}
+		l.visitors[key] = b
+	}
+	elapsed := now.Sub(b.lastSeen).Seconds()
+	b.tokens = math.Min(s.burst, b.tokens+elapsed*s.rate)
+	b.lastSeen = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / s.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// evictLoop calls evictIdle every interval until ctx is done.
+func (l *rateLimiter) evictLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			l.evictIdle(now, interval)
+		}
+	}
+}
+
+// evictIdle drops clients idle for longer than interval whose bucket would
+// already be full again, since forgetting them is indistinguishable from
+// keeping them.
+func (l *rateLimiter) evictIdle(now time.Time, interval time.Duration) {
+	idle := interval
+	if s := currentSettings(); s.rate > 0 {
+		if full := time.Duration(s.burst / s.rate * float64(time.Second)); full > idle {
+			idle = full
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, b := range l.visitors {
+		if now.Sub(b.lastSeen) > idle {
+			delete(l.visitors, key)
+		}
+	}
+}
+
+func (l *rateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := clientIP(r, l.trustProxy)
+		client := key
+		if info := requestInfoFrom(r.Context()); info.APIKey != nil {
+			key, client = "key "+info.APIKey.hash, "a chave "+info.APIKey.Prefix
+		} else if info.Subject != "" {
+			key, client = "jwt "+info.Subject, "o token de "+info.Subject
+		}
+		ok, wait := l.allow(key, time.Now())
+		if !ok {
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			msg := fmt.Sprint("limite de requisições excedido para ", client)
+			sendMsgError(w, codeRateLimited, msg, http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP returns the caller address. X-Forwarded-For is only honored when
+// the server runs behind a trusted proxy, otherwise clients could spoof it.
+// Even then only its rightmost entry is used: that is the one the proxy
+// appended, while everything to its left came from the client.
+func clientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		fwd := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+		for i := len(fwd) - 1; i >= 0; i-- {
+			if ip := strings.TrimSpace(fwd[i]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// parseRate parses values like "10/s", "100/m" or "1000/h" into requests per
+// second.
+func parseRate(s string) (float64, error) {
+	n, unit, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, errors.New("formato esperado: N/s, N/m ou N/h")
+	}
+	count, err := strconv.ParseFloat(n, 64)
+	if err != nil || count <= 0 {
+		return 0, fmt.Errorf("quantidade inválida: %q", n)
+	}
+	switch unit {
+	case "s":
+		return count, nil
+	case "m":
+		return count / 60, nil
+	case "h":
+		return count / 3600, nil
+	default:
+		return 0, fmt.Errorf("unidade inválida: %q", unit)
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    float64
+		wantErr bool
+	}{
+		{"10/s", 10, false},
+		{"120/m", 2, false},
+		{"3600/h", 1, false},
+		{"0.5/s", 0.5, false},
+		{"10", 0, true},
+		{"0/s", 0, true},
+		{"-1/s", 0, true},
+		{"x/s", 0, true},
+		{"10/d", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseRate(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRate(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseRate(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		trustProxy bool
+		want       string
+	}{
+		{"remote addr", "203.0.113.7:51234", nil, false, "203.0.113.7"},
+		{"ipv6 remote addr", "[2001:db8::1]:443", nil, false, "2001:db8::1"},
+		{"remote addr without port", "203.0.113.7", nil, false, "203.0.113.7"},
+		{"forwarded ignored without trust", "10.0.0.1:80", []string{"198.51.100.1"}, false, "10.0.0.1"},
+		{"forwarded single", "10.0.0.1:80", []string{"198.51.100.1"}, true, "198.51.100.1"},
+		{"spoofed entries on the left", "10.0.0.1:80", []string{"1.2.3.4, 5.6.7.8, 198.51.100.1"}, true, "198.51.100.1"},
+		{"several headers", "10.0.0.1:80", []string{"1.2.3.4", "198.51.100.1"}, true, "198.51.100.1"},
+		{"trailing empty entry", "10.0.0.1:80", []string{"198.51.100.1, "}, true, "198.51.100.1"},
+		{"empty header", "10.0.0.1:80", []string{""}, true, "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/cotacao", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := clientIP(r, tt.trustProxy); got != tt.want {
+				t.Errorf("clientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimiterAllow(t *testing.T) {
+	useSettings(t, func(c *Config) {
+		c.Rate = "1/s"
+		c.Burst = 2
+	})
+	l := &rateLimiter{visitors: make(map[string]*tokenBucket)}
+	now := time.Unix(1700000000, 0)
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow("a", now); !ok {
+			t.Fatalf("request %d within the burst was limited", i+1)
+		}
+	}
+	ok, wait := l.allow("a", now)
+	if ok {
+		t.Fatal("request past the burst was allowed")
+	}
+	if wait != time.Second {
+		t.Errorf("wait = %v, want 1s", wait)
+	}
+	if ok, _ := l.allow("b", now); !ok {
+		t.Error("another key shares the bucket of a")
+	}
+	if ok, _ := l.allow("a", now.Add(time.Second)); !ok {
+		t.Error("a token wasn't refilled after 1s")
+	}
+}
+
+func TestRateLimiterEvictIdle(t *testing.T) {
+	useSettings(t, func(c *Config) {
+		c.Rate = "1/s"
+		c.Burst = 2
+	})
+	l := newRateLimiter(false)
+	now := time.Unix(1700000000, 0)
+	l.allow("idle", now.Add(-2*time.Minute))
+	l.allow("recent", now.Add(-30*time.Second))
+
+	l.evictIdle(now, time.Minute)
+	if _, ok := l.visitors["idle"]; ok {
+		t.Error("idle client wasn't evicted")
+	}
+	if _, ok := l.visitors["recent"]; !ok {
+		t.Error("recent client was evicted")
+	}
+}
+
+func TestRateLimiterEvictLoopStops(t *testing.T) {
+	l := newRateLimiter(false)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.evictLoop(ctx, time.Millisecond)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("evictLoop didn't return after ctx was done")
+	}
+}
+
+func TestRateLimiterMiddleware(t *testing.T) {
+	useSettings(t, func(c *Config) {
+		c.Rate = "1/m"
+		c.Burst = 1
+	})
+	l := &rateLimiter{trustProxy: true, visitors: make(map[string]*tokenBucket)}
+	h := l.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	send := func(forwarded string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/cotacao", nil)
+		r.Header.Set("X-Forwarded-For", forwarded)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	if w := send("198.51.100.1"); w.Code != http.StatusOK {
+		t.Fatalf("first request status = %d, want 200", w.Code)
+	}
+	// Rotating the client-written entries must not buy a new bucket.
+	w := send("1.2.3.4, 198.51.100.1")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request status = %d, want 429", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After = %q, want 60", got)
+	}
+}
+
+// TestRateLimiterPerCaller puts the limiter behind requireAuth, as on
+// /cotacao: with an API key the bucket follows the key, whatever the IP.
+func TestRateLimiterPerCaller(t *testing.T) {
+	useSettings(t, func(c *Config) {
+		c.Rate = "1/m"
+		c.Burst = 2
+	})
+	mem := newMemoryStore()
+	useStore(t, mem)
+	prevRequired := apiKeyRequired
+	t.Cleanup(func() { apiKeyRequired = prevRequired })
+	apiKeyRequired = true
+
+	keys := make([]string, 2)
+	records := make([]*APIKey, 2)
+	for i := range keys {
+		k, key, err := newAPIKey("client "+strconv.Itoa(i), time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := mem.insertAPIKey(context.Background(), k); err != nil {
+			t.Fatal(err)
+		}
+		keys[i], records[i] = key, k
+	}
+
+	l := &rateLimiter{visitors: make(map[string]*tokenBucket)}
+	h := chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), logRequests, requireAuth, l.middleware)
+	send := func(key, remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/cotacao", nil)
+		r.RemoteAddr = remoteAddr
+		if key != "" {
+			r.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	// Two addresses share the burst of the first key.
+	for i, addr := range []string{"198.51.100.1:1000", "198.51.100.2:1000"} {
+		if w := send(keys[0], addr); w.Code != http.StatusOK {
+			t.Fatalf("request %d with the first key: status %d, body %s", i+1, w.Code, w.Body)
+		}
+	}
+	w := send(keys[0], "198.51.100.3:1000")
+	if w.Code != http.StatusTooManyRequests || problemCode(t, w) != codeRateLimited {
+		t.Fatalf("past the burst of the key: status %d, body %s; want 429 %s", w.Code, w.Body, codeRateLimited)
+	}
+	if got := w.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After = %q, want 60", got)
+	}
+	if !strings.Contains(w.Body.String(), records[0].Prefix) {
+		t.Errorf("body %s doesn't name the key %s", w.Body, records[0].Prefix)
+	}
+
+	// The second key, from an address the first one used, has its own bucket.
+	for i := 0; i < 2; i++ {
+		if w := send(keys[1], "198.51.100.1:1000"); w.Code != http.StatusOK {
+			t.Errorf("request %d with the second key: status %d, body %s", i+1, w.Code, w.Body)
+		}
+	}
+	// Unauthenticated requests are refused before taking a token.
+	if w := send("", "198.51.100.9:1000"); w.Code != http.StatusUnauthorized {
+		t.Errorf("without a key: status %d, want 401", w.Code)
+	}
+	if _, ok := l.visitors["198.51.100.9"]; ok {
+		t.Error("a refused request took a token of its IP")
+	}
+}
+
+func TestRateLimiterPerIP(t *testing.T) {
+	useSettings(t, func(c *Config) {
+		c.Rate = "1/s"
+		c.Burst = 1
+	})
+	l := &rateLimiter{visitors: make(map[string]*tokenBucket)}
+	h := chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), logRequests, l.middleware)
+	send := func(remoteAddr string) int {
+		r := httptest.NewRequest(http.MethodGet, "/cotacao", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+	tests := []struct {
+		remoteAddr string
+		want       int
+	}{
+		{"203.0.113.1:1000", http.StatusOK},
+		// Another port of the same host is the same client.
+		{"203.0.113.1:2000", http.StatusTooManyRequests},
+		{"203.0.113.2:1000", http.StatusOK},
+		{"[2001:db8::1]:1000", http.StatusOK},
+		{"[2001:db8::1]:1001", http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		if got := send(tt.remoteAddr); got != tt.want {
+			t.Errorf("request from %s: status %d, want %d", tt.remoteAddr, got, tt.want)
+		}
+	}
+}
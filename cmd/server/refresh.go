@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// refreshes coalesces the concurrent POST /cotacao/refresh calls for the same
+// pairs into a single upstream request.
+var refreshes = newFlightGroup()
+
+// saveError marks a refresh that fetched the quotations but couldn't store
+// them.
+type saveError struct {
+	err error
+}
+
+func (e saveError) Error() string {
+	return "falha ao salvar dados no banco: " + e.err.Error()
+}
+
+func (e saveError) Unwrap() error {
+	return e.err
+}
+
+// refreshQuotations fetches pairs from the upstream, skipping the cache, and
+// stores, caches and publishes them like a /cotacao miss would. The startup
+// warm-up goes through it too.
+func refreshQuotations(parent context.Context, timeout time.Duration, pairs []string) flightResult {
+	result := fetchAndCache(parent, timeout, pairs)
+	if result.err != nil {
+		return result
+	}
+	if err := storeQuotations(parent, pairs, result.quotations); err != nil {
+		return flightResult{err: err}
+	}
+	return result
+}
+
+// refreshHandler serves POST /cotacao/refresh, forcing an upstream fetch of
+// the pairs given like in GET /cotacao and answering with the same body.
+// X-Refresh-Shared tells the caller it got the result of a refresh already in
+// flight.
+func refreshHandler(w http.ResponseWriter, r *http.Request) {
+	timeout, clamped, err := requestedTimeout(r)
+	if err != nil {
+		msg := fmt.Sprint("POST /cotacao/refresh - ", err)
+		sendMsgError(w, codeBadRequest, msg, http.StatusBadRequest)
+		return
+	}
+	if clamped {
+		w.Header().Set("X-Effective-Timeout", timeout.String())
+	}
+	pairs, multiple, full, err := quotationQuery(r, "")
+	if err != nil {
+		msg := fmt.Sprint("POST /cotacao/refresh - ", err)
+		sendMsgError(w, codeBadRequest, msg, http.StatusBadRequest)
+		return
+	}
+
+	key := strings.Join(pairs, ",")
+	requestInfoFrom(r.Context()).Pair = key
+	result, shared, err := refreshes.do(r.Context(), key, func() flightResult {
+		return fetchAndCache(r.Context(), timeout, pairs)
+	}, func(result flightResult) error {
+		return storeQuotations(r.Context(), pairs, result.quotations)
+	})
+	if err != nil {
+		slog.WarnContext(r.Context(), "POST /cotacao/refresh - cliente desistiu aguardando atualização", "err", err)
+		return
+	}
+	if shared {
+		w.Header().Set("X-Refresh-Shared", "true")
+	}
+	if result.err != nil {
+		setUpstreamRetryAfter(w, result.err)
+		statusCode, code := fetchErrorStatus(result.err)
+		var saveErr saveError
+		if errors.As(result.err, &saveErr) {
+			statusCode, code = dbErrorStatus(saveErr.err, codeDBWriteFailed)
+		}
+		msg := fmt.Sprint("POST /cotacao/refresh - ", result.err)
+		if code == codeUpstreamTimeout {
+			msg = fmt.Sprint("POST /cotacao/refresh - requisição ultrapassou o tempo máximo de ", timeout)
+		}
+		sendMsgError(w, code, msg, statusCode)
+		return
+	}
+
+	if !shared {
+		slog.InfoContext(r.Context(), "POST /cotacao/refresh - cotações atualizadas", "pairs", key)
+	}
+	w.Header().Set("X-Quotation-Provider", result.provider)
+	w.Header().Set("X-Quotation-Source", quotationSource(result.provider))
+	err = sendJSON(w, http.StatusOK, quotationBody(pairs, result.quotations, multiple, full, result.provider))
+	if err != nil {
+		slog.WarnContext(r.Context(), "POST /cotacao/refresh - falha ao enviar resposta", "err", err)
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/twsm000/goxp-client-server-api/internal/buildinfo"
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+const (
+	reportDaily       string        = "daily"
+	reportWeekly      string        = "weekly"
+	reportTimeout     time.Duration = 30 * time.Second
+	reportSparkPoints int           = 24
+)
+
+// sparkLevels are the bars of a sparkline, lowest first.
+var sparkLevels = []rune("▁▂▃▄▅▆▇█")
+
+// reportJob is the summary mailed on the -report-cron schedule.
+type reportJob struct {
+	cron     cronSchedule
+	period   string
+	pairs    []string
+	from     *mail.Address
+	to       []*mail.Address
+	smtpAddr string
+	auth     smtp.Auth
+}
+
+// newReportJob builds the report of cfg, nil without -report-cron. cfg is
+// already validated.
+func newReportJob(cfg *Config) *reportJob {
+	if cfg.ReportCron == "" {
+		return nil
+	}
+	r := &reportJob{period: cfg.ReportPeriod, smtpAddr: cfg.SMTPAddr}
+	r.cron, _ = parseCron(cfg.ReportCron)
+	r.pairs, _ = upstream.ParsePairs(cfg.ReportPairs)
+	r.from, _ = mail.ParseAddress(cfg.ReportFrom)
+	r.to, _ = mail.ParseAddressList(cfg.ReportTo)
+	if cfg.SMTPUser != "" {
+		host, _, _ := net.SplitHostPort(cfg.SMTPAddr)
+		r.auth = smtp.PlainAuth("", cfg.SMTPUser, cfg.SMTPPassword, host)
+	}
+	return r
+}
+
+// window is the period summarized by the run at t: the 24 hours or the 7
+// days before it.
+func (r *reportJob) window(t time.Time) (from, to time.Time) {
+	if r.period == reportWeekly {
+		return t.AddDate(0, 0, -7), t
+	}
+	return t.AddDate(0, 0, -1), t
+}
+
+// startReportJob mails the report at every time of its cron schedule until
+// ctx is done. A failed run is only logged; the next one covers its own
+// period.
+func startReportJob(ctx context.Context) {
+	if emailReport == nil {
+		return
+	}
+	backgroundJobs.Add(1)
+	go func() {
+		defer backgroundJobs.Done()
+		for {
+			at := emailReport.cron.next(time.Now())
+			slog.Info("Relatório - próximo envio agendado", "at", at.Format(time.RFC3339))
+			timer := time.NewTimer(time.Until(at))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			if err := emailReport.send(ctx, at); err != nil {
+				slog.Warn("Relatório - falha ao enviar", "err", err)
+				continue
+			}
+			slog.Info("Relatório - enviado", "recipients", len(emailReport.to))
+		}
+	}()
+}
+
+// pairSummary is the line of a pair in the report, from the bids stored in
+// the period. Count is zero when there are none.
+type pairSummary struct {
+	Pair      string
+	Name      string
+	Count     int
+	Open      upstream.Decimal
+	Close     upstream.Decimal
+	High      upstream.Decimal
+	Low       upstream.Decimal
+	ChangePct *upstream.Decimal
+	Sparkline string
+}
+
+// summarizePair reads the quotations of pair stored between from and to.
+// The sparkline has the last bid of each of reportSparkPoints equal slices
+// of the period, skipping the empty ones.
+func summarizePair(ctx context.Context, pair string, from, to time.Time) (pairSummary, error) {
+	s := pairSummary{Pair: pair}
+	closes := make([]*upstream.Decimal, reportSparkPoints)
+	slice := to.Sub(from) / time.Duration(reportSparkPoints)
+	err := store.queryQuotations(ctx, historyFilter{Pair: pair, From: from, To: to}, func(q *upstream.Quotation) error {
+		bid := q.BidValue
+		if s.Count == 0 {
+			s.Open, s.High, s.Low, s.Name = bid, bid, bid, q.Name
+		}
+		s.Count++
+		s.Close = bid
+		if bid > s.High {
+			s.High = bid
+		}
+		if bid < s.Low {
+			s.Low = bid
+		}
+		if t, err := q.Time(); err == nil && slice > 0 {
+			i := int(t.Sub(from) / slice)
+			if i >= 0 && i < reportSparkPoints {
+				closes[i] = &bid
+			}
+		}
+		return nil
+	})
+	if err != nil || s.Count == 0 {
+		return s, err
+	}
+	s.ChangePct = percentChange(s.Open, s.Close)
+	s.Sparkline = sparkline(closes, s.Low, s.High)
+	return s, nil
+}
+
+// sparkline draws values between low and high as sparkLevels, a flat line
+// when they are all the same.
+func sparkline(values []*upstream.Decimal, low, high upstream.Decimal) string {
+	var b strings.Builder
+	top := len(sparkLevels) - 1
+	for _, v := range values {
+		if v == nil {
+			continue
+		}
+		level := top / 2
+		if high > low {
+			level = int(int64(top) * int64(*v-low) / int64(high-low))
+		}
+		b.WriteRune(sparkLevels[level])
+	}
+	return b.String()
+}
+
+// send builds the report of the period ending at and mails it.
+func (r *reportJob) send(ctx context.Context, at time.Time) error {
+	from, to := r.window(at)
+	summaries := make([]pairSummary, 0, len(r.pairs))
+	for _, pair := range r.pairs {
+		s, err := summarizePair(ctx, pair, from, to)
+		if err != nil {
+			return fmt.Errorf("falha ao consultar cotações de %s: %w", pair, err)
+		}
+		summaries = append(summaries, s)
+	}
+	subject, body := r.render(from, to, summaries)
+	return r.mail(ctx, subject, body)
+}
+
+// render writes the subject and the plain text body of the report.
+func (r *reportJob) render(from, to time.Time, summaries []pairSummary) (string, string) {
+	const layout = "2006-01-02 15:04"
+	kind := "diário"
+	if r.period == reportWeekly {
+		kind = "semanal"
+	}
+	subject := fmt.Sprintf("Relatório %s de cotações - %s", kind, to.UTC().Format("2006-01-02"))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Relatório %s de cotações, de %s a %s UTC.\n", kind, from.UTC().Format(layout), to.UTC().Format(layout))
+	fmt.Fprintf(&b, "Valores do bid armazenado no período.\n")
+	for _, s := range summaries {
+		b.WriteString("\n")
+		if s.Count == 0 {
+			fmt.Fprintf(&b, "%s: nenhuma cotação no período\n", s.Pair)
+			continue
+		}
+		fmt.Fprintf(&b, "%s (%s)\n", s.Pair, s.Name)
+		fmt.Fprintf(&b, "  abertura    %s\n", s.Open)
+		fmt.Fprintf(&b, "  fechamento  %s\n", s.Close)
+		fmt.Fprintf(&b, "  máxima      %s\n", s.High)
+		fmt.Fprintf(&b, "  mínima      %s\n", s.Low)
+		if s.ChangePct != nil {
+			fmt.Fprintf(&b, "  variação    %+.2f%%\n", s.ChangePct.Float64())
+		}
+		fmt.Fprintf(&b, "  %s  (%d cotações)\n", s.Sparkline, s.Count)
+	}
+	fmt.Fprintf(&b, "\n-- \ncotacao-server %s\n", buildinfo.Get().Version)
+	return subject, b.String()
+}
+
+// mail sends the message through -smtp-addr, upgrading to TLS with STARTTLS
+// when the server offers it. The credentials are only sent over TLS, or to
+// localhost, as smtp.PlainAuth requires.
+func (r *reportJob) mail(ctx context.Context, subject, body string) error {
+	msg, err := r.message(subject, body)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, reportTimeout)
+	defer cancel()
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", r.smtpAddr)
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	host, _, _ := net.SplitHostPort(r.smtpAddr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if r.auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return fmt.Errorf("servidor SMTP %s não oferece autenticação", r.smtpAddr)
+		}
+		if err := c.Auth(r.auth); err != nil {
+			return fmt.Errorf("autenticação SMTP recusada: %w", err)
+		}
+	}
+	if err := c.Mail(r.from.Address); err != nil {
+		return err
+	}
+	for _, rcpt := range r.to {
+		if err := c.Rcpt(rcpt.Address); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// message is the RFC 5322 message of the report, its UTF-8 body quoted
+// printable so it goes through servers without 8BITMIME.
+func (r *reportJob) message(subject, body string) ([]byte, error) {
+	id := make([]byte, 12)
+	rand.Read(id)
+	domain := r.from.Address[strings.LastIndex(r.from.Address, "@")+1:]
+	to := make([]string, len(r.to))
+	for i, a := range r.to {
+		to[i] = a.String()
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", r.from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(&b)
+	if _, err := qp.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	retentionBatchSize    int           = 500
+	retentionBatchTimeout time.Duration = 5 * time.Second
+	retentionMinInterval  time.Duration = time.Minute
+	retentionMaxInterval  time.Duration = time.Hour
+)
+
+// startRetentionJob periodically purges quotations and audit log rows older
+// than the retention window until ctx is done. A zero retention keeps
+// everything.
+func startRetentionJob(ctx context.Context) {
+	if retention <= 0 || noDB {
+		return
+	}
+
+	interval := retention / 10
+	if interval < retentionMinInterval {
+		interval = retentionMinInterval
+	}
+	if interval > retentionMaxInterval {
+		interval = retentionMaxInterval
+	}
+
+	backgroundJobs.Add(1)
+	go func() {
+		defer backgroundJobs.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			purgeExpiredQuotations(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func purgeExpiredQuotations(ctx context.Context) {
+	deleted, err := store.purgeQuotationsBefore(ctx, time.Now().Add(-retention))
+	if err != nil {
+		slog.Warn("Retenção - falha ao remover cotações antigas", "err", err)
+	}
+	if deleted > 0 {
+		slog.Info("Retenção - cotações removidas", "deleted", deleted)
+	}
+
+	deleted, err = store.purgeRequestLogBefore(ctx, time.Now().Add(-retention))
+	if err != nil {
+		slog.Warn("Retenção - falha ao remover registros de requisições antigos", "err", err)
+	}
+	if deleted > 0 {
+		slog.Info("Retenção - registros de requisições removidos", "deleted", deleted)
+	}
+}
+
+// purgeQuotationsBefore deletes quotations older than before in small
+// batches, so each transaction holds the write lock only briefly and inserts
+// can run in between.
+func (s *sqliteStore) purgeQuotationsBefore(ctx context.Context, before time.Time) (int64, error) {
+	return purgeBatches(ctx, s.deleteQuotationBatch, before)
+}
+
+// purgeRequestLogBefore deletes request_log rows older than before, in
+// batches like purgeQuotationsBefore.
+func (s *sqliteStore) purgeRequestLogBefore(ctx context.Context, before time.Time) (int64, error) {
+	return purgeBatches(ctx, s.deleteRequestLogBatch, before)
+}
+
+// purgeBatches calls deleteBatch until it removes less than a full batch.
+func purgeBatches(ctx context.Context, deleteBatch func(context.Context, time.Time) (int64, error), before time.Time) (int64, error) {
+	var total int64
+	for ctx.Err() == nil {
+		n, err := deleteBatch(ctx, before)
+		total += n
+		if err != nil || n < int64(retentionBatchSize) {
+			return total, err
+		}
+	}
+	return total, ctx.Err()
+}
+
+func (s *sqliteStore) deleteQuotationBatch(ctx context.Context, before time.Time) (int64, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, retentionBatchTimeout)
+	defer cancel()
+
+	result, err := s.db.ExecContext(dbCtx, `
+		DELETE FROM cotacao
+		WHERE rowid IN (
+			SELECT rowid FROM cotacao
+			WHERE timestamp_unix < ? OR timestamp_unix IS NULL
+			LIMIT ?
+		)
+	`, before.Unix(), retentionBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("falha ao executar query. %w", err)
+	}
+	return result.RowsAffected()
+}
+
+func (s *sqliteStore) deleteRequestLogBatch(ctx context.Context, before time.Time) (int64, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, retentionBatchTimeout)
+	defer cancel()
+
+	result, err := s.db.ExecContext(dbCtx, `
+		DELETE FROM request_log
+		WHERE id IN (
+			SELECT id FROM request_log
+			WHERE timestamp < ?
+			LIMIT ?
+		)
+	`, before.Unix(), retentionBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("falha ao executar query. %w", err)
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	dayLayout     string        = "2006-01-02"
+	rollupTimeout time.Duration = time.Minute
+)
+
+// startRollupJob consolidates every completed day into cotacao_daily, right
+// away and then every rollupInterval, until ctx is done. A zero interval
+// disables it.
+func startRollupJob(ctx context.Context) {
+	if rollupInterval <= 0 || noDB {
+		return
+	}
+
+	backgroundJobs.Add(1)
+	go func() {
+		defer backgroundJobs.Done()
+		ticker := time.NewTicker(rollupInterval)
+		defer ticker.Stop()
+		for {
+			if _, err := store.rollupDays(ctx, time.Now()); err != nil {
+				slog.Warn("Consolidação - falha ao consolidar dias", "err", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// startOfDay returns the UTC midnight starting the day of t.
+func startOfDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+// rolledUntil returns the end of the last day in cotacao_daily, the zero time
+// when nothing was consolidated yet. Days are consolidated in order, so every
+// day before it is in the table.
+func (s *sqliteStore) rolledUntil(ctx context.Context) (time.Time, error) {
+	var last sql.NullString
+	err := s.readDB.QueryRowContext(ctx, `SELECT MAX(day) FROM cotacao_daily`).Scan(&last)
+	if err != nil || !last.Valid {
+		return time.Time{}, err
+	}
+	day, err := time.Parse(dayLayout, last.String)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("dia consolidado inválido: %q", last.String)
+	}
+	return day.AddDate(0, 0, 1), nil
+}
+
+// rollupDays consolidates the days after the last consolidated one that are
+// over by now, returning how many were consolidated. Each day is written in
+// its own transaction, and days already in the table are ignored, so running
+// it twice, or again after a crash, is harmless.
+func (s *sqliteStore) rollupDays(ctx context.Context, now time.Time) (int, error) {
+	from, err := s.rolledUntil(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("falha ao consultar último dia consolidado. %w", err)
+	}
+	days, err := s.pendingDays(ctx, from, startOfDay(now))
+	if err != nil {
+		return 0, err
+	}
+
+	for i, day := range days {
+		if err := s.rollupDay(ctx, day); err != nil {
+			return i, fmt.Errorf("falha ao consolidar %s. %w", day.Format(dayLayout), err)
+		}
+		slog.Info("Consolidação - dia consolidado", "day", day.Format(dayLayout))
+	}
+	return len(days), nil
+}
+
+// pendingDays lists the days in [from, to) with stored quotations.
+func (s *sqliteStore) pendingDays(ctx context.Context, from, to time.Time) ([]time.Time, error) {
+	rows, err := s.readDB.QueryContext(ctx, `
+		SELECT DISTINCT date(timestamp_unix, 'unixepoch')
+		FROM cotacao
+		WHERE timestamp_unix >= ? AND timestamp_unix < ?
+		ORDER BY 1
+	`, from.Unix(), to.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("falha ao consultar dias pendentes. %w", err)
+	}
+	defer rows.Close()
+
+	var days []time.Time
+	for rows.Next() {
+		var raw string
+		if err := rows.Scan(&raw); err != nil {
+			return nil, fmt.Errorf("falha ao ler dia pendente. %w", err)
+		}
+		day, err := time.Parse(dayLayout, raw)
+		if err != nil {
+			return nil, fmt.Errorf("dia pendente inválido: %q", raw)
+		}
+		days = append(days, day)
+	}
+	return days, rows.Err()
+}
+
+// rollupDay writes the rows of day, one per pair.
+func (s *sqliteStore) rollupDay(ctx context.Context, day time.Time) error {
+	dbCtx, cancel := context.WithTimeout(ctx, rollupTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(dbCtx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// ?1 is the day, [?2, ?3) its unix range.
+	_, err = tx.ExecContext(dbCtx, `
+		INSERT OR IGNORE INTO cotacao_daily(
+			day,
+			code,
+			code_in,
+			open_bid,
+			close_bid,
+			min_bid,
+			max_bid,
+			avg_bid,
+			min_ask,
+			max_ask,
+			avg_ask,
+			samples,
+			first_timestamp,
+			last_timestamp
+		)
+		SELECT
+			?1,
+			c.code,
+			c.code_in,
+			(SELECT o.bid_units FROM cotacao o
+				WHERE o.code = c.code AND o.code_in = c.code_in
+				AND o.timestamp_unix >= ?2 AND o.timestamp_unix < ?3
+				ORDER BY o.timestamp_unix, o.rowid LIMIT 1),
+			(SELECT o.bid_units FROM cotacao o
+				WHERE o.code = c.code AND o.code_in = c.code_in
+				AND o.timestamp_unix >= ?2 AND o.timestamp_unix < ?3
+				ORDER BY o.timestamp_unix DESC, o.rowid DESC LIMIT 1),
+			MIN(c.bid_units),
+			MAX(c.bid_units),
+			AVG(c.bid_units),
+			MIN(c.ask_units),
+			MAX(c.ask_units),
+			AVG(c.ask_units),
+			COUNT(*),
+			MIN(c.timestamp_unix),
+			MAX(c.timestamp_unix)
+		FROM cotacao c
+		WHERE c.timestamp_unix >= ?2 AND c.timestamp_unix < ?3
+		GROUP BY c.code, c.code_in
+	`, day.Format(dayLayout), day.Unix(), day.AddDate(0, 0, 1).Unix())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// legacyDeprecated is when the unversioned paths were deprecated in favor
+// of /v1, announced in their Deprecation header (RFC 9745).
+var legacyDeprecated = time.Date(2026, time.October, 14, 0, 0, 0, 0, time.UTC)
+
+// router registers routes on a ServeMux, each wrapped in the middlewares of
+// the router it was registered on. The routers derived by with and version
+// share the mux, so a group of routes gets its middlewares, or its API
+// version, in one place instead of at every route.
+type router struct {
+	mux    *http.ServeMux
+	mws    []middleware
+	prefix string
+	legacy bool
+}
+
+func newRouter() *router {
+	return &router{mux: http.NewServeMux()}
+}
+
+// with returns a router whose routes also go through mws, inside the
+// middlewares of rt.
+func (rt *router) with(mws ...middleware) *router {
+	derived := *rt
+	derived.mws = append(append([]middleware(nil), rt.mws...), mws...)
+	return &derived
+}
+
+// version returns a router for the API version served below prefix, such
+// as /v1. Handlers see the path without the prefix, so every version can
+// mount the same handlers, or its own, side by side. With legacy the routes
+// are also served without the prefix, as deprecated aliases; only /v1
+// should set it.
+func (rt *router) version(prefix string, legacy bool) *router {
+	derived := rt.with()
+	derived.prefix, derived.legacy = prefix, legacy
+	return derived
+}
+
+// handle serves the methods of rs at pattern.
+func (rt *router) handle(pattern string, rs routes) {
+	h := chain(rs, rt.mws...)
+	if rt.prefix == "" {
+		rt.mux.Handle(pattern, h)
+		return
+	}
+	rt.mux.Handle(rt.prefix+pattern, http.StripPrefix(rt.prefix, h))
+	if rt.legacy {
+		rt.mux.Handle(pattern, rt.deprecated(h))
+	}
+}
+
+// deprecated marks the responses of a legacy path with the Deprecation
+// header and links them to the same path under the prefix.
+func (rt *router) deprecated(next http.Handler) http.Handler {
+	deprecation := fmt.Sprint("@", legacyDeprecated.Unix())
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", deprecation)
+		w.Header().Add("Link", fmt.Sprintf("<%s%s>; rel=\"successor-version\"", rt.prefix, r.URL.EscapedPath()))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// routes maps the methods of a route to their handlers. Other methods get
+// 405 with the Allow header, so handlers don't check the method themselves.
+type routes map[string]http.HandlerFunc
+
+// get is the route of a handler answering only GET.
+func get(h http.HandlerFunc) routes {
+	return routes{http.MethodGet: h}
+}
+
+// post is the route of a handler answering only POST.
+func post(h http.HandlerFunc) routes {
+	return routes{http.MethodPost: h}
+}
+
+func (rs routes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h, ok := rs[r.Method]
+	if !ok {
+		w.Header().Set("Allow", rs.allow())
+		sendMsgError(w, codeMethodNotAllowed, "método não permitido: "+r.Method, http.StatusMethodNotAllowed)
+		return
+	}
+	h(w, r)
+}
+
+// allow lists the methods of rs for the Allow header.
+func (rs routes) allow() string {
+	methods := make([]string, 0, len(rs))
+	for method := range rs {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return strings.Join(methods, ", ")
+}
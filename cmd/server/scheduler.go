@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+// startPollScheduler fetches and stores the default pair, and the pairs of
+// the alert rules, every poll interval of the current settings until ctx is
+// done, building the history without depending on clients calling /cotacao.
+// A zero interval pauses it; a new interval takes effect from the last poll,
+// without waiting for the old one.
+func startPollScheduler(ctx context.Context) {
+	backgroundJobs.Add(1)
+	go func() {
+		defer backgroundJobs.Done()
+		var last time.Time
+		for {
+			s := currentSettings()
+			if s.poll <= 0 {
+				select {
+				case <-ctx.Done():
+					return
+				case <-s.changed:
+				}
+				continue
+			}
+			wait := time.Until(last.Add(s.poll))
+			if wait <= 0 {
+				pollQuotation(ctx)
+				last = time.Now()
+				continue
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			case <-s.changed:
+				timer.Stop()
+			}
+		}
+	}()
+}
+
+// pollQuotation runs a single scheduler iteration. Errors are only logged so
+// a failing upstream or database never brings the server down. The default
+// pair is fetched on its own, so a rule on a pair the upstream rejects can't
+// keep it from being stored. The pairs of the rules go in batches of
+// upstream.MaxPairsPerRequest, and a failed batch is fetched again pair by
+// pair. The rules are checked against every new quotation.
+func pollQuotation(ctx context.Context) {
+	rules, err := store.alertRules(ctx)
+	if err != nil && !errors.Is(err, errNoDatabase) {
+		slog.Warn("Agendador - falha ao consultar alertas", "err", err)
+	}
+	pairs := alertPairs(rules)
+	if err := pollPairs(ctx, pairs[:1], rules); err != nil {
+		slog.Warn("Agendador - falha ao buscar cotação", "err", err)
+	}
+	for pairs = pairs[1:]; len(pairs) > 0; {
+		batch := pairs
+		if len(batch) > upstream.MaxPairsPerRequest {
+			batch = batch[:upstream.MaxPairsPerRequest]
+		}
+		pairs = pairs[len(batch):]
+
+		err := pollPairs(ctx, batch, rules)
+		if err == nil {
+			continue
+		}
+		if len(batch) == 1 {
+			slog.Warn("Agendador - falha ao buscar cotação", "err", err)
+			continue
+		}
+		slog.Warn("Agendador - falha ao buscar lote, buscando par a par", "err", err)
+		for _, pair := range batch {
+			if err := pollPairs(ctx, []string{pair}, rules); err != nil {
+				slog.Warn("Agendador - falha ao buscar cotação", "pair", pair, "err", err)
+			}
+		}
+	}
+}
+
+// pollPairs fetches pairs in a single upstream request, then stores each of
+// them and checks the rules against the new ones.
+func pollPairs(ctx context.Context, pairs []string, rules []AlertRule) error {
+	fetchCtx, cancel := context.WithTimeout(ctx, currentSettings().requestTimeout)
+	quotations, _, err := providers.Fetch(fetchCtx, pairs...)
+	cancel()
+	if err != nil {
+		return err
+	}
+	for _, pair := range pairs {
+		cotacao := quotations[pair]
+		if pollSave(ctx, &cotacao) {
+			evaluateAlerts(ctx, cotacao, rules)
+		}
+	}
+	return nil
+}
+
+// pollSave publishes and stores a polled quotation, reporting whether it is
+// new: false when it has the timestamp of the last stored one or couldn't be
+// stored.
+func pollSave(ctx context.Context, cotacao *upstream.Quotation) bool {
+	hub.publish(*cotacao)
+
+	pair := cotacao.Code + "-" + cotacao.CodeIn
+	last, err := storedQuotation(ctx, pair)
+	if errors.Is(err, errNoDatabase) {
+		// Nothing to store, the poll only feeds the stream subscribers.
+		return true
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		slog.Warn("Agendador - falha ao consultar última cotação", "err", err)
+		return false
+	}
+	if last != nil && last.Timestamp == cotacao.Timestamp {
+		slog.Info("Agendador - cotação inalterada", "pair", pair, "since", cotacao.CreateDate)
+		return false
+	}
+
+	// A cross rate stores its legs, so it goes through persistQuotation.
+	err = persistQuotation(ctx, cotacao)
+	if err != nil {
+		slog.Warn("Agendador - falha ao salvar dados no banco", "err", err)
+		return false
+	}
+	slog.Info("Agendador - cotação salva", "pair", pair, "bid", cotacao.Bid)
+	return true
+}
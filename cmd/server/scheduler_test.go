@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+func TestPollQuotationIsolatesFailingPairs(t *testing.T) {
+	useSettings(t, nil)
+	mem := newMemoryStore()
+	useStore(t, mem)
+	// EUR-USD too, or EUR-BRL would be served as a cross rate.
+	fake := newFakeUpstream(t, http.StatusOK, "EUR-BRL", "EUR-USD")
+	useChain(t, "awesomeapi", upstream.ChainConfig{BaseURL: fake.URL})
+	ctx := context.Background()
+	for _, pair := range []string{"EUR-BRL", "GBP-BRL"} {
+		rule := AlertRule{Pair: pair, Condition: "bid > 100", WebhookURL: "https://example.com/hook", Enabled: true, CreatedAt: time.Now()}
+		if err := mem.insertAlertRule(ctx, &rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pollQuotation(ctx)
+
+	for _, tt := range []struct {
+		pair   string
+		stored bool
+	}{
+		{"USD-BRL", true},
+		{"GBP-BRL", true},
+		{"EUR-BRL", false},
+	} {
+		q, err := mem.latestQuotation(ctx, tt.pair)
+		if stored := err == nil && q != nil; stored != tt.stored {
+			t.Errorf("%s stored = %v, want %v (err %v)", tt.pair, stored, tt.stored, err)
+		}
+	}
+}
+
+func TestPollQuotationDefaultPairAlone(t *testing.T) {
+	useSettings(t, nil)
+	mem := newMemoryStore()
+	useStore(t, mem)
+	fake := newFakeUpstream(t, http.StatusOK)
+	useChain(t, "awesomeapi", upstream.ChainConfig{BaseURL: fake.URL})
+
+	pollQuotation(context.Background())
+
+	if _, err := mem.latestQuotation(context.Background(), upstream.DefaultPair); err != nil {
+		t.Errorf("default pair wasn't stored: %v", err)
+	}
+	if n := fake.hits.Load(); n != 1 {
+		t.Errorf("upstream got %d requests, want 1", n)
+	}
+}
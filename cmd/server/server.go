@@ -0,0 +1,822 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/twsm000/goxp-client-server-api/internal/buildinfo"
+	"github.com/twsm000/goxp-client-server-api/internal/tracing"
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+var (
+	databaseTimeout  time.Duration
+	busyTimeout      time.Duration
+	databaseReaders  int
+	listenNetwork    string
+	serverListenAddr string
+	socketMode       os.FileMode
+	databaseFile     string
+	noDB             bool
+	store            storage
+	upstreamURL      string
+	providerFile     string
+	supportedPairs   []string
+	providers        *upstream.Chain
+	upstreamSlots    chan struct{}
+	upstreamWait     time.Duration
+	limiter          *rateLimiter
+	cors             *corsPolicy
+	retention        time.Duration
+	streamInterval   time.Duration
+	rollupInterval   time.Duration
+	writer           *asyncWriter
+	strictSave       bool
+	cache            *quotationCache
+	maxStale         time.Duration
+	audit            *auditLog
+	adminKey         string
+	apiKeyRequired   bool
+	staticAPIKeys    map[string]*APIKey
+	jwtAuth          *jwtVerifier
+	tlsConfig        *tls.Config
+	acmeManager      *autocert.Manager
+	acmeHTTPAddr     string
+	grpcAddr         string
+	metricsEnabled   bool
+	debugEnabled     bool
+	debugAddr        string
+	defaultLang      string
+	tracer           *tracing.Tracer
+	noWarmup         bool
+	strictWarmup     bool
+	warmupTimeout    time.Duration
+	hub              = newQuotationHub()
+	backgroundJobs   sync.WaitGroup
+	emailReport      *reportJob
+	shutdownTimeout  time.Duration
+)
+
+func main() {
+	cfg, err := LoadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fatal("Invalid argument", "err", err)
+	}
+	if cfg.Version {
+		fmt.Println("cotacao-server", buildinfo.Get())
+		return
+	}
+	if err := applyConfig(cfg); err != nil {
+		fatal("Invalid argument", "err", err)
+	}
+	slog.Info("Versão", "version", buildinfo.Get().String())
+	slog.Info("Configuração efetiva", "config", cfg.String())
+
+	if noDB {
+		slog.Info("Persistência desativada, cotações não serão armazenadas")
+		store = noopStore{}
+	} else if databaseFile == memoryDBFile {
+		slog.Info("Persistência em memória, cotações serão perdidas ao encerrar")
+		store = newMemoryStore()
+	} else {
+		store = startDatabase(databaseFile)
+	}
+	defer store.close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	startWarmup(ctx)
+	startRetentionJob(ctx)
+	limiter.startEviction(ctx)
+	startStreamPoller(ctx)
+	startPollScheduler(ctx)
+	startRollupJob(ctx)
+	startReportJob(ctx)
+	startDebugServer(ctx)
+	startReloadOnSIGHUP(ctx)
+	startACMEServer(ctx)
+	startGRPCServer(ctx)
+	startHTTPServer(ctx)
+	stop()
+	backgroundJobs.Wait()
+	if writer != nil {
+		writer.close()
+	}
+	if audit != nil {
+		audit.close()
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := tracer.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("Falha ao exportar spans pendentes", "err", err)
+	}
+}
+
+// applyConfig publishes cfg to the package state used by the handlers and
+// background jobs.
+func applyConfig(cfg *Config) error {
+	setupLogging(cfg.LogFormat, os.Stderr)
+	databaseTimeout = time.Duration(cfg.DatabaseTimeout)
+	busyTimeout = time.Duration(cfg.BusyTimeout)
+	databaseReaders = int(cfg.DBReaders)
+	shutdownTimeout = time.Duration(cfg.DrainTimeout)
+	databaseFile = cfg.DBFile
+	noDB = cfg.NoDB || cfg.DBFile == ""
+	upstreamURL = cfg.UpstreamURL
+	providerFile = cfg.ProviderFile
+	supportedPairs, _ = parseSupportedPairs(cfg.SupportedPairs)
+	retention = time.Duration(cfg.Retention)
+	streamInterval = time.Duration(cfg.StreamInterval)
+	rollupInterval = time.Duration(cfg.RollupInterval)
+	if cfg.GRPCPort != 0 {
+		grpcAddr = fmt.Sprint(":", cfg.GRPCPort)
+	}
+	debugEnabled = cfg.Debug
+	metricsEnabled = !cfg.NoMetrics
+	debugAddr = cfg.DebugAddr
+	strictSave = cfg.StrictSave
+	adminKey = cfg.AdminKey
+	apiKeyRequired = cfg.RequireAPIKey
+	staticAPIKeys = parseStaticAPIKeys(cfg.APIKeys)
+	jwtAuth = newJWTVerifier(cfg)
+	maxStale = time.Duration(cfg.MaxStale)
+	upstreamWait = time.Duration(cfg.UpstreamWait)
+	if cfg.MaxUpstream > 0 {
+		upstreamSlots = make(chan struct{}, cfg.MaxUpstream)
+	}
+	defaultLang, _ = parseLang(cfg.Lang)
+	tracer = tracing.New(cfg.OTLPEndpoint, "cotacao-server")
+	noWarmup = cfg.NoWarmup
+	strictWarmup = cfg.StrictWarmup
+	warmupTimeout = time.Duration(cfg.WarmupTimeout)
+	emailReport = newReportJob(cfg)
+
+	var err error
+	listenNetwork, serverListenAddr, err = parseListen(cfg.Listen, cfg.Port)
+	if err != nil {
+		return err
+	}
+	acmeHTTPAddr = cfg.ACMEHTTP
+	if tlsConfig, err = newTLSConfig(cfg); err != nil {
+		return err
+	}
+	mode, _ := strconv.ParseUint(cfg.SocketMode, 8, 32)
+	socketMode = os.FileMode(mode)
+
+	timeouts, _ := upstream.ParseTimeouts(cfg.ProviderTimeout)
+	var budgetRate float64
+	if cfg.UpstreamRate != "" {
+		budgetRate, _ = parseRate(cfg.UpstreamRate)
+	}
+	providers, err = upstream.NewChain(cfg.Providers, upstream.ChainConfig{
+		BaseURL:          upstreamURL,
+		File:             providerFile,
+		BreakerThreshold: int(cfg.Breaker),
+		BreakerCooldown:  time.Duration(cfg.BreakerWait),
+		Retries:          int(cfg.Retries),
+		RetryBackoff:     time.Duration(cfg.RetryBackoff),
+		RetryJitter:      cfg.RetryJitter,
+		BudgetRate:       budgetRate,
+		BudgetBurst:      int(cfg.UpstreamBurst),
+		Timeouts:         timeouts,
+		Observe:          observeUpstream,
+	})
+	if err != nil {
+		return err
+	}
+	s, err := newRuntimeSettings(cfg)
+	if err != nil {
+		return err
+	}
+	storeSettings(s)
+	// The limiter and the cache always exist, following the rate and the ttl
+	// of the current settings, so PATCH /admin/config can turn them on.
+	limiter = newRateLimiter(cfg.TrustProxy)
+	cors = newCORSPolicy(cfg.CORSOrigins, cfg.CORSMethods, cfg.CORSHeaders)
+	if cfg.AsyncSave {
+		writer = newAsyncWriter(asyncQueueSize, int(cfg.FlushSize), time.Duration(cfg.FlushInterval))
+	}
+	if cfg.CacheSize > 0 {
+		cache = newQuotationCache(int(cfg.CacheSize))
+	}
+	if cfg.Audit && !noDB {
+		audit = newAuditLog(auditQueueSize)
+	}
+	return nil
+}
+
+// startHTTPServer serves until ctx is done, then waits for in-flight requests
+// to finish before returning.
+func startHTTPServer(ctx context.Context) {
+	// With -require-api-key or -jwt-* the quotation endpoints need an API key
+	// or a JWT, checked before the rate limit so it counts per caller.
+	var quotationMiddlewares []middleware
+	if authRequired() {
+		quotationMiddlewares = append(quotationMiddlewares, requireAuth)
+		slog.Info("Autenticação obrigatória nos endpoints de cotação")
+	}
+	// A dedicated mux instead of http.DefaultServeMux, where net/http/pprof
+	// registers itself, keeps the profiling handlers off the public port.
+	rt := newRouter()
+	// The API lives under /v1; the unversioned paths of before stay as
+	// deprecated aliases. A /v2 gets its own rt.version next to it.
+	v1 := rt.version("/v1", true)
+	quotes := v1.with(quotationMiddlewares...)
+	quotes.with(limiter.middleware).handle("/cotacao", get(cotacaoHandler))
+	quotes.with(limiter.middleware).handle("/cotacao/", get(cotacaoHandler))
+	quotes.handle("/cotacao/latest", get(latestHandler))
+	quotes.handle("/cotacao/history", routes{http.MethodGet: listHistoryHandler, http.MethodDelete: purgeHistoryHandler})
+	quotes.handle("/cotacao/history/", get(historyItemHandler))
+	quotes.handle("/cotacao/history.csv", get(historyCSVHandler))
+	quotes.handle("/cotacao/stream", get(streamHandler))
+	quotes.handle("/cotacao/events", get(eventsHandler))
+	quotes.handle("/cotacao/stats", get(statsHandler))
+	// Endpoints added since /v1 have no unversioned alias.
+	current := rt.version("/v1", false).with(quotationMiddlewares...)
+	current.with(limiter.middleware).handle("/convert", get(convertHandler))
+	// The alerts make the server call out to the webhooks, so they always
+	// need an API key or a JWT, which also owns the rules.
+	alerts := rt.version("/v1", false).with(requireAuth)
+	alerts.handle("/alerts", routes{http.MethodGet: alertsHandler, http.MethodPost: createAlertHandler})
+	alerts.handle("/alerts/", routes{http.MethodGet: alertHandler, http.MethodPut: alertHandler, http.MethodDelete: alertHandler})
+	rt.handle("/healthz", get(healthHandler))
+	rt.handle("/readyz", get(readyHandler))
+	rt.handle("/version", get(versionHandler))
+	rt.handle("/openapi.json", get(openAPIHandler(openAPISpec())))
+	rt.handle("/docs", get(docsHandler))
+	if metricsEnabled {
+		rt.handle("/metrics", get(metricsHandler))
+	}
+	rt.mux.HandleFunc("/", notFoundHandler)
+	if adminKey != "" || jwtAuth != nil {
+		admin := v1.with(requireAdminKey)
+		admin.handle("/cotacao/refresh", post(refreshHandler))
+		admin.handle("/admin/requests", get(adminRequestsHandler))
+		admin.handle("/admin/rollup", post(adminRollupHandler))
+		admin.handle("/admin/config", routes{http.MethodGet: adminConfigHandler, http.MethodPatch: adminConfigHandler})
+		admin.handle("/admin/reload", post(adminReloadHandler))
+		admin.handle("/admin/keys", routes{http.MethodGet: adminKeysHandler, http.MethodPost: adminKeysHandler})
+		admin.handle("/admin/keys/", routes{http.MethodDelete: adminKeyHandler})
+	} else {
+		slog.Info("Endpoints /admin e /cotacao/refresh desabilitados, informe -admin-key ou -jwt-* para habilitá-los")
+	}
+	rootMiddlewares := []middleware{logRequests}
+	if metricsEnabled {
+		rootMiddlewares = append(rootMiddlewares, instrumentRequests(rt.mux))
+	}
+	if tracer != nil {
+		rootMiddlewares = append(rootMiddlewares, traceRequests)
+		slog.Info("Tracing habilitado")
+	}
+	if cors != nil {
+		rootMiddlewares = append(rootMiddlewares, cors.middleware)
+		slog.Info("CORS habilitado")
+	}
+	// Innermost, so the 500 of a panicking handler is still logged, counted
+	// and traced by the middlewares above.
+	rootMiddlewares = append(rootMiddlewares, recoverPanics)
+	root := chain(rt.mux, rootMiddlewares...)
+	server := &http.Server{
+		Handler:   root,
+		TLSConfig: tlsConfig,
+	}
+	server.RegisterOnShutdown(hub.close)
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		slog.Info("Encerrando servidor, aguardando requisições em andamento", "timeout", shutdownTimeout)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			// Closing the connections cancels the contexts of the requests
+			// left, so their queries stop before the database is closed.
+			slog.Warn("Falha ao encerrar servidor, fechando conexões restantes", "err", err)
+			server.Close()
+		}
+	}()
+
+	ln, err := listen(listenNetwork, serverListenAddr)
+	if err != nil {
+		fatal("Servidor falhou", "err", err)
+	}
+	if listenNetwork == "unix" {
+		slog.Info("Iniciando servidor no socket", "path", serverListenAddr)
+	} else {
+		slog.Info("Iniciando servidor no endereço", "addr", serverListenAddr)
+	}
+	if tlsConfig != nil {
+		slog.Info("TLS habilitado")
+		if tlsConfig.ClientCAs != nil {
+			slog.Info("Certificado de cliente exigido nos endpoints /admin")
+		}
+		err = server.ServeTLS(ln, "", "")
+	} else {
+		err = server.Serve(ln)
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		fatal("Servidor falhou", "err", err)
+	}
+	<-shutdownDone
+}
+
+// cotacaoHandler lives under a single deadline covering both the upstream call
+// and the save, so the latency seen by the client is capped. The deadline is
+// -rt unless the request asks for another one. With -max-stale a
+// recent enough stored quotation is served without calling the upstream.
+// ?nocache=true or Cache-Control: no-cache skips both and refreshes the cache.
+// The body is JSON, XML or CSV as negotiated by Accept or ?format=.
+func cotacaoHandler(w http.ResponseWriter, r *http.Request) {
+	// Below /cotacao/ only a pair is a known path, anything else keeps
+	// answering 404.
+	var pathPair string
+	if r.URL.Path != "/cotacao" {
+		pathPair = strings.TrimPrefix(r.URL.Path, "/cotacao/")
+		if _, err := upstream.ParsePairs(pathPair); err != nil || strings.Contains(pathPair, ",") {
+			notFoundHandler(w, r)
+			return
+		}
+	}
+	w.Header().Add("Vary", "Accept")
+	format, err := negotiateFormat(r)
+	if err != nil {
+		sendFormatError(w, "GET /cotacao", err)
+		return
+	}
+
+	timeout, clamped, err := requestedTimeout(r)
+	if err != nil {
+		msg := fmt.Sprint("GET /cotacao - ", err)
+		sendMsgError(w, codeBadRequest, msg, http.StatusBadRequest)
+		return
+	}
+	if clamped {
+		w.Header().Set("X-Effective-Timeout", timeout.String())
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	info := requestInfoFrom(r.Context())
+
+	pairs, multiple, full, err := quotationQuery(r, pathPair)
+	if err != nil {
+		msg := fmt.Sprint("GET /cotacao - ", err)
+		sendMsgError(w, codeBadRequest, msg, http.StatusBadRequest)
+		return
+	}
+
+	bypass, err := cacheBypass(r)
+	if err != nil {
+		msg := fmt.Sprint("GET /cotacao - ", err)
+		sendMsgError(w, codeBadRequest, msg, http.StatusBadRequest)
+		return
+	}
+
+	info.Pair = strings.Join(pairs, ",")
+	quotations, missing := make(map[string]upstream.Quotation, len(pairs)), pairs
+	if !bypass {
+		quotations, missing = cachedQuotations(pairs)
+	}
+	provider, stale, saving := "cache", false, false
+	if len(missing) > 0 && maxStale > 0 && !bypass {
+		var stored map[string]upstream.Quotation
+		stored, missing = recentStoredQuotations(ctx, missing, time.Now())
+		for pair, q := range stored {
+			quotations[pair] = q
+			provider = "database"
+		}
+	}
+	if len(missing) > 0 {
+		// Cached quotations were already saved and published when fetched;
+		// the missing ones are, once, by the call shared with the concurrent
+		// misses, even when this request gives up.
+		var result flightResult
+		fetchStart := time.Now()
+		result, _, err = fetchAndStore(ctx, timeout, missing)
+		info.UpstreamDuration = time.Since(fetchStart)
+		if err == nil {
+			err, provider, saving = result.err, result.provider, result.saving
+		}
+		fetched := result.quotations
+		// While the breaker is open or the upstream budget is spent the last
+		// stored quotations are served instead of failing.
+		if errors.Is(err, upstream.ErrCircuitOpen) || errors.Is(err, upstream.ErrBudgetExhausted) {
+			if stored, dbErr := storedQuotations(ctx, missing); dbErr == nil {
+				fetched, provider, err, stale = stored, "database", nil, true
+			}
+		}
+		for pair, q := range fetched {
+			quotations[pair] = q
+		}
+	}
+	var saveErr saveError
+	switch {
+	case errors.As(err, &saveErr):
+		statusCode, code := dbErrorStatus(saveErr.err, codeDBWriteFailed)
+		sendMsgError(w, code, fmt.Sprint("GET /cotacao - ", err), statusCode)
+		return
+	case err != nil:
+		setUpstreamRetryAfter(w, err)
+		statusCode, code := fetchErrorStatus(err)
+		var msg string
+		if code == codeUpstreamTimeout {
+			msg = fmt.Sprint("requisição ultrapassou o tempo máximo de ", timeout)
+		} else {
+			msg = fmt.Sprint("GET /cotacao - ", err)
+		}
+		sendMsgError(w, code, msg, statusCode)
+		return
+	case saving && strictSave:
+		msg := "GET /cotacao - prazo da requisição esgotado antes de salvar a cotação"
+		sendMsgError(w, codeDBTimeout, msg, http.StatusServiceUnavailable)
+		return
+	case saving:
+		slog.WarnContext(r.Context(), "GET /cotacao - prazo da requisição esgotado, cotação ainda sendo salva", "pair", info.Pair)
+	}
+
+	w.Header().Set("X-Quotation-Provider", provider)
+	w.Header().Set("X-Quotation-Source", quotationSource(provider))
+	if age, ok := quotationAge(pairs, quotations, time.Now()); ok {
+		w.Header().Set("X-Quotation-Age", strconv.FormatInt(int64(age/time.Second), 10))
+	}
+	if stale {
+		w.Header().Set("X-Quotation-Stale", "true")
+	}
+	body := quotationBody(pairs, quotations, multiple, full, provider)
+	variant := fmt.Sprint(multiple, full)
+	if format != formatJSON {
+		variant += " " + format
+	}
+	etag, lastModified := quotationValidators(variant, pairs, quotations)
+	if setValidators(w, r, etag, lastModified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	err = sendQuotation(w, format, pairs, quotations, body, provider)
+	if err != nil {
+		slog.WarnContext(r.Context(), "GET /cotacao - falha ao enviar resposta", "err", err)
+	}
+}
+
+// quotationQuery reads the query parameters shared by /cotacao and
+// /cotacao/refresh: the pairs, defaulting to the default pair, and full. A
+// single pair comes from ?pair= or pathPair, the segment of
+// /cotacao/{pair}; several from ?pairs=, which alone reports multiple and
+// keys the body by pair. Pairs outside -supported-pairs are rejected.
+func quotationQuery(r *http.Request, pathPair string) (pairs []string, multiple, full bool, err error) {
+	pairs = []string{upstream.DefaultPair}
+	query := r.URL.Query()
+	multiple = query.Has("pairs")
+	given := 0
+	for _, set := range []bool{multiple, query.Has("pair"), pathPair != ""} {
+		if set {
+			given++
+		}
+	}
+	if given > 1 {
+		return nil, false, false, errors.New("informe o par em apenas um entre ?pair=, ?pairs= e /cotacao/{pair}")
+	}
+	switch raw := query.Get("pair") + pathPair; {
+	case multiple:
+		pairs, err = upstream.ParsePairs(query.Get("pairs"))
+	case given == 1 && strings.Contains(raw, ","):
+		err = fmt.Errorf("par inválido: %q (use ?pairs= para vários pares)", raw)
+	case given == 1:
+		pairs, err = upstream.ParsePairs(raw)
+	}
+	if err != nil {
+		return nil, false, false, err
+	}
+	if err := checkSupportedPairs(pairs); err != nil {
+		return nil, false, false, err
+	}
+	if raw := query.Get("full"); raw != "" {
+		full, err = strconv.ParseBool(raw)
+		if err != nil {
+			return nil, false, false, fmt.Errorf("parâmetro full inválido: %q", raw)
+		}
+	}
+	return pairs, multiple, full, nil
+}
+
+// checkSupportedPairs rejects the pairs missing from -supported-pairs, unless
+// both legs of their cross rate are listed. Without the list every well
+// formed pair is accepted.
+func checkSupportedPairs(pairs []string) error {
+	if supportedPairs == nil {
+		return nil
+	}
+	var unsupported []string
+	for _, pair := range pairs {
+		first, second, cross := upstream.CrossLegs(pair)
+		if cross && containsPair(supportedPairs, first) && containsPair(supportedPairs, second) {
+			continue
+		}
+		if !containsPair(supportedPairs, pair) {
+			unsupported = append(unsupported, pair)
+		}
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("par não suportado: %s (suportados: %s)",
+			strings.Join(unsupported, ","), strings.Join(supportedPairs, ","))
+	}
+	return nil
+}
+
+// parseSupportedPairs reads the -supported-pairs list, which unlike ?pairs=
+// isn't bounded by upstream.MaxPairsPerRequest. An empty list returns nil.
+func parseSupportedPairs(raw string) ([]string, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var pairs []string
+	for _, p := range strings.Split(raw, ",") {
+		parsed, err := upstream.ParsePairs(p)
+		if err != nil {
+			return nil, err
+		}
+		if !containsPair(pairs, parsed[0]) {
+			pairs = append(pairs, parsed[0])
+		}
+	}
+	return pairs, nil
+}
+
+func containsPair(pairs []string, pair string) bool {
+	for _, p := range pairs {
+		if p == pair {
+			return true
+		}
+	}
+	return false
+}
+
+// cachedQuotations returns the fresh cached quotations among pairs and the
+// pairs that still have to be fetched.
+func cachedQuotations(pairs []string) (map[string]upstream.Quotation, []string) {
+	quotations := make(map[string]upstream.Quotation, len(pairs))
+	if cache == nil {
+		return quotations, pairs
+	}
+	var missing []string
+	now := time.Now()
+	for _, pair := range pairs {
+		if q, ok := cache.get(pair, now); ok {
+			quotations[pair] = q
+		} else {
+			missing = append(missing, pair)
+		}
+	}
+	return quotations, missing
+}
+
+// quotationSource tells where the provider that answered got its data:
+// upstream, cache or db.
+func quotationSource(provider string) string {
+	switch provider {
+	case "cache":
+		return "cache"
+	case "database":
+		return "db"
+	default:
+		return "upstream"
+	}
+}
+
+// quotationAge is the age of the oldest of the quotations, never negative so
+// a clock running behind the upstream doesn't report data from the future.
+// ok is false when no quotation has a usable date.
+func quotationAge(pairs []string, quotations map[string]upstream.Quotation, now time.Time) (age time.Duration, ok bool) {
+	for _, pair := range pairs {
+		t, err := quotations[pair].Time()
+		if err != nil {
+			continue
+		}
+		if d := now.Sub(t); !ok || d > age {
+			age, ok = d, true
+		}
+	}
+	if age < 0 {
+		age = 0
+	}
+	return age, ok
+}
+
+// requestedTimeout reads the X-Request-Timeout header, or the timeout query
+// parameter, clamping it to the max request timeout. Without either it is the
+// request timeout. clamped reports whether the requested value was above the
+// max.
+func requestedTimeout(r *http.Request) (d time.Duration, clamped bool, err error) {
+	s := currentSettings()
+	raw := r.Header.Get("X-Request-Timeout")
+	if raw == "" {
+		raw = r.URL.Query().Get("timeout")
+	}
+	if raw == "" {
+		return s.requestTimeout, false, nil
+	}
+	d, err = time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		return 0, false, fmt.Errorf("timeout inválido: %q", raw)
+	}
+	if d > s.maxTimeout {
+		return s.maxTimeout, true, nil
+	}
+	return d, false, nil
+}
+
+// quotationBody shapes the /cotacao response. By default only the bid is
+// returned; full returns the whole quotation. Multiple pairs are keyed by pair.
+// Every quotation carries source, the provider that answered, as in
+// X-Quotation-Provider.
+func quotationBody(pairs []string, quotations map[string]upstream.Quotation, multiple, full bool, source string) any {
+	shape := func(pair string, q upstream.Quotation) any {
+		if full {
+			return FullQuotationResponse{Quotation: q, Source: source, Pair: pair}
+		}
+		return QuotationResponse{
+			Bid:        q.Bid,
+			BidValue:   q.BidValue,
+			Source:     source,
+			Pair:       pair,
+			Derived:    q.Derived,
+			Components: componentRates(q),
+		}
+	}
+	if !multiple {
+		return shape("", quotations[pairs[0]])
+	}
+	body := make(map[string]any, len(pairs))
+	for _, pair := range pairs {
+		body[pair] = shape(pair, quotations[pair])
+	}
+	return body
+}
+
+// notFoundHandler answers the paths no route matches, so clients get the
+// problem document instead of the plain-text page of net/http.
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	slog.InfoContext(r.Context(), "Rota não encontrada", "method", r.Method, "path", r.URL.Path)
+	sendMsgError(w, codeNotFound, "rota não encontrada: "+r.URL.Path, http.StatusNotFound)
+}
+
+func latestHandler(w http.ResponseWriter, r *http.Request) {
+	var pair string
+	if raw := r.URL.Query().Get("pair"); raw != "" {
+		pairs, err := upstream.ParsePairs(raw)
+		if err != nil || len(pairs) != 1 {
+			msg := fmt.Sprint("GET /cotacao/latest - par inválido: ", raw)
+			sendMsgError(w, codeBadRequest, msg, http.StatusBadRequest)
+			return
+		}
+		pair = pairs[0]
+	}
+
+	info := requestInfoFrom(r.Context())
+	info.Pair = pair
+	dbStart := time.Now()
+	cotacao, err := storedQuotation(r.Context(), pair)
+	info.DBDuration = time.Since(dbStart)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			sendMsgError(w, codeNotFound, "GET /cotacao/latest - nenhuma cotação armazenada", http.StatusNotFound)
+			return
+		}
+		statusCode, code := dbErrorStatus(err, codeDBReadFailed)
+		msg := fmt.Sprint("GET /cotacao/latest - ", err)
+		sendMsgError(w, code, msg, statusCode)
+		return
+	}
+
+	err = sendJSON(w, http.StatusOK, cotacao)
+	if err != nil {
+		slog.WarnContext(r.Context(), "GET /cotacao/latest - falha ao enviar resposta", "err", err)
+	}
+}
+
+const (
+	jsonContentType    string = "application/json; charset=utf-8"
+	problemContentType string = "application/problem+json; charset=utf-8"
+	// problemTypePrefix names the problem type of each error code, as a URN
+	// since the codes have no page of their own.
+	problemTypePrefix string = "urn:cotacao:error:"
+)
+
+// sendJSON encodes v before writing anything, so an encoding failure is still
+// answered with a 500 instead of a 200 with a truncated body. The returned
+// error is the encoding or write failure, for logging.
+func sendJSON(w http.ResponseWriter, statusCode int, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		msg := fmt.Sprint("falha ao codificar resposta: ", err)
+		sendMsgError(w, codeInternal, msg, http.StatusInternalServerError)
+		return err
+	}
+	w.Header().Set("Content-Type", jsonContentType)
+	w.WriteHeader(statusCode)
+	_, err = w.Write(append(body, '\n'))
+	return err
+}
+
+// sendMsgError must be called before anything was written to w; it writes
+// the status and an RFC 7807 problem document in a single pass. The title is
+// the catalog message of code in the language of the request; msg, logged as
+// is, goes in detail.
+func sendMsgError(w http.ResponseWriter, code, msg string, statusCode int) {
+	info := responseInfo(w)
+	slog.Info(msg, "request_id", info.RequestID, "code", code, "status", statusCode)
+	info.ErrorCode = code
+	lang := info.Lang
+	if lang == "" {
+		lang = defaultLang
+	}
+	body, _ := json.Marshal(Problem{
+		Type:      problemTypePrefix + code,
+		Title:     errorMessage(code, lang),
+		Status:    statusCode,
+		Detail:    msg,
+		Code:      code,
+		RequestID: info.RequestID,
+	})
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(statusCode)
+	w.Write(append(body, '\n'))
+}
+
+// Problem is an error response, an RFC 7807 problem details document. Code
+// and RequestID are extension members.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail"`
+	Code      string `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+type QuotationResponse struct {
+	XMLName  xml.Name         `json:"-" xml:"quotation"`
+	Pair     string           `json:"-" xml:"pair,attr,omitempty"`
+	Bid      string           `json:"bid" xml:"bid"`
+	BidValue upstream.Decimal `json:"bid_value" xml:"bid_value"`
+	Source   string           `json:"source" xml:"source"`
+	// Derived and Components show a cross rate and the rates it came from,
+	// as upstream.Quotation does.
+	Derived    bool            `json:"derived,omitempty" xml:"derived,omitempty"`
+	Components []ComponentRate `json:"components,omitempty" xml:"components>rate,omitempty"`
+}
+
+// ComponentRate is a leg of a cross rate, its prices encoded like those of
+// upstream.Quotation.
+type ComponentRate struct {
+	Pair      string           `json:"pair" xml:"pair,attr"`
+	Bid       string           `json:"bid" xml:"bid"`
+	BidValue  upstream.Decimal `json:"bid_value" xml:"bid_value"`
+	Ask       string           `json:"ask" xml:"ask"`
+	AskValue  upstream.Decimal `json:"ask_value" xml:"ask_value"`
+	Timestamp string           `json:"timestamp" xml:"timestamp"`
+}
+
+// componentRates lists the legs of q, nil unless it is a cross rate.
+func componentRates(q upstream.Quotation) []ComponentRate {
+	var rates []ComponentRate
+	for _, c := range q.Components {
+		rates = append(rates, ComponentRate{
+			Pair:      c.Code + "-" + c.CodeIn,
+			Bid:       c.Bid,
+			BidValue:  c.BidValue,
+			Ask:       c.Ask,
+			AskValue:  c.AskValue,
+			Timestamp: c.Timestamp,
+		})
+	}
+	return rates
+}
+
+// FullQuotationResponse is a quotation of a ?full=true response.
+type FullQuotationResponse struct {
+	XMLName xml.Name `json:"-" xml:"quotation"`
+	Pair    string   `json:"-" xml:"pair,attr,omitempty"`
+	upstream.Quotation
+	Source string `json:"source" xml:"source"`
+}
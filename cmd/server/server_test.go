@@ -0,0 +1,451 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+// getCotacao serves GET target with cotacaoHandler.
+func getCotacao(t *testing.T, target string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	cotacaoHandler(w, httptest.NewRequest(http.MethodGet, target, nil))
+	return w
+}
+
+func TestCotacaoHandlerUpstream(t *testing.T) {
+	useSettings(t, nil)
+	mem := newMemoryStore()
+	useStore(t, mem)
+	fake := newFakeUpstream(t, http.StatusOK)
+	useChain(t, "awesomeapi", upstream.ChainConfig{BaseURL: fake.URL})
+
+	w := getCotacao(t, "/cotacao")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	var resp QuotationResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Bid != "5.1234" {
+		t.Errorf("body = %s, %v; want the bid of the fake upstream", w.Body, err)
+	}
+	if n := fake.hits.Load(); n != 1 {
+		t.Errorf("upstream got %d requests, want 1", n)
+	}
+	if got := w.Header().Get("X-Quotation-Provider"); got != "awesomeapi" {
+		t.Errorf("X-Quotation-Provider = %q, want awesomeapi", got)
+	}
+	if _, err := mem.latestQuotation(context.Background(), upstream.DefaultPair); err != nil {
+		t.Errorf("quotation wasn't stored: %v", err)
+	}
+}
+
+func TestCotacaoHandlerUpstreamError(t *testing.T) {
+	useSettings(t, nil)
+	useStore(t, newMemoryStore())
+	fake := newFakeUpstream(t, http.StatusInternalServerError)
+	useChain(t, "awesomeapi", upstream.ChainConfig{BaseURL: fake.URL})
+
+	w := getCotacao(t, "/cotacao")
+	if w.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, want %d; body %s", w.Code, http.StatusBadGateway, w.Body)
+	}
+	var resp Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Code != codeUpstreamError {
+		t.Errorf("body = %s, %v; want a problem with code %s", w.Body, err, codeUpstreamError)
+	}
+}
+
+// getLatest serves GET target with latestHandler.
+func getLatest(t *testing.T, target string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	latestHandler(w, httptest.NewRequest(http.MethodGet, target, nil))
+	return w
+}
+
+func TestLatestHandler(t *testing.T) {
+	stores := map[string]func(t *testing.T) storage{
+		"sqlite": func(t *testing.T) storage { return useDatabase(t) },
+		"memory": func(t *testing.T) storage {
+			mem := newMemoryStore()
+			useStore(t, mem)
+			return mem
+		},
+	}
+	for name, open := range stores {
+		t.Run(name, func(t *testing.T) {
+			useSettings(t, nil)
+			fake := newFakeUpstream(t, http.StatusOK)
+			useChain(t, "awesomeapi", upstream.ChainConfig{BaseURL: fake.URL})
+			s := open(t)
+
+			if w := getLatest(t, "/cotacao/latest"); w.Code != http.StatusNotFound {
+				t.Errorf("empty table: status = %d, want 404; body %s", w.Code, w.Body)
+			} else if ct := w.Header().Get("Content-Type"); ct != problemContentType {
+				t.Errorf("empty table: Content-Type = %q", ct)
+			}
+
+			// Inserted out of order: the newest is by timestamp, not rowid.
+			for _, q := range []upstream.Quotation{
+				{Code: "USD", CodeIn: "BRL", Bid: "5.2", Ask: "5.21", Timestamp: "1791968690", CreateDate: "2026-10-14 10:04:50"},
+				{Code: "USD", CodeIn: "BRL", Bid: "5.1", Ask: "5.11", Timestamp: "1791968680", CreateDate: "2026-10-14 10:04:40"},
+				{Code: "EUR", CodeIn: "BRL", Bid: "6.0", Ask: "6.01", Timestamp: "1791968700", CreateDate: "2026-10-14 10:05:00"},
+			} {
+				if err := s.saveQuotation(context.Background(), &q); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			tests := []struct {
+				target     string
+				wantStatus int
+				wantBid    string
+			}{
+				// Without a pair, the newest row of any pair.
+				{"/cotacao/latest", http.StatusOK, "6.0"},
+				{"/cotacao/latest?pair=usd-brl", http.StatusOK, "5.2"},
+				{"/cotacao/latest?pair=EUR-BRL", http.StatusOK, "6.0"},
+				{"/cotacao/latest?pair=GBP-BRL", http.StatusNotFound, ""},
+				{"/cotacao/latest?pair=USDBRL", http.StatusBadRequest, ""},
+				{"/cotacao/latest?pair=USD-BRL,EUR-BRL", http.StatusBadRequest, ""},
+			}
+			for _, tt := range tests {
+				w := getLatest(t, tt.target)
+				if w.Code != tt.wantStatus {
+					t.Errorf("GET %s status = %d, want %d; body %s", tt.target, w.Code, tt.wantStatus, w.Body)
+					continue
+				}
+				if tt.wantStatus != http.StatusOK {
+					continue
+				}
+				var q upstream.Quotation
+				if err := json.Unmarshal(w.Body.Bytes(), &q); err != nil || q.Bid != tt.wantBid || q.Ask == "" || q.CreateDate == "" {
+					t.Errorf("GET %s body = %s, %v; want the full quotation with bid %s", tt.target, w.Body, err, tt.wantBid)
+				}
+			}
+			if n := fake.hits.Load(); n != 0 {
+				t.Errorf("upstream got %d requests, want none", n)
+			}
+		})
+	}
+}
+
+func TestLatestHandlerDatabaseTimeout(t *testing.T) {
+	useSettings(t, nil)
+	useDatabase(t)
+	databaseTimeout = time.Nanosecond
+
+	w := getLatest(t, "/cotacao/latest")
+	var p Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil || w.Code != http.StatusServiceUnavailable || p.Code != codeDBTimeout {
+		t.Errorf("status = %d, body %s; want 503 %s", w.Code, w.Body, codeDBTimeout)
+	}
+}
+
+// jsonKeys returns the sorted keys of the JSON object in body.
+func jsonKeys(t *testing.T, body []byte) []string {
+	t.Helper()
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(body, &obj); err != nil {
+		t.Fatalf("body %s: %v", body, err)
+	}
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// TestCotacaoHandlerShapes pins both response contracts: the default one
+// must never grow by accident, ?full=true carries every Quotation field.
+func TestCotacaoHandlerShapes(t *testing.T) {
+	useSettings(t, nil)
+	useStore(t, newMemoryStore())
+	fake := newFakeUpstream(t, http.StatusOK)
+	useChain(t, "awesomeapi", upstream.ChainConfig{BaseURL: fake.URL})
+
+	minimal := []string{"bid", "bid_value", "source"}
+	full := []string{"ask", "ask_value", "bid", "bid_value", "code", "codein", "create_date", "high", "high_value",
+		"low", "low_value", "name", "pctChange", "source", "timestamp", "varBid"}
+	tests := []struct {
+		target string
+		want   []string
+	}{
+		{"/cotacao", minimal},
+		{"/cotacao?full=false", minimal},
+		{"/cotacao?full=true", full},
+		{"/cotacao?full=1", full},
+		{"/cotacao/USD-BRL?full=true", full},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			w := getCotacao(t, tt.target)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %s", w.Code, w.Body)
+			}
+			if got := jsonKeys(t, w.Body.Bytes()); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("keys = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("full values", func(t *testing.T) {
+		var q FullQuotationResponse
+		if err := json.Unmarshal(getCotacao(t, "/cotacao?full=true").Body.Bytes(), &q); err != nil {
+			t.Fatal(err)
+		}
+		if q.Bid != "5.1234" || q.Ask != "5.1240" || q.High != "5.2" || q.Low != "5.0" || q.CreateDate != "2026-10-14 10:00:00" {
+			t.Errorf("full quotation = %+v", q)
+		}
+	})
+	t.Run("invalid full", func(t *testing.T) {
+		if w := getCotacao(t, "/cotacao?full=yes"); w.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want 400", w.Code)
+		}
+	})
+}
+
+// failingWriter is a ResponseWriter whose body writes fail, as when the
+// client went away. It records every WriteHeader and the Content-Type in
+// effect when the status was sent.
+type failingWriter struct {
+	header      http.Header
+	statuses    []int
+	contentType string
+	writes      int
+}
+
+func newFailingWriter() *failingWriter {
+	return &failingWriter{header: make(http.Header)}
+}
+
+func (w *failingWriter) Header() http.Header { return w.header }
+
+func (w *failingWriter) WriteHeader(status int) {
+	w.statuses = append(w.statuses, status)
+	w.contentType = w.header.Get("Content-Type")
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(w.statuses) == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.writes++
+	return 0, errors.New("broken pipe")
+}
+
+func TestCotacaoHandlerFailingWriter(t *testing.T) {
+	useSettings(t, nil)
+	useStore(t, newMemoryStore())
+	fake := newFakeUpstream(t, http.StatusOK)
+	useChain(t, "awesomeapi", upstream.ChainConfig{BaseURL: fake.URL})
+
+	tests := []struct {
+		target          string
+		wantStatus      int
+		wantContentType string
+	}{
+		{"/cotacao", http.StatusOK, jsonContentType},
+		{"/cotacao?format=xml", http.StatusOK, xmlContentType},
+		{"/cotacao?format=csv", http.StatusOK, csvContentType},
+		{"/cotacao?pairs=USD-BRL,EUR-BRL&full=true", http.StatusOK, jsonContentType},
+		{"/cotacao?pair=nope", http.StatusBadRequest, problemContentType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			w := newFailingWriter()
+			cotacaoHandler(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if len(w.statuses) != 1 || w.statuses[0] != tt.wantStatus {
+				t.Errorf("WriteHeader calls = %v, want a single %d", w.statuses, tt.wantStatus)
+			}
+			if w.contentType != tt.wantContentType {
+				t.Errorf("Content-Type when the status was sent = %q, want %q", w.contentType, tt.wantContentType)
+			}
+			if w.writes != 1 {
+				t.Errorf("body written %d times after the failure, want 1", w.writes)
+			}
+		})
+	}
+}
+
+func TestSendEncodingFailure(t *testing.T) {
+	// JSON can't encode infinities, XML can't encode maps.
+	tests := []struct {
+		name string
+		send func(http.ResponseWriter) error
+	}{
+		{"json", func(w http.ResponseWriter) error { return sendJSON(w, http.StatusOK, math.Inf(1)) }},
+		{"xml", func(w http.ResponseWriter) error { return sendXML(w, http.StatusOK, map[string]string{"bid": "5.1"}) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			if err := tt.send(w); err == nil {
+				t.Fatal("want the encoding error")
+			}
+			var p Problem
+			if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+				t.Fatalf("body %q isn't a problem document: %v", w.Body, err)
+			}
+			if w.Code != http.StatusInternalServerError || p.Code != codeInternal {
+				t.Errorf("status %d code %q, want 500 %s", w.Code, p.Code, codeInternal)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != problemContentType {
+				t.Errorf("Content-Type = %q, want %q", ct, problemContentType)
+			}
+		})
+	}
+}
+
+func TestCotacaoHandlerFreshness(t *testing.T) {
+	useSettings(t, func(c *Config) { c.CacheTTL = Duration(time.Minute) })
+	mem := newMemoryStore()
+	useStore(t, mem)
+	fake := newFakeUpstream(t, http.StatusOK)
+	useChain(t, "awesomeapi", upstream.ChainConfig{BaseURL: fake.URL})
+	prevCache, prevMaxStale := cache, maxStale
+	t.Cleanup(func() { cache, maxStale = prevCache, prevMaxStale })
+	cache = newQuotationCache(4)
+
+	// A quotation stored 90s ago, recent enough for -max-stale.
+	maxStale = time.Hour
+	stored := upstream.Quotation{Code: "EUR", CodeIn: "BRL", Bid: "6.01", Timestamp: strconv.FormatInt(time.Now().Add(-90*time.Second).Unix(), 10)}
+	if err := mem.saveQuotation(context.Background(), &stored); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		target     string
+		wantSource string
+		minAge     int
+		maxAge     int
+	}{
+		{"upstream", "/cotacao", "upstream", 0, 2},
+		{"cache", "/cotacao", "cache", 0, 2},
+		{"db", "/cotacao?pair=EUR-BRL", "db", 90, 92},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := getCotacao(t, tt.target)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %s", w.Code, w.Body)
+			}
+			if got := w.Header().Get("X-Quotation-Source"); got != tt.wantSource {
+				t.Errorf("X-Quotation-Source = %q, want %q", got, tt.wantSource)
+			}
+			age, err := strconv.Atoi(w.Header().Get("X-Quotation-Age"))
+			if err != nil || age < tt.minAge || age > tt.maxAge {
+				t.Errorf("X-Quotation-Age = %q, want %d to %d", w.Header().Get("X-Quotation-Age"), tt.minAge, tt.maxAge)
+			}
+		})
+	}
+	if n := fake.hits.Load(); n != 1 {
+		t.Errorf("upstream got %d requests, want only the first", n)
+	}
+}
+
+func TestQuotationAge(t *testing.T) {
+	now := time.Date(2026, 10, 14, 13, 0, 0, 0, time.UTC)
+	unix := func(d time.Duration) string { return strconv.FormatInt(now.Add(d).Unix(), 10) }
+	tests := []struct {
+		name       string
+		quotations map[string]upstream.Quotation
+		want       time.Duration
+		ok         bool
+	}{
+		{"timestamp", map[string]upstream.Quotation{"USD-BRL": {Timestamp: unix(-30 * time.Second)}}, 30 * time.Second, true},
+		// 10:00 in São Paulo is 13:00 UTC.
+		{"create_date", map[string]upstream.Quotation{"USD-BRL": {CreateDate: "2026-10-14 09:58:00"}}, 2 * time.Minute, true},
+		{"oldest pair", map[string]upstream.Quotation{
+			"USD-BRL": {Timestamp: unix(-5 * time.Second)},
+			"EUR-BRL": {Timestamp: unix(-time.Hour)},
+		}, time.Hour, true},
+		{"from the future", map[string]upstream.Quotation{"USD-BRL": {Timestamp: unix(time.Minute)}}, 0, true},
+		{"undated pair ignored", map[string]upstream.Quotation{
+			"USD-BRL": {Timestamp: unix(-5 * time.Second)},
+			"EUR-BRL": {},
+		}, 5 * time.Second, true},
+		{"no dates", map[string]upstream.Quotation{"USD-BRL": {}}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pairs := make([]string, 0, len(tt.quotations))
+			for pair := range tt.quotations {
+				pairs = append(pairs, pair)
+			}
+			got, ok := quotationAge(pairs, tt.quotations, now)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("quotationAge() = %v, %v; want %v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+// TestCotacaoHandlerBreakerOpen opens the breaker with 2 upstream failures,
+// then expects the last stored quotation, or a fast 503 without one.
+func TestCotacaoHandlerBreakerOpen(t *testing.T) {
+	useSettings(t, nil)
+	mem := newMemoryStore()
+	useStore(t, mem)
+	fake := newFakeUpstream(t, http.StatusInternalServerError)
+	useChain(t, "awesomeapi", upstream.ChainConfig{BaseURL: fake.URL, BreakerThreshold: 2, BreakerCooldown: time.Minute})
+	stored := upstream.Quotation{Code: "USD", CodeIn: "BRL", Bid: "5.0001", Timestamp: strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)}
+	if err := mem.saveQuotation(context.Background(), &stored); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := getCotacao(t, "/cotacao?nocache=true"); w.Code != http.StatusBadGateway {
+			t.Fatalf("request %d while the upstream fails: status %d, want 502", i+1, w.Code)
+		}
+	}
+	if got := providers.Breaker().State(); got != upstream.BreakerOpen {
+		t.Fatalf("breaker = %s after 2 failures, want open", got)
+	}
+
+	w := getCotacao(t, "/cotacao?nocache=true")
+	var resp QuotationResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); w.Code != http.StatusOK || err != nil || resp.Bid != "5.0001" {
+		t.Fatalf("while open: status %d, body %s; want the stored quotation", w.Code, w.Body)
+	}
+	for header, want := range map[string]string{"X-Quotation-Stale": "true", "X-Quotation-Source": "db"} {
+		if got := w.Header().Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+
+	start := time.Now()
+	w = getCotacao(t, "/cotacao?pair=EUR-BRL")
+	if w.Code != http.StatusServiceUnavailable || problemCode(t, w) != codeUpstreamUnavailable {
+		t.Errorf("nothing stored: status %d, body %s; want 503 %s", w.Code, w.Body, codeUpstreamUnavailable)
+	}
+	if retry, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || retry < 1 || retry > 60 {
+		t.Errorf("Retry-After = %q, want the rest of the 1m cool-down", w.Header().Get("Retry-After"))
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("open breaker answered in %v, want it to fail fast", elapsed)
+	}
+	if n := fake.hits.Load(); n != 2 {
+		t.Errorf("upstream got %d requests, want only the 2 before the breaker opened", n)
+	}
+
+	hw := httptest.NewRecorder()
+	healthHandler(hw, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	var health HealthResponse
+	if err := json.Unmarshal(hw.Body.Bytes(), &health); err != nil || health.UpstreamBreaker != "open" {
+		t.Errorf("/healthz = %s, want upstream_breaker open", hw.Body)
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// mutableSettings are the config fields PATCH /admin/config may change while
+// the server runs, by their JSON names.
+var mutableSettings = []string{"request_timeout", "max_request_timeout", "cache_ttl", "rate", "burst", "poll_interval", "log_level"}
+
+// logLevel filters the access log written by logRequests.
+type logLevel int
+
+const (
+	logDebug logLevel = iota
+	logInfo
+	logWarn
+)
+
+func parseLogLevel(s string) (logLevel, bool) {
+	switch s {
+	case "debug":
+		return logDebug, true
+	case "info":
+		return logInfo, true
+	case "warn":
+		return logWarn, true
+	default:
+		return 0, false
+	}
+}
+
+// runtimeSettings is a snapshot of the settings that can change while the
+// server runs, along with the configuration they came from. A snapshot is
+// never modified: a change builds a new one and swaps the pointer, so readers
+// always see a consistent set without locking.
+type runtimeSettings struct {
+	config         *Config
+	requestTimeout time.Duration
+	maxTimeout     time.Duration
+	cacheTTL       time.Duration
+	rate           float64 // requests per second, 0 disables the rate limit
+	burst          float64
+	poll           time.Duration
+	logLevel       logLevel
+	changed        chan struct{} // closed once the snapshot is replaced
+}
+
+var (
+	settings atomic.Pointer[runtimeSettings]
+	// settingsMu serializes the changes, not the reads.
+	settingsMu sync.Mutex
+)
+
+func newRuntimeSettings(cfg *Config) (*runtimeSettings, error) {
+	s := &runtimeSettings{
+		config:         cfg,
+		requestTimeout: time.Duration(cfg.RequestTimeout),
+		maxTimeout:     time.Duration(cfg.MaxTimeout),
+		cacheTTL:       time.Duration(cfg.CacheTTL),
+		burst:          float64(cfg.Burst),
+		poll:           time.Duration(cfg.PollInterval),
+		changed:        make(chan struct{}),
+	}
+	if cfg.Rate != "" {
+		rps, err := parseRate(cfg.Rate)
+		if err != nil {
+			return nil, err
+		}
+		s.rate = rps
+	}
+	s.logLevel, _ = parseLogLevel(cfg.LogLevel)
+	return s, nil
+}
+
+// currentSettings returns the snapshot in effect.
+func currentSettings() *runtimeSettings {
+	return settings.Load()
+}
+
+// storeSettings puts s in effect and wakes whoever waits on the previous
+// snapshot, like the poll scheduler.
+func storeSettings(s *runtimeSettings) {
+	if old := settings.Swap(s); old != nil {
+		close(old.changed)
+	}
+}
+
+// ConfigChange is a row of the config_change table, the audit trail of PATCH
+// /admin/config. Values are JSON encoded.
+type ConfigChange struct {
+	Timestamp  time.Time `json:"timestamp"`
+	Field      string    `json:"field"`
+	OldValue   string    `json:"old_value"`
+	NewValue   string    `json:"new_value"`
+	RemoteAddr string    `json:"remote_addr"`
+}
+
+// settingsError is a PATCH /admin/config document that can't be applied.
+type settingsError struct {
+	msg string
+}
+
+func (e settingsError) Error() string {
+	return e.msg
+}
+
+// updateSettings applies patch, a partial config document, on top of the
+// configuration in effect. Only mutableSettings may appear in it, and the
+// result goes through the same validation as the startup configuration. The
+// changes are recorded in config_change before taking effect, so none goes
+// unaudited; a failure to record them leaves the settings untouched.
+func updateSettings(ctx context.Context, patch map[string]json.RawMessage, remoteAddr string) ([]ConfigChange, error) {
+	var unknown, immutable []string
+	known := configFields()
+	for name := range patch {
+		switch {
+		case !known[name]:
+			unknown = append(unknown, name)
+		case !isMutableSetting(name):
+			immutable = append(immutable, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, settingsError{"campos desconhecidos: " + strings.Join(unknown, ", ")}
+	}
+	if len(immutable) > 0 {
+		sort.Strings(immutable)
+		return nil, settingsError{fmt.Sprintf("campos não podem ser alterados em execução: %s (alteráveis: %s)",
+			strings.Join(immutable, ", "), strings.Join(mutableSettings, ", "))}
+	}
+
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+
+	current := currentSettings()
+	next := *current.config
+	for name, value := range patch {
+		doc, _ := json.Marshal(map[string]json.RawMessage{name: value})
+		if err := json.Unmarshal(doc, &next); err != nil {
+			return nil, settingsError{fmt.Sprintf("valor inválido para %s: %v", name, err)}
+		}
+	}
+	if err := next.validate(); err != nil {
+		return nil, settingsError{err.Error()}
+	}
+	s, err := newRuntimeSettings(&next)
+	if err != nil {
+		return nil, settingsError{err.Error()}
+	}
+
+	before, after := configValues(current.config), configValues(&next)
+	now := time.Now()
+	var changes []ConfigChange
+	for _, name := range mutableSettings {
+		if string(before[name]) != string(after[name]) {
+			changes = append(changes, ConfigChange{
+				Timestamp:  now,
+				Field:      name,
+				OldValue:   string(before[name]),
+				NewValue:   string(after[name]),
+				RemoteAddr: remoteAddr,
+			})
+		}
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	if err := store.insertConfigChanges(ctx, changes); err != nil {
+		return nil, err
+	}
+	storeSettings(s)
+	for _, c := range changes {
+		slog.InfoContext(ctx, "Admin - configuração alterada", "field", c.Field, "old", c.OldValue, "new", c.NewValue, "remote_addr", c.RemoteAddr)
+	}
+	return changes, nil
+}
+
+// reloadSettings reads the configuration again like at startup, from the
+// command line, the environment and the -config file, and applies the
+// mutableSettings that differ from the ones in effect through
+// updateSettings, source standing for the remote address in the audit trail.
+// The file is the reference: a value changed by PATCH /admin/config and not
+// in it goes back to the file or default value. Other fields that changed
+// need a restart; they are logged and left as they are.
+func reloadSettings(ctx context.Context, source string) ([]ConfigChange, error) {
+	cfg, err := LoadConfig(os.Args[1:])
+	if err != nil {
+		return nil, settingsError{"configuração inválida: " + err.Error()}
+	}
+
+	before, after := configValues(currentSettings().config), configValues(cfg)
+	patch := make(map[string]json.RawMessage)
+	var restart []string
+	for name, value := range after {
+		switch {
+		case string(before[name]) == string(value):
+		case isMutableSetting(name):
+			patch[name] = value
+		default:
+			restart = append(restart, name)
+		}
+	}
+	if len(restart) > 0 {
+		sort.Strings(restart)
+		slog.WarnContext(ctx, "Recarga - campos alterados só têm efeito após reiniciar", "fields", restart)
+	}
+	return updateSettings(ctx, patch, source)
+}
+
+// startReloadOnSIGHUP reloads the settings on every SIGHUP until ctx is
+// done.
+func startReloadOnSIGHUP(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	backgroundJobs.Add(1)
+	go func() {
+		defer backgroundJobs.Done()
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+			}
+			changes, err := reloadSettings(ctx, "SIGHUP")
+			if err != nil {
+				slog.Warn("Recarga - configuração mantida", "err", err)
+				continue
+			}
+			slog.Info("Recarga - configurações alteradas", "count", len(changes))
+		}
+	}()
+}
+
+func isMutableSetting(name string) bool {
+	for _, m := range mutableSettings {
+		if m == name {
+			return true
+		}
+	}
+	return false
+}
+
+// configFields returns the JSON names of the config fields.
+func configFields() map[string]bool {
+	fields := make(map[string]bool)
+	for name := range configValues(defaultConfig()) {
+		fields[name] = true
+	}
+	return fields
+}
+
+// configValues returns the JSON encoded value of each field of cfg, secrets
+// redacted.
+func configValues(cfg *Config) map[string]json.RawMessage {
+	var values map[string]json.RawMessage
+	b, _ := json.Marshal(cfg.redacted())
+	json.Unmarshal(b, &values)
+	return values
+}
+
+func (s *sqliteStore) insertConfigChanges(ctx context.Context, changes []ConfigChange) error {
+	dbCtx, cancel := context.WithTimeout(ctx, databaseTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(dbCtx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, c := range changes {
+		_, err = tx.ExecContext(dbCtx, `
+			INSERT INTO config_change(timestamp, field, old_value, new_value, remote_addr)
+			VALUES (?, ?, ?, ?, ?)
+		`, c.Timestamp.Unix(), c.Field, c.OldValue, c.NewValue, c.RemoteAddr)
+		if err != nil {
+			return fmt.Errorf("falha ao executar query. %w", err)
+		}
+	}
+	return tx.Commit()
+}
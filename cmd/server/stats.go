@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+// QuotationStats aggregates the stored quotations of a range. Day is only set
+// when the stats are grouped by day. Minimums and maximums are exact; averages
+// are rounded to the last decimal place.
+//
+// With a pair, ChangePct is the change of the average bid in percent, from the
+// first to the last day of the range or from the previous day, and StdDevBid,
+// for the whole range only, the standard deviation of the daily average bids.
+// SMA and EMA are the moving averages of the daily average bid keyed by
+// window, in days with quotations, as of the last day or of each day.
+type QuotationStats struct {
+	Day            string           `json:"day,omitempty"`
+	Count          int64            `json:"count"`
+	MinBid         upstream.Decimal `json:"min_bid"`
+	MaxBid         upstream.Decimal `json:"max_bid"`
+	AvgBid         upstream.Decimal `json:"avg_bid"`
+	MinAsk         upstream.Decimal `json:"min_ask"`
+	MaxAsk         upstream.Decimal `json:"max_ask"`
+	AvgAsk         upstream.Decimal `json:"avg_ask"`
+	FirstTimestamp *time.Time       `json:"first_timestamp,omitempty"`
+	LastTimestamp  *time.Time       `json:"last_timestamp,omitempty"`
+
+	ChangePct *upstream.Decimal           `json:"change_pct,omitempty"`
+	StdDevBid *upstream.Decimal           `json:"stddev_bid,omitempty"`
+	SMA       map[string]upstream.Decimal `json:"sma,omitempty"`
+	EMA       map[string]upstream.Decimal `json:"ema,omitempty"`
+}
+
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter, err := parseHistoryFilter(query)
+	if err != nil {
+		msg := fmt.Sprint("GET /cotacao/stats - ", err)
+		sendMsgError(w, codeBadRequest, msg, http.StatusBadRequest)
+		return
+	}
+
+	windows, err := parseStatsWindows(query.Get("windows"))
+	if err != nil {
+		msg := fmt.Sprint("GET /cotacao/stats - ", err)
+		sendMsgError(w, codeBadRequest, msg, http.StatusBadRequest)
+		return
+	}
+
+	info := requestInfoFrom(r.Context())
+	info.Pair = filter.Pair
+	dbStart := time.Now()
+	var body any
+	// The change and the averages mix the days of every pair without one,
+	// so they are left out.
+	switch group := query.Get("group"); group {
+	case "":
+		var stats *QuotationStats
+		stats, err = store.quotationStats(r.Context(), filter)
+		if err == nil && filter.Pair != "" {
+			err = summarizeDays(r.Context(), filter, windows, stats)
+		}
+		body = stats
+	case "day":
+		var days []*QuotationStats
+		days, err = store.dailyQuotationStats(r.Context(), filter)
+		if err == nil && filter.Pair != "" {
+			err = annotateDays(r.Context(), filter, windows, days)
+		}
+		body = days
+	default:
+		msg := fmt.Sprintf("GET /cotacao/stats - agrupamento inválido: %q", group)
+		sendMsgError(w, codeBadRequest, msg, http.StatusBadRequest)
+		return
+	}
+	info.DBDuration = time.Since(dbStart)
+	if err != nil {
+		statusCode, code := dbErrorStatus(err, codeDBReadFailed)
+		msg := fmt.Sprint("GET /cotacao/stats - ", err)
+		sendMsgError(w, code, msg, statusCode)
+		return
+	}
+
+	err = sendJSON(w, http.StatusOK, body)
+	if err != nil {
+		slog.WarnContext(r.Context(), "GET /cotacao/stats - falha ao enviar resposta", "err", err)
+	}
+}
+
+// statsColumns aggregates the rows of statsSource. Averages are weighted by
+// samples, so combining consolidated days with raw rows gives the same result
+// as averaging the raw rows alone.
+const statsColumns string = `
+	COALESCE(SUM(samples), 0),
+	MIN(min_bid),
+	MAX(max_bid),
+	SUM(avg_bid * samples) / SUM(samples),
+	MIN(min_ask),
+	MAX(max_ask),
+	SUM(avg_ask * samples) / SUM(samples),
+	MIN(first_timestamp),
+	MAX(last_timestamp)`
+
+// statsSource returns a subquery with per day (and pair) stats for f. Whole
+// day ranges are answered from cotacao_daily up to the last consolidated day;
+// the rest, like the current day, comes from the raw rows.
+func (s *sqliteStore) statsSource(ctx context.Context, f historyFilter) (string, []any, error) {
+	var until time.Time
+	if startOfDay(f.From).Equal(f.From.UTC()) && startOfDay(f.To).Equal(f.To.UTC()) {
+		var err error
+		until, err = s.rolledUntil(ctx)
+		if err != nil {
+			return "", nil, fmt.Errorf("falha ao consultar último dia consolidado. %w", err)
+		}
+		if !f.To.IsZero() && until.After(f.To) {
+			until = f.To
+		}
+	}
+
+	var (
+		source string
+		args   []any
+	)
+	if !until.IsZero() && until.After(f.From) {
+		source = `
+			SELECT day, samples, min_bid, max_bid, avg_bid, min_ask, max_ask, avg_ask, first_timestamp, last_timestamp
+			FROM cotacao_daily
+			WHERE day >= ? AND day < ?`
+		args = append(args, f.From.UTC().Format(dayLayout), until.Format(dayLayout))
+		if f.Pair != "" {
+			code, codeIn, _ := strings.Cut(f.Pair, "-")
+			source += " AND code = ? AND code_in = ?"
+			args = append(args, code, codeIn)
+		}
+		source += `
+			UNION ALL`
+		f.From = until
+	}
+
+	where, rawArgs := historyWhere(f)
+	source += `
+			SELECT
+				date(timestamp_unix, 'unixepoch') AS day,
+				COUNT(*) AS samples,
+				MIN(bid_units) AS min_bid,
+				MAX(bid_units) AS max_bid,
+				AVG(bid_units) AS avg_bid,
+				MIN(ask_units) AS min_ask,
+				MAX(ask_units) AS max_ask,
+				AVG(ask_units) AS avg_ask,
+				MIN(timestamp_unix) AS first_timestamp,
+				MAX(timestamp_unix) AS last_timestamp
+			FROM cotacao` + where + `
+			GROUP BY day`
+	return "(" + source + ")", append(args, rawArgs...), nil
+}
+
+func (s *sqliteStore) quotationStats(ctx context.Context, f historyFilter) (*QuotationStats, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, databaseTimeout)
+	defer cancel()
+
+	source, args, err := s.statsSource(dbCtx, f)
+	if err != nil {
+		return nil, err
+	}
+	row := s.readDB.QueryRowContext(dbCtx, "SELECT '',"+statsColumns+" FROM "+source, args...)
+	return scanStats(row)
+}
+
+func (s *sqliteStore) dailyQuotationStats(ctx context.Context, f historyFilter) ([]*QuotationStats, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, databaseTimeout)
+	defer cancel()
+
+	source, args, err := s.statsSource(dbCtx, f)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.readDB.QueryContext(dbCtx, `
+		SELECT day,`+statsColumns+`
+		FROM `+source+`
+		GROUP BY day
+		ORDER BY day`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao consultar estatísticas. %w", err)
+	}
+	defer rows.Close()
+
+	stats := []*QuotationStats{}
+	for rows.Next() {
+		s, err := scanStats(rows)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
+
+func scanStats(row interface{ Scan(...any) error }) (*QuotationStats, error) {
+	var (
+		s                              QuotationStats
+		minBid, maxBid, minAsk, maxAsk sql.NullInt64
+		avgBid, avgAsk                 sql.NullFloat64
+		firstTimestamp, lastTimestamp  sql.NullInt64
+	)
+	err := row.Scan(
+		&s.Day,
+		&s.Count,
+		&minBid,
+		&maxBid,
+		&avgBid,
+		&minAsk,
+		&maxAsk,
+		&avgAsk,
+		&firstTimestamp,
+		&lastTimestamp,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao ler estatísticas. %w", err)
+	}
+	// AVG of the integer units is computed by sqlite as a float, exact to the
+	// unit for any realistic price, and rounded back to a Decimal.
+	s.MinBid, s.MaxBid = upstream.Decimal(minBid.Int64), upstream.Decimal(maxBid.Int64)
+	s.AvgBid = upstream.Decimal(math.Round(avgBid.Float64))
+	s.MinAsk, s.MaxAsk = upstream.Decimal(minAsk.Int64), upstream.Decimal(maxAsk.Int64)
+	s.AvgAsk = upstream.Decimal(math.Round(avgAsk.Float64))
+	if firstTimestamp.Valid {
+		first := time.Unix(firstTimestamp.Int64, 0).UTC()
+		last := time.Unix(lastTimestamp.Int64, 0).UTC()
+		s.FirstTimestamp, s.LastTimestamp = &first, &last
+	}
+	return &s, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+// errNoDatabase is returned by noopStore for everything that needs stored
+// data. Handlers answer it with 501.
+var errNoDatabase = errors.New("persistência desativada (-no-db)")
+
+// storage is where quotations and the request log are kept: sqliteStore
+// normally, memoryStore with -db :memory: and noopStore when running with
+// -no-db.
+type storage interface {
+	saveQuotation(ctx context.Context, q *upstream.Quotation) error
+	saveQuotations(ctx context.Context, batch []upstream.Quotation) error
+	latestQuotation(ctx context.Context, pair string) (*upstream.Quotation, error)
+	quotationByID(ctx context.Context, id int64) (*StoredQuotation, error)
+	queryQuotations(ctx context.Context, f historyFilter, fn func(*upstream.Quotation) error) error
+	quotationPage(ctx context.Context, f historyFilter, p historyPage) ([]StoredQuotation, int64, error)
+	quotationStats(ctx context.Context, f historyFilter) (*QuotationStats, error)
+	dailyQuotationStats(ctx context.Context, f historyFilter) ([]*QuotationStats, error)
+	rollupDays(ctx context.Context, now time.Time) (int, error)
+	purgeQuotationsBefore(ctx context.Context, before time.Time) (int64, error)
+	purgeRequestLogBefore(ctx context.Context, before time.Time) (int64, error)
+	insertRequestLog(batch []RequestLogEntry) error
+	recentRequestLog(ctx context.Context, limit int) ([]RequestLogEntry, error)
+	insertConfigChanges(ctx context.Context, changes []ConfigChange) error
+	insertAPIKey(ctx context.Context, k *APIKey) error
+	apiKeyByHash(ctx context.Context, hash string) (*APIKey, error)
+	listAPIKeys(ctx context.Context) ([]APIKey, error)
+	revokeAPIKey(ctx context.Context, id int64, now time.Time) error
+	insertAlertRule(ctx context.Context, rule *AlertRule) error
+	alertRule(ctx context.Context, id int64) (*AlertRule, error)
+	alertRules(ctx context.Context) ([]AlertRule, error)
+	updateAlertRule(ctx context.Context, rule *AlertRule) error
+	deleteAlertRule(ctx context.Context, id int64) error
+	setAlertTriggered(ctx context.Context, id int64, at *time.Time) error
+	ping(ctx context.Context) error
+	close() error
+}
+
+// noopStore discards writes and fails reads with errNoDatabase, turning the
+// server into a pure proxy of the upstream.
+type noopStore struct{}
+
+func (noopStore) saveQuotation(context.Context, *upstream.Quotation) error { return nil }
+
+func (noopStore) saveQuotations(context.Context, []upstream.Quotation) error { return nil }
+
+func (noopStore) latestQuotation(context.Context, string) (*upstream.Quotation, error) {
+	return nil, errNoDatabase
+}
+
+func (noopStore) quotationByID(context.Context, int64) (*StoredQuotation, error) {
+	return nil, errNoDatabase
+}
+
+func (noopStore) queryQuotations(context.Context, historyFilter, func(*upstream.Quotation) error) error {
+	return errNoDatabase
+}
+
+func (noopStore) quotationPage(context.Context, historyFilter, historyPage) ([]StoredQuotation, int64, error) {
+	return nil, 0, errNoDatabase
+}
+
+func (noopStore) quotationStats(context.Context, historyFilter) (*QuotationStats, error) {
+	return nil, errNoDatabase
+}
+
+func (noopStore) dailyQuotationStats(context.Context, historyFilter) ([]*QuotationStats, error) {
+	return nil, errNoDatabase
+}
+
+func (noopStore) rollupDays(context.Context, time.Time) (int, error) {
+	return 0, errNoDatabase
+}
+
+func (noopStore) purgeQuotationsBefore(context.Context, time.Time) (int64, error) {
+	return 0, errNoDatabase
+}
+
+func (noopStore) purgeRequestLogBefore(context.Context, time.Time) (int64, error) {
+	return 0, errNoDatabase
+}
+
+func (noopStore) insertRequestLog([]RequestLogEntry) error { return nil }
+
+func (noopStore) insertConfigChanges(context.Context, []ConfigChange) error { return nil }
+
+func (noopStore) recentRequestLog(context.Context, int) ([]RequestLogEntry, error) {
+	return nil, errNoDatabase
+}
+
+func (noopStore) insertAPIKey(context.Context, *APIKey) error { return errNoDatabase }
+
+func (noopStore) apiKeyByHash(context.Context, string) (*APIKey, error) {
+	return nil, errNoDatabase
+}
+
+func (noopStore) listAPIKeys(context.Context) ([]APIKey, error) {
+	return nil, errNoDatabase
+}
+
+func (noopStore) revokeAPIKey(context.Context, int64, time.Time) error { return errNoDatabase }
+
+func (noopStore) insertAlertRule(context.Context, *AlertRule) error { return errNoDatabase }
+
+func (noopStore) alertRule(context.Context, int64) (*AlertRule, error) {
+	return nil, errNoDatabase
+}
+
+func (noopStore) alertRules(context.Context) ([]AlertRule, error) {
+	return nil, errNoDatabase
+}
+
+func (noopStore) updateAlertRule(context.Context, *AlertRule) error { return errNoDatabase }
+
+func (noopStore) deleteAlertRule(context.Context, int64) error { return errNoDatabase }
+
+func (noopStore) setAlertTriggered(context.Context, int64, *time.Time) error { return errNoDatabase }
+
+func (noopStore) ping(context.Context) error { return errNoDatabase }
+
+func (noopStore) close() error { return nil }
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+const (
+	streamHeartbeat  time.Duration = 15 * time.Second
+	subscriberBuffer int           = 8
+	// replayBuffer is how many events the hub keeps for /cotacao/events
+	// clients reconnecting with Last-Event-ID.
+	replayBuffer int = 64
+	// sseRetry is the reconnection delay suggested to EventSource clients.
+	sseRetry time.Duration = 3 * time.Second
+)
+
+// streamEvent is a quotation published by the hub, numbered in publication
+// order.
+type streamEvent struct {
+	id        uint64
+	quotation upstream.Quotation
+}
+
+// quotationHub fans out quotations to the connected stream subscribers. Only
+// quotations whose bid changed since the last publication of the pair are
+// delivered. The last replayBuffer events are kept so Server-Sent Events
+// clients can resume where they left off.
+type quotationHub struct {
+	mu          sync.Mutex
+	closed      bool
+	subscribers map[chan streamEvent]struct{}
+	lastBid     map[string]string
+	lastID      uint64
+	recent      []streamEvent
+}
+
+func newQuotationHub() *quotationHub {
+	return &quotationHub{
+		subscribers: make(map[chan streamEvent]struct{}),
+		lastBid:     make(map[string]string),
+	}
+}
+
+// subscribe registers a new subscriber. The returned function removes it and
+// must be called once the subscriber is gone. The channel is closed when the
+// hub shuts down.
+func (h *quotationHub) subscribe() (<-chan streamEvent, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.subscribeLocked()
+}
+
+// resume subscribes like subscribe and also returns the kept events published
+// after lastID, so nothing falls between the replay and the live events. An
+// id the hub hasn't reached, from before a restart, replays everything kept.
+func (h *quotationHub) resume(lastID uint64) ([]streamEvent, <-chan streamEvent, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var missed []streamEvent
+	for _, e := range h.recent {
+		if e.id > lastID || lastID > h.lastID {
+			missed = append(missed, e)
+		}
+	}
+	ch, unsubscribe := h.subscribeLocked()
+	return missed, ch, unsubscribe
+}
+
+func (h *quotationHub) subscribeLocked() (<-chan streamEvent, func()) {
+	ch := make(chan streamEvent, subscriberBuffer)
+	if h.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	h.subscribers[ch] = struct{}{}
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subscribers[ch]; ok {
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// publish delivers q to every subscriber if its bid changed, and updates the
+// cotacao_bid metric. Slow subscribers whose buffer is full miss the event
+// instead of blocking the publisher; Server-Sent Events clients get it back
+// when they reconnect.
+func (h *quotationHub) publish(q upstream.Quotation) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	pair := q.Code + "-" + q.CodeIn
+	// Every quotation fetched goes through here, whatever fetched it.
+	latestBid.WithLabelValues(pair).Set(q.BidValue.Float64())
+	if h.closed || h.lastBid[pair] == q.Bid {
+		return
+	}
+	h.lastBid[pair] = q.Bid
+	h.lastID++
+	e := streamEvent{id: h.lastID, quotation: q}
+	if len(h.recent) == replayBuffer {
+		copy(h.recent, h.recent[1:])
+		h.recent = h.recent[:replayBuffer-1]
+	}
+	h.recent = append(h.recent, e)
+	for ch := range h.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+func (h *quotationHub) subscriberCount() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subscribers)
+}
+
+// close disconnects every subscriber, letting stream handlers return so the
+// server shutdown doesn't wait on long lived connections.
+func (h *quotationHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for ch := range h.subscribers {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+}
+
+// startStreamPoller fetches the default pair every streamInterval while there
+// are subscribers, so the stream keeps moving without anyone calling
+// /cotacao.
+func startStreamPoller(ctx context.Context) {
+	if streamInterval <= 0 {
+		return
+	}
+
+	backgroundJobs.Add(1)
+	go func() {
+		defer backgroundJobs.Done()
+		ticker := time.NewTicker(streamInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if hub.subscriberCount() == 0 {
+				continue
+			}
+
+			fetchCtx, cancel := context.WithTimeout(ctx, currentSettings().requestTimeout)
+			quotations, _, err := providers.Fetch(fetchCtx, upstream.DefaultPair)
+			cancel()
+			if err != nil {
+				slog.Warn("Stream - falha ao buscar cotação", "err", err)
+				continue
+			}
+			hub.publish(quotations[upstream.DefaultPair])
+		}
+	}()
+}
+
+// streamHandler serves /cotacao/stream as a WebSocket when the client asks
+// for the upgrade, and as Server-Sent Events otherwise.
+func streamHandler(w http.ResponseWriter, r *http.Request) {
+	pair, ok := streamPair(w, r)
+	if !ok {
+		return
+	}
+	if isWebSocketUpgrade(r) {
+		serveWebSocket(w, r, pair)
+		return
+	}
+	serveEvents(w, r, pair)
+}
+
+// eventsHandler serves /cotacao/events, the Server-Sent Events stream for
+// clients that can't use WebSockets.
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	pair, ok := streamPair(w, r)
+	if !ok {
+		return
+	}
+	serveEvents(w, r, pair)
+}
+
+// streamPair returns the pair of the ?pair= filter of a stream request,
+// empty for every pair.
+func streamPair(w http.ResponseWriter, r *http.Request) (string, bool) {
+	raw := r.URL.Query().Get("pair")
+	if raw == "" {
+		return "", true
+	}
+	pairs, err := upstream.ParsePairs(raw)
+	if err != nil || len(pairs) != 1 {
+		msg := fmt.Sprint("GET ", r.URL.Path, " - par inválido: ", raw)
+		sendMsgError(w, codeBadRequest, msg, http.StatusBadRequest)
+		return "", false
+	}
+	return pairs[0], true
+}
+
+// serveEvents streams the quotations of pair (every pair when empty) as
+// Server-Sent Events. Each event carries its hub id, so a client
+// reconnecting with Last-Event-ID first gets the kept events it missed.
+func serveEvents(w http.ResponseWriter, r *http.Request, pair string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		sendMsgError(w, codeInternal, "GET "+r.URL.Path+" - streaming não suportado", http.StatusInternalServerError)
+		return
+	}
+
+	var (
+		missed      []streamEvent
+		events      <-chan streamEvent
+		unsubscribe func()
+	)
+	if raw := r.Header.Get("Last-Event-ID"); raw != "" {
+		lastID, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			msg := fmt.Sprint("GET ", r.URL.Path, " - Last-Event-ID inválido: ", raw)
+			sendMsgError(w, codeBadRequest, msg, http.StatusBadRequest)
+			return
+		}
+		missed, events, unsubscribe = hub.resume(lastID)
+	} else {
+		events, unsubscribe = hub.subscribe()
+	}
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", sseRetry.Milliseconds()); err != nil {
+		return
+	}
+	for _, e := range missed {
+		if err := writeEvent(w, r, pair, e); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := writeEvent(w, r, pair, e); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes e as a quotation event unless it's filtered out by pair.
+// Only write failures are returned; a quotation that can't be encoded is
+// logged and skipped.
+func writeEvent(w http.ResponseWriter, r *http.Request, pair string, e streamEvent) error {
+	q := e.quotation
+	if pair != "" && q.Code+"-"+q.CodeIn != pair {
+		return nil
+	}
+	data, err := json.Marshal(q)
+	if err != nil {
+		slog.WarnContext(r.Context(), "GET "+r.URL.Path+" - falha ao codificar cotação", "err", err)
+		return nil
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: quotation\ndata: %s\n\n", e.id, data)
+	return err
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+// selfSignedValidity is short on purpose: -tls-self-signed is for
+// development, and the certificate is generated again on every start.
+const selfSignedValidity time.Duration = 30 * 24 * time.Hour
+
+var errMissingClientCert = errors.New("certificado de cliente ausente ou não confiável")
+
+// newTLSConfig builds the TLS settings shared by the HTTP and gRPC servers,
+// or returns nil when none of -tls-cert, -tls-self-signed and -acme-domain
+// is set. With -acme-domain the certificate comes from acmeManager. With
+// -tls-client-ca, client certificates signed by that CA are verified when
+// presented; requireClientCert then demands one on the admin endpoints.
+func newTLSConfig(cfg *Config) (*tls.Config, error) {
+	if cfg.TLSCert == "" && !cfg.TLSSelfSigned && cfg.ACMEDomain == "" {
+		return nil, nil
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	switch {
+	case cfg.ACMEDomain != "":
+		m, err := newACMEManager(cfg)
+		if err != nil {
+			return nil, err
+		}
+		acmeManager = m
+		config.GetCertificate = m.GetCertificate
+		config.NextProtos = []string{"h2", "http/1.1", acme.ALPNProto}
+	case cfg.TLSSelfSigned:
+		cert, err := selfSignedCert(time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("falha ao gerar certificado autoassinado: %w", err)
+		}
+		fingerprint := sha256.Sum256(cert.Certificate[0])
+		slog.Info("Certificado autoassinado gerado", "sha256", fmt.Sprintf("%X", fingerprint))
+		config.Certificates = []tls.Certificate{cert}
+	default:
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("%v - %s", err, tlsCertUsage)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if cfg.TLSClientCA != "" {
+		pem, err := os.ReadFile(cfg.TLSClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("%v - %s", err, tlsClientCAUsage)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("nenhum certificado em %s - %s", cfg.TLSClientCA, tlsClientCAUsage)
+		}
+		// The quotation endpoints stay open to clients without certificates.
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		config.ClientCAs = pool
+	}
+	return config, nil
+}
+
+// selfSignedCert generates an ECDSA certificate for localhost, the loopback
+// addresses and the host name.
+func selfSignedCert(now time.Time) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	names := []string{"localhost"}
+	if host, err := os.Hostname(); err == nil && host != "localhost" {
+		names = append(names, host)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "cotacao-server"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              names,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// requireClientCert rejects requests without a client certificate verified
+// against -tls-client-ca. It does nothing when -tls-client-ca is not set.
+func requireClientCert(next http.Handler) http.Handler {
+	if tlsConfig == nil || tlsConfig.ClientCAs == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			sendMsgError(w, codeForbidden, errMissingClientCert.Error(), http.StatusForbidden)
+			return
+		}
+		slog.InfoContext(r.Context(), "Certificado de cliente aceito", "method", r.Method, "path", r.URL.Path, "subject", r.TLS.VerifiedChains[0][0].Subject.CommonName)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/twsm000/goxp-client-server-api/internal/tracing"
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+// Warm-up states, as reported by /healthz.
+const (
+	warmupPending int32 = iota
+	warmupOK
+	warmupFailed
+	warmupSkipped
+)
+
+// warmupState holds the outcome of the startup warm-up.
+var warmupState atomic.Int32
+
+func warmupStatus() string {
+	switch warmupState.Load() {
+	case warmupOK:
+		return "ok"
+	case warmupFailed:
+		return "failed"
+	case warmupSkipped:
+		return "skipped"
+	default:
+		return "pending"
+	}
+}
+
+// startWarmup fetches the default pair once before the first request needs
+// it, filling the cache and storing the row through the same path as POST
+// /cotacao/refresh, which also finds a misconfigured upstream early. A failure
+// is only a warning unless -strict-warmup is set, in which case the server
+// exits; for that reason the strict warm-up runs before the server starts
+// listening while the lenient one runs in the background.
+func startWarmup(ctx context.Context) {
+	if noWarmup {
+		warmupState.Store(warmupSkipped)
+		return
+	}
+	if strictWarmup {
+		if err := warmUp(ctx); err != nil {
+			fatal("Aquecimento falhou com -strict-warmup", "err", err)
+		}
+		return
+	}
+
+	backgroundJobs.Add(1)
+	go func() {
+		defer backgroundJobs.Done()
+		if err := warmUp(ctx); err != nil {
+			slog.Warn("Aquecimento falhou, servidor segue sem cache", "err", err)
+		}
+	}()
+}
+
+func warmUp(ctx context.Context) (err error) {
+	ctx, span := tracer.Start(ctx, "warmup", tracing.KindInternal)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	start := time.Now()
+	result := refreshQuotations(ctx, warmupTimeout, []string{upstream.DefaultPair})
+	if result.err != nil {
+		warmupState.Store(warmupFailed)
+		return result.err
+	}
+	warmupState.Store(warmupOK)
+	slog.Info("Aquecimento concluído", "duration", time.Since(start).Round(time.Millisecond),
+		"provider", result.provider, "pair", upstream.DefaultPair, "bid", result.quotations[upstream.DefaultPair].Bid)
+	return nil
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	wsWriteTimeout time.Duration = 10 * time.Second
+	// wsPongWait is how long the connection may stay silent; each ping sent
+	// every streamHeartbeat should be answered well before it.
+	wsPongWait     time.Duration = 2 * streamHeartbeat
+	wsMaxFrameSize int64         = 4096
+	// wsGUID is appended to Sec-WebSocket-Key to compute the accept key, as
+	// RFC 6455 section 4.2.2 defines.
+	wsGUID string = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+// WebSocket opcodes and close codes used by the stream.
+const (
+	wsContinuation byte = 0x0
+	wsText         byte = 0x1
+	wsBinary       byte = 0x2
+	wsClose        byte = 0x8
+	wsPing         byte = 0x9
+	wsPong         byte = 0xA
+
+	wsCloseGoingAway     uint16 = 1001
+	wsCloseProtocolError uint16 = 1002
+	wsCloseTooBig        uint16 = 1009
+)
+
+var errWSProtocol = errors.New("websocket: frame inválido")
+
+// isWebSocketUpgrade reports whether r asks to switch to the WebSocket
+// protocol.
+func isWebSocketUpgrade(r *http.Request) bool {
+	return headerHasToken(r.Header, "Connection", "upgrade") &&
+		strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsConn is a server side WebSocket connection. Writes come from the stream
+// loop and from the reader answering pings, so they're serialized.
+type wsConn struct {
+	conn net.Conn
+	buf  *bufio.Reader
+	mu   sync.Mutex
+}
+
+// upgradeWebSocket completes the opening handshake and takes over the
+// connection from net/http. On failure the response has already been sent.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, bool) {
+	if origin := r.Header.Get("Origin"); !allowedWebSocketOrigin(r, origin) {
+		msg := fmt.Sprint("GET /cotacao/stream - origem não permitida: ", origin)
+		sendMsgError(w, codeOriginNotAllowed, msg, http.StatusForbidden)
+		return nil, false
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		sendMsgError(w, codeBadRequest, "GET /cotacao/stream - versão de WebSocket não suportada", http.StatusUpgradeRequired)
+		return nil, false
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		sendMsgError(w, codeBadRequest, "GET /cotacao/stream - Sec-WebSocket-Key ausente", http.StatusBadRequest)
+		return nil, false
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		sendMsgError(w, codeInternal, "GET /cotacao/stream - WebSocket não suportado", http.StatusInternalServerError)
+		return nil, false
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		slog.WarnContext(r.Context(), "GET /cotacao/stream - falha ao assumir conexão", "err", err)
+		return nil, false
+	}
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	accept := base64.StdEncoding.EncodeToString(sum[:])
+	conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	_, err = fmt.Fprintf(conn, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", accept)
+	if err != nil {
+		conn.Close()
+		return nil, false
+	}
+	return &wsConn{conn: conn, buf: rw.Reader}, true
+}
+
+// allowedWebSocketOrigin tells whether a page from origin may open the
+// stream. Browsers don't apply CORS to WebSocket handshakes, so the origin is
+// checked here against -cors-origins, or, with CORS disabled, against the
+// host of the server itself. Clients that send no Origin aren't browsers.
+func allowedWebSocketOrigin(r *http.Request, origin string) bool {
+	if origin == "" {
+		return true
+	}
+	if cors != nil {
+		return cors.allowsOrigin(origin)
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// writeFrame sends a single unfragmented frame, in one write. Server frames
+// are never masked.
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	header := make([]byte, 2, 10+len(payload))
+	header[0] = 0x80 | opcode
+	switch n := len(payload); {
+	case n <= 125:
+		header[1] = byte(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	_, err := c.conn.Write(append(header, payload...))
+	return err
+}
+
+func (c *wsConn) writeClose(code uint16) error {
+	return c.writeFrame(wsClose, binary.BigEndian.AppendUint16(nil, code))
+}
+
+// readLoop handles the frames sent by the client until the connection fails
+// or the client closes it: pings are answered, pongs extend the read
+// deadline and messages are discarded, the stream being one way.
+func (c *wsConn) readLoop() {
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	for {
+		opcode, payload, err := c.readFrame()
+		if err != nil {
+			if errors.Is(err, errWSProtocol) {
+				c.writeClose(wsCloseProtocolError)
+			}
+			return
+		}
+		switch opcode {
+		case wsClose:
+			c.writeFrame(wsClose, payload)
+			return
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload); err != nil {
+				return
+			}
+		case wsPong:
+			c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		}
+	}
+}
+
+// readFrame reads a frame from the client, unmasking control frames. Data
+// frame payloads are skipped; frames above wsMaxFrameSize close the
+// connection.
+func (c *wsConn) readFrame() (byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.buf, head[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := head[0] & 0x0F
+	masked := head[1]&0x80 != 0
+	size := int64(head[1] & 0x7F)
+	// Clients must mask, no extension setting the reserved bits was
+	// negotiated, and control frames can't be fragmented.
+	fin, reserved := head[0]&0x80 != 0, head[0]&0x70
+	control := opcode >= wsClose
+	if !masked || reserved != 0 || (control && !fin) || (opcode > wsBinary && opcode < wsClose) || opcode > wsPong {
+		return 0, nil, errWSProtocol
+	}
+	switch size {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.buf, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		size = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.buf, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		size = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+	if size < 0 || size > wsMaxFrameSize {
+		c.writeClose(wsCloseTooBig)
+		return 0, nil, fmt.Errorf("websocket: frame de %d bytes", size)
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.buf, mask[:]); err != nil {
+		return 0, nil, err
+	}
+
+	if !control {
+		_, err := io.CopyN(io.Discard, c.buf, size)
+		return opcode, nil, err
+	}
+	if size > 125 {
+		return 0, nil, errWSProtocol
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(c.buf, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
+
+// serveWebSocket pushes the quotations of pair (every pair when empty) to a
+// WebSocket client as text messages, pinging it every streamHeartbeat. The
+// connection is closed when the client goes away, stops answering or the
+// hub shuts down.
+func serveWebSocket(w http.ResponseWriter, r *http.Request, pair string) {
+	ws, ok := upgradeWebSocket(w, r)
+	if !ok {
+		return
+	}
+	defer ws.conn.Close()
+
+	events, unsubscribe := hub.subscribe()
+	defer unsubscribe()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ws.readLoop()
+	}()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case <-done:
+			return
+		case <-heartbeat.C:
+			err = ws.writeFrame(wsPing, nil)
+		case e, ok := <-events:
+			if !ok {
+				ws.writeClose(wsCloseGoingAway)
+				return
+			}
+			q := e.quotation
+			if pair != "" && q.Code+"-"+q.CodeIn != pair {
+				continue
+			}
+			data, jsonErr := json.Marshal(q)
+			if jsonErr != nil {
+				slog.WarnContext(r.Context(), "GET /cotacao/stream - falha ao codificar cotação", "err", jsonErr)
+				continue
+			}
+			err = ws.writeFrame(wsText, data)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
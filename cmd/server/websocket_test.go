@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// wsFrame is a frame as seen on the wire, before masking.
+type wsFrame struct {
+	opcode  byte
+	payload []byte
+}
+
+// clientFrame encodes a frame as a client sends it: masked, with the
+// shortest length encoding unless long forces the 64 bit one.
+func clientFrame(fin bool, opcode byte, payload []byte, long bool) []byte {
+	var b bytes.Buffer
+	head := opcode
+	if fin {
+		head |= 0x80
+	}
+	b.WriteByte(head)
+	switch n := len(payload); {
+	case long:
+		b.WriteByte(0x80 | 127)
+		binary.Write(&b, binary.BigEndian, uint64(n))
+	case n <= 125:
+		b.WriteByte(0x80 | byte(n))
+	default:
+		b.WriteByte(0x80 | 126)
+		binary.Write(&b, binary.BigEndian, uint16(n))
+	}
+	mask := [4]byte{0x37, 0xfa, 0x21, 0x3d}
+	b.Write(mask[:])
+	for i, c := range payload {
+		b.WriteByte(c ^ mask[i%4])
+	}
+	return b.Bytes()
+}
+
+// readServerFrame reads a frame sent by the server, failing the test when
+// it is masked or fragmented.
+func readServerFrame(t *testing.T, r io.Reader) wsFrame {
+	t.Helper()
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		t.Fatalf("reading frame: %v", err)
+	}
+	if head[0]&0x80 == 0 || head[1]&0x80 != 0 {
+		t.Fatalf("frame header %08b %08b: want FIN set and no mask", head[0], head[1])
+	}
+	size := uint64(head[1] & 0x7F)
+	switch size {
+	case 126:
+		var ext uint16
+		binary.Read(r, binary.BigEndian, &ext)
+		size = uint64(ext)
+	case 127:
+		binary.Read(r, binary.BigEndian, &size)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatalf("reading %d byte payload: %v", size, err)
+	}
+	return wsFrame{head[0] & 0x0F, payload}
+}
+
+func closePayload(code uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, code)
+}
+
+func TestWSReadLoop(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames [][]byte
+		want   []wsFrame
+	}{
+		{
+			"ping is answered",
+			[][]byte{clientFrame(true, wsPing, []byte("hi"), false), clientFrame(true, wsClose, closePayload(1000), false)},
+			[]wsFrame{{wsPong, []byte("hi")}, {wsClose, closePayload(1000)}},
+		},
+		{
+			"fragmented message with a ping in between",
+			[][]byte{
+				clientFrame(false, wsText, []byte("ab"), false),
+				clientFrame(true, wsPing, []byte("x"), false),
+				clientFrame(true, wsContinuation, []byte("cd"), false),
+				clientFrame(true, wsClose, nil, false),
+			},
+			[]wsFrame{{wsPong, []byte("x")}, {wsClose, []byte{}}},
+		},
+		{
+			"data frames are skipped",
+			[][]byte{
+				clientFrame(true, wsBinary, bytes.Repeat([]byte{1}, 300), false),
+				clientFrame(true, wsText, bytes.Repeat([]byte{2}, 3000), true),
+				clientFrame(true, wsPing, nil, false),
+				clientFrame(true, wsClose, nil, false),
+			},
+			[]wsFrame{{wsPong, []byte{}}, {wsClose, []byte{}}},
+		},
+		{
+			"pong is not answered",
+			[][]byte{clientFrame(true, wsPong, nil, false), clientFrame(true, wsClose, nil, false)},
+			[]wsFrame{{wsClose, []byte{}}},
+		},
+		{
+			"unmasked frame",
+			[][]byte{{0x81, 0x02, 'h', 'i'}},
+			[]wsFrame{{wsClose, closePayload(wsCloseProtocolError)}},
+		},
+		{
+			"reserved bit",
+			[][]byte{append([]byte{0xC1}, clientFrame(true, wsText, []byte("hi"), false)[1:]...)},
+			[]wsFrame{{wsClose, closePayload(wsCloseProtocolError)}},
+		},
+		{
+			"reserved opcode",
+			[][]byte{clientFrame(true, 0x3, nil, false)},
+			[]wsFrame{{wsClose, closePayload(wsCloseProtocolError)}},
+		},
+		{
+			"fragmented ping",
+			[][]byte{clientFrame(false, wsPing, []byte("x"), false)},
+			[]wsFrame{{wsClose, closePayload(wsCloseProtocolError)}},
+		},
+		{
+			"control frame over 125 bytes",
+			[][]byte{clientFrame(true, wsPing, bytes.Repeat([]byte{'p'}, 126), false)},
+			[]wsFrame{{wsClose, closePayload(wsCloseProtocolError)}},
+		},
+		{
+			"frame over wsMaxFrameSize",
+			[][]byte{clientFrame(true, wsText, make([]byte, wsMaxFrameSize+1), false)},
+			[]wsFrame{{wsClose, closePayload(wsCloseTooBig)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer client.Close()
+			ws := &wsConn{conn: server, buf: bufio.NewReader(server)}
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				defer server.Close()
+				ws.readLoop()
+			}()
+			// The server may stop reading halfway, so the frames are written
+			// while the answers are read.
+			go func() {
+				for _, f := range tt.frames {
+					if _, err := client.Write(f); err != nil {
+						return
+					}
+				}
+			}()
+
+			client.SetReadDeadline(time.Now().Add(5 * time.Second))
+			for i, want := range tt.want {
+				got := readServerFrame(t, client)
+				if got.opcode != want.opcode || !bytes.Equal(got.payload, want.payload) {
+					t.Errorf("frame %d = %#x %v, want %#x %v", i, got.opcode, got.payload, want.opcode, want.payload)
+				}
+			}
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("readLoop didn't return")
+			}
+		})
+	}
+}
+
+func TestWSWriteFrame(t *testing.T) {
+	tests := []struct {
+		size     int
+		wantHead []byte
+	}{
+		{0, []byte{0x81, 0}},
+		{125, []byte{0x81, 125}},
+		{126, []byte{0x81, 126, 0, 126}},
+		{0xFFFF, []byte{0x81, 126, 0xFF, 0xFF}},
+		{0x10000, []byte{0x81, 127, 0, 0, 0, 0, 0, 1, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.size), func(t *testing.T) {
+			server, client := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+			ws := &wsConn{conn: server}
+			payload := bytes.Repeat([]byte{'q'}, tt.size)
+			errc := make(chan error, 1)
+			go func() { errc <- ws.writeFrame(wsText, payload) }()
+
+			client.SetReadDeadline(time.Now().Add(5 * time.Second))
+			head := make([]byte, len(tt.wantHead))
+			if _, err := io.ReadFull(client, head); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(head, tt.wantHead) {
+				t.Errorf("header = %v, want %v", head, tt.wantHead)
+			}
+			got := make([]byte, tt.size)
+			if _, err := io.ReadFull(client, got); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Error("payload was changed")
+			}
+			if err := <-errc; err != nil {
+				t.Errorf("writeFrame() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestUpgradeWebSocket(t *testing.T) {
+	useSettings(t, nil)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, ok := upgradeWebSocket(w, r)
+		if !ok {
+			return
+		}
+		defer ws.conn.Close()
+		ws.writeFrame(wsText, []byte("hello"))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name       string
+		version    string
+		key        string
+		origin     string
+		wantStatus int
+	}{
+		// The key and accept value of the example in RFC 6455 section 1.3.
+		{"handshake", "13", "dGhlIHNhbXBsZSBub25jZQ==", "", http.StatusSwitchingProtocols},
+		{"same origin", "13", "dGhlIHNhbXBsZSBub25jZQ==", srv.URL, http.StatusSwitchingProtocols},
+		{"other origin", "13", "dGhlIHNhbXBsZSBub25jZQ==", "https://evil.example", http.StatusForbidden},
+		{"unsupported version", "8", "dGhlIHNhbXBsZSBub25jZQ==", "", http.StatusUpgradeRequired},
+		{"missing key", "13", "", "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			req, _ := http.NewRequest(http.MethodGet, srv.URL+"/cotacao/stream", nil)
+			req.Header.Set("Connection", "Upgrade")
+			req.Header.Set("Upgrade", "websocket")
+			req.Header.Set("Sec-WebSocket-Version", tt.version)
+			if tt.key != "" {
+				req.Header.Set("Sec-WebSocket-Key", tt.key)
+			}
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if err := req.Write(conn); err != nil {
+				t.Fatal(err)
+			}
+			br := bufio.NewReader(conn)
+			resp, err := http.ReadResponse(br, req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			switch resp.StatusCode {
+			case http.StatusSwitchingProtocols:
+				if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+					t.Errorf("Sec-WebSocket-Accept = %q", got)
+				}
+				if f := readServerFrame(t, br); f.opcode != wsText || string(f.payload) != "hello" {
+					t.Errorf("first frame = %#x %q, want text hello", f.opcode, f.payload)
+				}
+			case http.StatusUpgradeRequired:
+				if got := resp.Header.Get("Sec-WebSocket-Version"); got != "13" {
+					t.Errorf("Sec-WebSocket-Version = %q, want 13", got)
+				}
+			}
+		})
+	}
+}
+
+func TestAllowedWebSocketOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		origins string
+		origin  string
+		want    bool
+	}{
+		{"no origin", "", "", true},
+		{"same host without cors", "", "http://example.com", true},
+		{"other host without cors", "", "http://evil.example", false},
+		{"invalid origin without cors", "", "http://[::1", false},
+		{"allowed origin", "https://app.example", "https://app.example", true},
+		{"origin outside the allowlist", "https://app.example", "https://evil.example", false},
+		{"same host outside the allowlist", "https://app.example", "http://example.com", false},
+		{"any origin", "*", "https://evil.example", true},
+	}
+	defer func(orig *corsPolicy) { cors = orig }(cors)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cors = newCORSPolicy(tt.origins, defaultCORSMethods, defaultCORSHeaders)
+			r := httptest.NewRequest(http.MethodGet, "http://example.com/cotacao/stream", nil)
+			if got := allowedWebSocketOrigin(r, tt.origin); got != tt.want {
+				t.Errorf("allowedWebSocketOrigin(%q) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
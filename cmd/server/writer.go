@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+const (
+	asyncQueueSize int = 256
+	// asyncMaxAttempts bounds the saves of a batch while the database is
+	// busy, waiting asyncRetryBackoff, then twice as long each time.
+	asyncMaxAttempts  int           = 5
+	asyncRetryBackoff time.Duration = 50 * time.Millisecond
+)
+
+// asyncWriter persists quotations in a dedicated goroutine, taking the
+// database insert out of the request path. Quotations are buffered and saved
+// in batches of up to flushSize, at least every flushInterval.
+type asyncWriter struct {
+	mu            sync.RWMutex
+	closed        bool
+	queue         chan upstream.Quotation
+	done          chan struct{}
+	flushSize     int
+	flushInterval time.Duration
+}
+
+func newAsyncWriter(size, flushSize int, flushInterval time.Duration) *asyncWriter {
+	a := &asyncWriter{
+		queue:         make(chan upstream.Quotation, size),
+		done:          make(chan struct{}),
+		flushSize:     flushSize,
+		flushInterval: flushInterval,
+	}
+	go a.run()
+	return a
+}
+
+func (a *asyncWriter) run() {
+	defer close(a.done)
+	ticker := time.NewTicker(a.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]upstream.Quotation, 0, a.flushSize)
+	for {
+		select {
+		case cotacao, ok := <-a.queue:
+			if !ok {
+				a.flush(batch)
+				return
+			}
+			batch = append(batch, cotacao)
+			if len(batch) < a.flushSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+		a.flush(batch)
+		batch = batch[:0]
+	}
+}
+
+// flush saves batch, retrying once, and for as long as asyncMaxAttempts
+// allows while the database is busy. A batch that still fails is logged
+// quotation by quotation, so the data can be recovered from the logs.
+func (a *asyncWriter) flush(batch []upstream.Quotation) {
+	if len(batch) == 0 {
+		return
+	}
+	backoff := asyncRetryBackoff
+	var err error
+	for attempt := 1; attempt <= asyncMaxAttempts; attempt++ {
+		// The requests that produced the quotations are long gone, so the
+		// insert gets its own context bounded by databaseTimeout.
+		err = store.saveQuotations(context.Background(), batch)
+		if err == nil || (attempt > 1 && !isBusy(err)) || attempt == asyncMaxAttempts {
+			break
+		}
+		slog.Warn("Escrita assíncrona - falha ao salvar lote, tentando novamente", "size", len(batch), "backoff", backoff, "err", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	if err != nil {
+		for _, cotacao := range batch {
+			slog.Error("Escrita assíncrona - falha ao salvar cotação", "pair", cotacao.Code+"-"+cotacao.CodeIn, "timestamp", cotacao.Timestamp, "err", err)
+		}
+	}
+}
+
+// enqueue hands q to the writer. It returns false when the queue is full or
+// already closed, in which case the caller must save it itself.
+func (a *asyncWriter) enqueue(q upstream.Quotation) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		return false
+	}
+	select {
+	case a.queue <- q:
+		return true
+	default:
+		return false
+	}
+}
+
+// close stops accepting quotations and waits until the queued and buffered
+// ones are saved.
+func (a *asyncWriter) close() {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.queue)
+	}
+	a.mu.Unlock()
+	<-a.done
+}
+
+// persistQuotation saves q through the async writer when enabled, falling
+// back to a synchronous insert when the queue can't take it so nothing is
+// dropped. A cross rate saves its legs instead, and is derived from them
+// again when read.
+func persistQuotation(ctx context.Context, q *upstream.Quotation) error {
+	if q.Derived {
+		for i := range q.Components {
+			if err := persistQuotation(ctx, &q.Components[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if writer != nil {
+		if writer.enqueue(*q) {
+			return nil
+		}
+		slog.Warn("Escrita assíncrona - fila cheia, salvando de forma síncrona")
+	}
+	return store.saveQuotation(ctx, q)
+}
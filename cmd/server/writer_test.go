@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/twsm000/goxp-client-server-api/internal/upstream"
+)
+
+// batchStore records the batches it saves, failing the first failures saves
+// with err.
+type batchStore struct {
+	noopStore
+	mu       sync.Mutex
+	batches  [][]upstream.Quotation
+	calls    int
+	failures int
+	err      error
+	saved    []upstream.Quotation
+}
+
+func (s *batchStore) saveQuotation(_ context.Context, q *upstream.Quotation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.saved = append(s.saved, *q)
+	return nil
+}
+
+func (s *batchStore) saveQuotations(_ context.Context, batch []upstream.Quotation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if s.calls <= s.failures {
+		return s.err
+	}
+	s.batches = append(s.batches, append([]upstream.Quotation(nil), batch...))
+	return nil
+}
+
+func (s *batchStore) sizes() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sizes := make([]int, len(s.batches))
+	for i, b := range s.batches {
+		sizes[i] = len(b)
+	}
+	return sizes
+}
+
+// waitBatches waits up to a second for n batches to be saved.
+func (s *batchStore) waitBatches(t *testing.T, n int) []int {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for len(s.sizes()) < n && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	return s.sizes()
+}
+
+func testQuotation(i int) upstream.Quotation {
+	return upstream.Quotation{Code: "USD", CodeIn: "BRL", Bid: "5.1", Timestamp: strconv.Itoa(1791968680 + i)}
+}
+
+func enqueueAll(t *testing.T, a *asyncWriter, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if !a.enqueue(testQuotation(i)) {
+			t.Fatalf("enqueue() #%d refused", i)
+		}
+	}
+}
+
+func TestAsyncWriterFlushSize(t *testing.T) {
+	s := &batchStore{}
+	useStore(t, s)
+	a := newAsyncWriter(asyncQueueSize, 3, time.Hour)
+	enqueueAll(t, a, 7)
+	if got := s.waitBatches(t, 2); len(got) != 2 || got[0] != 3 || got[1] != 3 {
+		t.Errorf("batches before close = %v, want [3 3]", got)
+	}
+	a.close()
+	if got := s.sizes(); len(got) != 3 || got[2] != 1 {
+		t.Errorf("batches after close = %v, want [3 3 1]", got)
+	}
+}
+
+func TestAsyncWriterFlushInterval(t *testing.T) {
+	s := &batchStore{}
+	useStore(t, s)
+	a := newAsyncWriter(asyncQueueSize, 50, 20*time.Millisecond)
+	defer a.close()
+	enqueueAll(t, a, 2)
+	if got := s.waitBatches(t, 1); len(got) != 1 || got[0] != 2 {
+		t.Errorf("batches after the interval = %v, want [2]", got)
+	}
+}
+
+func TestAsyncWriterCloseFlushes(t *testing.T) {
+	s := &batchStore{}
+	useStore(t, s)
+	a := newAsyncWriter(asyncQueueSize, 50, time.Hour)
+	enqueueAll(t, a, 5)
+	a.close()
+	if got := s.sizes(); len(got) != 1 || got[0] != 5 {
+		t.Errorf("batches once close returned = %v, want [5]", got)
+	}
+	if a.enqueue(testQuotation(5)) {
+		t.Error("enqueue() accepted a quotation after close")
+	}
+	a.close()
+}
+
+func TestAsyncWriterRetry(t *testing.T) {
+	tests := []struct {
+		name      string
+		failures  int
+		err       error
+		wantCalls int
+		wantSaved bool
+	}{
+		{"retried once", 1, errors.New("disk I/O error"), 2, true},
+		{"fails twice", 2, errors.New("disk I/O error"), 2, false},
+		{"busy retried", 3, context.DeadlineExceeded, 4, true},
+		{"busy gives up", asyncMaxAttempts, context.DeadlineExceeded, asyncMaxAttempts, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t, logFormatText)
+			s := &batchStore{failures: tt.failures, err: tt.err}
+			useStore(t, s)
+			a := newAsyncWriter(asyncQueueSize, 50, time.Hour)
+			enqueueAll(t, a, 2)
+			a.close()
+
+			if s.calls != tt.wantCalls || (len(s.batches) == 1) != tt.wantSaved {
+				t.Errorf("%d saves, batches %v; want %d saves, saved %v", s.calls, s.sizes(), tt.wantCalls, tt.wantSaved)
+			}
+			lost := strings.Count(logs.String(), "falha ao salvar cotação")
+			if tt.wantSaved && lost != 0 {
+				t.Errorf("%d quotations logged as lost, want none", lost)
+			}
+			if !tt.wantSaved {
+				if lost != 2 || !strings.Contains(logs.String(), "timestamp=1791968681") || !strings.Contains(logs.String(), "pair=USD-BRL") {
+					t.Errorf("logs don't hold both payloads:\n%s", logs)
+				}
+			}
+		})
+	}
+}
+
+func TestPersistQuotationFallback(t *testing.T) {
+	s := &batchStore{}
+	useStore(t, s)
+	prev := writer
+	t.Cleanup(func() { writer = prev })
+	writer = newAsyncWriter(asyncQueueSize, 50, time.Hour)
+	writer.close()
+
+	q := testQuotation(0)
+	if err := persistQuotation(context.Background(), &q); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.saved) != 1 || s.calls != 0 {
+		t.Errorf("%d synchronous saves and %d batches, want the quotation saved synchronously", len(s.saved), s.calls)
+	}
+}
+
+// BenchmarkQuotationInserts compares inserting each quotation in its own
+// transaction with batching them through the async writer, on a sqlite
+// file.
+func BenchmarkQuotationInserts(b *testing.B) {
+	useSettings(b, nil)
+	ctx := context.Background()
+
+	b.Run("per row", func(b *testing.B) {
+		db := useDatabase(b)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			q := testQuotation(i)
+			if err := db.saveQuotation(ctx, &q); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("batched", func(b *testing.B) {
+		useDatabase(b)
+		b.ResetTimer()
+		a := newAsyncWriter(asyncQueueSize, 50, 500*time.Millisecond)
+		for i := 0; i < b.N; i++ {
+			for !a.enqueue(testQuotation(i)) {
+				runtime.Gosched()
+			}
+		}
+		a.close()
+	})
+}
@@ -0,0 +1,78 @@
+// Package buildinfo identifies the running build of the server and the
+// client. Version, Commit and Date are set at link time:
+//
+//	go build -ldflags "-X github.com/twsm000/goxp-client-server-api/internal/buildinfo.Version=v1.2.0 \
+//		-X github.com/twsm000/goxp-client-server-api/internal/buildinfo.Commit=$(git rev-parse HEAD) \
+//		-X github.com/twsm000/goxp-client-server-api/internal/buildinfo.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/server
+//
+// When they aren't, Commit and Date come from the VCS stamping go build does
+// in a git checkout, Date being then the time of the commit.
+package buildinfo
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Set with -ldflags -X.
+var (
+	Version = "dev"
+	Commit  = ""
+	Date    = ""
+)
+
+// Info describes a build.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the info of the running binary. A commit built from a
+// modified tree gets a "-dirty" suffix.
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, BuildDate: Date, GoVersion: runtime.Version()}
+	if info.Commit != "" && info.BuildDate != "" {
+		return info
+	}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	var revision, vcsTime string
+	var modified bool
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.time":
+			vcsTime = s.Value
+		case "vcs.modified":
+			modified = s.Value == "true"
+		}
+	}
+	if info.Commit == "" && revision != "" {
+		info.Commit = revision
+		if modified {
+			info.Commit += "-dirty"
+		}
+	}
+	if info.BuildDate == "" {
+		info.BuildDate = vcsTime
+	}
+	return info
+}
+
+// String formats i as printed by -version.
+func (i Info) String() string {
+	commit, date := i.Commit, i.BuildDate
+	if commit == "" {
+		commit = "desconhecido"
+	}
+	if date == "" {
+		date = "desconhecida"
+	}
+	return fmt.Sprintf("%s (commit %s, build %s, %s)", i.Version, commit, date, i.GoVersion)
+}
@@ -0,0 +1,5 @@
+// Package quotationpb holds the Go code generated from quotation.proto, the
+// QuotationService the server serves on -grpc-port.
+package quotationpb
+
+//go:generate buf generate
@@ -0,0 +1,216 @@
+// QuotationService is served by the server on -grpc-port. It reads the same
+// provider chain and database as the HTTP API.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: quotation.proto
+
+package quotationpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	QuotationService_GetLatest_FullMethodName    = "/cotacao.v1.QuotationService/GetLatest"
+	QuotationService_GetHistory_FullMethodName   = "/cotacao.v1.QuotationService/GetHistory"
+	QuotationService_StreamQuotes_FullMethodName = "/cotacao.v1.QuotationService/StreamQuotes"
+)
+
+// QuotationServiceClient is the client API for QuotationService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type QuotationServiceClient interface {
+	// GetLatest returns the most recent stored quotation of pair, of any pair
+	// when empty. NOT_FOUND when nothing was stored yet.
+	GetLatest(ctx context.Context, in *GetLatestRequest, opts ...grpc.CallOption) (*Quotation, error)
+	// GetHistory returns a page of the stored quotations, like
+	// GET /cotacao/history.
+	GetHistory(ctx context.Context, in *GetHistoryRequest, opts ...grpc.CallOption) (*GetHistoryResponse, error)
+	// StreamQuotes sends every new quotation published while the call lasts,
+	// like GET /cotacao/stream.
+	StreamQuotes(ctx context.Context, in *StreamQuotesRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Quotation], error)
+}
+
+type quotationServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewQuotationServiceClient(cc grpc.ClientConnInterface) QuotationServiceClient {
+	return &quotationServiceClient{cc}
+}
+
+func (c *quotationServiceClient) GetLatest(ctx context.Context, in *GetLatestRequest, opts ...grpc.CallOption) (*Quotation, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Quotation)
+	err := c.cc.Invoke(ctx, QuotationService_GetLatest_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *quotationServiceClient) GetHistory(ctx context.Context, in *GetHistoryRequest, opts ...grpc.CallOption) (*GetHistoryResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetHistoryResponse)
+	err := c.cc.Invoke(ctx, QuotationService_GetHistory_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *quotationServiceClient) StreamQuotes(ctx context.Context, in *StreamQuotesRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Quotation], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &QuotationService_ServiceDesc.Streams[0], QuotationService_StreamQuotes_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[StreamQuotesRequest, Quotation]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type QuotationService_StreamQuotesClient = grpc.ServerStreamingClient[Quotation]
+
+// QuotationServiceServer is the server API for QuotationService service.
+// All implementations must embed UnimplementedQuotationServiceServer
+// for forward compatibility.
+type QuotationServiceServer interface {
+	// GetLatest returns the most recent stored quotation of pair, of any pair
+	// when empty. NOT_FOUND when nothing was stored yet.
+	GetLatest(context.Context, *GetLatestRequest) (*Quotation, error)
+	// GetHistory returns a page of the stored quotations, like
+	// GET /cotacao/history.
+	GetHistory(context.Context, *GetHistoryRequest) (*GetHistoryResponse, error)
+	// StreamQuotes sends every new quotation published while the call lasts,
+	// like GET /cotacao/stream.
+	StreamQuotes(*StreamQuotesRequest, grpc.ServerStreamingServer[Quotation]) error
+	mustEmbedUnimplementedQuotationServiceServer()
+}
+
+// UnimplementedQuotationServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedQuotationServiceServer struct{}
+
+func (UnimplementedQuotationServiceServer) GetLatest(context.Context, *GetLatestRequest) (*Quotation, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetLatest not implemented")
+}
+func (UnimplementedQuotationServiceServer) GetHistory(context.Context, *GetHistoryRequest) (*GetHistoryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetHistory not implemented")
+}
+func (UnimplementedQuotationServiceServer) StreamQuotes(*StreamQuotesRequest, grpc.ServerStreamingServer[Quotation]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamQuotes not implemented")
+}
+func (UnimplementedQuotationServiceServer) mustEmbedUnimplementedQuotationServiceServer() {}
+func (UnimplementedQuotationServiceServer) testEmbeddedByValue()                          {}
+
+// UnsafeQuotationServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to QuotationServiceServer will
+// result in compilation errors.
+type UnsafeQuotationServiceServer interface {
+	mustEmbedUnimplementedQuotationServiceServer()
+}
+
+func RegisterQuotationServiceServer(s grpc.ServiceRegistrar, srv QuotationServiceServer) {
+	// If the following call pancis, it indicates UnimplementedQuotationServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&QuotationService_ServiceDesc, srv)
+}
+
+func _QuotationService_GetLatest_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetLatestRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(QuotationServiceServer).GetLatest(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: QuotationService_GetLatest_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(QuotationServiceServer).GetLatest(ctx, req.(*GetLatestRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _QuotationService_GetHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetHistoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(QuotationServiceServer).GetHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: QuotationService_GetHistory_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(QuotationServiceServer).GetHistory(ctx, req.(*GetHistoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _QuotationService_StreamQuotes_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamQuotesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(QuotationServiceServer).StreamQuotes(m, &grpc.GenericServerStream[StreamQuotesRequest, Quotation]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type QuotationService_StreamQuotesServer = grpc.ServerStreamingServer[Quotation]
+
+// QuotationService_ServiceDesc is the grpc.ServiceDesc for QuotationService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var QuotationService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "cotacao.v1.QuotationService",
+	HandlerType: (*QuotationServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetLatest",
+			Handler:    _QuotationService_GetLatest_Handler,
+		},
+		{
+			MethodName: "GetHistory",
+			Handler:    _QuotationService_GetHistory_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamQuotes",
+			Handler:       _QuotationService_StreamQuotes_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "quotation.proto",
+}
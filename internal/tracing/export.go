@@ -0,0 +1,223 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	exportQueueSize int           = 2048
+	exportBatchSize int           = 256
+	exportInterval  time.Duration = 5 * time.Second
+	exportTimeout   time.Duration = 10 * time.Second
+	scopeName       string        = "github.com/twsm000/goxp-client-server-api/internal/tracing"
+)
+
+// OTLP status codes.
+const (
+	statusUnset int = 0
+	statusError int = 2
+)
+
+type attribute struct {
+	key   string
+	value any
+}
+
+// The otlp* types are the OTLP/HTTP JSON encoding of the trace export
+// request. Trace and span ids are hex strings, times are nanoseconds since
+// the epoch written as decimal strings.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              Kind            `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            otlpStatus      `json:"status"`
+	}
+	otlpAttribute struct {
+		Key   string         `json:"key"`
+		Value map[string]any `json:"value"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+// otlpValue wraps v in the AnyValue field matching its type.
+func otlpValue(v any) map[string]any {
+	switch v := v.(type) {
+	case string:
+		return map[string]any{"stringValue": v}
+	case bool:
+		return map[string]any{"boolValue": v}
+	case int:
+		return map[string]any{"intValue": strconv.Itoa(v)}
+	case int64:
+		return map[string]any{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		return map[string]any{"doubleValue": v}
+	default:
+		return map[string]any{"stringValue": fmt.Sprint(v)}
+	}
+}
+
+// export converts the span, ended at end, to its OTLP form. s.mu must be
+// held.
+func (s *Span) export(end time.Time) otlpSpan {
+	span := otlpSpan{
+		TraceID:           hex.EncodeToString(s.ctx.trace[:]),
+		SpanID:            hex.EncodeToString(s.ctx.span[:]),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+		Status:            otlpStatus{Code: statusUnset},
+	}
+	if s.parent != (spanID{}) {
+		span.ParentSpanID = hex.EncodeToString(s.parent[:])
+	}
+	for _, a := range s.attrs {
+		span.Attributes = append(span.Attributes, otlpAttribute{a.key, otlpValue(a.value)})
+	}
+	if s.failed {
+		span.Status = otlpStatus{Code: statusError, Message: s.errMsg}
+	}
+	return span
+}
+
+// exporter sends the ended spans to the collector in batches, from a single
+// goroutine, so ending a span never waits on the network. Spans arriving
+// while the queue is full, or after shutdown, are dropped.
+type exporter struct {
+	url      string
+	client   *http.Client
+	resource otlpResource
+	mu       sync.RWMutex
+	closed   bool
+	queue    chan otlpSpan
+	done     chan struct{}
+}
+
+func newExporter(url, service string) *exporter {
+	e := &exporter{
+		url:    url,
+		client: &http.Client{Timeout: exportTimeout},
+		resource: otlpResource{Attributes: []otlpAttribute{
+			{"service.name", otlpValue(service)},
+		}},
+		queue: make(chan otlpSpan, exportQueueSize),
+		done:  make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+func (e *exporter) enqueue(span otlpSpan) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.closed {
+		return
+	}
+	select {
+	case e.queue <- span:
+	default:
+		slog.Warn("Tracing - fila cheia, span descartado", "span", span.Name)
+	}
+}
+
+func (e *exporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	batch := make([]otlpSpan, 0, exportBatchSize)
+	for {
+		select {
+		case span, ok := <-e.queue:
+			if !ok {
+				e.send(batch)
+				return
+			}
+			batch = append(batch, span)
+			if len(batch) < exportBatchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+		e.send(batch)
+		batch = batch[:0]
+	}
+}
+
+// send posts batch to the collector. Failures are only logged: losing spans
+// must not affect the traced program.
+func (e *exporter) send(batch []otlpSpan) {
+	if len(batch) == 0 {
+		return
+	}
+	body, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   e.resource,
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: scopeName}, Spans: batch}},
+	}}})
+	if err != nil {
+		slog.Warn("Tracing - falha ao codificar spans", "err", err)
+		return
+	}
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		slog.Warn("Tracing - falha ao exportar spans", "err", err)
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		slog.Warn("Tracing - coletor recusou spans", "status", resp.Status)
+	}
+}
+
+// shutdown stops the exporter after sending the queued spans.
+func (e *exporter) shutdown(ctx context.Context) error {
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.queue)
+	}
+	e.mu.Unlock()
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
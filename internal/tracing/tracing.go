@@ -0,0 +1,195 @@
+// Package tracing records OpenTelemetry compatible spans and exports them to
+// an OTLP/HTTP collector. A nil *Tracer is valid and records nothing, as do
+// Start and the *Span methods when the context carries no span, so callers
+// don't need to check whether tracing is enabled.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kind is the role of a span in a trace, as numbered by OTLP.
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// traceparentHeader is the W3C Trace Context header.
+const traceparentHeader string = "traceparent"
+
+type (
+	traceID [16]byte
+	spanID  [8]byte
+)
+
+// spanContext identifies a span, local or received from another process.
+type spanContext struct {
+	trace traceID
+	span  spanID
+}
+
+type (
+	spanKey   struct{}
+	remoteKey struct{}
+)
+
+// Tracer creates spans and hands the ended ones to its exporter.
+type Tracer struct {
+	exporter *exporter
+}
+
+// New creates a tracer exporting to the OTLP/HTTP collector at endpoint, like
+// "http://localhost:4318", as the service named service. An empty endpoint
+// returns nil, the no-op tracer.
+func New(endpoint, service string) *Tracer {
+	if endpoint == "" {
+		return nil
+	}
+	return &Tracer{exporter: newExporter(strings.TrimRight(endpoint, "/")+"/v1/traces", service)}
+}
+
+// Shutdown exports the spans still buffered, waiting until ctx is done.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	return t.exporter.shutdown(ctx)
+}
+
+// Start opens a span named name, the child of the span in ctx or of the
+// remote parent stored by Extract, or the root of a new trace.
+func (t *Tracer) Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	s := &Span{tracer: t, name: name, kind: kind, start: time.Now()}
+	if parent, ok := ctx.Value(spanKey{}).(*Span); ok {
+		s.ctx.trace, s.parent = parent.ctx.trace, parent.ctx.span
+	} else if remote, ok := ctx.Value(remoteKey{}).(spanContext); ok {
+		s.ctx.trace, s.parent = remote.trace, remote.span
+	} else {
+		rand.Read(s.ctx.trace[:])
+	}
+	rand.Read(s.ctx.span[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Start opens a child of the span in ctx with the same tracer. Without a span
+// in ctx, tracing is off for this call chain and nothing is recorded.
+func Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	parent, ok := ctx.Value(spanKey{}).(*Span)
+	if !ok {
+		return ctx, nil
+	}
+	return parent.tracer.Start(ctx, name, kind)
+}
+
+// WithSpanFrom returns ctx carrying the span of from, if any, so work
+// detached from the cancellation of from is still part of its trace.
+func WithSpanFrom(ctx, from context.Context) context.Context {
+	if s, ok := from.Value(spanKey{}).(*Span); ok {
+		return context.WithValue(ctx, spanKey{}, s)
+	}
+	return ctx
+}
+
+// Extract stores the parent received in the traceparent header of h in ctx.
+// Missing or malformed headers are ignored.
+func Extract(ctx context.Context, h http.Header) context.Context {
+	if sc, ok := parseTraceparent(h.Get(traceparentHeader)); ok {
+		return context.WithValue(ctx, remoteKey{}, sc)
+	}
+	return ctx
+}
+
+// Inject sets the traceparent header of h to the span in ctx, if any.
+func Inject(ctx context.Context, h http.Header) {
+	if s, ok := ctx.Value(spanKey{}).(*Span); ok {
+		h.Set(traceparentHeader, fmt.Sprintf("00-%x-%x-01", s.ctx.trace, s.ctx.span))
+	}
+}
+
+// parseTraceparent reads a version 00 traceparent, like
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01".
+func parseTraceparent(raw string) (spanContext, bool) {
+	var sc spanContext
+	parts := strings.Split(raw, "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.trace[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.span[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	if sc.trace == (traceID{}) || sc.span == (spanID{}) {
+		return sc, false
+	}
+	return sc, true
+}
+
+// Span is an operation of a trace. Its methods are safe on a nil *Span and
+// for concurrent use.
+type Span struct {
+	tracer *Tracer
+	ctx    spanContext
+	parent spanID
+	name   string
+	kind   Kind
+	start  time.Time
+
+	mu     sync.Mutex
+	attrs  []attribute
+	errMsg string
+	failed bool
+	ended  bool
+}
+
+// SetAttribute records key=value on the span. Values are kept as strings,
+// ints, floats or bools; anything else is formatted with fmt.
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs = append(s.attrs, attribute{key, value})
+}
+
+// RecordError marks the span as failed with err. A nil err is ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failed, s.errMsg = true, err.Error()
+}
+
+// End closes the span and queues it for export. Only the first call counts.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	end := time.Now()
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	data := s.export(end)
+	s.mu.Unlock()
+	s.tracer.exporter.enqueue(data)
+}
@@ -0,0 +1,220 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		ok     bool
+	}{
+		{"valid", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"not sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true},
+		{"empty", "", false},
+		{"other version", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"short trace id", "00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01", false},
+		{"short span id", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902-01", false},
+		{"not hex", "00-4bf92f3577b34da6a3ce929d0e0e47zz-00f067aa0ba902b7-01", false},
+		{"zero trace id", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"zero span id", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"missing flags", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, ok := parseTraceparent(tt.header)
+			if ok != tt.ok {
+				t.Fatalf("parseTraceparent(%q) ok = %v, want %v", tt.header, ok, tt.ok)
+			}
+			if ok && hex.EncodeToString(sc.trace[:]) != "4bf92f3577b34da6a3ce929d0e0e4736" {
+				t.Errorf("trace id = %x", sc.trace)
+			}
+		})
+	}
+}
+
+func TestNilTracer(t *testing.T) {
+	var tracer *Tracer
+	ctx := context.Background()
+	got, span := tracer.Start(ctx, "op", KindServer)
+	if got != ctx || span != nil {
+		t.Fatalf("nil tracer Start() = %v, %v; want ctx unchanged and a nil span", got, span)
+	}
+	span.SetAttribute("k", "v")
+	span.RecordError(errors.New("boom"))
+	span.End()
+	if _, span := Start(ctx, "child", KindInternal); span != nil {
+		t.Error("Start() without a span in ctx returned a span")
+	}
+	h := http.Header{}
+	Inject(ctx, h)
+	if len(h) != 0 {
+		t.Errorf("Inject() without a span set %v", h)
+	}
+	if err := tracer.Shutdown(ctx); err != nil {
+		t.Errorf("nil tracer Shutdown() error = %v", err)
+	}
+}
+
+// collector is an OTLP/HTTP endpoint keeping the requests it gets.
+type collector struct {
+	*httptest.Server
+	mu       sync.Mutex
+	paths    []string
+	requests []otlpRequest
+}
+
+func newCollector(t *testing.T) *collector {
+	t.Helper()
+	c := &collector{}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req otlpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.paths = append(c.paths, r.URL.Path)
+		c.requests = append(c.requests, req)
+	}))
+	t.Cleanup(c.Close)
+	return c
+}
+
+// spans returns the spans received, by name.
+func (c *collector) spans() map[string]otlpSpan {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	spans := make(map[string]otlpSpan)
+	for _, req := range c.requests {
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, s := range ss.Spans {
+					spans[s.Name] = s
+				}
+			}
+		}
+	}
+	return spans
+}
+
+func shutdown(t *testing.T, tracer *Tracer) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tracer.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+}
+
+func TestTracerExport(t *testing.T) {
+	c := newCollector(t)
+	tracer := New(c.URL+"/", "cotacao-server")
+
+	ctx, root := tracer.Start(context.Background(), "GET /cotacao", KindServer)
+	root.SetAttribute("http.status_code", 200)
+	root.SetAttribute("cache.hit", false)
+	root.SetAttribute("bid", 5.25)
+	root.SetAttribute("pair", "USD-BRL")
+	root.SetAttribute("elapsed", 2*time.Second)
+	_, child := Start(ctx, "upstream.fetch", KindClient)
+	child.RecordError(errors.New("timeout"))
+	child.RecordError(nil)
+	child.End()
+	child.End()
+	root.End()
+	shutdown(t, tracer)
+
+	if len(c.paths) != 1 || c.paths[0] != "/v1/traces" {
+		t.Fatalf("collector got %v, want one request to /v1/traces", c.paths)
+	}
+	resource := c.requests[0].ResourceSpans[0].Resource.Attributes
+	if len(resource) != 1 || resource[0].Key != "service.name" || resource[0].Value["stringValue"] != "cotacao-server" {
+		t.Errorf("resource = %+v", resource)
+	}
+	spans := c.spans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	r, f := spans["GET /cotacao"], spans["upstream.fetch"]
+	if r.ParentSpanID != "" || r.Kind != KindServer || r.Status.Code != statusUnset {
+		t.Errorf("root = %+v", r)
+	}
+	if f.TraceID != r.TraceID || f.ParentSpanID != r.SpanID || f.Kind != KindClient {
+		t.Errorf("child = %+v, want a client span under %s/%s", f, r.TraceID, r.SpanID)
+	}
+	if f.Status.Code != statusError || f.Status.Message != "timeout" {
+		t.Errorf("child status = %+v, want error timeout", f.Status)
+	}
+	want := map[string]map[string]any{
+		"http.status_code": {"intValue": "200"},
+		"cache.hit":        {"boolValue": false},
+		"bid":              {"doubleValue": 5.25},
+		"pair":             {"stringValue": "USD-BRL"},
+		"elapsed":          {"stringValue": "2s"},
+	}
+	for _, a := range r.Attributes {
+		for k, v := range want[a.Key] {
+			if a.Value[k] != v {
+				t.Errorf("attribute %s = %v, want %s %v", a.Key, a.Value, k, v)
+			}
+		}
+		delete(want, a.Key)
+	}
+	if len(want) != 0 {
+		t.Errorf("attributes missing: %v", want)
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	c := newCollector(t)
+	tracer := New(c.URL, "cotacao-server")
+	defer shutdown(t, tracer)
+
+	in := http.Header{}
+	in.Set(traceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, span := tracer.Start(Extract(context.Background(), in), "GET /cotacao", KindServer)
+	if got := hex.EncodeToString(span.ctx.trace[:]); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("trace id = %s, want the remote one", got)
+	}
+	if got := hex.EncodeToString(span.parent[:]); got != "00f067aa0ba902b7" {
+		t.Errorf("parent = %s, want the remote span", got)
+	}
+
+	out := http.Header{}
+	Inject(ctx, out)
+	sc, ok := parseTraceparent(out.Get(traceparentHeader))
+	if !ok || sc.trace != span.ctx.trace || sc.span != span.ctx.span {
+		t.Errorf("Inject() = %q, want the span %x/%x", out.Get(traceparentHeader), span.ctx.trace, span.ctx.span)
+	}
+
+	detached := WithSpanFrom(context.Background(), ctx)
+	if _, child := Start(detached, "store", KindInternal); child == nil || child.parent != span.ctx.span {
+		t.Error("Start() on a WithSpanFrom context isn't a child of the span")
+	}
+	if got := Extract(context.Background(), http.Header{traceparentHeader: {"garbage"}}); got.Value(remoteKey{}) != nil {
+		t.Error("Extract() kept a malformed traceparent")
+	}
+}
+
+func TestEndAfterShutdown(t *testing.T) {
+	c := newCollector(t)
+	tracer := New(c.URL, "cotacao-server")
+	_, span := tracer.Start(context.Background(), "late", KindInternal)
+	shutdown(t, tracer)
+	span.End()
+	shutdown(t, tracer)
+	if len(c.spans()) != 0 {
+		t.Error("span ended after Shutdown was exported")
+	}
+}
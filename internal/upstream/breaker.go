@@ -0,0 +1,149 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without calling the provider while its circuit
+// breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker aberto, upstream indisponível")
+
+// BreakerState is the state of a Breaker.
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// Breaker is a circuit breaker around a Provider. After threshold consecutive
+// failures it opens and fails fast for cooldown; then a single probe request
+// is let through (half-open) and its outcome closes or reopens the circuit.
+type Breaker struct {
+	provider  Provider
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// NewBreaker wraps p in a circuit breaker.
+func NewBreaker(p Provider, threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{
+		provider:  p,
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+func (b *Breaker) Name() string {
+	return b.provider.Name()
+}
+
+func (b *Breaker) Fetch(ctx context.Context, pairs ...string) (map[string]Quotation, error) {
+	if err := b.acquire(); err != nil {
+		return nil, err
+	}
+	quotations, err := b.provider.Fetch(ctx, pairs...)
+	b.record(err)
+	return quotations, err
+}
+
+// State reports the current state, moving from open to half-open once the
+// cool-down is over.
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.cooldown {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// RetryAfter is how long the breaker stays open, zero when it isn't.
+func (b *Breaker) RetryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != BreakerOpen {
+		return 0
+	}
+	if left := b.cooldown - time.Since(b.openedAt); left > 0 {
+		return left
+	}
+	return 0
+}
+
+func (b *Breaker) acquire() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return ErrCircuitOpen
+		}
+		b.transition(BreakerHalfOpen)
+		b.probing = true
+		return nil
+	case BreakerHalfOpen:
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+func (b *Breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// A request canceled by its caller, or never sent for lack of budget,
+	// says nothing about the upstream.
+	if errors.Is(err, context.Canceled) || errors.Is(err, ErrBudgetExhausted) {
+		b.probing = false
+		return
+	}
+
+	if err == nil {
+		b.failures = 0
+		if b.state != BreakerClosed {
+			b.transition(BreakerClosed)
+		}
+		b.probing = false
+		return
+	}
+
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.openedAt = time.Now()
+		if b.state != BreakerOpen {
+			b.transition(BreakerOpen)
+		}
+	}
+	b.probing = false
+}
+
+// transition must be called with mu held.
+func (b *Breaker) transition(to BreakerState) {
+	slog.Info("Circuit breaker mudou de estado", "provider", b.provider.Name(), "from", b.state.String(), "to", to.String())
+	b.state = to
+}
@@ -0,0 +1,153 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func fetchN(t *testing.T, b *Breaker, n int) error {
+	t.Helper()
+	var err error
+	for i := 0; i < n; i++ {
+		_, err = b.Fetch(context.Background(), "USD-BRL")
+	}
+	return err
+}
+
+func TestBreakerOpensAfterThreshold(t *testing.T) {
+	p := &fakeProvider{name: "awesomeapi", err: errors.New("502")}
+	b := NewBreaker(p, 3, time.Minute)
+
+	fetchN(t, b, 2)
+	if got := b.State(); got != BreakerClosed {
+		t.Fatalf("state after 2 failures = %s, want closed", got)
+	}
+	// A success starts the count again.
+	p.err = nil
+	fetchN(t, b, 1)
+	p.err = errors.New("502")
+	fetchN(t, b, 2)
+	if got := b.State(); got != BreakerClosed {
+		t.Fatalf("state after a success and 2 failures = %s, want closed", got)
+	}
+	fetchN(t, b, 1)
+	if got := b.State(); got != BreakerOpen {
+		t.Fatalf("state after 3 consecutive failures = %s, want open", got)
+	}
+
+	calls := p.calls
+	if err := fetchN(t, b, 5); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Fetch() while open error = %v, want ErrCircuitOpen", err)
+	}
+	if p.calls != calls {
+		t.Errorf("provider called %d times while open, want it left alone", p.calls-calls)
+	}
+	if wait := b.RetryAfter(); wait <= 0 || wait > time.Minute {
+		t.Errorf("RetryAfter() = %v, want up to the 1m cool-down", wait)
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	tests := []struct {
+		name      string
+		probeErr  error
+		wantState BreakerState
+	}{
+		{"probe succeeds", nil, BreakerClosed},
+		{"probe fails", errors.New("502"), BreakerOpen},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const cooldown = 30 * time.Millisecond
+			p := &fakeProvider{name: "awesomeapi", err: errors.New("502")}
+			b := NewBreaker(p, 1, cooldown)
+			fetchN(t, b, 1)
+			if got := b.State(); got != BreakerOpen {
+				t.Fatalf("state = %s, want open", got)
+			}
+			time.Sleep(cooldown)
+			if got, wait := b.State(), b.RetryAfter(); got != BreakerHalfOpen || wait != 0 {
+				t.Fatalf("state after the cool-down = %s, retry after %v; want half-open, 0", got, wait)
+			}
+
+			p.err = tt.probeErr
+			calls := p.calls
+			_, err := b.Fetch(context.Background(), "USD-BRL")
+			if err != tt.probeErr || p.calls != calls+1 {
+				t.Fatalf("probe Fetch() = %v after %d calls, want the provider's %v", err, p.calls-calls, tt.probeErr)
+			}
+			if got := b.State(); got != tt.wantState {
+				t.Errorf("state after the probe = %s, want %s", got, tt.wantState)
+			}
+		})
+	}
+}
+
+// blockingProvider waits for release before answering.
+type blockingProvider struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (p *blockingProvider) Name() string { return "awesomeapi" }
+
+func (p *blockingProvider) Fetch(ctx context.Context, pairs ...string) (map[string]Quotation, error) {
+	p.started <- struct{}{}
+	<-p.release
+	return map[string]Quotation{"USD-BRL": {Bid: "5.1"}}, nil
+}
+
+func TestBreakerSingleProbe(t *testing.T) {
+	const cooldown = 20 * time.Millisecond
+	p := &fakeProvider{name: "awesomeapi", err: errors.New("502")}
+	b := NewBreaker(p, 1, cooldown)
+	fetchN(t, b, 1)
+	time.Sleep(cooldown)
+
+	blocking := &blockingProvider{started: make(chan struct{}, 1), release: make(chan struct{})}
+	b.provider = blocking
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if _, err := b.Fetch(context.Background(), "USD-BRL"); err != nil {
+			t.Errorf("probe Fetch() error = %v", err)
+		}
+	}()
+	<-blocking.started
+	for i := 0; i < 3; i++ {
+		if _, err := b.Fetch(context.Background(), "USD-BRL"); !errors.Is(err, ErrCircuitOpen) {
+			t.Errorf("Fetch() during the probe error = %v, want ErrCircuitOpen", err)
+		}
+	}
+	close(blocking.release)
+	wg.Wait()
+	if got := b.State(); got != BreakerClosed {
+		t.Errorf("state after the probe = %s, want closed", got)
+	}
+}
+
+func TestBreakerIgnoresCallerErrors(t *testing.T) {
+	for _, err := range []error{context.Canceled, fmt.Errorf("budget: %w", ErrBudgetExhausted)} {
+		t.Run(err.Error(), func(t *testing.T) {
+			p := &fakeProvider{name: "awesomeapi", err: err}
+			b := NewBreaker(p, 2, time.Minute)
+			fetchN(t, b, 5)
+			if got := b.State(); got != BreakerClosed {
+				t.Errorf("state after 5 %v = %s, want closed", err, got)
+			}
+		})
+	}
+}
+
+func TestBreakerStateString(t *testing.T) {
+	for state, want := range map[BreakerState]string{BreakerClosed: "closed", BreakerOpen: "open", BreakerHalfOpen: "half-open"} {
+		if got := state.String(); got != want {
+			t.Errorf("BreakerState(%d).String() = %q, want %q", state, got, want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrBudgetExhausted is returned without calling the provider while its call
+// budget has no token left.
+var ErrBudgetExhausted = errors.New("orçamento de chamadas ao upstream esgotado")
+
+// Budget is a token bucket shared by every caller of a Provider: at most
+// burst calls at once, refilled at rate calls per second. It keeps the server
+// within the quota of a free upstream whatever the number of clients, pairs,
+// pollers and retries.
+type Budget struct {
+	provider Provider
+	rate     float64
+	burst    float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewBudget wraps p in a budget of rate calls per second, starting full.
+func NewBudget(p Provider, rate float64, burst int) *Budget {
+	return &Budget{
+		provider: p,
+		rate:     rate,
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		last:     time.Now(),
+	}
+}
+
+func (b *Budget) Name() string {
+	return b.provider.Name()
+}
+
+func (b *Budget) Fetch(ctx context.Context, pairs ...string) (map[string]Quotation, error) {
+	if !b.take(time.Now()) {
+		return nil, ErrBudgetExhausted
+	}
+	return b.provider.Fetch(ctx, pairs...)
+}
+
+// RetryAfter is how long until the next call is allowed, zero when one is
+// available now.
+func (b *Budget) RetryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *Budget) take(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// refill must be called with mu held. now may be slightly behind last when
+// concurrent callers read the clock before taking the lock.
+func (b *Budget) refill(now time.Time) {
+	if !now.After(b.last) {
+		return
+	}
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
@@ -0,0 +1,196 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CrossCurrency is the currency cross rates go through: EUR-JPY is derived
+// from EUR-USD and USD-JPY.
+const CrossCurrency string = "USD"
+
+// CrossLegs returns the pairs pair is derived from as a cross rate, ok false
+// when one of its currencies is already CrossCurrency.
+func CrossLegs(pair string) (first, second string, ok bool) {
+	code, codeIn, found := strings.Cut(pair, "-")
+	if !found || code == CrossCurrency || codeIn == CrossCurrency || code == codeIn {
+		return "", "", false
+	}
+	return code + "-" + CrossCurrency, CrossCurrency + "-" + codeIn, true
+}
+
+// CrossRate derives the quotation of pair from first and second, its
+// CrossLegs. Bid and ask are the products of the bids and of the asks,
+// rounded half to even to DecimalScale places, so they carry the rounding of
+// both legs and of the product. The quotation is as old as its oldest leg;
+// high and low aren't known, since the legs may peak at different times. The
+// legs are kept in Components.
+func CrossRate(pair string, first, second Quotation) (Quotation, error) {
+	wantFirst, wantSecond, ok := CrossLegs(pair)
+	if !ok || pairKey(first.Code+first.CodeIn) != pairKey(wantFirst) || pairKey(second.Code+second.CodeIn) != pairKey(wantSecond) {
+		return Quotation{}, fmt.Errorf("%s não é o cruzamento de %s-%s e %s-%s", pair, first.Code, first.CodeIn, second.Code, second.CodeIn)
+	}
+	bid, err := first.BidValue.Mul(second.BidValue, DecimalScale, RoundHalfEven)
+	if err != nil {
+		return Quotation{}, fmt.Errorf("bid de %s: %w", pair, err)
+	}
+	ask, err := first.AskValue.Mul(second.AskValue, DecimalScale, RoundHalfEven)
+	if err != nil {
+		return Quotation{}, fmt.Errorf("ask de %s: %w", pair, err)
+	}
+
+	code, codeIn, _ := strings.Cut(pair, "-")
+	q := Quotation{
+		Code:       code,
+		CodeIn:     codeIn,
+		Name:       crossName(pair, first.Name, second.Name),
+		Bid:        bid.String(),
+		Ask:        ask.String(),
+		BidValue:   bid,
+		AskValue:   ask,
+		Timestamp:  first.Timestamp,
+		CreateDate: first.CreateDate,
+		Derived:    true,
+		Components: []Quotation{first, second},
+	}
+	if oldest, err := first.Time(); err == nil {
+		if t, err := second.Time(); err == nil && t.Before(oldest) {
+			q.Timestamp, q.CreateDate = second.Timestamp, second.CreateDate
+		}
+	}
+	// A day's change compounds over the legs; varBid follows from it.
+	p1, err1 := strconv.ParseFloat(first.PctChange, 64)
+	p2, err2 := strconv.ParseFloat(second.PctChange, 64)
+	if err1 == nil && err2 == nil {
+		pct := ((1+p1/100)*(1+p2/100) - 1) * 100
+		q.PctChange = strconv.FormatFloat(pct, 'f', 4, 64)
+		q.VarBid = strconv.FormatFloat(bid.Float64()-bid.Float64()/(1+pct/100), 'f', 6, 64)
+	}
+	return q, nil
+}
+
+// crossName joins "Euro/Dólar Americano" and "Dólar Americano/Iene Japonês"
+// into "Euro/Iene Japonês", or falls back to pair.
+func crossName(pair, first, second string) string {
+	from, _, ok1 := strings.Cut(first, "/")
+	_, to, ok2 := strings.Cut(second, "/")
+	if !ok1 || !ok2 {
+		return pair
+	}
+	return from + "/" + to
+}
+
+// CrossRates fetches the pairs a Provider doesn't offer, those it answers
+// with 404, as cross rates of their CrossLegs. A pair found to need the legs
+// is remembered, so later calls ask for the legs straight away.
+type CrossRates struct {
+	provider Provider
+
+	mu      sync.Mutex
+	crossed map[string]bool
+}
+
+// NewCrossRates wraps p.
+func NewCrossRates(p Provider) *CrossRates {
+	return &CrossRates{provider: p, crossed: make(map[string]bool)}
+}
+
+func (c *CrossRates) Name() string {
+	return c.provider.Name()
+}
+
+func (c *CrossRates) Fetch(ctx context.Context, pairs ...string) (map[string]Quotation, error) {
+	if cross := c.known(pairs); len(cross) > 0 {
+		return c.fetchCrossed(ctx, pairs, cross)
+	}
+	quotations, err := c.provider.Fetch(ctx, pairs...)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Code != http.StatusNotFound {
+		return quotations, err
+	}
+
+	// A 404 doesn't say which pair is missing, so every crossable one is
+	// derived, and only remembered when it is the only candidate.
+	var cross []string
+	for _, pair := range pairs {
+		if _, _, ok := CrossLegs(pair); ok {
+			cross = append(cross, pair)
+		}
+	}
+	if len(cross) == 0 {
+		return nil, err
+	}
+	quotations, crossErr := c.fetchCrossed(ctx, pairs, cross)
+	if crossErr != nil {
+		return nil, err
+	}
+	if len(cross) == 1 {
+		c.mu.Lock()
+		c.crossed[cross[0]] = true
+		c.mu.Unlock()
+	}
+	return quotations, nil
+}
+
+// known returns the pairs already found to need their legs.
+func (c *CrossRates) known(pairs []string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var cross []string
+	for _, pair := range pairs {
+		if c.crossed[pair] {
+			cross = append(cross, pair)
+		}
+	}
+	return cross
+}
+
+// fetchCrossed fetches pairs in a single call, asking for the legs of the
+// ones in cross instead of the pairs themselves, and derives them.
+func (c *CrossRates) fetchCrossed(ctx context.Context, pairs, cross []string) (map[string]Quotation, error) {
+	isCross := make(map[string]bool, len(cross))
+	for _, pair := range cross {
+		isCross[pair] = true
+	}
+	seen := make(map[string]bool)
+	var request []string
+	add := func(pair string) {
+		if !seen[pair] {
+			seen[pair] = true
+			request = append(request, pair)
+		}
+	}
+	for _, pair := range pairs {
+		if isCross[pair] {
+			first, second, _ := CrossLegs(pair)
+			add(first)
+			add(second)
+		} else {
+			add(pair)
+		}
+	}
+
+	fetched, err := c.provider.Fetch(ctx, request...)
+	if err != nil {
+		return nil, err
+	}
+	quotations := make(map[string]Quotation, len(pairs))
+	for _, pair := range pairs {
+		if !isCross[pair] {
+			quotations[pair] = fetched[pair]
+			continue
+		}
+		first, second, _ := CrossLegs(pair)
+		q, err := CrossRate(pair, fetched[first], fetched[second])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+		}
+		quotations[pair] = q
+	}
+	return quotations, nil
+}
@@ -0,0 +1,166 @@
+package upstream
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// DecimalScale is the number of decimal places kept by Decimal.
+const DecimalScale = 8
+
+const decimalUnit int64 = 100000000 // 10^DecimalScale
+
+var (
+	errDecimalSyntax = errors.New("número decimal inválido")
+	errDecimalRange  = errors.New("resultado fora do intervalo de Decimal")
+)
+
+// Decimal is a fixed-point number with DecimalScale decimal places, held as
+// an integer count of 10^-8 units so prices never go through a float. It is
+// encoded in JSON as a number written with its exact digits.
+type Decimal int64
+
+// ParseDecimal parses a plain decimal string such as "5.4321" or "-0.0001".
+// Exponents, spaces, a missing integer or fractional part and significant
+// digits beyond DecimalScale are rejected; trailing zeros are accepted.
+func ParseDecimal(s string) (Decimal, error) {
+	raw := s
+	neg := strings.HasPrefix(s, "-")
+	if neg {
+		s = s[1:]
+	}
+	intPart, frac, hasDot := strings.Cut(s, ".")
+	if !isDigits(intPart) || (hasDot && !isDigits(frac)) {
+		return 0, fmt.Errorf("%w: %q", errDecimalSyntax, raw)
+	}
+	if len(frac) > DecimalScale {
+		if strings.Trim(frac[DecimalScale:], "0") != "" {
+			return 0, fmt.Errorf("%w: %q tem mais de %d casas decimais", errDecimalSyntax, raw, DecimalScale)
+		}
+		frac = frac[:DecimalScale]
+	}
+	frac += strings.Repeat("0", DecimalScale-len(frac))
+
+	i, err := strconv.ParseInt(intPart, 10, 64)
+	if err != nil || i > math.MaxInt64/decimalUnit-1 {
+		return 0, fmt.Errorf("%w: %q fora do intervalo", errDecimalSyntax, raw)
+	}
+	f, _ := strconv.ParseInt(frac, 10, 64)
+	units := i*decimalUnit + f
+	if neg {
+		units = -units
+	}
+	return Decimal(units), nil
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// String formats d without trailing zeros, e.g. "5.4321" or "5".
+func (d Decimal) String() string {
+	units := int64(d)
+	sign := ""
+	if units < 0 {
+		sign, units = "-", -units
+	}
+	s := sign + strconv.FormatInt(units/decimalUnit, 10)
+	frac := strings.TrimRight(fmt.Sprintf("%08d", units%decimalUnit), "0")
+	if frac != "" {
+		s += "." + frac
+	}
+	return s
+}
+
+// Float64 converts d to the nearest float64, for display or approximate math.
+func (d Decimal) Float64() float64 {
+	return float64(d) / float64(decimalUnit)
+}
+
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// MarshalText writes d like String, for encodings without numbers like XML.
+func (d Decimal) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a string holding one.
+func (d *Decimal) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		return nil
+	}
+	s := strings.Trim(string(b), `"`)
+	v, err := ParseDecimal(s)
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
+
+// RoundingMode selects how Mul drops the digits beyond the places it keeps.
+type RoundingMode int
+
+const (
+	RoundHalfUp   RoundingMode = iota // ties away from zero
+	RoundHalfEven                     // ties to the even neighbor
+	RoundDown                         // toward zero
+	RoundUp                           // away from zero
+)
+
+// Mul returns d×e rounded with mode to places decimal places, 0 to
+// DecimalScale. The product is exact before rounding, so amounts far above
+// any price don't lose digits; only a result beyond the range of Decimal
+// fails.
+func (d Decimal) Mul(e Decimal, places int, mode RoundingMode) (Decimal, error) {
+	if places < 0 || places > DecimalScale {
+		return 0, fmt.Errorf("casas decimais devem estar entre 0 e %d: %d", DecimalScale, places)
+	}
+	product := new(big.Int).Mul(big.NewInt(int64(d)), big.NewInt(int64(e)))
+	return roundScaled(product, 2*DecimalScale, places, mode)
+}
+
+// roundScaled rounds n, a number with scale decimal places, to places ones
+// and returns it as a Decimal.
+func roundScaled(n *big.Int, scale, places int, mode RoundingMode) (Decimal, error) {
+	div := pow10(scale - places)
+	q, r := new(big.Int).QuoRem(n, div, new(big.Int))
+	if r.Sign() != 0 {
+		away := mode == RoundUp
+		if mode == RoundHalfUp || mode == RoundHalfEven {
+			twice := new(big.Int).Lsh(new(big.Int).Abs(r), 1)
+			switch twice.Cmp(div) {
+			case 1:
+				away = true
+			case 0:
+				away = mode == RoundHalfUp || q.Bit(0) == 1
+			}
+		}
+		if away {
+			q.Add(q, big.NewInt(int64(n.Sign())))
+		}
+	}
+	q.Mul(q, pow10(DecimalScale-places))
+	if !q.IsInt64() {
+		return 0, errDecimalRange
+	}
+	return Decimal(q.Int64()), nil
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
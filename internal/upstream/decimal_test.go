@@ -0,0 +1,150 @@
+package upstream
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseDecimal(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    Decimal
+		wantStr string
+		ok      bool
+	}{
+		{"5.4321", 543210000, "5.4321", true},
+		{"0.0001", 10000, "0.0001", true},
+		{"-0.0001", -10000, "-0.0001", true},
+		{"0.00000001", 1, "0.00000001", true},
+		{"5.43210000", 543210000, "5.4321", true},
+		{"5.0000", 500000000, "5", true},
+		{"5.432100000000", 543210000, "5.4321", true},
+		{"0", 0, "0", true},
+		{"0.0", 0, "0", true},
+		{"-0", 0, "0", true},
+		{"92233720367", 9223372036700000000, "92233720367", true},
+		{"92233720368", 0, "", false},
+		{"0.000000001", 0, "", false},
+		{"5.432100001", 0, "", false},
+		{"", 0, "", false},
+		{"-", 0, "", false},
+		{".5", 0, "", false},
+		{"5.", 0, "", false},
+		{"5,4321", 0, "", false},
+		{"1e-4", 0, "", false},
+		{" 5.4", 0, "", false},
+		{"+5.4", 0, "", false},
+		{"--5", 0, "", false},
+		{"NaN", 0, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, err := ParseDecimal(tt.raw)
+			if !tt.ok {
+				if !errors.Is(err, errDecimalSyntax) {
+					t.Errorf("ParseDecimal(%q) = %v, %v; want errDecimalSyntax", tt.raw, got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("ParseDecimal(%q) = %d, %v; want %d", tt.raw, got, err, tt.want)
+			}
+			if s := got.String(); s != tt.wantStr {
+				t.Errorf("String() = %q, want %q", s, tt.wantStr)
+			}
+		})
+	}
+}
+
+func TestDecimalJSON(t *testing.T) {
+	b, err := json.Marshal(struct {
+		Bid      string  `json:"bid"`
+		BidValue Decimal `json:"bid_value"`
+	}{"5.43210", 543210000})
+	if err != nil || string(b) != `{"bid":"5.43210","bid_value":5.4321}` {
+		t.Errorf("json.Marshal() = %s, %v", b, err)
+	}
+
+	tests := []struct {
+		in   string
+		want Decimal
+		ok   bool
+	}{
+		{`0.0001`, 10000, true},
+		{`"0.0001"`, 10000, true},
+		{`5.10`, 510000000, true},
+		{`null`, 0, true},
+		{`1e2`, 0, false},
+		{`"abc"`, 0, false},
+	}
+	for _, tt := range tests {
+		var d Decimal
+		err := json.Unmarshal([]byte(tt.in), &d)
+		if (err == nil) != tt.ok || d != tt.want {
+			t.Errorf("json.Unmarshal(%s) = %d, %v; want %d, ok %v", tt.in, d, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestDecimalMul(t *testing.T) {
+	tests := []struct {
+		name   string
+		d, e   string
+		places int
+		mode   RoundingMode
+		want   string
+	}{
+		{"exact", "100", "5.4321", 4, RoundHalfUp, "543.21"},
+		{"smallest price", "0.0001", "5.1234", 8, RoundHalfUp, "0.00051234"},
+		{"smallest price half up", "0.0001", "5.1234", 4, RoundHalfUp, "0.0005"},
+		{"smallest price up", "0.0001", "5.1234", 4, RoundUp, "0.0006"},
+		{"smallest price down", "0.0001", "5.1234", 4, RoundDown, "0.0005"},
+		{"below the kept places", "0.0001", "0.0001", 2, RoundHalfUp, "0"},
+		{"below the kept places up", "0.0001", "0.0001", 2, RoundUp, "0.01"},
+		{"trailing zeros", "1.50", "5.1240", 2, RoundHalfUp, "7.69"},
+		{"tie half up", "0.25", "5.1234", 4, RoundHalfUp, "1.2809"},
+		{"tie half even down", "0.25", "5.1234", 4, RoundHalfEven, "1.2808"},
+		{"tie half even up", "0.25", "5.1238", 4, RoundHalfEven, "1.281"},
+		{"negative tie half up", "-0.25", "5.1234", 4, RoundHalfUp, "-1.2809"},
+		{"negative up", "-0.0001", "5.1234", 4, RoundUp, "-0.0006"},
+		{"negative down", "-0.0001", "5.1234", 4, RoundDown, "-0.0005"},
+		{"zero places", "2.5", "1", 0, RoundHalfEven, "2"},
+		{"large amount", "1000000000", "5.12345678", 8, RoundHalfUp, "5123456780"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, _ := ParseDecimal(tt.d)
+			e, _ := ParseDecimal(tt.e)
+			got, err := d.Mul(e, tt.places, tt.mode)
+			if err != nil || got.String() != tt.want {
+				t.Errorf("%s.Mul(%s, %d, %d) = %s, %v; want %s", tt.d, tt.e, tt.places, tt.mode, got, err, tt.want)
+			}
+		})
+	}
+
+	if _, err := Decimal(1<<62).Mul(Decimal(1<<62), 2, RoundHalfUp); !errors.Is(err, errDecimalRange) {
+		t.Errorf("overflowing Mul() error = %v, want errDecimalRange", err)
+	}
+	if _, err := Decimal(1).Mul(1, DecimalScale+1, RoundHalfUp); err == nil {
+		t.Errorf("Mul() with %d places succeeded", DecimalScale+1)
+	}
+}
+
+func TestParseValues(t *testing.T) {
+	q := Quotation{High: "5.2000", Low: "5.0", Bid: "0.0001", Ask: "5.12340"}
+	if err := ParseValues(&q); err != nil {
+		t.Fatal(err)
+	}
+	if q.HighValue != 520000000 || q.LowValue != 500000000 || q.BidValue != 10000 || q.AskValue != 512340000 {
+		t.Errorf("ParseValues() = high %s low %s bid %s ask %s", q.HighValue, q.LowValue, q.BidValue, q.AskValue)
+	}
+	if q.Bid != "0.0001" || q.Ask != "5.12340" {
+		t.Errorf("ParseValues() changed the strings to %q and %q", q.Bid, q.Ask)
+	}
+
+	q = Quotation{High: "5.2", Low: "5.0", Bid: "5,1", Ask: "5.1"}
+	if err := ParseValues(&q); !errors.Is(err, errDecimalSyntax) {
+		t.Errorf("ParseValues() with bid %q error = %v, want errDecimalSyntax", q.Bid, err)
+	}
+}
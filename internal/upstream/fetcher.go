@@ -0,0 +1,265 @@
+// Package upstream fetches currency quotations from AwesomeAPI compatible
+// servers and other providers.
+package upstream
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/twsm000/goxp-client-server-api/internal/tracing"
+)
+
+const (
+	DefaultURL         string = "https://economia.awesomeapi.com.br"
+	DefaultPair        string = "USD-BRL"
+	quotationPath      string = "/json/last/"
+	MaxPairsPerRequest int    = 10
+)
+
+var pairPattern = regexp.MustCompile(`^[A-Z0-9]{2,10}-[A-Z0-9]{2,10}$`)
+
+var (
+	// ErrStatus reports a non 200 response from the upstream.
+	ErrStatus = errors.New("upstream respondeu com status inesperado")
+	// ErrInvalidPayload reports an upstream body that isn't a usable quotation.
+	ErrInvalidPayload = errors.New("resposta inválida do upstream")
+)
+
+// oldestValidTimestamp bounds how old an upstream timestamp may plausibly be.
+var oldestValidTimestamp = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Fetcher retrieves quotations from an AwesomeAPI compatible server.
+type Fetcher struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewFetcher creates a fetcher for baseURL. A nil client means
+// http.DefaultClient.
+func NewFetcher(baseURL string, client *http.Client) *Fetcher {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Fetcher{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  client,
+	}
+}
+
+// Fetch requests the current quotation of every pair in a single upstream
+// call. The result is keyed by the requested pair (e.g. "USD-BRL"). The caller
+// controls the deadline through ctx. When ctx carries a span, the call is
+// traced and the trace propagated to the upstream.
+func (f *Fetcher) Fetch(ctx context.Context, pairs ...string) (quotations map[string]Quotation, err error) {
+	endpoint := f.baseURL + quotationPath + strings.Join(pairs, ",")
+	ctx, span := tracing.Start(ctx, "awesomeapi GET", tracing.KindClient)
+	span.SetAttribute("http.url", endpoint)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao criar requisição. %w", err)
+	}
+	tracing.Inject(ctx, req.Header)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("requisição falhou. %w", err)
+	}
+	defer resp.Body.Close()
+	span.SetAttribute("http.status_code", resp.StatusCode)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+
+	return decodeQuotations(resp.Body, pairs)
+}
+
+// Name identifies the provider in logs and response headers.
+func (f *Fetcher) Name() string {
+	return "awesomeapi"
+}
+
+// decodeQuotations reads an AwesomeAPI payload and validates the entry of
+// every requested pair. The usual shape is an object keyed by pair without
+// the dash (e.g. "USDBRL"); keys are matched case-insensitively, with or
+// without the dash. An array of quotations, as returned by other AwesomeAPI
+// endpoints, is matched by code and codein.
+func decodeQuotations(r io.Reader, pairs []string) (map[string]Quotation, error) {
+	var raw json.RawMessage
+	err := json.NewDecoder(r).Decode(&raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: falha ao decodificar corpo da requisição. %v", ErrInvalidPayload, err)
+	}
+
+	byKey := make(map[string]Quotation)
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && trimmed[0] == '[' {
+		var list []Quotation
+		if err := json.Unmarshal(trimmed, &list); err != nil {
+			return nil, fmt.Errorf("%w: falha ao decodificar corpo da requisição. %v", ErrInvalidPayload, err)
+		}
+		for _, q := range list {
+			byKey[pairKey(q.Code+q.CodeIn)] = q
+		}
+	} else {
+		var payload map[string]Quotation
+		if err := json.Unmarshal(trimmed, &payload); err != nil {
+			return nil, fmt.Errorf("%w: falha ao decodificar corpo da requisição. %v", ErrInvalidPayload, err)
+		}
+		for key, q := range payload {
+			byKey[pairKey(key)] = q
+		}
+	}
+	if len(byKey) == 0 {
+		return nil, fmt.Errorf("%w: nenhuma cotação na resposta", ErrInvalidPayload)
+	}
+
+	quotations := make(map[string]Quotation, len(pairs))
+	for _, pair := range pairs {
+		q, ok := byKey[pairKey(pair)]
+		if !ok {
+			return nil, fmt.Errorf("%w: par %s ausente na resposta", ErrInvalidPayload, pair)
+		}
+		if err := validateQuotation(pair, &q); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidPayload, pair, err)
+		}
+		quotations[pair] = q
+	}
+	return quotations, nil
+}
+
+// pairKey normalizes a pair or payload key for lookups: "usd-brl", "USDBRL"
+// and "USD-BRL" are the same key.
+func pairKey(s string) string {
+	return strings.ToUpper(strings.ReplaceAll(s, "-", ""))
+}
+
+// validateQuotation rejects quotations that would otherwise be stored as rows
+// of empty or meaningless values, and fills the decimal price fields. An
+// empty create_date is accepted, a malformed one isn't.
+func validateQuotation(pair string, q *Quotation) error {
+	code, codeIn, _ := strings.Cut(pair, "-")
+	if !strings.EqualFold(q.Code, code) || !strings.EqualFold(q.CodeIn, codeIn) {
+		return fmt.Errorf("code/codein %s/%s não correspondem ao par", q.Code, q.CodeIn)
+	}
+
+	if err := ParseValues(q); err != nil {
+		return err
+	}
+	if q.BidValue <= 0 {
+		return fmt.Errorf("bid inválido: %q", q.Bid)
+	}
+
+	sec, err := strconv.ParseInt(q.Timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("timestamp inválido: %q", q.Timestamp)
+	}
+	ts := time.Unix(sec, 0)
+	if ts.Before(oldestValidTimestamp) || ts.After(time.Now().Add(24*time.Hour)) {
+		return fmt.Errorf("timestamp fora do intervalo plausível: %q", q.Timestamp)
+	}
+	if q.CreateDate != "" {
+		if _, err := q.CreatedAt(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParsePairs splits a comma separated list of pairs, normalizing and
+// deduplicating them while keeping the original order.
+func ParsePairs(raw string) ([]string, error) {
+	seen := make(map[string]bool)
+	var pairs []string
+	for _, p := range strings.Split(raw, ",") {
+		pair := strings.ToUpper(strings.TrimSpace(p))
+		if !pairPattern.MatchString(pair) {
+			return nil, fmt.Errorf("par inválido: %q", p)
+		}
+		if seen[pair] {
+			continue
+		}
+		seen[pair] = true
+		pairs = append(pairs, pair)
+	}
+	if len(pairs) > MaxPairsPerRequest {
+		return nil, fmt.Errorf("no máximo %d pares por requisição", MaxPairsPerRequest)
+	}
+	return pairs, nil
+}
+
+// ValidateURL accepts only absolute http(s) URLs.
+func ValidateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("o esquema deve ser http ou https")
+	}
+	if u.Host == "" {
+		return errors.New("host não informado")
+	}
+	return nil
+}
+
+// Quotation is a quotation in the AwesomeAPI format. The prices keep the
+// upstream strings for compatibility; the *Value fields hold the same prices
+// as decimals.
+type Quotation struct {
+	Code       string  `json:"code" xml:"code"`
+	CodeIn     string  `json:"codein" xml:"codein"`
+	Name       string  `json:"name" xml:"name"`
+	High       string  `json:"high" xml:"high"`
+	Low        string  `json:"low" xml:"low"`
+	VarBid     string  `json:"varBid" xml:"varBid"`
+	PctChange  string  `json:"pctChange" xml:"pctChange"`
+	Bid        string  `json:"bid" xml:"bid"`
+	Ask        string  `json:"ask" xml:"ask"`
+	Timestamp  string  `json:"timestamp" xml:"timestamp"`
+	CreateDate string  `json:"create_date" xml:"create_date"`
+	HighValue  Decimal `json:"high_value" xml:"high_value"`
+	LowValue   Decimal `json:"low_value" xml:"low_value"`
+	BidValue   Decimal `json:"bid_value" xml:"bid_value"`
+	AskValue   Decimal `json:"ask_value" xml:"ask_value"`
+	// Derived marks a cross rate, computed from the quotations in Components
+	// by CrossRate rather than quoted by the provider.
+	Derived    bool        `json:"derived,omitempty" xml:"derived,omitempty"`
+	Components []Quotation `json:"components,omitempty" xml:"components>quotation,omitempty"`
+}
+
+// ParseValues fills the decimal fields of q from its price strings.
+func ParseValues(q *Quotation) error {
+	prices := []struct {
+		name  string
+		raw   string
+		value *Decimal
+	}{
+		{"high", q.High, &q.HighValue},
+		{"low", q.Low, &q.LowValue},
+		{"bid", q.Bid, &q.BidValue},
+		{"ask", q.Ask, &q.AskValue},
+	}
+	for _, p := range prices {
+		v, err := ParseDecimal(p.raw)
+		if err != nil {
+			return fmt.Errorf("%s inválido: %w", p.name, err)
+		}
+		*p.value = v
+	}
+	return nil
+}
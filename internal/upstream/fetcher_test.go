@@ -0,0 +1,174 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// usdBRL is an AwesomeAPI USDBRL entry timestamped now.
+func usdBRL() string {
+	return `{"code":"USD","codein":"BRL","name":"Dólar Americano/Real Brasileiro","high":"5.2301","low":"5.1012",` +
+		`"varBid":"-0.0123","pctChange":"-0.24","bid":"5.1234","ask":"5.1240",` +
+		`"timestamp":"` + strconv.FormatInt(time.Now().Unix(), 10) + `","create_date":"2026-10-14 10:00:00"}`
+}
+
+func TestFetcherBaseURL(t *testing.T) {
+	var gotPath string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		w.Write([]byte(`{"USDBRL":` + usdBRL() + `}`))
+	}))
+	defer srv.Close()
+
+	for _, base := range []string{srv.URL, srv.URL + "/"} {
+		t.Run(base, func(t *testing.T) {
+			quotations, err := NewFetcher(base, srv.Client()).Fetch(context.Background(), "USD-BRL")
+			if err != nil {
+				t.Fatalf("Fetch() error = %v", err)
+			}
+			if gotPath != "/json/last/USD-BRL" {
+				t.Errorf("upstream path = %q, want /json/last/USD-BRL", gotPath)
+			}
+			if q := quotations["USD-BRL"]; q.Bid != "5.1234" || q.Ask != "5.1240" {
+				t.Errorf("quotation = %+v", q)
+			}
+		})
+	}
+}
+
+func TestFetcherStatusError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	_, err := NewFetcher(srv.URL, srv.Client()).Fetch(context.Background(), "USD-BRL")
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Code != http.StatusServiceUnavailable || !errors.Is(err, ErrStatus) {
+		t.Errorf("Fetch() error = %v, want a 503 StatusError", err)
+	}
+}
+
+func TestFetcherHonorsContext(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := NewFetcher(srv.URL, srv.Client()).Fetch(ctx, "USD-BRL")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Fetch() error = %v, want the deadline of ctx", err)
+	}
+}
+
+func TestValidateURL(t *testing.T) {
+	tests := []struct {
+		raw string
+		ok  bool
+	}{
+		{"https://economia.awesomeapi.com.br", true},
+		{"http://localhost:9911/", true},
+		{"https://proxy.example.com/awesomeapi", true},
+		{"ftp://economia.awesomeapi.com.br", false},
+		{"file:///etc/passwd", false},
+		{"economia.awesomeapi.com.br", false},
+		{"https://", false},
+		{"://", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			if err := ValidateURL(tt.raw); (err == nil) != tt.ok {
+				t.Errorf("ValidateURL(%q) error = %v, want ok %v", tt.raw, err, tt.ok)
+			}
+		})
+	}
+}
+
+// entry is an AwesomeAPI entry of code/codeIn timestamped now, with bid and
+// create_date replaced.
+func entry(code, codeIn, bid, createDate string) string {
+	return `{"code":"` + code + `","codein":"` + codeIn + `","name":"Test","high":"5.2","low":"5.0",` +
+		`"bid":"` + bid + `","ask":"5.1240","timestamp":"` + strconv.FormatInt(time.Now().Unix(), 10) +
+		`","create_date":"` + createDate + `"}`
+}
+
+func TestDecodeQuotations(t *testing.T) {
+	usd := entry("USD", "BRL", "5.1234", "2026-10-14 10:00:00")
+	eur := entry("EUR", "BRL", "6.01", "2026-10-14 10:00:00")
+	tests := []struct {
+		name    string
+		payload string
+		pairs   []string
+		wantBid map[string]string
+	}{
+		{"object", `{"USDBRL":` + usd + `}`, []string{"USD-BRL"}, map[string]string{"USD-BRL": "5.1234"}},
+		{"lower case key", `{"usdbrl":` + usd + `}`, []string{"USD-BRL"}, map[string]string{"USD-BRL": "5.1234"}},
+		{"dashed key", `{"USD-BRL":` + usd + `}`, []string{"USD-BRL"}, map[string]string{"USD-BRL": "5.1234"}},
+		{"several pairs", `{"USDBRL":` + usd + `,"EURBRL":` + eur + `}`, []string{"USD-BRL", "EUR-BRL"},
+			map[string]string{"USD-BRL": "5.1234", "EUR-BRL": "6.01"}},
+		{"extra pairs ignored", `{"USDBRL":` + usd + `,"EURBRL":` + eur + `}`, []string{"EUR-BRL"}, map[string]string{"EUR-BRL": "6.01"}},
+		{"array", `[` + eur + `,` + usd + `]`, []string{"USD-BRL", "EUR-BRL"},
+			map[string]string{"USD-BRL": "5.1234", "EUR-BRL": "6.01"}},
+		{"lower case codes", `{"USDBRL":` + entry("usd", "brl", "5.1234", "") + `}`, []string{"USD-BRL"}, map[string]string{"USD-BRL": "5.1234"}},
+		{"surrounding space", "\n  {\"USDBRL\":" + usd + "}\n", []string{"USD-BRL"}, map[string]string{"USD-BRL": "5.1234"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeQuotations(strings.NewReader(tt.payload), tt.pairs)
+			if err != nil {
+				t.Fatalf("decodeQuotations() error = %v", err)
+			}
+			if len(got) != len(tt.wantBid) {
+				t.Errorf("decodeQuotations() = %d quotations, want %d", len(got), len(tt.wantBid))
+			}
+			for pair, bid := range tt.wantBid {
+				if q := got[pair]; q.Bid != bid || q.BidValue.String() != bid {
+					t.Errorf("%s bid = %q (%s), want %s", pair, q.Bid, q.BidValue, bid)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeQuotationsInvalid(t *testing.T) {
+	usd := entry("USD", "BRL", "5.1234", "2026-10-14 10:00:00")
+	tests := []struct {
+		name    string
+		payload string
+		pairs   []string
+	}{
+		{"empty body", ``, []string{"USD-BRL"}},
+		{"not json", `<html>`, []string{"USD-BRL"}},
+		{"empty object", `{}`, []string{"USD-BRL"}},
+		{"empty array", `[]`, []string{"USD-BRL"}},
+		{"null", `null`, []string{"USD-BRL"}},
+		{"string", `"USDBRL"`, []string{"USD-BRL"}},
+		{"missing pair", `{"USDBRL":` + usd + `}`, []string{"USD-BRL", "EUR-BRL"}},
+		{"mismatched codes", `{"EURBRL":` + usd + `}`, []string{"EUR-BRL"}},
+		{"mismatched codein", `{"USDEUR":` + usd + `}`, []string{"USD-EUR"}},
+		{"malformed entry", `{"USDBRL":[1,2]}`, []string{"USD-BRL"}},
+		{"empty bid", `{"USDBRL":` + entry("USD", "BRL", "", "") + `}`, []string{"USD-BRL"}},
+		{"zero bid", `{"USDBRL":` + entry("USD", "BRL", "0.0000", "") + `}`, []string{"USD-BRL"}},
+		{"float bid", `{"USDBRL":` + entry("USD", "BRL", "5.1e0", "") + `}`, []string{"USD-BRL"}},
+		{"bad create_date", `{"USDBRL":` + entry("USD", "BRL", "5.1", "14/10/2026") + `}`, []string{"USD-BRL"}},
+		{"old timestamp", `{"USDBRL":{"code":"USD","codein":"BRL","high":"5","low":"5","bid":"5","ask":"5","timestamp":"1"}}`, []string{"USD-BRL"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeQuotations(strings.NewReader(tt.payload), tt.pairs)
+			if !errors.Is(err, ErrInvalidPayload) {
+				t.Errorf("decodeQuotations() = %v, %v; want ErrInvalidPayload", got, err)
+			}
+		})
+	}
+}
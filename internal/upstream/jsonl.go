@@ -0,0 +1,60 @@
+package upstream
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// maxRecordSize bounds a single line of a JSON Lines file.
+const maxRecordSize int = 1 << 20
+
+// ErrMissingBid reports a quotation without its bid, the one field every
+// consumer relies on.
+var ErrMissingBid = errors.New("cotação sem bid")
+
+// Record is a line of the JSON Lines file written by the client: the full
+// quotation plus the time the client fetched it.
+type Record struct {
+	Quotation
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// MarshalRecord encodes rec as a single line, newline included, so it can be
+// appended with one write.
+func MarshalRecord(rec Record) ([]byte, error) {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+// ReadRecords streams the records of a JSON Lines file, calling fn for each
+// one and stopping at the first error. Blank lines and unknown fields are
+// ignored; a line without a bid fails with ErrMissingBid.
+func ReadRecords(r io.Reader, fn func(Record) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxRecordSize)
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return fmt.Errorf("linha %d: %w", n, err)
+		}
+		if rec.Bid == "" {
+			return fmt.Errorf("linha %d: %w", n, ErrMissingBid)
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
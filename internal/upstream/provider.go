@@ -0,0 +1,257 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/twsm000/goxp-client-server-api/internal/tracing"
+)
+
+// Provider is a source of quotations. Fetch returns the quotation of
+// every requested pair, keyed by pair (e.g. "USD-BRL"), or an error if any of
+// them is unavailable.
+//
+// It was first sketched as QuotationProvider with Fetch(ctx, pair)
+// (*Quotation, error). It takes several pairs instead because /cotacao?pairs=
+// and the poller send up to MaxPairsPerRequest pairs in a single AwesomeAPI
+// request: one call per pair would multiply the requests counted against
+// the AwesomeAPI rate limit and the -upstream-rate budget, and the breaker
+// would count a batch as several failures. A single pair is just Fetch(ctx,
+// pair)[pair].
+type Provider interface {
+	Name() string
+	Fetch(ctx context.Context, pairs ...string) (map[string]Quotation, error)
+}
+
+// FileProvider serves quotations from a local file in the AwesomeAPI format.
+// It is meant for development and as a last resort fallback.
+type FileProvider struct {
+	Path string
+}
+
+func (p *FileProvider) Name() string {
+	return "file"
+}
+
+func (p *FileProvider) Fetch(ctx context.Context, pairs ...string) (map[string]Quotation, error) {
+	file, err := os.Open(p.Path)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao abrir arquivo de cotações. %w", err)
+	}
+	defer file.Close()
+	return decodeQuotations(file, pairs)
+}
+
+// ProviderFactory builds a provider from the chain settings.
+type ProviderFactory func(cfg ChainConfig) Provider
+
+var factories = make(map[string]ProviderFactory)
+
+// KnownProviders lists the names accepted by NewChain. It is filled by
+// Register and must not be modified otherwise.
+var KnownProviders = make(map[string]bool)
+
+// Register makes a provider available to NewChain, and so to -providers,
+// under name. It is meant to be called from init functions and panics on a
+// duplicate name.
+func Register(name string, factory ProviderFactory) {
+	if KnownProviders[name] {
+		panic("upstream: provedor registrado duas vezes: " + name)
+	}
+	factories[name] = factory
+	KnownProviders[name] = true
+}
+
+func init() {
+	Register("awesomeapi", func(cfg ChainConfig) Provider {
+		return NewFetcher(cfg.BaseURL, nil)
+	})
+	Register("file", func(cfg ChainConfig) Provider {
+		return &FileProvider{Path: cfg.File}
+	})
+}
+
+// ChainConfig holds the settings of the providers built by NewChain.
+type ChainConfig struct {
+	// BaseURL is the server of the awesomeapi provider.
+	BaseURL string
+	// File is read by the file provider.
+	File string
+	// BreakerThreshold is the number of consecutive awesomeapi failures that
+	// opens its circuit breaker. Zero disables the breaker.
+	BreakerThreshold int
+	// BreakerCooldown is how long the breaker stays open.
+	BreakerCooldown time.Duration
+	// Retries is the number of extra awesomeapi attempts after a transient
+	// failure, waiting RetryBackoff, doubled each time and reduced by up to
+	// RetryJitter (0 to 1). Zero disables the retries.
+	Retries      int
+	RetryBackoff time.Duration
+	RetryJitter  float64
+	// BudgetRate caps the awesomeapi calls, retries included, at that many
+	// per second across every caller, allowing bursts of BudgetBurst. Zero
+	// disables the budget.
+	BudgetRate  float64
+	BudgetBurst int
+	// Timeouts bounds each attempt of the named providers, so a slow one
+	// leaves time for the next. Providers without an entry get whatever is
+	// left of the caller's deadline.
+	Timeouts map[string]time.Duration
+	// Observe, when set, is called after each provider attempt with its
+	// duration and error, for metrics.
+	Observe func(provider string, d time.Duration, err error)
+}
+
+// Chain tries each provider in order until one succeeds.
+type Chain struct {
+	providers []Provider
+	timeouts  map[string]time.Duration
+	observe   func(provider string, d time.Duration, err error)
+	breaker   *Breaker
+	budget    *Budget
+	last      atomic.Pointer[FetchOutcome]
+}
+
+// FetchOutcome is how the last Fetch of a chain ended: Err is nil when a
+// provider served it.
+type FetchOutcome struct {
+	At  time.Time
+	Err error
+}
+
+// NewChain builds the chain from a comma separated list of registered
+// provider names.
+func NewChain(names string, cfg ChainConfig) (*Chain, error) {
+	c := &Chain{timeouts: cfg.Timeouts, observe: cfg.Observe}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		factory, ok := factories[name]
+		if !ok {
+			return nil, fmt.Errorf("provedor desconhecido: %q", name)
+		}
+		p := factory(cfg)
+		// The retries and the breaker guard the remote upstream only; the
+		// file provider is the fallback while it fails. The breaker counts a
+		// call once, after its retries and cross rate fallback, while the
+		// budget counts every request actually sent.
+		if name == "awesomeapi" && cfg.BudgetRate > 0 {
+			c.budget = NewBudget(p, cfg.BudgetRate, cfg.BudgetBurst)
+			p = c.budget
+		}
+		if name == "awesomeapi" && cfg.Retries > 0 {
+			p = NewRetry(p, cfg.Retries, cfg.RetryBackoff, cfg.RetryJitter)
+		}
+		if name == "awesomeapi" {
+			p = NewCrossRates(p)
+		}
+		if name == "awesomeapi" && cfg.BreakerThreshold > 0 {
+			c.breaker = NewBreaker(p, cfg.BreakerThreshold, cfg.BreakerCooldown)
+			p = c.breaker
+		}
+		c.providers = append(c.providers, p)
+	}
+	return c, nil
+}
+
+// Fetch returns the quotations and the name of the provider that served them.
+// Each provider runs under its own timeout, when it has one, and the next is
+// tried while ctx isn't done. When every provider fails the error of the
+// first one is returned, as it is the most relevant to the caller.
+func (c *Chain) Fetch(ctx context.Context, pairs ...string) (map[string]Quotation, string, error) {
+	var firstErr error
+	for _, p := range c.providers {
+		quotations, err := c.fetch(ctx, p, pairs)
+		if err == nil {
+			c.last.Store(&FetchOutcome{At: time.Now()})
+			return quotations, p.Name(), nil
+		}
+		slog.Warn("Provedor falhou", "provider", p.Name(), "err", err)
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if firstErr == nil {
+		firstErr = errors.New("nenhum provedor configurado")
+	}
+	c.last.Store(&FetchOutcome{At: time.Now(), Err: firstErr})
+	return nil, "", firstErr
+}
+
+// LastFetch reports how the last Fetch ended, false before the first one.
+func (c *Chain) LastFetch() (FetchOutcome, bool) {
+	if last := c.last.Load(); last != nil {
+		return *last, true
+	}
+	return FetchOutcome{}, false
+}
+
+// fetch makes one attempt of the chain under its own span, so a trace shows
+// the time spent on a provider that failed before the next one answered.
+func (c *Chain) fetch(ctx context.Context, p Provider, pairs []string) (map[string]Quotation, error) {
+	ctx, span := tracing.Start(ctx, "provider "+p.Name(), tracing.KindInternal)
+	defer span.End()
+	span.SetAttribute("provider.name", p.Name())
+	span.SetAttribute("provider.pairs", strings.Join(pairs, ","))
+	if timeout, ok := c.timeouts[p.Name()]; ok {
+		span.SetAttribute("provider.timeout", timeout.String())
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	quotations, err := p.Fetch(ctx, pairs...)
+	if c.observe != nil {
+		c.observe(p.Name(), time.Since(start), err)
+	}
+	span.RecordError(err)
+	return quotations, err
+}
+
+// ParseTimeouts reads per provider timeouts like "awesomeapi=300ms,file=50ms".
+func ParseTimeouts(raw string) (map[string]time.Duration, error) {
+	timeouts := make(map[string]time.Duration)
+	if raw == "" {
+		return timeouts, nil
+	}
+	for _, item := range strings.Split(raw, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok || !KnownProviders[name] {
+			return nil, fmt.Errorf("timeout de provedor inválido: %q", item)
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("timeout de provedor inválido: %q", item)
+		}
+		timeouts[name] = d
+	}
+	return timeouts, nil
+}
+
+// Breaker returns the circuit breaker of the awesomeapi provider, nil when it
+// is disabled or the provider isn't in the chain.
+func (c *Chain) Breaker() *Breaker {
+	return c.breaker
+}
+
+// Budget returns the call budget of the awesomeapi provider, nil when it is
+// disabled or the provider isn't in the chain.
+func (c *Chain) Budget() *Budget {
+	return c.budget
+}
+
+func (c *Chain) String() string {
+	names := make([]string, len(c.providers))
+	for i, p := range c.providers {
+		names[i] = p.Name()
+	}
+	return strings.Join(names, ",")
+}
@@ -0,0 +1,121 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeProvider serves quotations, or fails with err.
+type fakeProvider struct {
+	name  string
+	err   error
+	calls int
+}
+
+func (p *fakeProvider) Name() string {
+	return p.name
+}
+
+func (p *fakeProvider) Fetch(ctx context.Context, pairs ...string) (map[string]Quotation, error) {
+	p.calls++
+	if p.err != nil {
+		return nil, p.err
+	}
+	quotations := make(map[string]Quotation, len(pairs))
+	for _, pair := range pairs {
+		quotations[pair] = Quotation{Bid: "5.1"}
+	}
+	return quotations, nil
+}
+
+func TestChainFallback(t *testing.T) {
+	errDown := errors.New("primary down")
+	errAlsoDown := errors.New("secondary down")
+	tests := []struct {
+		name         string
+		providers    []*fakeProvider
+		wantProvider string
+		wantErr      error
+	}{
+		{"primary", []*fakeProvider{{name: "primary"}, {name: "secondary"}}, "primary", nil},
+		{"fallback", []*fakeProvider{{name: "primary", err: errDown}, {name: "secondary"}}, "secondary", nil},
+		{"all fail", []*fakeProvider{{name: "primary", err: errDown}, {name: "secondary", err: errAlsoDown}}, "", errDown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var observed []string
+			c := &Chain{observe: func(provider string, _ time.Duration, err error) {
+				observed = append(observed, provider)
+			}}
+			for _, p := range tt.providers {
+				c.providers = append(c.providers, p)
+			}
+			if _, ok := c.LastFetch(); ok {
+				t.Error("LastFetch() before any fetch reports one")
+			}
+
+			quotations, provider, err := c.Fetch(context.Background(), "USD-BRL", "EUR-BRL")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Fetch() error = %v, want %v", err, tt.wantErr)
+			}
+			if provider != tt.wantProvider {
+				t.Errorf("provider = %q, want %q", provider, tt.wantProvider)
+			}
+			if err == nil && len(quotations) != 2 {
+				t.Errorf("got %d quotations, want 2 from a single call", len(quotations))
+			}
+			for _, p := range tt.providers {
+				if p.calls > 1 {
+					t.Errorf("%s called %d times for one batch", p.name, p.calls)
+				}
+			}
+			if len(observed) == 0 || observed[0] != "primary" {
+				t.Errorf("observed attempts = %v, want primary first", observed)
+			}
+			last, ok := c.LastFetch()
+			if !ok || !errors.Is(last.Err, tt.wantErr) {
+				t.Errorf("LastFetch() = %+v, %v", last, ok)
+			}
+		})
+	}
+}
+
+func TestNewChainUnknownProvider(t *testing.T) {
+	if _, err := NewChain("awesomeapi,ecb", ChainConfig{}); err == nil {
+		t.Error("NewChain() with an unknown provider: want error")
+	}
+}
+
+func TestParseTimeouts(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    map[string]time.Duration
+		wantErr bool
+	}{
+		{"", map[string]time.Duration{}, false},
+		{"awesomeapi=300ms", map[string]time.Duration{"awesomeapi": 300 * time.Millisecond}, false},
+		{"awesomeapi=300ms, file=50ms", map[string]time.Duration{"awesomeapi": 300 * time.Millisecond, "file": 50 * time.Millisecond}, false},
+		{"ecb=1s", nil, true},
+		{"awesomeapi", nil, true},
+		{"awesomeapi=0s", nil, true},
+		{"awesomeapi=soon", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, err := ParseTimeouts(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTimeouts(%q) error = %v, wantErr %v", tt.raw, err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ParseTimeouts(%q) = %v, want %v", tt.raw, got, tt.want)
+			}
+			for name, d := range tt.want {
+				if got[name] != d {
+					t.Errorf("timeout of %s = %v, want %v", name, got[name], d)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// StatusError is a non 200 response from the upstream. It matches ErrStatus
+// with errors.Is.
+type StatusError struct {
+	Code   int
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return ErrStatus.Error() + ": " + e.Status
+}
+
+func (e *StatusError) Is(target error) bool {
+	return target == ErrStatus
+}
+
+// Retry calls a Provider again after transient failures: network errors,
+// 5xx and 429 responses. The delay starts at backoff and doubles after each
+// attempt, reduced by up to jitter (a fraction from 0 to 1) so concurrent
+// callers don't retry in lockstep. No attempt starts when the delay would go
+// past the deadline of ctx.
+type Retry struct {
+	provider Provider
+	retries  int
+	backoff  time.Duration
+	jitter   float64
+}
+
+// NewRetry wraps p, allowing up to retries extra attempts.
+func NewRetry(p Provider, retries int, backoff time.Duration, jitter float64) *Retry {
+	return &Retry{
+		provider: p,
+		retries:  retries,
+		backoff:  backoff,
+		jitter:   jitter,
+	}
+}
+
+func (r *Retry) Name() string {
+	return r.provider.Name()
+}
+
+func (r *Retry) Fetch(ctx context.Context, pairs ...string) (map[string]Quotation, error) {
+	delay := r.backoff
+	for attempt := 0; ; attempt++ {
+		quotations, err := r.provider.Fetch(ctx, pairs...)
+		if err == nil || attempt == r.retries || !transient(err) {
+			return quotations, err
+		}
+
+		wait := delay - time.Duration(rand.Float64()*r.jitter*float64(delay))
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= wait {
+			return nil, err
+		}
+		slog.Warn("Provedor falhou, nova tentativa agendada", "provider", r.Name(), "wait", wait, "err", err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+// transient reports whether err is worth another attempt. Timeouts of the
+// caller's context aren't: the deadline is already spent.
+func transient(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code >= http.StatusInternalServerError || statusErr.Code == http.StatusTooManyRequests
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
@@ -0,0 +1,44 @@
+package upstream
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+	_ "time/tzdata" // the zone database, for hosts without one
+)
+
+// createDateLayout is the layout of create_date, São Paulo wall clock time.
+const createDateLayout string = "2006-01-02 15:04:05"
+
+var saoPaulo = loadLocation("America/Sao_Paulo")
+
+func loadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return loc
+}
+
+// Time returns the instant q refers to. The unix timestamp is preferred; the
+// create_date, in São Paulo local time, is the fallback. Wall clock times
+// skipped or repeated by a DST change resolve as time.ParseInLocation does.
+func (q Quotation) Time() (time.Time, error) {
+	if sec, err := strconv.ParseInt(q.Timestamp, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	t, err := q.CreatedAt()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("data da cotação inválida: timestamp %q, create_date %q", q.Timestamp, q.CreateDate)
+	}
+	return t, nil
+}
+
+// CreatedAt parses create_date, São Paulo local time.
+func (q Quotation) CreatedAt() (time.Time, error) {
+	t, err := time.ParseInLocation(createDateLayout, q.CreateDate, saoPaulo)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("create_date inválido: %q", q.CreateDate)
+	}
+	return t, nil
+}
@@ -0,0 +1,69 @@
+package upstream
+
+import (
+	"testing"
+	"time"
+)
+
+// São Paulo last observed DST from 2018-11-04, when midnight skipped to
+// 01:00, to 2019-02-17, when midnight went back to 23:00 of the 16th.
+func TestCreatedAt(t *testing.T) {
+	tests := []struct {
+		name       string
+		createDate string
+		want       string // UTC
+		ok         bool
+	}{
+		{"standard time", "2026-10-14 10:00:00", "2026-10-14T13:00:00Z", true},
+		{"before DST starts", "2018-11-03 23:59:59", "2018-11-04T02:59:59Z", true},
+		{"skipped hour", "2018-11-04 00:30:00", "2018-11-04T02:30:00Z", true},
+		{"DST starts", "2018-11-04 01:00:00", "2018-11-04T03:00:00Z", true},
+		{"daylight time", "2019-01-15 12:00:00", "2019-01-15T14:00:00Z", true},
+		{"repeated hour, first", "2019-02-16 23:30:00", "2019-02-17T01:30:00Z", true},
+		{"DST ends", "2019-02-17 00:00:00", "2019-02-17T03:00:00Z", true},
+		{"after DST", "2019-02-17 12:00:00", "2019-02-17T15:00:00Z", true},
+		{"midnight", "2026-01-01 00:00:00", "2026-01-01T03:00:00Z", true},
+		{"empty", "", "", false},
+		{"ISO layout", "2026-10-14T10:00:00", "", false},
+		{"brazilian layout", "14/10/2026 10:00:00", "", false},
+		{"no seconds", "2026-10-14 10:00", "", false},
+		{"out of range", "2026-02-30 10:00:00", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Quotation{CreateDate: tt.createDate}.CreatedAt()
+			if !tt.ok {
+				if err == nil {
+					t.Errorf("CreatedAt(%q) = %v, want an error", tt.createDate, got)
+				}
+				return
+			}
+			if err != nil || got.UTC().Format(time.RFC3339) != tt.want {
+				t.Errorf("CreatedAt(%q) = %v, %v; want %s", tt.createDate, got.UTC(), err, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuotationTime(t *testing.T) {
+	tests := []struct {
+		name string
+		q    Quotation
+		want int64
+		ok   bool
+	}{
+		{"timestamp", Quotation{Timestamp: "1791968680", CreateDate: "2000-01-01 00:00:00"}, 1791968680, true},
+		{"create_date fallback", Quotation{CreateDate: "2019-01-15 12:00:00"}, 1547560800, true},
+		{"bad timestamp", Quotation{Timestamp: "ontem", CreateDate: "2026-10-14 10:00:00"}, 1791982800, true},
+		{"neither", Quotation{Timestamp: "ontem", CreateDate: "14/10/2026"}, 0, false},
+		{"empty", Quotation{}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.q.Time()
+			if (err == nil) != tt.ok || (tt.ok && got.Unix() != tt.want) {
+				t.Errorf("Time() = %v, %v; want unix %d, ok %v", got, err, tt.want, tt.ok)
+			}
+		})
+	}
+}